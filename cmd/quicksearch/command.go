@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/internal/core"
+	"log"
+	"os"
+	"sort"
+)
+
+// RunCommand runs the offline command, the server must be stopped.
+func RunCommand(args []string) {
+	switch args[0] {
+	case "migrate-meta":
+		migrateMeta(args[1:])
+	default:
+		log.Fatalf("unknown command [%s], supported commands: migrate-meta", args[0])
+	}
+}
+
+func migrateMeta(args []string) {
+	fs := flag.NewFlagSet("migrate-meta", flag.ExitOnError)
+	from := fs.String("from", config.Global.Storage.MetaType, "the storage type of the current metadata, bolt, leveldb or badger.")
+	to := fs.String("to", "", "the storage type to migrate the metadata to, bolt, leveldb or badger.")
+	_ = fs.Parse(args)
+	if len(*to) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	res, err := core.MigrateMeta(config.Global.Storage.DataDir, *from, *to)
+	if err != nil {
+		log.Fatalf("migrate-meta: %+v", err)
+	}
+	names := make([]string, 0, len(res.Pairs))
+	for name := range res.Pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("migrated %d pairs of [%s] from %s to %s", res.Pairs[name], name, *from, *to)
+	}
+	log.Printf("the old metadata is kept in [%s], set `storage.meta-type: %s` in the config before starting the server.", res.Backup, *to)
+}
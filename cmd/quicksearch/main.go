@@ -14,6 +14,11 @@ import (
 func main() {
 	// init config.
 	InitConfig()
+	// run the command instead of the server if given, e.g. `quicksearch -c config.yaml migrate-meta --to leveldb`.
+	if flag.NArg() > 0 {
+		RunCommand(flag.Args())
+		return
+	}
 	// start search engine.
 	engine := core.NewEngine()
 	if err := engine.Run(); err != nil {
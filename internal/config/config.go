@@ -21,6 +21,7 @@ type Engine struct {
 	DefaultNumberOfShards   int `mapstructure:"default-number-of-shards" json:"default_number_of_shards" yaml:"default-number-of-shards"`
 	DefaultBatchSize        int `mapstructure:"default-batch-size" json:"default_batch_size" yaml:"default-batch-size"`
 	DefaultSearchResultSize int `mapstructure:"default-search-result-size" json:"default_search_result_size" yaml:"default-search-result-size"`
+	MaxOpenScrolls          int `mapstructure:"max-open-scrolls" json:"max_open_scrolls" yaml:"max-open-scrolls"`
 }
 
 type Storage struct {
@@ -0,0 +1,376 @@
+package core
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	bindex "github.com/blevesearch/bleve_index_api"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"strings"
+	"sync"
+)
+
+// AggregationRequest defines an aggregation, it has exactly one type, e.g. terms, stats. The bucket
+// aggregations(terms, histogram, date_histogram, filter, filters) can have sub aggregations which are
+// computed in each bucket.
+type AggregationRequest struct {
+	Terms         *TermsAggregation              `json:"terms,omitempty"`
+	Min           *MetricAggregation             `json:"min,omitempty"`
+	Max           *MetricAggregation             `json:"max,omitempty"`
+	Avg           *MetricAggregation             `json:"avg,omitempty"`
+	Sum           *MetricAggregation             `json:"sum,omitempty"`
+	Stats         *MetricAggregation             `json:"stats,omitempty"`
+	Cardinality   *MetricAggregation             `json:"cardinality,omitempty"`
+	Histogram     *HistogramAggregation          `json:"histogram,omitempty"`
+	DateHistogram *DateHistogramAggregation      `json:"date_histogram,omitempty"`
+	Filter        query.Query                    `json:"filter,omitempty"`
+	Filters       *FiltersAggregation            `json:"filters,omitempty"`
+	Aggregations  map[string]*AggregationRequest `json:"aggregations,omitempty"`
+}
+
+type TermsAggregation struct {
+	Field       string            `json:"field"`
+	Size        int               `json:"size"`          // the number of buckets returned, default 10
+	MinDocCount *int              `json:"min_doc_count"` // default 1
+	Order       map[string]string `json:"order"`         // {"_count": "desc"}(default) or {"_key": "asc"}
+}
+
+type MetricAggregation struct {
+	Field string `json:"field"`
+}
+
+type HistogramAggregation struct {
+	Field       string  `json:"field"`
+	Interval    float64 `json:"interval"`
+	Offset      float64 `json:"offset"`
+	MinDocCount int     `json:"min_doc_count"` // the empty buckets are returned if 0
+}
+
+type DateHistogramAggregation struct {
+	Field            string `json:"field"`
+	FixedInterval    string `json:"fixed_interval"`    // e.g. "30s", "1h", "7d"
+	CalendarInterval string `json:"calendar_interval"` // minute, hour, day, week, month, quarter, year, or "1d", "1M" etc.
+	TimeZone         string `json:"time_zone"`         // e.g. "+08:00", "Asia/Shanghai", default UTC
+	MinDocCount      int    `json:"min_doc_count"`     // the empty buckets are returned if 0
+}
+
+// FiltersAggregation defines the buckets by queries, the Filters can be an object with
+// the bucket names as keys, or an array of the queries which returns the buckets in order.
+type FiltersAggregation struct {
+	Filters    map[string]query.Query `json:"-"`
+	FilterList []query.Query          `json:"-"`
+}
+
+func (r *AggregationRequest) UnmarshalJSON(input []byte) error {
+	var temp struct {
+		Terms         *TermsAggregation              `json:"terms"`
+		Min           *MetricAggregation             `json:"min"`
+		Max           *MetricAggregation             `json:"max"`
+		Avg           *MetricAggregation             `json:"avg"`
+		Sum           *MetricAggregation             `json:"sum"`
+		Stats         *MetricAggregation             `json:"stats"`
+		Cardinality   *MetricAggregation             `json:"cardinality"`
+		Histogram     *HistogramAggregation          `json:"histogram"`
+		DateHistogram *DateHistogramAggregation      `json:"date_histogram"`
+		Filter        stdjson.RawMessage             `json:"filter"`
+		Filters       *FiltersAggregation            `json:"filters"`
+		Aggregations  map[string]*AggregationRequest `json:"aggregations"`
+		Aggs          map[string]*AggregationRequest `json:"aggs"`
+	}
+	if err := json.Unmarshal(input, &temp); err != nil {
+		return err
+	}
+	r.Terms = temp.Terms
+	r.Min = temp.Min
+	r.Max = temp.Max
+	r.Avg = temp.Avg
+	r.Sum = temp.Sum
+	r.Stats = temp.Stats
+	r.Cardinality = temp.Cardinality
+	r.Histogram = temp.Histogram
+	r.DateHistogram = temp.DateHistogram
+	r.Filters = temp.Filters
+	r.Aggregations = temp.Aggregations
+	if r.Aggregations == nil {
+		r.Aggregations = temp.Aggs
+	}
+	if len(temp.Filter) > 0 {
+		q, err := parseQuery(temp.Filter, "filter")
+		if err != nil {
+			return err
+		}
+		r.Filter = q
+	}
+	if r.types() != 1 {
+		return errors.ErrInvalidAggregation
+	}
+	return nil
+}
+
+// types returns the number of aggregation types defined.
+func (r *AggregationRequest) types() int {
+	n := 0
+	for _, defined := range []bool{r.Terms != nil, r.Min != nil, r.Max != nil, r.Avg != nil, r.Sum != nil, r.Stats != nil,
+		r.Cardinality != nil, r.Histogram != nil, r.DateHistogram != nil, r.Filter != nil, r.Filters != nil} {
+		if defined {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *FiltersAggregation) UnmarshalJSON(input []byte) error {
+	var temp struct {
+		Filters stdjson.RawMessage `json:"filters"`
+	}
+	if err := json.Unmarshal(input, &temp); err != nil {
+		return err
+	}
+	var list []stdjson.RawMessage
+	if err := json.Unmarshal(temp.Filters, &list); err == nil {
+		f.FilterList = make([]query.Query, 0, len(list))
+		for i, raw := range list {
+			q, err := parseQuery(raw, fmt.Sprintf("filters[%d]", i))
+			if err != nil {
+				return err
+			}
+			f.FilterList = append(f.FilterList, q)
+		}
+		return nil
+	}
+	var filters map[string]stdjson.RawMessage
+	if err := json.Unmarshal(temp.Filters, &filters); err != nil {
+		return err
+	}
+	f.Filters = make(map[string]query.Query, len(filters))
+	for name, raw := range filters {
+		q, err := parseQuery(raw, "filters."+name)
+		if err != nil {
+			return err
+		}
+		f.Filters[name] = q
+	}
+	return nil
+}
+
+func (f *FiltersAggregation) MarshalJSON() ([]byte, error) {
+	if f.FilterList != nil {
+		return json.Marshal(map[string]interface{}{"filters": f.FilterList})
+	}
+	return json.Marshal(map[string]interface{}{"filters": f.Filters})
+}
+
+// queries returns all the filter queries.
+func (f *FiltersAggregation) queries() []query.Query {
+	if f.FilterList != nil {
+		return f.FilterList
+	}
+	queries := make([]query.Query, 0, len(f.Filters))
+	for _, q := range f.Filters {
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+// aggregation wraps the search query to compute the aggregations of the matched documents. The searcher
+// of each shard feeds its matches to the aggregators, so the aggregations are computed in the same pass
+// and on the same reader as the hits.
+type aggregation struct {
+	query.Query
+	aggs     map[string]aggregator
+	fields   []string
+	filters  []query.Query
+	mappings map[imapping.IndexMapping]*IndexMapping // the index mappings by the mappings of shards
+	mu       sync.Mutex                              // the shards are searched concurrently
+}
+
+// newAggregation creates the aggregation of requests on the documents matching q, the mappings are the
+// index mappings by the mappings of shards searched.
+func newAggregation(q query.Query, requests map[string]*AggregationRequest, mappings map[imapping.IndexMapping]*IndexMapping) (*aggregation, error) {
+	if q == nil {
+		q = bleve.NewMatchAllQuery()
+	}
+	aggs, err := newAggregators(requests)
+	if err != nil {
+		return nil, err
+	}
+	a := &aggregation{Query: q, aggs: aggs, mappings: mappings}
+	fields := make(map[string]bool)
+	walkAggregations(requests, func(r *AggregationRequest) {
+		for _, field := range r.fields() {
+			fields[field] = true
+		}
+		if r.Filter != nil {
+			a.filters = append(a.filters, r.Filter)
+		}
+		if r.Filters != nil {
+			a.filters = append(a.filters, r.Filters.queries()...)
+		}
+	})
+	for field := range fields {
+		a.fields = append(a.fields, field)
+	}
+	return a, nil
+}
+
+func (a *aggregation) Searcher(reader bindex.IndexReader, mapping imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	ctx := &aggContext{mapping: a.mappings[mapping], filters: make(map[query.Query]map[string]struct{}, len(a.filters))}
+	for _, filter := range a.filters {
+		ids := make(map[string]struct{})
+		if err := visitMatches(reader, mapping, filter, func(id bindex.IndexInternalID) error {
+			ids[string(id)] = struct{}{}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		ctx.filters[filter] = ids
+	}
+	dvReader, err := reader.DocValueReader(a.fields)
+	if err != nil {
+		return nil, err
+	}
+	searcher, err := a.Query.Searcher(reader, mapping, options)
+	if err != nil {
+		return nil, err
+	}
+	return &aggSearcher{Searcher: searcher, aggregation: a, ctx: ctx, dvReader: dvReader}, nil
+}
+
+// results returns the results of aggregations after the search.
+func (a *aggregation) results() (map[string]interface{}, error) {
+	results := make(map[string]interface{}, len(a.aggs))
+	for name, agg := range a.aggs {
+		result, err := agg.result()
+		if err != nil {
+			return nil, fmt.Errorf("aggregation [%s]: %w", name, err)
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+// walkAggregations calls fn with each aggregation request and its sub aggregations.
+func walkAggregations(requests map[string]*AggregationRequest, fn func(r *AggregationRequest)) {
+	for _, r := range requests {
+		fn(r)
+		walkAggregations(r.Aggregations, fn)
+	}
+}
+
+// fields returns the fields whose values are needed by the aggregation.
+func (r *AggregationRequest) fields() []string {
+	var field string
+	switch {
+	case r.Terms != nil:
+		field = r.Terms.Field
+	case r.Histogram != nil:
+		field = r.Histogram.Field
+	case r.DateHistogram != nil:
+		field = r.DateHistogram.Field
+	default:
+		for _, m := range []*MetricAggregation{r.Min, r.Max, r.Avg, r.Sum, r.Stats, r.Cardinality} {
+			if m != nil {
+				field = m.Field
+			}
+		}
+	}
+	if len(field) == 0 {
+		return nil
+	}
+	return []string{field}
+}
+
+// aggContext provides the mapping and the filter matches of the shard being collected.
+type aggContext struct {
+	mapping *IndexMapping
+	filters map[query.Query]map[string]struct{} // the internal ids of documents matching the filters
+}
+
+// aggDoc is a matched document with the values of fields needed by aggregations.
+type aggDoc struct {
+	id     string
+	values map[string][][]byte
+	ctx    *aggContext
+}
+
+// aggSearcher feeds the matches of the wrapped searcher to the aggregators.
+type aggSearcher struct {
+	search.Searcher
+	aggregation *aggregation
+	ctx         *aggContext
+	dvReader    bindex.DocValueReader
+}
+
+func (s *aggSearcher) Next(sctx *search.SearchContext) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Next(sctx)
+	if err == nil && dm != nil {
+		err = s.collect(dm.IndexInternalID)
+	}
+	return dm, err
+}
+
+func (s *aggSearcher) Advance(sctx *search.SearchContext, id bindex.IndexInternalID) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Advance(sctx, id)
+	if err == nil && dm != nil {
+		err = s.collect(dm.IndexInternalID)
+	}
+	return dm, err
+}
+
+func (s *aggSearcher) collect(id bindex.IndexInternalID) error {
+	fields := s.aggregation.fields
+	doc := &aggDoc{id: string(id), values: make(map[string][][]byte, len(fields)), ctx: s.ctx}
+	if len(fields) > 0 {
+		if err := s.dvReader.VisitDocValues(id, func(field string, term []byte) {
+			doc.values[field] = append(doc.values[field], append([]byte(nil), term...))
+		}); err != nil {
+			return err
+		}
+	}
+	s.aggregation.mu.Lock()
+	defer s.aggregation.mu.Unlock()
+	for _, agg := range s.aggregation.aggs {
+		agg.collect(doc)
+	}
+	return nil
+}
+
+// visitMatches calls fn with the internal id of each document matching q.
+func visitMatches(reader bindex.IndexReader, mapping imapping.IndexMapping, q query.Query, fn func(id bindex.IndexInternalID) error) error {
+	searcher, err := q.Searcher(reader, mapping, search.SearcherOptions{})
+	if err != nil {
+		return err
+	}
+	defer searcher.Close()
+	sctx := &search.SearchContext{DocumentMatchPool: search.NewDocumentMatchPool(searcher.DocumentMatchPoolSize(), 0)}
+	dm, err := searcher.Next(sctx)
+	for err == nil && dm != nil {
+		if err = fn(dm.IndexInternalID); err != nil {
+			return err
+		}
+		sctx.DocumentMatchPool.Put(dm)
+		dm, err = searcher.Next(sctx)
+	}
+	return err
+}
+
+// fieldType returns the type of field in mapping, empty if the field is not mapped explicitly.
+func (im *IndexMapping) fieldType(field string) string {
+	if im == nil || im.DefaultMapping == nil {
+		return ""
+	}
+	dm := im.DefaultMapping
+	for _, name := range strings.Split(field, ".") {
+		if dm = dm.Properties[name]; dm == nil {
+			return ""
+		}
+	}
+	if len(dm.Fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(dm.Fields[0].Type)
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"errors"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"reflect"
+	"testing"
+)
+
+const aggMapping = `{
+    "default_mapping": {
+        "properties": {
+            "tag": {"fields": [{"type": "keyword"}]},
+            "price": {"fields": [{"type": "number"}]},
+            "created": {"fields": [{"type": "datetime"}]}
+        }
+    }
+}`
+
+func TestAggregations(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(aggMapping), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	docs := []map[string]interface{}{
+		{"tag": "a", "price": 5, "created": "2022-01-01T10:00:00Z"},
+		{"tag": "a", "price": 15, "created": "2022-01-01T20:00:00Z"},
+		{"tag": "b", "price": 25, "created": "2022-01-03T10:00:00Z"},
+		{"tag": "a", "price": 35, "created": "2022-02-01T10:00:00Z"},
+		{"tag": "c", "price": 8, "created": "2022-03-01T10:00:00Z"},
+	}
+	for i, doc := range docs {
+		if _, err := index.IndexOrUpdateDocument(string(rune('1'+i)), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := &SearchRequest{}
+	if err := json.Unmarshal([]byte(`{
+    "query": {"match_all": {}},
+    "aggs": {
+        "tags": {
+            "terms": {"field": "tag", "size": 2},
+            "aggs": {"avg_price": {"avg": {"field": "price"}}}
+        },
+        "price_stats": {"stats": {"field": "price"}},
+        "latest": {"max": {"field": "created"}},
+        "tag_count": {"cardinality": {"field": "tag"}},
+        "prices": {"histogram": {"field": "price", "interval": 10}},
+        "days": {"date_histogram": {"field": "created", "fixed_interval": "1d", "min_doc_count": 1}},
+        "months": {"date_histogram": {"field": "created", "calendar_interval": "month", "time_zone": "+08:00"}},
+        "cheap": {"filter": {"max": 10, "field": "price"}},
+        "groups": {"filters": {"filters": {"a": {"term": "a", "field": "tag"}, "b": {"term": "b", "field": "tag"}}}}
+    }
+}`), req); err != nil {
+		t.Fatal(err)
+	}
+	res, err := index.Search(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("aggregations", res.Aggregations)
+
+	// compares the results in JSON form.
+	var aggs map[string]interface{}
+	data, err := json.Marshal(res.Aggregations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &aggs); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"tags": map[string]interface{}{
+			"doc_count_error_upper_bound": 0.0,
+			"sum_other_doc_count":         1.0,
+			"buckets": []interface{}{
+				map[string]interface{}{"key": "a", "doc_count": 3.0, "avg_price": map[string]interface{}{"value": 55.0 / 3}},
+				map[string]interface{}{"key": "b", "doc_count": 1.0, "avg_price": map[string]interface{}{"value": 25.0}},
+			},
+		},
+		"price_stats": map[string]interface{}{"count": 5.0, "min": 5.0, "max": 35.0, "avg": 17.6, "sum": 88.0},
+		"latest":      map[string]interface{}{"value": 1646128800000.0, "value_as_string": "2022-03-01T10:00:00.000Z"},
+		"tag_count":   map[string]interface{}{"value": 3.0},
+		"prices": map[string]interface{}{"buckets": []interface{}{
+			map[string]interface{}{"key": 0.0, "doc_count": 2.0},
+			map[string]interface{}{"key": 10.0, "doc_count": 1.0},
+			map[string]interface{}{"key": 20.0, "doc_count": 1.0},
+			map[string]interface{}{"key": 30.0, "doc_count": 1.0},
+		}},
+		"days": map[string]interface{}{"buckets": []interface{}{
+			map[string]interface{}{"key": 1640995200000.0, "key_as_string": "2022-01-01T00:00:00.000Z", "doc_count": 2.0},
+			map[string]interface{}{"key": 1641168000000.0, "key_as_string": "2022-01-03T00:00:00.000Z", "doc_count": 1.0},
+			map[string]interface{}{"key": 1643673600000.0, "key_as_string": "2022-02-01T00:00:00.000Z", "doc_count": 1.0},
+			map[string]interface{}{"key": 1646092800000.0, "key_as_string": "2022-03-01T00:00:00.000Z", "doc_count": 1.0},
+		}},
+		"months": map[string]interface{}{"buckets": []interface{}{
+			map[string]interface{}{"key": 1640966400000.0, "key_as_string": "2022-01-01T00:00:00.000+08:00", "doc_count": 3.0},
+			map[string]interface{}{"key": 1643644800000.0, "key_as_string": "2022-02-01T00:00:00.000+08:00", "doc_count": 1.0},
+			map[string]interface{}{"key": 1646064000000.0, "key_as_string": "2022-03-01T00:00:00.000+08:00", "doc_count": 1.0},
+		}},
+		"cheap": map[string]interface{}{"doc_count": 2.0},
+		"groups": map[string]interface{}{"buckets": map[string]interface{}{
+			"a": map[string]interface{}{"doc_count": 3.0},
+			"b": map[string]interface{}{"doc_count": 1.0},
+		}},
+	}
+	for name, e := range expected {
+		if !reflect.DeepEqual(aggs[name], e) {
+			t.Errorf("aggregation %s: %v, expected %v", name, aggs[name], e)
+		}
+	}
+
+	// the invalid aggregations are rejected.
+	if err := json.Unmarshal([]byte(`{"aggs": {"bad": {"min": {"field": "price"}, "max": {"field": "price"}}}}`), &SearchRequest{}); err == nil {
+		t.Error("aggregation with two types is accepted")
+	}
+	req.Aggregations = map[string]*AggregationRequest{"bad": {Histogram: &HistogramAggregation{Field: "price"}}}
+	if _, err := index.Search(req); err == nil {
+		t.Error("histogram without interval is accepted")
+	}
+
+	// the empty buckets are filled by the bucket indices, none of the collected buckets is dropped.
+	req.Aggregations = map[string]*AggregationRequest{"fine": {Histogram: &HistogramAggregation{Field: "price", Interval: 0.1, Offset: 0.03}}}
+	if res, err = index.Search(req); err != nil {
+		t.Fatal(err)
+	}
+	buckets := res.Aggregations["fine"].(map[string]interface{})["buckets"].([]map[string]interface{})
+	var count int64
+	for _, b := range buckets {
+		count += b["doc_count"].(int64)
+	}
+	if len(buckets) != 301 || count != 5 {
+		t.Errorf("histogram with interval 0.1 returns %d buckets of %d docs, expected 301 buckets of 5 docs", len(buckets), count)
+	}
+	req.Aggregations = map[string]*AggregationRequest{"fine": {Histogram: &HistogramAggregation{Field: "price", Interval: 0.0001}}}
+	if _, err := index.Search(req); !errors.Is(err, qerrors.ErrTooManyBuckets) {
+		t.Errorf("histogram with too many buckets: %v, expected %v", err, qerrors.ErrTooManyBuckets)
+	}
+
+	// the aggregations are collected with the hits, only on the matched documents.
+	if err := json.Unmarshal([]byte(`{
+    "query": {"term": "a", "field": "tag"},
+    "size": 1,
+    "aggs": {"price_stats": {"stats": {"field": "price"}}}
+}`), req); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = index.Search(req); err != nil {
+		t.Fatal(err)
+	}
+	if stats := res.Aggregations["price_stats"].(map[string]interface{}); res.TotalHits != 3 || stats["count"] != int64(3) || stats["sum"] != 55.0 {
+		t.Errorf("aggregation on %d hits: %v, expected count 3 and sum 55", res.TotalHits, stats)
+	}
+}
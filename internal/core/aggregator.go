@@ -0,0 +1,720 @@
+package core
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBuckets limits the number of buckets returned by a histogram aggregation, including the empty ones.
+const maxBuckets = 65535
+
+// dateFormat is the format of `key_as_string` and `value_as_string` for datetime values.
+const dateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// aggregator collects the matched documents and computes the result of an aggregation.
+type aggregator interface {
+	collect(doc *aggDoc)
+	result() (map[string]interface{}, error)
+}
+
+// newAggregators creates the aggregators of requests, the requests are validated.
+func newAggregators(requests map[string]*AggregationRequest) (map[string]aggregator, error) {
+	aggs := make(map[string]aggregator, len(requests))
+	for name, r := range requests {
+		agg, err := newAggregator(r)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation [%s]: %s", name, err)
+		}
+		aggs[name] = agg
+	}
+	return aggs, nil
+}
+
+func newAggregator(r *AggregationRequest) (aggregator, error) {
+	if r == nil || r.types() != 1 {
+		return nil, fmt.Errorf("must have exactly one aggregation type")
+	}
+	// validates the sub aggregations, the aggregators of buckets are created when collecting.
+	if _, err := newAggregators(r.Aggregations); err != nil {
+		return nil, err
+	}
+	if field := r.fields(); r.Filter == nil && r.Filters == nil && len(field) == 0 {
+		return nil, fmt.Errorf("field is required")
+	}
+	switch {
+	case r.Terms != nil:
+		return newTermsAggregator(r)
+	case r.Histogram != nil:
+		if r.Histogram.Interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+		return &histogramAggregator{req: r, buckets: make(map[int64]*bucket)}, nil
+	case r.DateHistogram != nil:
+		return newDateHistogramAggregator(r)
+	case r.Filter != nil:
+		return &filterAggregator{filter: r.Filter, bucket: newBucket(nil, r.Aggregations)}, nil
+	case r.Filters != nil:
+		return newFiltersAggregator(r), nil
+	case r.Cardinality != nil:
+		return &cardinalityAggregator{field: r.Cardinality.Field, values: make(map[interface{}]struct{})}, nil
+	}
+	agg := &metricAggregator{min: math.Inf(1), max: math.Inf(-1)}
+	switch {
+	case r.Min != nil:
+		agg.typ, agg.field = "min", r.Min.Field
+	case r.Max != nil:
+		agg.typ, agg.field = "max", r.Max.Field
+	case r.Avg != nil:
+		agg.typ, agg.field = "avg", r.Avg.Field
+	case r.Sum != nil:
+		agg.typ, agg.field = "sum", r.Sum.Field
+	case r.Stats != nil:
+		agg.typ, agg.field = "stats", r.Stats.Field
+	}
+	return agg, nil
+}
+
+// numbers returns the numeric values of field, the datetime values are returned in milliseconds.
+func (d *aggDoc) numbers(field string) []float64 {
+	datetime := d.ctx.mapping.fieldType(field) == "datetime"
+	values := make([]float64, 0, len(d.values[field]))
+	for _, term := range d.values[field] {
+		i, ok := prefixCodedInt64(term)
+		if !ok {
+			continue
+		}
+		if datetime {
+			values = append(values, float64(i/int64(time.Millisecond)))
+		} else {
+			values = append(values, numeric.Int64ToFloat64(i))
+		}
+	}
+	return values
+}
+
+// keys returns the distinct values of field as bucket keys, the numbers are float64, the datetime
+// values are milliseconds in float64, the booleans are bool, and the others are string.
+func (d *aggDoc) keys(field string) []interface{} {
+	typ := d.ctx.mapping.fieldType(field)
+	keys := make([]interface{}, 0, len(d.values[field]))
+	seen := make(map[interface{}]bool, len(d.values[field]))
+	for _, term := range d.values[field] {
+		var key interface{}
+		switch typ {
+		case "number", "datetime":
+			i, ok := prefixCodedInt64(term)
+			if !ok {
+				continue
+			}
+			if typ == "datetime" {
+				key = float64(i / int64(time.Millisecond))
+			} else {
+				key = numeric.Int64ToFloat64(i)
+			}
+		case "boolean":
+			key = string(term) == "T"
+		case "":
+			// the dynamic fields, numbers are indexed as prefix coded terms with different shifts.
+			if valid, shift := numeric.ValidPrefixCodedTermBytes(term); valid {
+				if shift != 0 {
+					continue
+				}
+				i, _ := numeric.PrefixCoded(term).Int64()
+				key = numeric.Int64ToFloat64(i)
+			} else {
+				key = string(term)
+			}
+		default:
+			key = string(term)
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// prefixCodedInt64 decodes the full precision term of numeric and datetime fields.
+func prefixCodedInt64(term []byte) (int64, bool) {
+	valid, shift := numeric.ValidPrefixCodedTermBytes(term)
+	if !valid || shift != 0 {
+		return 0, false
+	}
+	i, err := numeric.PrefixCoded(term).Int64()
+	return i, err == nil
+}
+
+// matches reports whether the document matches the filter.
+func (d *aggDoc) matches(filter query.Query) bool {
+	_, ok := d.ctx.filters[filter][d.id]
+	return ok
+}
+
+// bucket is a set of documents with the sub aggregations computed on them.
+type bucket struct {
+	key   interface{}
+	count int64
+	subs  map[string]aggregator
+}
+
+func newBucket(key interface{}, requests map[string]*AggregationRequest) *bucket {
+	// the requests are already validated.
+	subs, _ := newAggregators(requests)
+	return &bucket{key: key, subs: subs}
+}
+
+func (b *bucket) collect(doc *aggDoc) {
+	b.count++
+	for _, sub := range b.subs {
+		sub.collect(doc)
+	}
+}
+
+// result returns the doc count and the results of sub aggregations of bucket.
+func (b *bucket) result() (map[string]interface{}, error) {
+	result := map[string]interface{}{"doc_count": b.count}
+	for name, sub := range b.subs {
+		r, err := sub.result()
+		if err != nil {
+			return nil, fmt.Errorf("aggregation [%s]: %w", name, err)
+		}
+		result[name] = r
+	}
+	return result, nil
+}
+
+type metricAggregator struct {
+	typ      string
+	field    string
+	datetime bool
+	count    int64
+	sum      float64
+	min      float64
+	max      float64
+}
+
+func (a *metricAggregator) collect(doc *aggDoc) {
+	for _, v := range doc.numbers(a.field) {
+		a.datetime = doc.ctx.mapping.fieldType(a.field) == "datetime"
+		a.count++
+		a.sum += v
+		a.min = math.Min(a.min, v)
+		a.max = math.Max(a.max, v)
+	}
+}
+
+func (a *metricAggregator) result() (map[string]interface{}, error) {
+	var min, max, avg interface{}
+	if a.count > 0 {
+		min, max, avg = a.min, a.max, a.sum/float64(a.count)
+	}
+	switch a.typ {
+	case "min":
+		return a.value(min), nil
+	case "max":
+		return a.value(max), nil
+	case "avg":
+		return a.value(avg), nil
+	case "sum":
+		return map[string]interface{}{"value": a.sum}, nil
+	}
+	return map[string]interface{}{"count": a.count, "min": min, "max": max, "avg": avg, "sum": a.sum}, nil
+}
+
+// value returns the single value result, the datetime value is also formatted as string.
+func (a *metricAggregator) value(v interface{}) map[string]interface{} {
+	result := map[string]interface{}{"value": v}
+	if ms, ok := v.(float64); ok && a.datetime {
+		result["value_as_string"] = time.UnixMilli(int64(ms)).UTC().Format(dateFormat)
+	}
+	return result
+}
+
+type cardinalityAggregator struct {
+	field  string
+	values map[interface{}]struct{}
+}
+
+func (a *cardinalityAggregator) collect(doc *aggDoc) {
+	for _, key := range doc.keys(a.field) {
+		a.values[key] = struct{}{}
+	}
+}
+
+func (a *cardinalityAggregator) result() (map[string]interface{}, error) {
+	return map[string]interface{}{"value": len(a.values)}, nil
+}
+
+type termsAggregator struct {
+	req         *AggregationRequest
+	size        int
+	minDocCount int64
+	orderByKey  bool
+	asc         bool
+	typ         string
+	buckets     map[interface{}]*bucket
+}
+
+func newTermsAggregator(r *AggregationRequest) (*termsAggregator, error) {
+	agg := &termsAggregator{req: r, size: r.Terms.Size, minDocCount: 1, buckets: make(map[interface{}]*bucket)}
+	if agg.size <= 0 {
+		agg.size = 10
+	}
+	if r.Terms.MinDocCount != nil {
+		agg.minDocCount = int64(*r.Terms.MinDocCount)
+	}
+	for key, order := range r.Terms.Order {
+		switch key {
+		case "_count":
+		case "_key":
+			agg.orderByKey = true
+		default:
+			return nil, fmt.Errorf("unsupported order [%s]", key)
+		}
+		switch strings.ToLower(order) {
+		case "asc":
+			agg.asc = true
+		case "desc":
+		default:
+			return nil, fmt.Errorf("unsupported order [%s]", order)
+		}
+	}
+	if len(r.Terms.Order) > 1 {
+		return nil, fmt.Errorf("only one order is supported")
+	}
+	return agg, nil
+}
+
+func (a *termsAggregator) collect(doc *aggDoc) {
+	a.typ = doc.ctx.mapping.fieldType(a.req.Terms.Field)
+	for _, key := range doc.keys(a.req.Terms.Field) {
+		b := a.buckets[key]
+		if b == nil {
+			b = newBucket(key, a.req.Aggregations)
+			a.buckets[key] = b
+		}
+		b.collect(doc)
+	}
+}
+
+func (a *termsAggregator) result() (map[string]interface{}, error) {
+	buckets := make([]*bucket, 0, len(a.buckets))
+	for _, b := range a.buckets {
+		if b.count >= a.minDocCount {
+			buckets = append(buckets, b)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if !a.orderByKey && buckets[i].count != buckets[j].count {
+			return (buckets[i].count < buckets[j].count) == a.asc
+		}
+		if a.orderByKey && !a.asc {
+			return compareKeys(buckets[j].key, buckets[i].key)
+		}
+		return compareKeys(buckets[i].key, buckets[j].key)
+	})
+	var others int64
+	if len(buckets) > a.size {
+		for _, b := range buckets[a.size:] {
+			others += b.count
+		}
+		buckets = buckets[:a.size]
+	}
+	results := make([]map[string]interface{}, 0, len(buckets))
+	for _, b := range buckets {
+		result, err := b.result()
+		if err != nil {
+			return nil, err
+		}
+		result["key"] = b.key
+		switch key := b.key.(type) {
+		case bool:
+			result["key"], result["key_as_string"] = 0, "false"
+			if key {
+				result["key"], result["key_as_string"] = 1, "true"
+			}
+		case float64:
+			if a.typ == "datetime" {
+				result["key_as_string"] = time.UnixMilli(int64(key)).UTC().Format(dateFormat)
+			}
+		}
+		results = append(results, result)
+	}
+	return map[string]interface{}{
+		"doc_count_error_upper_bound": 0,
+		"sum_other_doc_count":         others,
+		"buckets":                     results,
+	}, nil
+}
+
+// compareKeys returns whether key a is less than b, numbers are ordered before strings.
+func compareKeys(a, b interface{}) bool {
+	switch ka := a.(type) {
+	case float64:
+		if kb, ok := b.(float64); ok {
+			return ka < kb
+		}
+		return true
+	case bool:
+		if kb, ok := b.(bool); ok {
+			return !ka && kb
+		}
+		_, ok := b.(string)
+		return ok
+	case string:
+		if kb, ok := b.(string); ok {
+			return ka < kb
+		}
+	}
+	return false
+}
+
+type histogramAggregator struct {
+	req     *AggregationRequest
+	buckets map[int64]*bucket // the key is the index of bucket, floor((value-offset)/interval)
+}
+
+func (a *histogramAggregator) collect(doc *aggDoc) {
+	h := a.req.Histogram
+	seen := make(map[int64]bool)
+	for _, v := range doc.numbers(h.Field) {
+		i := int64(math.Floor((v - h.Offset) / h.Interval))
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		b := a.buckets[i]
+		if b == nil {
+			b = newBucket(nil, a.req.Aggregations)
+			a.buckets[i] = b
+		}
+		b.collect(doc)
+	}
+}
+
+func (a *histogramAggregator) result() (map[string]interface{}, error) {
+	h := a.req.Histogram
+	indices := make([]int64, 0, len(a.buckets))
+	for i := range a.buckets {
+		indices = append(indices, i)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	// fills the empty buckets between the min and max keys.
+	if h.MinDocCount <= 0 && len(indices) > 1 {
+		first, last := indices[0], indices[len(indices)-1]
+		if last-first >= maxBuckets {
+			return nil, tooManyBuckets()
+		}
+		indices = indices[:0]
+		for i := first; i <= last; i++ {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) > maxBuckets {
+		return nil, tooManyBuckets()
+	}
+	results := make([]map[string]interface{}, 0, len(indices))
+	for _, i := range indices {
+		b := a.buckets[i]
+		if b == nil {
+			b = newBucket(nil, a.req.Aggregations)
+		}
+		if b.count < int64(h.MinDocCount) {
+			continue
+		}
+		result, err := b.result()
+		if err != nil {
+			return nil, err
+		}
+		// computes the key from the index to avoid the accumulated error of floats.
+		result["key"] = float64(i)*h.Interval + h.Offset
+		results = append(results, result)
+	}
+	return map[string]interface{}{"buckets": results}, nil
+}
+
+// tooManyBuckets returns the error of a histogram aggregation with more than maxBuckets buckets.
+func tooManyBuckets() error {
+	return fmt.Errorf("%w, must be less than or equal to [%d]", errors.ErrTooManyBuckets, maxBuckets)
+}
+
+type dateHistogramAggregator struct {
+	req      *AggregationRequest
+	location *time.Location
+	round    func(t time.Time) time.Time // returns the start of bucket which t belongs to
+	next     func(t time.Time) time.Time // returns the start of next bucket
+	buckets  map[int64]*bucket           // the key is the start of bucket in nanoseconds
+}
+
+func newDateHistogramAggregator(r *AggregationRequest) (*dateHistogramAggregator, error) {
+	h := r.DateHistogram
+	agg := &dateHistogramAggregator{req: r, buckets: make(map[int64]*bucket)}
+	var err error
+	if agg.location, err = parseTimeZone(h.TimeZone); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(h.FixedInterval) > 0 && len(h.CalendarInterval) > 0:
+		return nil, fmt.Errorf("only one of fixed_interval and calendar_interval can be set")
+	case len(h.FixedInterval) > 0:
+		interval, err := parseFixedInterval(h.FixedInterval)
+		if err != nil {
+			return nil, err
+		}
+		agg.round = func(t time.Time) time.Time {
+			// the buckets are aligned to the local time of time zone.
+			_, offset := t.In(agg.location).Zone()
+			local := t.UnixNano() + int64(offset)*int64(time.Second)
+			start := local - local%int64(interval)
+			if local%int64(interval) < 0 {
+				start -= int64(interval)
+			}
+			return time.Unix(0, start-int64(offset)*int64(time.Second))
+		}
+		agg.next = func(t time.Time) time.Time {
+			return agg.round(t.Add(interval))
+		}
+	case len(h.CalendarInterval) > 0:
+		if agg.round, agg.next, err = calendarInterval(h.CalendarInterval, agg.location); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("fixed_interval or calendar_interval is required")
+	}
+	return agg, nil
+}
+
+// parseTimeZone parses the offset like "+08:00" or the name like "Asia/Shanghai".
+func parseTimeZone(tz string) (*time.Location, error) {
+	if len(tz) == 0 {
+		return time.UTC, nil
+	}
+	if tz[0] == '+' || tz[0] == '-' {
+		t, err := time.Parse("-07:00", tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time_zone [%s]", tz)
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(tz, offset), nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time_zone [%s]", tz)
+	}
+	return loc, nil
+}
+
+// parseFixedInterval parses the interval like "500ms", "30s", "1m", "1h", "7d".
+func parseFixedInterval(interval string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{{"ms", time.Millisecond}, {"s", time.Second}, {"m", time.Minute}, {"h", time.Hour}, {"d", 24 * time.Hour}}
+	for _, u := range units {
+		if !strings.HasSuffix(interval, u.suffix) {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(interval, u.suffix), 10, 64)
+		if err != nil || n <= 0 {
+			break
+		}
+		return time.Duration(n) * u.unit, nil
+	}
+	return 0, fmt.Errorf("invalid fixed_interval [%s]", interval)
+}
+
+// calendarInterval returns the round and next functions of calendar interval in loc.
+func calendarInterval(interval string, loc *time.Location) (round, next func(t time.Time) time.Time, err error) {
+	switch interval {
+	case "minute", "1m":
+		round = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		}
+		next = func(t time.Time) time.Time { return round(t.Add(time.Minute)) }
+	case "hour", "1h":
+		round = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		next = func(t time.Time) time.Time { return round(t.Add(time.Hour)) }
+	case "day", "1d":
+		round = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		next = func(t time.Time) time.Time { return round(t).AddDate(0, 0, 1) }
+	case "week", "1w":
+		round = func(t time.Time) time.Time {
+			// the weeks start on Monday.
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+		}
+		next = func(t time.Time) time.Time { return round(t).AddDate(0, 0, 7) }
+	case "month", "1M":
+		round = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		next = func(t time.Time) time.Time { return round(t).AddDate(0, 1, 0) }
+	case "quarter", "1q":
+		round = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), (t.Month()-1)/3*3+1, 1, 0, 0, 0, 0, loc)
+		}
+		next = func(t time.Time) time.Time { return round(t).AddDate(0, 3, 0) }
+	case "year", "1y":
+		round = func(t time.Time) time.Time {
+			return time.Date(t.In(loc).Year(), 1, 1, 0, 0, 0, 0, loc)
+		}
+		next = func(t time.Time) time.Time { return round(t).AddDate(1, 0, 0) }
+	default:
+		err = fmt.Errorf("invalid calendar_interval [%s]", interval)
+	}
+	return
+}
+
+func (a *dateHistogramAggregator) collect(doc *aggDoc) {
+	field := a.req.DateHistogram.Field
+	seen := make(map[int64]bool)
+	for _, term := range doc.values[field] {
+		nanos, ok := prefixCodedInt64(term)
+		if !ok {
+			continue
+		}
+		key := a.round(time.Unix(0, nanos)).UnixNano()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		b := a.buckets[key]
+		if b == nil {
+			b = newBucket(key, a.req.Aggregations)
+			a.buckets[key] = b
+		}
+		b.collect(doc)
+	}
+}
+
+func (a *dateHistogramAggregator) result() (map[string]interface{}, error) {
+	h := a.req.DateHistogram
+	keys := make([]int64, 0, len(a.buckets))
+	for key := range a.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	// fills the empty buckets between the min and max keys.
+	if h.MinDocCount <= 0 && len(keys) > 1 {
+		filled := make([]int64, 0, len(keys))
+		last := keys[len(keys)-1]
+		for t := time.Unix(0, keys[0]); t.UnixNano() <= last; t = a.next(t) {
+			if len(filled) == maxBuckets {
+				return nil, tooManyBuckets()
+			}
+			filled = append(filled, t.UnixNano())
+		}
+		keys = filled
+	}
+	if len(keys) > maxBuckets {
+		return nil, tooManyBuckets()
+	}
+	results := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		b := a.buckets[key]
+		if b == nil {
+			b = newBucket(key, a.req.Aggregations)
+		}
+		if b.count < int64(h.MinDocCount) {
+			continue
+		}
+		result, err := b.result()
+		if err != nil {
+			return nil, err
+		}
+		t := time.Unix(0, key).In(a.location)
+		result["key"] = t.UnixMilli()
+		result["key_as_string"] = t.Format(dateFormat)
+		results = append(results, result)
+	}
+	return map[string]interface{}{"buckets": results}, nil
+}
+
+type filterAggregator struct {
+	filter query.Query
+	bucket *bucket
+}
+
+func (a *filterAggregator) collect(doc *aggDoc) {
+	if doc.matches(a.filter) {
+		a.bucket.collect(doc)
+	}
+}
+
+func (a *filterAggregator) result() (map[string]interface{}, error) {
+	return a.bucket.result()
+}
+
+type filtersAggregator struct {
+	req     *FiltersAggregation
+	filters []query.Query
+	names   []string // the bucket names of keyed filters
+	buckets []*bucket
+}
+
+func newFiltersAggregator(r *AggregationRequest) *filtersAggregator {
+	agg := &filtersAggregator{req: r.Filters}
+	if r.Filters.FilterList != nil {
+		agg.filters = r.Filters.FilterList
+	} else {
+		for name := range r.Filters.Filters {
+			agg.names = append(agg.names, name)
+		}
+		sort.Strings(agg.names)
+		for _, name := range agg.names {
+			agg.filters = append(agg.filters, r.Filters.Filters[name])
+		}
+	}
+	for range agg.filters {
+		agg.buckets = append(agg.buckets, newBucket(nil, r.Aggregations))
+	}
+	return agg
+}
+
+func (a *filtersAggregator) collect(doc *aggDoc) {
+	for i, filter := range a.filters {
+		if doc.matches(filter) {
+			a.buckets[i].collect(doc)
+		}
+	}
+}
+
+func (a *filtersAggregator) result() (map[string]interface{}, error) {
+	if a.req.FilterList != nil {
+		buckets := make([]map[string]interface{}, 0, len(a.buckets))
+		for _, b := range a.buckets {
+			result, err := b.result()
+			if err != nil {
+				return nil, err
+			}
+			buckets = append(buckets, result)
+		}
+		return map[string]interface{}{"buckets": buckets}, nil
+	}
+	buckets := make(map[string]interface{}, len(a.buckets))
+	for i, b := range a.buckets {
+		result, err := b.result()
+		if err != nil {
+			return nil, err
+		}
+		buckets[a.names[i]] = result
+	}
+	return map[string]interface{}{"buckets": buckets}, nil
+}
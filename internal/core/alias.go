@@ -0,0 +1,302 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"github.com/feimingxliu/quicksearch/pkg/util/slices"
+	"sort"
+)
+
+// Alias is a named pointer to one or more indices.
+type Alias struct {
+	Name       string   `json:"name"`
+	Indices    []string `json:"indices"`
+	WriteIndex string   `json:"write_index,omitempty"` // the index which receives the writes through the alias
+}
+
+type AliasAction struct {
+	Add    *AliasActionDetail `json:"add,omitempty"`
+	Remove *AliasActionDetail `json:"remove,omitempty"`
+}
+
+type AliasActionDetail struct {
+	Index        string `json:"index"`
+	Alias        string `json:"alias"`
+	IsWriteIndex *bool  `json:"is_write_index,omitempty"`
+}
+
+// writeIndex returns the index which receives the writes through the alias,
+// an alias pointing to a single index writes to that index implicitly.
+func (a *Alias) writeIndex() string {
+	if a.WriteIndex != "" {
+		return a.WriteIndex
+	}
+	if len(a.Indices) == 1 {
+		return a.Indices[0]
+	}
+	return ""
+}
+
+func (a *Alias) clone() *Alias {
+	c := &Alias{
+		Name:       a.Name,
+		Indices:    make([]string, len(a.Indices)),
+		WriteIndex: a.WriteIndex,
+	}
+	copy(c.Indices, a.Indices)
+	return c
+}
+
+// ListAliases returns all aliases sorted by name.
+func ListAliases() []*Alias {
+	engine.aliasMu.RLock()
+	aliases := make([]*Alias, 0, len(engine.aliases))
+	for _, alias := range engine.aliases {
+		aliases = append(aliases, alias.clone())
+	}
+	engine.aliasMu.RUnlock()
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Name < aliases[j].Name
+	})
+	return aliases
+}
+
+// GetAlias returns the alias with the name.
+func GetAlias(name string) (*Alias, error) {
+	alias := engine.getAlias(name)
+	if alias == nil {
+		return nil, errors.ErrAliasNotFound
+	}
+	return alias, nil
+}
+
+// UpdateAliases applies the actions atomically, either all the actions take effect or none of them.
+func UpdateAliases(actions []*AliasAction) error {
+	engine.aliasMu.Lock()
+	defer engine.aliasMu.Unlock()
+	// apply the actions to a copy, so the failed actions leave nothing behind.
+	aliases := engine.cloneAliases()
+	changed := make(map[string]bool)
+	for _, action := range actions {
+		if action == nil {
+			return errors.ErrInvalidAliasAction
+		}
+		switch {
+		case action.Add != nil && action.Remove == nil:
+			detail := action.Add
+			if detail.Index == "" || detail.Alias == "" {
+				return errors.ErrInvalidAliasAction
+			}
+			if !indexExists(detail.Index) {
+				return errors.ErrIndexNotFound
+			}
+			if indexExists(detail.Alias) {
+				return errors.ErrIndexAliasConflict
+			}
+			alias := aliases[detail.Alias]
+			if alias == nil {
+				alias = &Alias{Name: detail.Alias}
+				aliases[detail.Alias] = alias
+			}
+			if !slices.ContainsStr(alias.Indices, detail.Index) {
+				alias.Indices = append(alias.Indices, detail.Index)
+			}
+			if detail.IsWriteIndex != nil {
+				if *detail.IsWriteIndex {
+					alias.WriteIndex = detail.Index
+				} else if alias.WriteIndex == detail.Index {
+					alias.WriteIndex = ""
+				}
+			}
+			changed[detail.Alias] = true
+		case action.Remove != nil && action.Add == nil:
+			detail := action.Remove
+			if detail.Index == "" || detail.Alias == "" {
+				return errors.ErrInvalidAliasAction
+			}
+			alias := aliases[detail.Alias]
+			if alias == nil || !slices.ContainsStr(alias.Indices, detail.Index) {
+				return errors.ErrAliasNotFound
+			}
+			alias.removeIndex(detail.Index)
+			changed[detail.Alias] = true
+		default:
+			return errors.ErrInvalidAliasAction
+		}
+	}
+	if err := engine.saveAliases(aliases, changed); err != nil {
+		return err
+	}
+	engine.aliases = aliases
+	return nil
+}
+
+// ResolveIndices returns the opened indices referred to by name, which can be
+// either an index name or an alias.
+func ResolveIndices(name string) ([]*Index, error) {
+	alias := engine.getAlias(name)
+	if alias == nil {
+		index, err := GetIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		return []*Index{index}, nil
+	}
+	indices := make([]*Index, 0, len(alias.Indices))
+	for _, indexName := range alias.Indices {
+		index, err := GetIndex(indexName)
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+// ResolveIndexList returns the opened indices referred to by the comma separated index names or aliases,
+// all the indices are returned if names is empty.
+func ResolveIndexList(names string) ([]*Index, error) {
+	if len(names) == 0 {
+		return openAllIndices()
+	}
+	indices := make([]*Index, 0)
+	seen := make(map[string]bool)
+	for _, name := range splitFields(names) {
+		resolved, err := ResolveIndices(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range resolved {
+			if !seen[index.Name] {
+				seen[index.Name] = true
+				indices = append(indices, index)
+			}
+		}
+	}
+	return indices, nil
+}
+
+// ResolveWriteIndex returns the name of the index which the writes to name should go to.
+// If name is not an alias, it's returned as it is.
+func ResolveWriteIndex(name string) (string, error) {
+	alias := engine.getAlias(name)
+	if alias == nil {
+		return name, nil
+	}
+	writeIndex := alias.writeIndex()
+	if writeIndex == "" {
+		return "", errors.ErrAliasNoWriteIndex
+	}
+	return writeIndex, nil
+}
+
+func (a *Alias) removeIndex(index string) {
+	indices := make([]string, 0, len(a.Indices))
+	for _, name := range a.Indices {
+		if name != index {
+			indices = append(indices, name)
+		}
+	}
+	a.Indices = indices
+	if a.WriteIndex == index {
+		a.WriteIndex = ""
+	}
+}
+
+// getAlias returns a copy of the alias, or nil if the alias doesn't exist.
+func (e *Engine) getAlias(name string) *Alias {
+	e.aliasMu.RLock()
+	defer e.aliasMu.RUnlock()
+	if alias := e.aliases[name]; alias != nil {
+		return alias.clone()
+	}
+	return nil
+}
+
+// saveAliases persists the changed aliases in one batch, so either all or none of them are saved. The aliases
+// without any index are saved empty and then deleted, the empty ones left by a failed deletion are skipped when loaded.
+func (e *Engine) saveAliases(aliases map[string]*Alias, changed map[string]bool) error {
+	keys := make([]string, 0, len(changed))
+	values := make([][]byte, 0, len(changed))
+	for name := range changed {
+		b, err := json.Marshal(aliases[name])
+		if err != nil {
+			return err
+		}
+		keys = append(keys, name)
+		values = append(values, b)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := e.aliasMeta.Batch(keys, values); err != nil {
+		return err
+	}
+	for _, name := range keys {
+		if len(aliases[name].Indices) == 0 {
+			delete(aliases, name)
+			_ = e.aliasMeta.Delete(name)
+		}
+	}
+	return nil
+}
+
+// removeIndexFromAliases removes the deleted index from all aliases.
+func (e *Engine) removeIndexFromAliases(index string) error {
+	e.aliasMu.Lock()
+	defer e.aliasMu.Unlock()
+	// apply the removal to a copy, so the aliases in memory are unchanged if the save fails.
+	aliases := e.cloneAliases()
+	changed := make(map[string]bool)
+	for name, alias := range aliases {
+		if slices.ContainsStr(alias.Indices, index) {
+			alias.removeIndex(index)
+			changed[name] = true
+		}
+	}
+	if err := e.saveAliases(aliases, changed); err != nil {
+		return err
+	}
+	e.aliases = aliases
+	return nil
+}
+
+// cloneAliases returns a deep copy of the aliases, the caller must hold aliasMu.
+func (e *Engine) cloneAliases() map[string]*Alias {
+	aliases := make(map[string]*Alias, len(e.aliases))
+	for name, alias := range e.aliases {
+		aliases[name] = alias.clone()
+	}
+	return aliases
+}
+
+func (e *Engine) loadAllAliases() error {
+	data, err := e.aliasMeta.List()
+	if err != nil {
+		return err
+	}
+	e.aliasMu.Lock()
+	defer e.aliasMu.Unlock()
+	for _, d := range data {
+		alias := new(Alias)
+		if err = json.Unmarshal(d, alias); err != nil {
+			return err
+		}
+		// the alias is removed, see saveAliases.
+		if len(alias.Indices) == 0 {
+			_ = e.aliasMeta.Delete(alias.Name)
+			continue
+		}
+		e.aliases[alias.Name] = alias
+	}
+	return nil
+}
+
+// indexExists reports whether the index metadata exists, the index is not opened.
+func indexExists(name string) bool {
+	if engine.getIndex(name) != nil {
+		return true
+	}
+	_, err := engine.meta.Get(name)
+	return err == nil
+}
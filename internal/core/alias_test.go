@@ -0,0 +1,110 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	const aliasName = indexName + "-alias"
+	oldIndex, err := NewIndex(WithName(indexName + "-v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newIndex, err := NewIndex(WithName(indexName + "-v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := oldIndex.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if err := newIndex.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GetAlias(aliasName); err != errors.ErrAliasNotFound {
+			t.Error("alias not removed after deleting its indices")
+		}
+	}()
+	if _, err := oldIndex.IndexOrUpdateDocument("1", map[string]interface{}{"version": "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newIndex.IndexOrUpdateDocument("1", map[string]interface{}{"version": "v2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = UpdateAliases([]*AliasAction{{Add: &AliasActionDetail{Index: oldIndex.Name, Alias: aliasName}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := ResolveWriteIndex(aliasName); err != nil || name != oldIndex.Name {
+		t.Fatalf("ResolveWriteIndex: %s, %v", name, err)
+	}
+
+	// the failed actions should take no effect.
+	err = UpdateAliases([]*AliasAction{
+		{Remove: &AliasActionDetail{Index: oldIndex.Name, Alias: aliasName}},
+		{Add: &AliasActionDetail{Index: "not-exists", Alias: aliasName}},
+	})
+	if err != errors.ErrIndexNotFound {
+		t.Fatalf("UpdateAliases: %v", err)
+	}
+	if alias, err := GetAlias(aliasName); err != nil || len(alias.Indices) != 1 || alias.Indices[0] != oldIndex.Name {
+		t.Fatalf("alias changed by failed actions: %+v", alias)
+	}
+
+	// swap the alias to the new index.
+	isWriteIndex := true
+	err = UpdateAliases([]*AliasAction{
+		{Remove: &AliasActionDetail{Index: oldIndex.Name, Alias: aliasName}},
+		{Add: &AliasActionDetail{Index: newIndex.Name, Alias: aliasName, IsWriteIndex: &isWriteIndex}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("aliases", ListAliases())
+	indices, err := ResolveIndices(aliasName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := SearchIndices(&SearchRequest{Query: &MatchAllQuery{}, Size: 10}, indices...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 || res.Hits[0].Source["version"] != "v2" {
+		t.Errorf("search through alias got unexpected hits: %+v", res.Hits)
+	}
+
+	// an alias can't share the name with an index.
+	if _, err := NewIndex(WithName(aliasName)); err != errors.ErrIndexAliasConflict {
+		t.Errorf("NewIndex: %v", err)
+	}
+	err = UpdateAliases([]*AliasAction{{Add: &AliasActionDetail{Index: newIndex.Name, Alias: oldIndex.Name}}})
+	if err != errors.ErrIndexAliasConflict {
+		t.Errorf("UpdateAliases: %v", err)
+	}
+
+	// the alias without any index is deleted, and the empty one left in the storage is skipped when loaded.
+	err = UpdateAliases([]*AliasAction{{Remove: &AliasActionDetail{Index: newIndex.Name, Alias: aliasName}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.aliasMeta.Get(aliasName); err != errors.ErrKeyNotFound {
+		t.Errorf("the removed alias is in the storage: %v", err)
+	}
+	if err := engine.aliasMeta.Set(aliasName, []byte(`{"name": "`+aliasName+`", "indices": []}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.loadAllAliases(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetAlias(aliasName); err != errors.ErrAliasNotFound {
+		t.Errorf("GetAlias of the empty alias: %v", err)
+	}
+	if _, err := engine.aliasMeta.Get(aliasName); err != errors.ErrKeyNotFound {
+		t.Errorf("the empty alias is not deleted when loaded: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package core
+
+import (
+	"fmt"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/gse"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/jieba"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/sego"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/synonym"
+	"sort"
+	"strings"
+)
+
+// Analysis declares the custom analysis components of index, the components are referenced by name, e.g. the
+// analyzers use the char filters, tokenizer and token filters, the FieldMapping.Analyzer uses the analyzers.
+// Each component has a "type", the others are the options of the type.
+type Analysis struct {
+	CharFilters  map[string]map[string]interface{} `json:"char_filters,omitempty" mapstructure:"char_filters"`
+	Tokenizers   map[string]map[string]interface{} `json:"tokenizers,omitempty" mapstructure:"tokenizers"`
+	TokenFilters map[string]map[string]interface{} `json:"token_filters,omitempty" mapstructure:"token_filters"`
+	Analyzers    map[string]map[string]interface{} `json:"analyzers,omitempty" mapstructure:"analyzers"`
+}
+
+// the common names of components mapped to the names registered in bleve.
+var (
+	charFilterNames  = map[string]string{"html_strip": "html", "zero_width": "zero_width_spaces"}
+	tokenizerNames   = map[string]string{"standard": "unicode", "keyword": "single", "jieba": jieba.Name}
+	tokenFilterNames = map[string]string{"lowercase": "to_lower", "porter": "stemmer_porter"}
+	analyzerNames    = map[string]string{"jieba": jieba.Name}
+)
+
+// builtinName returns the name registered in bleve.
+func builtinName(names map[string]string, name string) string {
+	if builtin, ok := names[name]; ok {
+		return builtin
+	}
+	return name
+}
+
+// languages maps the language names to the codes of bleve lang packages, the codes are used by the stemmers
+// "stemmer_<code>_snowball" and stop words "stop_<code>".
+var languages = map[string]string{
+	"danish": "da", "dutch": "nl", "english": "en", "finnish": "fi", "french": "fr", "german": "de", "hungarian": "hu",
+	"italian": "it", "norwegian": "no", "portuguese": "pt", "romanian": "ro", "russian": "ru", "spanish": "es",
+	"swedish": "sv", "turkish": "tr",
+}
+
+// register translates the components to bleve configs and adds them to the mapping.
+func (a *Analysis) register(mapping *imapping.IndexMappingImpl) error {
+	if a == nil {
+		return nil
+	}
+	for _, name := range sortedNames(a.CharFilters) {
+		config, err := charFilterConfig(a.CharFilters[name])
+		if err == nil {
+			err = mapping.AddCustomCharFilter(name, config)
+		}
+		if err != nil {
+			return fmt.Errorf("char filter [%s]: %s", name, err)
+		}
+	}
+	for _, name := range sortedNames(a.Tokenizers) {
+		config, err := tokenizerConfig(a.Tokenizers[name])
+		if err == nil {
+			err = mapping.AddCustomTokenizer(name, config)
+		}
+		if err != nil {
+			return fmt.Errorf("tokenizer [%s]: %s", name, err)
+		}
+	}
+	for _, name := range sortedNames(a.TokenFilters) {
+		config, err := tokenFilterConfig(name, a.TokenFilters[name], mapping)
+		if err == nil {
+			err = mapping.AddCustomTokenFilter(name, config)
+		}
+		if err != nil {
+			return fmt.Errorf("token filter [%s]: %s", name, err)
+		}
+	}
+	for _, name := range sortedNames(a.Analyzers) {
+		config, err := a.analyzerConfig(a.Analyzers[name], mapping)
+		if err == nil {
+			err = mapping.AddCustomAnalyzer(name, config)
+		}
+		if err != nil {
+			return fmt.Errorf("analyzer [%s]: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func sortedNames(components map[string]map[string]interface{}) []string {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// copyConfig copies the component with the type.
+func copyConfig(component map[string]interface{}, typ string) map[string]interface{} {
+	config := make(map[string]interface{}, len(component))
+	for k, v := range component {
+		config[k] = v
+	}
+	config["type"] = typ
+	return config
+}
+
+func componentType(component map[string]interface{}) (string, error) {
+	typ, ok := component["type"].(string)
+	if !ok || len(typ) == 0 {
+		return "", fmt.Errorf("type is required")
+	}
+	return strings.ToLower(typ), nil
+}
+
+func charFilterConfig(component map[string]interface{}) (map[string]interface{}, error) {
+	typ, err := componentType(component)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "pattern_replace":
+		// {"type": "pattern_replace", "pattern": "\\d+", "replacement": "#"}
+		config := copyConfig(component, "regexp")
+		config["regexp"], config["replace"] = component["pattern"], component["replacement"]
+		if config["replace"] == nil {
+			config["replace"] = ""
+		}
+		return config, nil
+	}
+	return copyConfig(component, builtinName(charFilterNames, typ)), nil
+}
+
+func tokenizerConfig(component map[string]interface{}) (map[string]interface{}, error) {
+	typ, err := componentType(component)
+	if err != nil {
+		return nil, err
+	}
+	typ = builtinName(tokenizerNames, typ)
+	config := copyConfig(component, typ)
+	switch typ {
+	case "pattern":
+		// {"type": "pattern", "pattern": "\\w+"}
+		config["type"], config["regexp"] = "regexp", component["pattern"]
+	case gse.Name, jieba.Name, sego.Name:
+		// the dicts are optional, the embedded dicts are used if omitted.
+		for option, value := range chineseTokenizerDefaults(typ) {
+			if _, ok := config[option]; !ok {
+				config[option] = value
+			}
+		}
+	}
+	return config, nil
+}
+
+// chineseTokenizerDefaults returns the default options of the chinese tokenizers.
+func chineseTokenizerDefaults(typ string) map[string]interface{} {
+	switch typ {
+	case gse.Name:
+		return map[string]interface{}{"dict_path": "", "stop_words": "", "alpha": false}
+	case jieba.Name:
+		return map[string]interface{}{"dict_path": "", "hmm_path": "", "user_dict_path": "", "idf": "", "stop_words": ""}
+	case sego.Name:
+		return map[string]interface{}{"dict_path": ""}
+	}
+	return nil
+}
+
+func tokenFilterConfig(name string, component map[string]interface{}, mapping *imapping.IndexMappingImpl) (map[string]interface{}, error) {
+	typ, err := componentType(component)
+	if err != nil {
+		return nil, err
+	}
+	typ = builtinName(tokenFilterNames, typ)
+	config := copyConfig(component, typ)
+	switch typ {
+	case "stop":
+		// {"type": "stop", "stopwords": ["a", "the"] | "_english_", "stopwords_path": "path/to/file"}
+		config = map[string]interface{}{"type": "stop_tokens"}
+		switch stopwords := component["stopwords"].(type) {
+		case string:
+			code, ok := languages[strings.Trim(stopwords, "_")]
+			if !ok {
+				return nil, fmt.Errorf("unknown stopwords [%s]", stopwords)
+			}
+			config["stop_token_map"] = "stop_" + code
+		case []interface{}:
+			tokenMap := name + "_stopwords"
+			if err := mapping.AddCustomTokenMap(tokenMap, map[string]interface{}{"type": "custom", "tokens": stopwords}); err != nil {
+				return nil, err
+			}
+			config["stop_token_map"] = tokenMap
+		case nil:
+			path, ok := component["stopwords_path"].(string)
+			if !ok {
+				return nil, fmt.Errorf("stopwords or stopwords_path is required")
+			}
+			tokenMap := name + "_stopwords"
+			if err := mapping.AddCustomTokenMap(tokenMap, map[string]interface{}{"type": "custom", "filename": path}); err != nil {
+				return nil, err
+			}
+			config["stop_token_map"] = tokenMap
+		default:
+			return nil, fmt.Errorf("stopwords must be a string or an array of string")
+		}
+	case "stemmer":
+		// {"type": "stemmer", "language": "english"}
+		language, _ := component["language"].(string)
+		language = strings.ToLower(language)
+		switch language {
+		case "", "english", "porter":
+			return map[string]interface{}{"type": "stemmer_porter"}, nil
+		}
+		code, ok := languages[language]
+		if !ok {
+			return nil, fmt.Errorf("unknown stemmer language [%s]", language)
+		}
+		if code == "pt" {
+			return map[string]interface{}{"type": "stemmer_pt_light"}, nil
+		}
+		return map[string]interface{}{"type": "stemmer_" + code + "_snowball"}, nil
+	case "edge_ngram", "ngram":
+		// {"type": "edge_ngram", "min_gram": 1, "max_gram": 10, "side": "front"}
+		config["min"], config["max"] = 1.0, 2.0
+		if min, ok := component["min_gram"]; ok {
+			config["min"] = min
+		}
+		if max, ok := component["max_gram"]; ok {
+			config["max"] = max
+		}
+		config["back"] = component["side"] == "back"
+		for _, option := range []string{"min", "max"} {
+			if v, ok := toFloat64(config[option]); ok {
+				config[option] = v
+			} else {
+				return nil, fmt.Errorf("%s_gram must be a number", option)
+			}
+		}
+	case synonym.Name:
+		// {"type": "synonym", "synonyms": ["a, b => c"], "synonyms_path": "path/to/file", "expand": true}
+		if synonyms, ok := component["synonyms"].([]string); ok {
+			list := make([]interface{}, 0, len(synonyms))
+			for _, s := range synonyms {
+				list = append(list, s)
+			}
+			config["synonyms"] = list
+		}
+	}
+	return config, nil
+}
+
+// toFloat64 converts the numbers decoded from json or mapstructure to float64.
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func (a *Analysis) analyzerConfig(component map[string]interface{}, mapping *imapping.IndexMappingImpl) (map[string]interface{}, error) {
+	typ, err := componentType(component)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "custom" {
+		typ = builtinName(analyzerNames, typ)
+		if _, ok := chineseAnalyzers[typ]; ok {
+			// {"type": "gse"} is short for the analyzer with the gse tokenizer of default options.
+			if err := registerChineseTokenizer(mapping, typ); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"type": typ, "tokenizer": typ}, nil
+		}
+		return copyConfig(component, typ), nil
+	}
+	// {"type": "custom", "char_filters": [...], "tokenizer": "standard", "token_filters": [...]}
+	config := copyConfig(component, "custom")
+	tokenizer, ok := component["tokenizer"].(string)
+	if !ok {
+		return nil, fmt.Errorf("tokenizer is required")
+	}
+	if _, defined := a.Tokenizers[tokenizer]; !defined {
+		tokenizer = builtinName(tokenizerNames, tokenizer)
+		if _, ok := chineseAnalyzers[tokenizer]; ok {
+			if err := registerChineseTokenizer(mapping, tokenizer); err != nil {
+				return nil, err
+			}
+		}
+	}
+	config["tokenizer"] = tokenizer
+	for _, key := range []string{"char_filters", "token_filters"} {
+		defined, builtins := a.CharFilters, charFilterNames
+		if key == "token_filters" {
+			defined, builtins = a.TokenFilters, tokenFilterNames
+		}
+		names, err := componentNames(component[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		for i, name := range names {
+			if _, ok := defined[name]; !ok {
+				names[i] = builtinName(builtins, name)
+			}
+		}
+		if len(names) > 0 {
+			list := make([]interface{}, 0, len(names))
+			for _, name := range names {
+				list = append(list, name)
+			}
+			config[key] = list
+		}
+	}
+	return config, nil
+}
+
+func componentNames(v interface{}) ([]string, error) {
+	switch names := v.(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return append([]string(nil), names...), nil
+	case []interface{}:
+		list := make([]string, 0, len(names))
+		for _, name := range names {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be an array of string")
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("must be an array of string")
+}
+
+// chineseAnalyzers are the analyzers can be used by name without definition, the tokenizers with default
+// options are registered on demand.
+var chineseAnalyzers = map[string]struct{}{gse.Name: {}, jieba.Name: {}, sego.Name: {}}
+
+// registerChineseTokenizer registers the chinese tokenizer with default options if not defined.
+func registerChineseTokenizer(mapping *imapping.IndexMappingImpl, name string) error {
+	if _, ok := mapping.CustomAnalysis.Tokenizers[name]; ok {
+		return nil
+	}
+	config := chineseTokenizerDefaults(name)
+	config["type"] = name
+	return mapping.AddCustomTokenizer(name, config)
+}
+
+// registerChineseAnalyzer registers the chinese analyzer with default options if not defined.
+func registerChineseAnalyzer(mapping *imapping.IndexMappingImpl, name string) error {
+	if _, ok := mapping.CustomAnalysis.Analyzers[name]; ok {
+		return nil
+	}
+	if err := registerChineseTokenizer(mapping, name); err != nil {
+		return err
+	}
+	return mapping.AddCustomAnalyzer(name, map[string]interface{}{"type": name, "tokenizer": name})
+}
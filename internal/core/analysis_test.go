@@ -0,0 +1,218 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const analysisMapping = `{
+    "default_mapping": {
+        "properties": {
+            "title": {"fields": [{"type": "text", "analyzer": "my_analyzer"}]},
+            "name": {"fields": [{"type": "text", "analyzer": "autocomplete"}]},
+            "content": {"fields": [{"type": "text", "analyzer": "jieba"}]}
+        }
+    },
+    "analysis": {
+        "char_filters": {
+            "my_html": {"type": "html_strip"}
+        },
+        "token_filters": {
+            "my_synonym": {"type": "synonym", "synonyms": ["quick, fast", "tv => television"]},
+            "my_stop": {"type": "stop", "stopwords": ["the", "a"]},
+            "my_stemmer": {"type": "stemmer", "language": "english"},
+            "my_edge": {"type": "edge_ngram", "min_gram": 1, "max_gram": 3}
+        },
+        "analyzers": {
+            "my_analyzer": {
+                "type": "custom",
+                "char_filters": ["my_html"],
+                "tokenizer": "standard",
+                "token_filters": ["lowercase", "my_stop", "my_synonym", "my_stemmer"]
+            },
+            "autocomplete": {"type": "custom", "tokenizer": "whitespace", "token_filters": ["lowercase", "my_edge"]}
+        }
+    }
+}`
+
+func TestAnalysis(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(analysisMapping), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := buildIndexMapping(im)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for analyzer, cases := range map[string]map[string][]string{
+		"my_analyzer":  {"<b>The Quick</b> TV jumps": {"quick", "fast", "televis", "jump"}},
+		"autocomplete": {"Search Engine": {"s", "se", "sea", "e", "en", "eng"}},
+	} {
+		for text, expected := range cases {
+			terms := make([]string, 0)
+			for _, token := range mapping.AnalyzerNamed(analyzer).Analyze([]byte(text)) {
+				terms = append(terms, string(token.Term))
+			}
+			if !reflect.DeepEqual(terms, expected) {
+				t.Errorf("analyze %q by %s: got %v, expected %v", text, analyzer, terms, expected)
+			}
+		}
+	}
+
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "The quick brown fox", "name": "quicksearch", "content": "我来到北京清华大学"},
+		"2": {"title": "A lazy dog watching TV", "name": "bleve", "content": "他来到了网易杭研大厦"},
+	}
+	for id, doc := range docs {
+		if _, err := index.IndexOrUpdateDocument(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for q, expected := range map[string]string{
+		`{"type": "match", "match": "fast", "field": "title"}`:       "1",
+		`{"type": "match", "match": "television", "field": "title"}`: "2",
+		`{"type": "match", "match": "quic", "field": "name"}`:        "1",
+		`{"type": "term", "term": "清华大学", "field": "content"}`:       "1",
+	} {
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(`{"query": `+q+`}`), req); err != nil {
+			t.Fatal(err)
+		}
+		res, err := index.Search(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Hits) != 1 || res.Hits[0].ID != expected {
+			t.Errorf("search %s: got %v, expected %s", q, res.Hits, expected)
+		}
+	}
+
+	// the invalid definitions are rejected.
+	for raw, msg := range map[string]string{
+		`{"default_analyzer": "unknown"}`: "unknown analyzer [unknown]",
+		`{"default_mapping": {"properties": {"a": {"fields": [{"type": "text", "analyzer": "missing"}]}}}}`: "unknown analyzer [missing]",
+		`{"analysis": {"token_filters": {"f": {"type": "stemmer", "language": "klingon"}}}}`:                "token filter [f]",
+		`{"analysis": {"analyzers": {"a": {"type": "custom", "tokenizer": "unknown"}}}}`:                    "analyzer [a]",
+	} {
+		m := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			t.Fatal(err)
+		}
+		im, err := BuildIndexMappingFromMap(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := buildIndexMapping(im); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("build %s: %v, expected %s", raw, err, msg)
+		}
+	}
+}
+
+func TestChineseNormalization(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(`{
+    "default_mapping": {"properties": {"title": {"fields": [{"type": "text", "analyzer": "chinese_pinyin"}]}}},
+    "analysis": {
+        "token_filters": {"my_pinyin": {"type": "pinyin", "keep_full_pinyin": true}},
+        "analyzers": {"chinese_pinyin": {"type": "custom", "tokenizer": "gse", "token_filters": ["stconvert", "my_pinyin"]}}
+    }
+}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	res, err := index.Analyze(&AnalyzeRequest{Text: "北京", Field: "title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms := make([]string, 0)
+	for _, token := range res.Tokens {
+		terms = append(terms, token.Token)
+		if token.Position != 0 {
+			t.Errorf("the pinyin of %s at position %d", token.Token, token.Position)
+		}
+	}
+	if expected := []string{"北京", "beijing", "bj", "bei", "jing"}; !reflect.DeepEqual(terms, expected) {
+		t.Errorf("analyze 北京: got %v, expected %v", terms, expected)
+	}
+
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "北京欢迎你"},
+		"2": {"title": "臺灣旅遊"},
+	}
+	for id, doc := range docs {
+		if _, err := index.IndexOrUpdateDocument(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for match, expected := range map[string]string{
+		"beijing": "1",
+		"bj":      "1",
+		"台湾":      "2",
+		"臺灣":      "2",
+		"taiwan":  "2",
+		"lvyou":   "2",
+	} {
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(`{"query": {"type": "match", "match": "`+match+`", "field": "title"}}`), req); err != nil {
+			t.Fatal(err)
+		}
+		res, err := index.Search(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Hits) != 1 || res.Hits[0].ID != expected {
+			t.Errorf("match %s: got %v, expected %s", match, res.Hits, expected)
+		}
+	}
+
+	for raw, msg := range map[string]string{
+		`{"analysis": {"token_filters": {"f": {"type": "stconvert", "convert_type": "t2x"}}}}`:                                     "unknown convert_type [t2x]",
+		`{"analysis": {"token_filters": {"f": {"type": "pinyin", "keep_joined_full_pinyin": false, "keep_first_letter": false}}}}`: "must be true",
+	} {
+		m := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			t.Fatal(err)
+		}
+		im, err := BuildIndexMappingFromMap(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := buildIndexMapping(im); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("build %s: %v, expected %s", raw, err, msg)
+		}
+	}
+}
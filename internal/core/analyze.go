@@ -0,0 +1,230 @@
+package core
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"sync"
+)
+
+// AnalyzeRequest analyzes the text by one of the analyzer, the analyzer of field or the chain of tokenizer
+// and filters, the standard analyzer is used if none of them. The components of the chain are the names or
+// the definitions in the format of Analysis, e.g. {"type": "stop", "stopwords": ["a"]}.
+type AnalyzeRequest struct {
+	Text         interface{}   `json:"text"` // a string or an array of string
+	Analyzer     string        `json:"analyzer,omitempty"`
+	Field        string        `json:"field,omitempty"`
+	CharFilters  []interface{} `json:"char_filters,omitempty"`
+	Tokenizer    interface{}   `json:"tokenizer,omitempty"`
+	TokenFilters []interface{} `json:"token_filters,omitempty"`
+	// the names in Elasticsearch.
+	CharFilter []interface{} `json:"char_filter,omitempty"`
+	Filter     []interface{} `json:"filter,omitempty"`
+}
+
+type AnalyzeResult struct {
+	Tokens []*AnalyzeToken `json:"tokens"`
+}
+
+type AnalyzeToken struct {
+	Token       string `json:"token"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Type        string `json:"type"`
+	Position    int    `json:"position"`
+}
+
+// the gap of positions and offsets between the texts of array, same as Elasticsearch.
+const (
+	positionIncrementGap = 100
+	offsetGap            = 1
+)
+
+var tokenTypes = map[analysis.TokenType]string{
+	analysis.AlphaNumeric: "<ALPHANUM>",
+	analysis.Ideographic:  "<IDEOGRAPHIC>",
+	analysis.Numeric:      "<NUM>",
+	analysis.DateTime:     "<DATE>",
+	analysis.Shingle:      "shingle",
+	analysis.Single:       "word",
+	analysis.Double:       "<DOUBLE>",
+	analysis.Boolean:      "<BOOLEAN>",
+	analysis.IP:           "<IP>",
+}
+
+// analyzeMapping caches the analyzers used without index, so the chinese dicts are loaded only once.
+var analyzeMapping struct {
+	sync.Mutex
+	mapping *imapping.IndexMappingImpl
+}
+
+// Analyze analyzes the text without index, the field is not allowed.
+func Analyze(req *AnalyzeRequest) (*AnalyzeResult, error) {
+	return analyzeText(req, nil)
+}
+
+// Analyze analyzes the text with the analyzers and components defined in the index.
+func (index *Index) Analyze(req *AnalyzeRequest) (*AnalyzeResult, error) {
+	if index.IsClosed() {
+		return nil, errors.ErrIndexClosed
+	}
+	return analyzeText(req, index)
+}
+
+func analyzeText(req *AnalyzeRequest, index *Index) (*AnalyzeResult, error) {
+	texts, err := req.texts()
+	if err != nil {
+		return nil, err
+	}
+	analyzer, err := req.analyzer(index)
+	if err != nil {
+		return nil, err
+	}
+	result := &AnalyzeResult{Tokens: make([]*AnalyzeToken, 0)}
+	position, offset := -1, 0
+	for i, text := range texts {
+		if i > 0 {
+			position += positionIncrementGap
+		}
+		base := position
+		for _, token := range analyzer.Analyze([]byte(text)) {
+			// the positions of bleve start from 1.
+			position = base + token.Position
+			result.Tokens = append(result.Tokens, &AnalyzeToken{
+				Token:       string(token.Term),
+				StartOffset: offset + token.Start,
+				EndOffset:   offset + token.End,
+				Type:        tokenTypes[token.Type],
+				Position:    position,
+			})
+		}
+		offset += len(text) + offsetGap
+	}
+	return result, nil
+}
+
+func (req *AnalyzeRequest) texts() ([]string, error) {
+	switch text := req.Text.(type) {
+	case string:
+		return []string{text}, nil
+	case []string:
+		return text, nil
+	case []interface{}:
+		texts := make([]string, 0, len(text))
+		for _, t := range text {
+			s, ok := t.(string)
+			if !ok {
+				return nil, fmt.Errorf("text must be a string or an array of string")
+			}
+			texts = append(texts, s)
+		}
+		return texts, nil
+	case nil:
+		return nil, fmt.Errorf("text is required")
+	}
+	return nil, fmt.Errorf("text must be a string or an array of string")
+}
+
+// analyzer returns the analyzer of the request.
+func (req *AnalyzeRequest) analyzer(index *Index) (*analysis.Analyzer, error) {
+	charFilters, tokenFilters := append(req.CharFilters, req.CharFilter...), append(req.TokenFilters, req.Filter...)
+	chain := req.Tokenizer != nil || len(charFilters) > 0 || len(tokenFilters) > 0
+	switch {
+	case len(req.Analyzer) > 0 && (len(req.Field) > 0 || chain), len(req.Field) > 0 && chain:
+		return nil, fmt.Errorf("only one of analyzer, field or tokenizer and filters can be set")
+	case len(req.Field) > 0:
+		if index == nil {
+			return nil, fmt.Errorf("field [%s] requires an index", req.Field)
+		}
+		mapping := index.Shards[0].Indexer.Mapping()
+		return mapping.AnalyzerNamed(mapping.AnalyzerNameForPath(req.Field)), nil
+	case chain:
+		return chainAnalyzer(charFilters, req.Tokenizer, tokenFilters, index)
+	}
+	name := req.Analyzer
+	if len(name) == 0 {
+		name = "standard"
+	}
+	if index != nil {
+		if mapping, ok := index.Shards[0].Indexer.Mapping().(*imapping.IndexMappingImpl); ok {
+			if _, defined := mapping.CustomAnalysis.Analyzers[name]; defined {
+				return mapping.AnalyzerNamed(name), nil
+			}
+		}
+	}
+	analyzeMapping.Lock()
+	defer analyzeMapping.Unlock()
+	if analyzeMapping.mapping == nil {
+		analyzeMapping.mapping = bleve.NewIndexMapping()
+	}
+	resolved, err := resolveAnalyzer(analyzeMapping.mapping, name)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeMapping.mapping.AnalyzerNamed(resolved), nil
+}
+
+// chainAnalyzer builds a custom analyzer of the components, the names defined in the analysis of index
+// are resolved to their definitions.
+func chainAnalyzer(charFilters []interface{}, tokenizer interface{}, tokenFilters []interface{}, index *Index) (*analysis.Analyzer, error) {
+	defined := new(Analysis)
+	if index != nil && index.Mapping != nil && index.Mapping.Analysis != nil {
+		defined = index.Mapping.Analysis
+	}
+	a := &Analysis{
+		CharFilters:  make(map[string]map[string]interface{}),
+		Tokenizers:   make(map[string]map[string]interface{}),
+		TokenFilters: make(map[string]map[string]interface{}),
+	}
+	if tokenizer == nil {
+		return nil, fmt.Errorf("tokenizer is required")
+	}
+	tokenizerName, err := chainComponent(tokenizer, "tokenizer", defined.Tokenizers, a.Tokenizers)
+	if err != nil {
+		return nil, err
+	}
+	analyzer := map[string]interface{}{"type": "custom", "tokenizer": tokenizerName}
+	for _, component := range []struct {
+		key        string
+		components []interface{}
+		defined    map[string]map[string]interface{}
+		added      map[string]map[string]interface{}
+	}{
+		{"char_filters", charFilters, defined.CharFilters, a.CharFilters},
+		{"token_filters", tokenFilters, defined.TokenFilters, a.TokenFilters},
+	} {
+		names := make([]interface{}, 0, len(component.components))
+		for i, c := range component.components {
+			name, err := chainComponent(c, fmt.Sprintf("%s[%d]", component.key, i), component.defined, component.added)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+		analyzer[component.key] = names
+	}
+	a.Analyzers = map[string]map[string]interface{}{"_analyze": analyzer}
+	mapping := bleve.NewIndexMapping()
+	if err := a.register(mapping); err != nil {
+		return nil, err
+	}
+	return mapping.AnalyzerNamed("_analyze"), nil
+}
+
+// chainComponent adds the definition of the component to added, returns the name of component.
+func chainComponent(component interface{}, key string, defined, added map[string]map[string]interface{}) (string, error) {
+	switch c := component.(type) {
+	case string:
+		if definition, ok := defined[c]; ok {
+			added[c] = definition
+		}
+		return c, nil
+	case map[string]interface{}:
+		name := "_" + key
+		added[name] = c
+		return name, nil
+	}
+	return "", fmt.Errorf("%s must be a name or a definition", key)
+}
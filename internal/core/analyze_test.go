@@ -0,0 +1,100 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(analysisMapping), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	analyze := func(body string, index *Index) (*AnalyzeResult, error) {
+		req := new(AnalyzeRequest)
+		if err := json.Unmarshal([]byte(body), req); err != nil {
+			t.Fatalf("parse %s: %s", body, err)
+		}
+		if index == nil {
+			return Analyze(req)
+		}
+		return index.Analyze(req)
+	}
+	terms := func(res *AnalyzeResult) []string {
+		terms := make([]string, 0, len(res.Tokens))
+		for _, token := range res.Tokens {
+			terms = append(terms, token.Token)
+		}
+		return terms
+	}
+
+	res, err := analyze(`{"text": ["Quick fox", "Dog"]}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*AnalyzeToken{
+		{Token: "quick", StartOffset: 0, EndOffset: 5, Type: "<ALPHANUM>", Position: 0},
+		{Token: "fox", StartOffset: 6, EndOffset: 9, Type: "<ALPHANUM>", Position: 1},
+		{Token: "dog", StartOffset: 10, EndOffset: 13, Type: "<ALPHANUM>", Position: 102},
+	}
+	if !reflect.DeepEqual(res.Tokens, expected) {
+		data, _ := json.Marshal(res.Tokens)
+		t.Errorf("analyze the array of text: %s", data)
+	}
+
+	cases := []struct {
+		body     string
+		index    *Index
+		expected []string
+	}{
+		{`{"text": "我来到北京清华大学", "analyzer": "jieba"}`, nil, []string{"我", "来到", "北京", "清华", "华大", "大学", "清华大学"}},
+		{`{"text": "<b>The Quick</b> TV", "analyzer": "my_analyzer"}`, index, []string{"quick", "fast", "televis"}},
+		{`{"text": "Search Engine", "field": "name"}`, index, []string{"s", "se", "sea", "e", "en", "eng"}},
+		{`{"text": "The Quick", "field": "unknown"}`, index, []string{"quick"}},
+		{`{"text": "The TV", "tokenizer": "whitespace", "token_filters": ["lowercase", "my_synonym"]}`, index, []string{"the", "television"}},
+		{`{"text": "a1b22c", "char_filter": [{"type": "pattern_replace", "pattern": "\\d+", "replacement": " "}], "tokenizer": "standard", "filter": [{"type": "reverse"}]}`, nil, []string{"a", "b", "c"}},
+		{`{"text": "Hello World", "tokenizer": {"type": "keyword"}, "token_filters": ["lowercase"]}`, nil, []string{"hello world"}},
+	}
+	for _, c := range cases {
+		res, err := analyze(c.body, c.index)
+		if err != nil {
+			t.Fatalf("analyze %s: %s", c.body, err)
+		}
+		if got := terms(res); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("analyze %s: got %v, expected %v", c.body, got, c.expected)
+		}
+	}
+
+	for body, msg := range map[string]string{
+		`{"analyzer": "standard"}`:                                          "text is required",
+		`{"text": "a", "analyzer": "unknown"}`:                              "unknown analyzer [unknown]",
+		`{"text": "a", "field": "title"}`:                                   "requires an index",
+		`{"text": "a", "analyzer": "standard", "tokenizer": "whitespace"}`:  "only one of",
+		`{"text": "a", "token_filters": ["lowercase"]}`:                     "tokenizer is required",
+		`{"text": "a", "tokenizer": "whitespace", "token_filters": [1]}`:    "token_filters[0] must be a name or a definition",
+		`{"text": "a", "tokenizer": "whitespace", "token_filters": ["no"]}`: "no token filter with name or type 'no'",
+	} {
+		if _, err := analyze(body, nil); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("analyze %s: %v, expected %s", body, err, msg)
+		}
+	}
+}
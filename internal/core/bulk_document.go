@@ -2,8 +2,7 @@ package core
 
 import (
 	"bufio"
-	"github.com/blevesearch/bleve/v2"
-	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"bytes"
 	"github.com/feimingxliu/quicksearch/internal/config"
 	"github.com/feimingxliu/quicksearch/pkg/errors"
 	"github.com/feimingxliu/quicksearch/pkg/util/json"
@@ -51,33 +50,35 @@ type BulkAction struct {
 }
 
 type BulkActionDetail struct {
-	Index string `json:"_index"`
-	ID    string `json:"_id"`
+	Index         string `json:"_index"`
+	ID            string `json:"_id"`
+	IfSeqNo       *int64 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64 `json:"if_primary_term,omitempty"`
+	Version       *int64 `json:"version,omitempty"`
 }
 
 // Bulk reads data from reader and execute bulk actions defined in reader.
 // The first line looks like {"$action":{"_index": "$index", "_id": "$docID"}},
-// the $action can be `create`, `delete`, `index`, `update`. Note that here's
-// update don't support update document partially  because of performance.
-// If $index is empty, the targetIndex will be used.
+// the $action can be `create`, `delete`, `index`, `update`. The document of
+// update looks like {"doc": {...}, "doc_as_upsert": bool, "upsert": {...}},
+// see newBulkUpdateOp for details. If $index is empty, the targetIndex will be used. The action can also specify
+// the precondition by `if_seq_no`, `if_primary_term` or `version`.
+// The `create` fails if the document exists. A malformed or failed action is
+// reported in its item, and the rest actions continue.
 func Bulk(targetIndex string, reader io.Reader) (*BulkResult, error) {
 	var (
 		startTime        = time.Now()
 		err              error
 		scanner          = bufio.NewScanner(reader)
 		bulkResult       = &BulkResult{}
-		mapping          = make(map[string]imapping.IndexMapping) // index => IndexMapping
-		batch            = make(map[bleve.Index]*bleve.Batch)     // index => Batch
+		batch            = newBulkBatch()
 		batchSize        = uint32(config.Global.Engine.DefaultBatchSize)
 		currentBatchSize uint32
-		action           = new(BulkAction)
+		actionName       string
+		detail           *BulkActionDetail
 		nextLineIsData   bool
-		indexName        string
-		index            *Index
-		bindex           bleve.Index
-		data             = make(map[string]interface{})
+		maybeData        bool // the line after a malformed action may be its document
 	)
-
 	defer func() {
 		bulkResult.Took = time.Since(startTime)
 		if err != nil {
@@ -86,180 +87,288 @@ func Bulk(targetIndex string, reader io.Reader) (*BulkResult, error) {
 	}()
 
 	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if maybeData {
+			maybeData = false
+			// skip the document of malformed action.
+			if name, _ := parseBulkAction(line); name == "" {
+				continue
+			}
+		}
 		if !nextLineIsData {
-			err = json.Unmarshal(scanner.Bytes(), action)
-			if err != nil {
-				return bulkResult, errors.ErrBulkDataFormat
+			actionName, detail = parseBulkAction(line)
+			if actionName == "" {
+				bulkResult.Items = append(bulkResult.Items, newBulkResultItem(guessBulkAction(line), newBulkErrorResult("", "", 400, errors.ErrBulkDataFormat)))
+				maybeData = true
+				continue
 			}
-			if action.Delete != nil {
-				indexName = action.Delete.Index
-				if indexName == "" {
-					indexName = targetIndex
-					if indexName == "" {
-						return bulkResult, errors.ErrBulkDataFormat
-					}
+			if actionName == "delete" {
+				indexName, err := resolveBulkIndex(detail.Index, targetIndex)
+				if err != nil {
+					bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, newBulkErrorResult(detail.Index, detail.ID, 400, err)))
+					continue
 				}
 				index, err := GetIndex(indexName)
 				if err != nil {
 					if err == errors.ErrIndexNotFound {
-						bulkResult.Items = append(bulkResult.Items, BulkResultItem{
-							Delete: NewBulkActionResult(indexName, action.Delete.ID, "index_not_found", 404, err, int64(len(bulkResult.Items))),
-						})
+						res := newBulkErrorResult(indexName, detail.ID, 404, err)
+						res.Result = "index_not_found"
+						bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, res))
 					} else {
 						return bulkResult, err
 					}
 					continue
 				}
-				docID := action.Delete.ID
-				bindex = index.getDocShard(docID).Indexer
-				if batch[bindex] == nil {
-					batch[bindex] = bindex.NewBatch()
-				}
-				batch[bindex].Delete(docID)
-				bulkResult.Items = append(bulkResult.Items, BulkResultItem{
-					Delete: NewBulkActionResult(indexName, action.Delete.ID, "deleted", 200, nil, int64(len(bulkResult.Items))),
-				})
-				nextLineIsData = false
+				op := newWriteOp(opDelete, indexName, detail.ID, nil, detail.writeOptions()...)
+				batch.add(index, op)
+				bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, op.result))
 				continue
 			}
-			if action.Index != nil || action.Create != nil || action.Update != nil {
-				nextLineIsData = true
-			}
-		} else {
-			nextLineIsData = false
-
-			err = json.Unmarshal(scanner.Bytes(), &data)
-			if err != nil {
-				return bulkResult, errors.ErrBulkDataFormat
-			}
-
-			if action.Index != nil || action.Create != nil || action.Update != nil {
-				var docID string
-				switch {
-				case action.Index != nil:
-					indexName = action.Index.Index
-					docID = action.Index.ID
-					if indexName == "" {
-						indexName = targetIndex
-						if indexName == "" {
-							return bulkResult, errors.ErrBulkDataFormat
-						}
-					}
-					if docID == "" {
-						docID = uuid.GetUUID()
-					}
-					bulkResult.Items = append(bulkResult.Items, BulkResultItem{
-						Index: NewBulkActionResult(indexName, docID, "indexed", 200, nil, int64(len(bulkResult.Items))),
-					})
-				case action.Create != nil:
-					indexName = action.Create.Index
-					docID = action.Create.ID
-					if indexName == "" {
-						indexName = targetIndex
-						if indexName == "" {
-							return bulkResult, errors.ErrBulkDataFormat
-						}
-					}
-					if docID == "" {
-						docID = uuid.GetUUID()
-					}
-					bulkResult.Items = append(bulkResult.Items, BulkResultItem{
-						Create: NewBulkActionResult(indexName, docID, "created", 201, nil, int64(len(bulkResult.Items))),
-					})
-				case action.Update != nil:
-					indexName = action.Update.Index
-					docID = action.Update.ID
-					if indexName == "" {
-						indexName = targetIndex
-						if indexName == "" {
-							return bulkResult, errors.ErrBulkDataFormat
-						}
-					}
-					if docID == "" {
-						docID = uuid.GetUUID()
-					}
-					bulkResult.Items = append(bulkResult.Items, BulkResultItem{
-						Update: NewBulkActionResult(indexName, docID, "updated", 200, nil, int64(len(bulkResult.Items))),
-					})
-				}
-				index, err = NewIndex(WithName(indexName))
-				if err != nil {
-					return bulkResult, err
-				}
-				bindex = index.getDocShard(docID).Indexer
-				if batch[bindex] == nil {
-					batch[bindex] = bindex.NewBatch()
-				}
-				if mapping[indexName] == nil {
-					mp, err := buildIndexMapping(index.Mapping)
-					if err != nil {
-						return bulkResult, err
-					}
-					mapping[indexName] = mp
-				}
-				bdoc, err := index.buildBleveDocument(docID, data, mapping[indexName])
-				if err != nil {
-					return bulkResult, err
-				}
-				err = batch[bindex].IndexAdvanced(bdoc)
-				if err != nil {
-					return bulkResult, err
-				}
+			nextLineIsData = true
+			continue
+		}
 
-				currentBatchSize++
-				if currentBatchSize >= batchSize {
-					for bindex, bat := range batch {
-						err = bindex.Batch(bat)
-						if err != nil {
-							return bulkResult, err
-						}
-						// do not forget to reset batch
-						bat.Reset()
-					}
-					currentBatchSize = 0
-				}
+		nextLineIsData = false
+		docID := detail.ID
+		if docID == "" {
+			docID = uuid.GetUUID()
+		}
+		data := make(map[string]interface{})
+		if err := json.Unmarshal(line, &data); err != nil {
+			bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, newBulkErrorResult(detail.Index, docID, 400, errors.ErrBulkDataFormat)))
+			continue
+		}
+		indexName, err := resolveBulkIndex(detail.Index, targetIndex)
+		if err != nil {
+			bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, newBulkErrorResult(detail.Index, docID, 400, err)))
+			continue
+		}
+		index, err := NewIndex(WithName(indexName))
+		if err != nil {
+			bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, newBulkErrorResult(indexName, docID, 400, err)))
+			continue
+		}
+		var op *writeOp
+		switch actionName {
+		case "create":
+			op = newWriteOp(opCreate, indexName, docID, data, detail.writeOptions()...)
+		case "update":
+			if op, err = newBulkUpdateOp(indexName, docID, data, detail.writeOptions()...); err != nil {
+				bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, newBulkErrorResult(indexName, docID, 400, err)))
 				continue
 			}
+		default:
+			op = newWriteOp(opIndex, indexName, docID, data, detail.writeOptions()...)
+		}
+		batch.add(index, op)
+		bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, op.result))
 
-			action = new(BulkAction)
+		currentBatchSize++
+		if currentBatchSize >= batchSize {
+			// the failures are reported in the items.
+			_ = batch.execute()
+			currentBatchSize = 0
 		}
 	}
+	// the document of last action is missing.
+	if nextLineIsData {
+		bulkResult.Items = append(bulkResult.Items, newBulkResultItem(actionName, newBulkErrorResult(detail.Index, detail.ID, 400, errors.ErrBulkDataFormat)))
+	}
 
 	// bulk the remaining
-	for bindex, bat := range batch {
-		if bat.Size() > 0 {
-			err = bindex.Batch(bat)
-			if err != nil {
-				return bulkResult, err
-			}
-		}
-	}
+	_ = batch.execute()
 
 	if err = scanner.Err(); err != nil {
 		return bulkResult, err
 	}
 
+	for _, item := range bulkResult.Items {
+		if item.result().Error != nil {
+			bulkResult.Errors = true
+		}
+	}
 	return bulkResult, err
 }
 
-func NewBulkActionResult(index string, docID string, result string, status int64, err interface{}, seqNo int64) *BulkActionResult {
-	return &BulkActionResult{
-		Index:       index,
-		ID:          docID,
-		Version:     1,
-		Result:      result,
-		Status:      status,
-		Error:       err,
-		SeqNo:       seqNo,
-		PrimaryTerm: 1,
-		Shards: &BulkShards{
-			Total:      1,
-			Successful: 1,
-			Failed:     0,
-		},
+// newBulkUpdateOp returns the op of update action. The fields in "doc" are merged deeply into the existing document,
+// if the document doesn't exist, the "upsert" is indexed, or the "doc" if "doc_as_upsert" is true, otherwise the
+// update fails. For compatibility, the body without "doc" and "upsert" is indexed as the whole document.
+func newBulkUpdateOp(indexName, docID string, body map[string]interface{}, opts ...WriteOption) (*writeOp, error) {
+	rawDoc, hasDoc := body["doc"]
+	rawUpsert, hasUpsert := body["upsert"]
+	if !hasDoc && !hasUpsert {
+		return newWriteOp(opIndex, indexName, docID, body, opts...), nil
+	}
+	doc, ok := rawDoc.(map[string]interface{})
+	if hasDoc && !ok {
+		return nil, errors.ErrBulkDataFormat
+	}
+	upsert, ok := rawUpsert.(map[string]interface{})
+	if hasUpsert && !ok {
+		return nil, errors.ErrBulkDataFormat
+	}
+	docAsUpsert, ok := body["doc_as_upsert"].(bool)
+	if _, exists := body["doc_as_upsert"]; exists && !ok {
+		return nil, errors.ErrBulkDataFormat
+	}
+	op := newWriteOp(opUpdate, indexName, docID, doc, opts...)
+	if docAsUpsert && hasDoc {
+		op.upsert = doc
+	} else if hasUpsert {
+		op.upsert = upsert
+	}
+	return op, nil
+}
+
+// parseBulkAction returns the name and detail of the action line,
+// the name is empty if the line is not a valid action.
+func parseBulkAction(line []byte) (string, *BulkActionDetail) {
+	action := new(BulkAction)
+	if err := json.Unmarshal(line, action); err != nil {
+		return "", nil
+	}
+	var (
+		name   string
+		detail *BulkActionDetail
+		n      int
+	)
+	for _, a := range []struct {
+		name   string
+		detail *BulkActionDetail
+	}{{"index", action.Index}, {"create", action.Create}, {"update", action.Update}, {"delete", action.Delete}} {
+		if a.detail != nil {
+			name, detail = a.name, a.detail
+			n++
+		}
+	}
+	if n != 1 {
+		return "", nil
+	}
+	return name, detail
+}
+
+// guessBulkAction guesses the action name of the malformed action line, which is used to report the error.
+func guessBulkAction(line []byte) string {
+	for _, name := range []string{"index", "create", "update", "delete"} {
+		if bytes.Contains(line, []byte(`"`+name+`"`)) {
+			return name
+		}
+	}
+	return "index"
+}
+
+func newBulkResultItem(action string, res *BulkActionResult) BulkResultItem {
+	switch action {
+	case "create":
+		return BulkResultItem{Create: res}
+	case "update":
+		return BulkResultItem{Update: res}
+	case "delete":
+		return BulkResultItem{Delete: res}
+	default:
+		return BulkResultItem{Index: res}
 	}
 }
 
+// newBulkErrorResult returns the result of the failed action.
+func newBulkErrorResult(index, docID string, status int64, err error) *BulkActionResult {
+	op := newWriteOp(opIndex, index, docID, nil)
+	op.fail(err, status)
+	return op.result
+}
+
+// bulkBatch groups the write ops by shard.
+type bulkBatch struct {
+	ops     map[*IndexShard][]*writeOp
+	indices map[*IndexShard]*Index
+}
+
+func newBulkBatch() *bulkBatch {
+	return &bulkBatch{
+		ops:     make(map[*IndexShard][]*writeOp),
+		indices: make(map[*IndexShard]*Index),
+	}
+}
+
+func (b *bulkBatch) add(index *Index, op *writeOp) {
+	shard := index.getDocShard(op.docID)
+	b.ops[shard] = append(b.ops[shard], op)
+	b.indices[shard] = index
+}
+
+// execute executes the ops of each shard and resets the batch. The ops of the shard failed to write are failed
+// with the error, the other shards continue, and the first error is returned.
+func (b *bulkBatch) execute() error {
+	var firstErr error
+	for shard, ops := range b.ops {
+		index := b.indices[shard]
+		err := index.putTemplateFields(ops)
+		if err == nil {
+			err = index.executeWrites(shard, ops)
+		}
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		for _, op := range ops {
+			if op.err == nil {
+				op.fail(err, 500)
+				op.result.Result, op.result.Version, op.result.SeqNo = "", 0, 0
+			}
+		}
+	}
+	b.ops = make(map[*IndexShard][]*writeOp)
+	b.indices = make(map[*IndexShard]*Index)
+	return firstErr
+}
+
+// writeOptions returns the precondition specified in the action.
+func (d *BulkActionDetail) writeOptions() []WriteOption {
+	opts := make([]WriteOption, 0)
+	if d.IfSeqNo != nil {
+		term := primaryTerm
+		if d.IfPrimaryTerm != nil {
+			term = *d.IfPrimaryTerm
+		}
+		opts = append(opts, IfSeqNo(*d.IfSeqNo, term))
+	}
+	if d.Version != nil {
+		opts = append(opts, IfVersion(*d.Version))
+	}
+	return opts
+}
+
+// result returns the result of the action.
+func (item BulkResultItem) result() *BulkActionResult {
+	switch {
+	case item.Index != nil:
+		return item.Index
+	case item.Create != nil:
+		return item.Create
+	case item.Update != nil:
+		return item.Update
+	default:
+		return item.Delete
+	}
+}
+
+// resolveBulkIndex returns the index which the bulk action should be executed on,
+// the targetIndex is used if the action doesn't specify one.
+func resolveBulkIndex(indexName, targetIndex string) (string, error) {
+	if indexName == "" {
+		indexName = targetIndex
+		if indexName == "" {
+			return "", errors.ErrBulkDataFormat
+		}
+	}
+	return ResolveWriteIndex(indexName)
+}
+
 // BulkIndex bulk index(update if exists) docs into index.
 func (index *Index) BulkIndex(docs []map[string]interface{}) error {
 	if index.IsClosed() {
@@ -270,43 +379,29 @@ func (index *Index) BulkIndex(docs []map[string]interface{}) error {
 	}
 	batchSize := uint32(config.Global.Engine.DefaultBatchSize)
 	var currentBatch uint32
-	batch := make(map[int]*bleve.Batch, index.NumberOfShards)
-	mapping, err := buildIndexMapping(index.Mapping)
-	if err != nil {
-		return err
-	}
+	batch := newBulkBatch()
+	var err error
+	ops := make([]*writeOp, 0, len(docs))
 	for _, mdoc := range docs {
-		docID := uuid.GetUUID()
-		shard := index.getDocShard(docID)
-		bleveIndex := shard.Indexer
-		if batch[shard.ID] == nil {
-			batch[shard.ID] = bleveIndex.NewBatch()
-		}
-		bdoc, err := index.buildBleveDocument(docID, mdoc, mapping)
-		if err != nil {
-			return err
-		}
-		err = batch[shard.ID].IndexAdvanced(bdoc)
-		if err != nil {
-			return err
-		}
+		op := newWriteOp(opIndex, index.Name, uuid.GetUUID(), mdoc)
+		batch.add(index, op)
+		ops = append(ops, op)
 		currentBatch++
 		// execute the batch
 		if currentBatch >= batchSize {
-			err = bleveIndex.Batch(batch[shard.ID])
-			if err != nil {
+			if err = batch.execute(); err != nil {
 				return err
 			}
-			// do not forget to reset the batch
-			batch[shard.ID].Reset()
+			currentBatch = 0
 		}
 	}
 	// execute remaining in the batches
-	for shardId, bat := range batch {
-		bleveIndex := index.Shards[shardId].Indexer
-		err = bleveIndex.Batch(bat)
-		if err != nil {
-			return err
+	if err = batch.execute(); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.err != nil {
+			return op.err
 		}
 	}
 	return nil
@@ -3,12 +3,15 @@ package core
 import (
 	"bufio"
 	"bytes"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
 	"github.com/feimingxliu/quicksearch/pkg/util"
 	"github.com/feimingxliu/quicksearch/pkg/util/json"
 	"golang.org/x/sync/errgroup"
 	"log"
 	"os"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -160,3 +163,180 @@ func bulkIndexDocument(t *testing.T, num int, clean bool) {
 		t.Fatal(err)
 	}
 }
+
+func TestBulkItemErrors(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	lines := []string{
+		`{"create": {"_id": "1"}}`,
+		`{"n": 1}`,
+		`{"create": {"_id": "1"}}`,
+		`{"n": 2}`,
+		`{"index": {"_id": "2"`,
+		`{"n": 3}`,
+		`{"delete": {"_id": "3"}}`,
+		`{"index": {"_id": "4"}}`,
+		`{"n": 4`,
+		`{"unknown": {"_id": "5"}}`,
+		`{"delete": {"_index": "not-exists", "_id": "1"}}`,
+		`{"index": {"_id": "6"}}`,
+		`{"n": 6}`,
+	}
+	res, err := Bulk(indexName, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("bulk result", res)
+	if !res.Errors {
+		t.Error("errors not reported")
+	}
+	expected := []struct {
+		result string
+		status int64
+	}{{"created", 201}, {"", 409}, {"", 400}, {"not_found", 404}, {"", 400}, {"", 400}, {"index_not_found", 404}, {"created", 201}}
+	if len(res.Items) != len(expected) {
+		t.Fatalf("got %d items, expected %d", len(res.Items), len(expected))
+	}
+	for i, e := range expected {
+		if r := res.Items[i].result(); r.Result != e.result || r.Status != e.status {
+			t.Errorf("item %d: %s %d, expected %s %d", i, r.Result, r.Status, e.result, e.status)
+		}
+	}
+	if res.Items[1].Create == nil || res.Items[3].Delete == nil {
+		t.Error("items are reported in wrong action")
+	}
+	if r := res.Items[3].result(); r.Error != nil {
+		t.Errorf("the delete of missing document has error: %v", r.Error)
+	}
+	doc, err := index.GetDocument("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := doc.Source.(map[string]interface{}); source["n"] != float64(1) {
+		t.Errorf("the document is overwritten by create: %v", source)
+	}
+}
+
+func TestBulkShardFailure(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := NewIndex(WithName("closed_"+indexName), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		for _, index := range []*Index{index, closed} {
+			if err := index.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	batch := newBulkBatch()
+	ops := make([]*writeOp, 0)
+	for _, target := range []*Index{index, closed} {
+		for _, id := range []string{"1", "2", "3", "4"} {
+			op := newWriteOp(opIndex, target.Name, id, map[string]interface{}{"n": id})
+			batch.add(target, op)
+			ops = append(ops, op)
+		}
+	}
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the shards of the closed index fail, the others are written.
+	if err := batch.execute(); err != qerrors.ErrIndexClosed {
+		t.Errorf("execute the batch with the closed index: %v", err)
+	}
+	for i, op := range ops {
+		if i < 4 {
+			if op.err != nil || op.result.Status != 201 {
+				t.Errorf("op %d: %+v", i, op.result)
+			}
+			continue
+		}
+		if op.err != qerrors.ErrIndexClosed || op.result.Status != 500 || op.result.Result != "" {
+			t.Errorf("op %d of the closed index: %+v", i, op.result)
+		}
+	}
+	if n, err := countDocuments(nil, index); err != nil || n != 4 {
+		t.Errorf("got %d documents, expected 4", n)
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	source := map[string]interface{}{"title": "v1", "user": map[string]interface{}{"name": "a", "age": 18}}
+	if _, err := index.IndexOrUpdateDocument("1", source); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		`{"update": {"_id": "1"}}`,
+		`{"doc": {"user": {"age": 19, "city": "Beijing"}}}`,
+		`{"update": {"_id": "1"}}`,
+		`{"doc": {"title": "v1"}}`,
+		`{"update": {"_id": "2"}}`,
+		`{"doc": {"title": "v2"}}`,
+		`{"update": {"_id": "2"}}`,
+		`{"doc": {"title": "v2"}, "upsert": {"title": "upsert"}}`,
+		`{"update": {"_id": "3"}}`,
+		`{"doc": {"title": "v3"}, "doc_as_upsert": true}`,
+		`{"update": {"_id": "3"}}`,
+		`{"doc": "v3"}`,
+	}
+	res, err := Bulk(indexName, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("bulk result", res)
+	expected := []struct {
+		result string
+		status int64
+	}{{"updated", 200}, {"noop", 200}, {"", 404}, {"created", 201}, {"created", 201}, {"", 400}}
+	if len(res.Items) != len(expected) {
+		t.Fatalf("got %d items, expected %d", len(res.Items), len(expected))
+	}
+	for i, e := range expected {
+		if r := res.Items[i].Update; r == nil || r.Result != e.result || r.Status != e.status {
+			t.Errorf("item %d: %+v, expected %s %d", i, r, e.result, e.status)
+		}
+	}
+	for id, expected := range map[string]map[string]interface{}{
+		"1": {"title": "v1", "user": map[string]interface{}{"name": "a", "age": float64(19), "city": "Beijing"}},
+		"2": {"title": "upsert"},
+		"3": {"title": "v3"},
+	} {
+		doc, err := index.GetDocument(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(doc.Source, expected) {
+			t.Errorf("document %s: %v, expected %v", id, doc.Source, expected)
+		}
+	}
+}
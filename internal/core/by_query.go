@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"time"
+)
+
+type ByQueryRequest struct {
+	Query query.Query            `json:"query"` // optional, matches all documents if omitted
+	Doc   map[string]interface{} `json:"doc"`   // the fields merged into the matched documents, only used by UpdateByQuery
+	Size  int                    `json:"size"`  // the number of documents processed per batch
+}
+
+type ByQueryResult struct {
+	Took     time.Duration `json:"took"`
+	Total    uint64        `json:"total"`   // the number of documents matched
+	Deleted  uint64        `json:"deleted"` // the number of documents deleted by DeleteByQuery
+	Updated  uint64        `json:"updated"` // the number of documents updated by UpdateByQuery
+	Failed   uint64        `json:"failed"`
+	Batches  uint64        `json:"batches"`
+	Failures []string      `json:"failures,omitempty"`
+}
+
+func (r *ByQueryRequest) UnmarshalJSON(input []byte) error {
+	var temp struct {
+		Q    stdjson.RawMessage     `json:"query"`
+		Doc  map[string]interface{} `json:"doc"`
+		Size int                    `json:"size"`
+	}
+	err := json.Unmarshal(input, &temp)
+	if err != nil {
+		return err
+	}
+	r.Doc = temp.Doc
+	r.Size = temp.Size
+	if len(temp.Q) == 0 {
+		r.Query = nil
+		return nil
+	}
+	r.Query, err = ParseQuery(temp.Q)
+	return err
+}
+
+// DeleteByQuery deletes all the documents matching req.Query in the indices.
+func DeleteByQuery(ctx context.Context, req *ByQueryRequest, indices ...*Index) (*ByQueryResult, error) {
+	result, deleted, err := byQuery(ctx, req, indices, func(index *Index, doc *Document) *writeOp {
+		return newWriteOp(opDelete, index.Name, doc.ID, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Deleted = deleted
+	return result, nil
+}
+
+// UpdateByQuery merges req.Doc deeply into all the documents matching req.Query in the indices.
+// The matched documents are reindexed with the current mapping if req.Doc is empty.
+func UpdateByQuery(ctx context.Context, req *ByQueryRequest, indices ...*Index) (*ByQueryResult, error) {
+	result, updated, err := byQuery(ctx, req, indices, func(index *Index, doc *Document) *writeOp {
+		op := newWriteOp(opUpdate, index.Name, doc.ID, req.Doc)
+		// the document is always indexed again to apply the current mapping.
+		op.detectNoop = false
+		return op
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Updated = updated
+	return result, nil
+}
+
+// reindexInPlace starts a background task which indexes all the documents of the indices again with
+// the current mapping.
+func reindexInPlace(description string, indices ...*Index) *Task {
+	status := new(ByQueryResult)
+	task := newTask("update_by_query", description, status)
+	task.run(func(ctx context.Context) error {
+		result, err := UpdateByQuery(ctx, new(ByQueryRequest), indices...)
+		if err != nil {
+			return err
+		}
+		task.update(func() {
+			*status = *result
+		})
+		return nil
+	})
+	return task
+}
+
+// byQuery walks the documents matching req.Query shard by shard, f returns the write op of
+// each document, and the ops are executed in batches.
+// It returns the number of documents modified successfully besides the result.
+func byQuery(ctx context.Context, req *ByQueryRequest, indices []*Index, f func(index *Index, doc *Document) *writeOp) (*ByQueryResult, uint64, error) {
+	start := time.Now()
+	for _, index := range indices {
+		if index.IsClosed() {
+			return nil, 0, errors.ErrIndexClosed
+		}
+	}
+	if req.Size <= 0 {
+		req.Size = config.Global.Engine.DefaultBatchSize
+	}
+	total, err := countDocuments(req.Query, indices...)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := &ByQueryResult{Total: total}
+	var succeeded uint64
+	for _, index := range indices {
+		err = index.scanDocuments(ctx, req.Query, req.Size, func(docs []*Document) error {
+			batch := newBulkBatch()
+			ops := make([]*writeOp, 0, len(docs))
+			for _, doc := range docs {
+				op := f(index, doc)
+				batch.add(index, op)
+				ops = append(ops, op)
+			}
+			if err := batch.execute(); err != nil {
+				return err
+			}
+			for _, op := range ops {
+				if op.err != nil {
+					result.Failed++
+					result.Failures = append(result.Failures, fmt.Sprintf("[%s][%s]: %s", index.Name, op.docID, op.err))
+					continue
+				}
+				succeeded++
+			}
+			result.Batches++
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	result.Took = time.Since(start)
+	return result, succeeded, nil
+}
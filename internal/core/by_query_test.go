@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"testing"
+)
+
+func TestByQuery(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := index.IndexOrUpdateDocument(fmt.Sprintf("%03d", i), map[string]interface{}{"n": i, "even": i%2 == 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	even := bleve.NewBoolFieldQuery(true)
+	even.SetField("even")
+	res, err := UpdateByQuery(context.Background(), &ByQueryRequest{Query: even, Doc: map[string]interface{}{"tag": "even"}, Size: 7}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("update by query", res)
+	if res.Total != 50 || res.Updated != 50 || res.Failed != 0 {
+		t.Errorf("updated %d of %d documents, expected 50", res.Updated, res.Total)
+	}
+	doc, err := index.GetDocument("002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := doc.Source.(map[string]interface{}); source["tag"] != "even" || source["n"] != float64(2) {
+		t.Errorf("unexpected document source: %v", source)
+	}
+	tag := bleve.NewTermQuery("even")
+	tag.SetField("tag")
+	if n, err := countDocuments(tag, index); err != nil || n != 50 {
+		t.Errorf("%d documents are tagged, expected 50", n)
+	}
+
+	res, err = DeleteByQuery(context.Background(), &ByQueryRequest{Query: tag, Size: 7}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("delete by query", res)
+	if res.Total != 50 || res.Deleted != 50 || res.Failed != 0 {
+		t.Errorf("deleted %d of %d documents, expected 50", res.Deleted, res.Total)
+	}
+	if n, err := countDocuments(nil, index); err != nil || n != 50 {
+		t.Errorf("index has %d documents, expected 50", n)
+	}
+	if n, err := countDocuments(even, index); err != nil || n != 0 {
+		t.Errorf("index has %d even documents, expected 0", n)
+	}
+}
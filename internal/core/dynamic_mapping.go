@@ -0,0 +1,421 @@
+package core
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"path"
+	"strings"
+)
+
+// the dynamic modes of mapping: the unknown fields are indexed by bleve if true, kept in `_source` but not
+// indexed if false, and the documents with unknown fields are rejected if strict.
+const (
+	dynamicTrue   = "true"
+	dynamicFalse  = "false"
+	dynamicStrict = "strict"
+)
+
+// DynamicTemplate maps the dynamic fields matching all the conditions to the field mapping.
+// The patterns support wildcard "*", the first matched template is used.
+type DynamicTemplate struct {
+	Match            string        `json:"match,omitempty" mapstructure:"match"`                           // the pattern of field name
+	Unmatch          string        `json:"unmatch,omitempty" mapstructure:"unmatch"`                       // the pattern of field name to exclude
+	PathMatch        string        `json:"path_match,omitempty" mapstructure:"path_match"`                 // the pattern of the dotted path
+	PathUnmatch      string        `json:"path_unmatch,omitempty" mapstructure:"path_unmatch"`             // the pattern of the dotted path to exclude
+	MatchMappingType string        `json:"match_mapping_type,omitempty" mapstructure:"match_mapping_type"` // "string", "number" or "boolean"
+	Mapping          *FieldMapping `json:"mapping" mapstructure:"mapping"`
+}
+
+// dynamicMode parses the dynamic setting, which is a bool or one of "true", "false" and "strict".
+// The parent mode is returned if it's omitted.
+func dynamicMode(value interface{}, parent string) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return parent, nil
+	case bool:
+		if v {
+			return dynamicTrue, nil
+		}
+		return dynamicFalse, nil
+	case string:
+		switch mode := strings.ToLower(v); mode {
+		case dynamicTrue, dynamicFalse, dynamicStrict:
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("invalid dynamic [%v], must be true, false or strict", value)
+}
+
+func validateDynamicTemplates(mapping *imapping.IndexMappingImpl, templates []*DynamicTemplate) error {
+	for i, template := range templates {
+		if template == nil || template.Mapping == nil {
+			return fmt.Errorf("dynamic_templates[%d]: mapping is required", i)
+		}
+		switch template.MatchMappingType {
+		case "", "*", "string", "number", "boolean":
+		default:
+			return fmt.Errorf("dynamic_templates[%d]: unknown match_mapping_type [%s]", i, template.MatchMappingType)
+		}
+		for _, pattern := range []string{template.Match, template.Unmatch, template.PathMatch, template.PathUnmatch} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("dynamic_templates[%d]: invalid pattern [%s]", i, pattern)
+			}
+		}
+		// the analyzer is registered in the mapping here, so the field mappings built for documents don't modify it.
+		if _, err := buildTemplateFieldMapping(mapping, template); err != nil {
+			return fmt.Errorf("dynamic_templates[%d]: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// match reports whether the dynamic field at the dotted path matches the template.
+func (t *DynamicTemplate) match(path string, value interface{}) bool {
+	name := path[strings.LastIndex(path, ".")+1:]
+	switch t.MatchMappingType {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return false
+		}
+	case "number":
+		if detectFieldType(nil, value) != "number" {
+			return false
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return false
+		}
+	}
+	return matchPattern(t.Match, name, true) && !matchPattern(t.Unmatch, name, false) &&
+		matchPattern(t.PathMatch, path, true) && !matchPattern(t.PathUnmatch, path, false)
+}
+
+// matchPattern matches s with pattern, an empty pattern results in omitted.
+func matchPattern(pattern, s string, omitted bool) bool {
+	if len(pattern) == 0 {
+		return omitted
+	}
+	matched, _ := path.Match(pattern, s)
+	return matched
+}
+
+// dynamicTemplate returns the first template matching the dynamic field.
+func (im *IndexMapping) dynamicTemplate(path string, value interface{}) *DynamicTemplate {
+	if im == nil {
+		return nil
+	}
+	for _, template := range im.DynamicTemplates {
+		if template.match(path, value) {
+			return template
+		}
+	}
+	return nil
+}
+
+func buildTemplateFieldMapping(mapping *imapping.IndexMappingImpl, template *DynamicTemplate) (*imapping.FieldMapping, error) {
+	// copy the field mapping since it's shared by the documents.
+	fm := *template.Mapping
+	fieldMapping, err := buildFieldMapping(&fm)
+	if err != nil {
+		return nil, err
+	}
+	if len(fieldMapping.Analyzer) > 0 {
+		if fieldMapping.Analyzer, err = resolveAnalyzer(mapping, fieldMapping.Analyzer); err != nil {
+			return nil, err
+		}
+	}
+	return fieldMapping, nil
+}
+
+// dynamicMapping checks the unknown fields of source against the dynamic modes, and returns the mapping with the
+// fields matching the dynamic templates declared, which is used to index the source. The mapping is copied along
+// the paths of the declared fields, so it's safe to modify.
+func (im *IndexMapping) dynamicMapping(mapping imapping.IndexMapping, source map[string]interface{}) (imapping.IndexMapping, error) {
+	impl, ok := mapping.(*imapping.IndexMappingImpl)
+	if im == nil || !ok || (len(im.DynamicTemplates) == 0 && !im.hasStrict()) {
+		return mapping, nil
+	}
+	typ, templates, err := im.templateFields(impl, source)
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return mapping, nil
+	}
+	copied := *impl
+	var root *imapping.DocumentMapping
+	if tm, ok := impl.TypeMapping[typ]; ok {
+		root = copyDocumentMapping(tm)
+		copied.TypeMapping = make(map[string]*imapping.DocumentMapping, len(impl.TypeMapping))
+		for t, m := range impl.TypeMapping {
+			copied.TypeMapping[t] = m
+		}
+		copied.TypeMapping[typ] = root
+	} else {
+		root = copyDocumentMapping(impl.DefaultMapping)
+		copied.DefaultMapping = root
+	}
+	for p, template := range templates {
+		fm, err := buildTemplateFieldMapping(impl, template)
+		if err != nil {
+			return nil, err
+		}
+		dm := root
+		for _, name := range strings.Split(p, ".") {
+			child, ok := dm.Properties[name]
+			if ok {
+				child = copyDocumentMapping(child)
+			} else {
+				child = bleve.NewDocumentMapping()
+				child.Dynamic = dm.Dynamic
+			}
+			dm.Properties[name] = child
+			dm = child
+		}
+		// the field is declared in the shard mapping but not yet in im while the template fields are put.
+		if len(dm.Fields) == 0 {
+			dm.Fields = append(dm.Fields, fm)
+		}
+	}
+	return &copied, nil
+}
+
+// templateFields returns the type of source and its dynamic fields matching the dynamic templates by their paths,
+// mapping is the one built from im. The unknown fields are rejected if strict.
+func (im *IndexMapping) templateFields(mapping *imapping.IndexMappingImpl, source map[string]interface{}) (string, map[string]*DynamicTemplate, error) {
+	typ := mapping.DefaultType
+	if t, ok := sourceValue(source, strings.Split(mapping.TypeField, ".")).(string); ok && len(t) > 0 {
+		typ = t
+	}
+	dm, found := im.TypeMapping[typ]
+	if !found {
+		dm = im.DefaultMapping
+	}
+	dynamic, err := dynamicMode(im.Dynamic, dynamicTrue)
+	if err != nil {
+		return "", nil, err
+	}
+	w := &dynamicWalker{im: im, typeField: mapping.TypeField, templates: make(map[string]*DynamicTemplate)}
+	if err := w.walkFields(dm, dynamic, "", source); err != nil {
+		return "", nil, err
+	}
+	return typ, w.templates, nil
+}
+
+// putTemplateFields adds the dynamic fields of ops matching the dynamic templates to the mapping of index before
+// the ops executed, so the queries resolve them with the template mappings like the declared fields.
+func (index *Index) putTemplateFields(ops []*writeOp) error {
+	index.mu.RLock()
+	mapping, err := index.templateMapping(ops)
+	index.mu.RUnlock()
+	if err != nil || mapping == nil {
+		return err
+	}
+	index.mu.Lock()
+	// the fields may be added by the concurrent writes.
+	var data []byte
+	if mapping, err = index.templateMapping(ops); err == nil && mapping != nil {
+		var bm imapping.IndexMapping
+		if bm, err = buildIndexMapping(mapping); err == nil {
+			if data, err = json.Marshal(bm); err == nil {
+				err = index.setMapping(mapping, data)
+			}
+		}
+	}
+	index.mu.Unlock()
+	if err != nil || mapping == nil {
+		return err
+	}
+	return index.UpdateMetadata()
+}
+
+// templateMapping returns a copy of the mapping of index with the template fields of ops added, or nil if none
+// is added. The caller must hold index.mu.
+func (index *Index) templateMapping(ops []*writeOp) (*IndexMapping, error) {
+	if index.closed {
+		return nil, errors.ErrIndexClosed
+	}
+	im := index.Mapping
+	if im == nil || len(im.DynamicTemplates) == 0 {
+		return nil, nil
+	}
+	impl, ok := index.Shards[0].Indexer.Mapping().(*imapping.IndexMappingImpl)
+	if !ok {
+		return nil, nil
+	}
+	var mapping *IndexMapping
+	added := false
+	for _, op := range ops {
+		for _, source := range []map[string]interface{}{op.source, op.upsert} {
+			if source == nil {
+				continue
+			}
+			// the op with the invalid fields fails when executed.
+			typ, templates, err := im.templateFields(impl, source)
+			if err != nil || len(templates) == 0 {
+				continue
+			}
+			if mapping == nil {
+				mapping = new(IndexMapping)
+				data, err := json.Marshal(im)
+				if err != nil {
+					return nil, err
+				}
+				if err := json.Unmarshal(data, mapping); err != nil {
+					return nil, err
+				}
+			}
+			if mapping.addTemplateFields(typ, templates) {
+				added = true
+			}
+		}
+	}
+	if !added {
+		return nil, nil
+	}
+	return mapping, nil
+}
+
+// addTemplateFields declares the fields of type typ with the mappings of the dynamic templates matched, and reports
+// whether any field is added.
+func (im *IndexMapping) addTemplateFields(typ string, templates map[string]*DynamicTemplate) bool {
+	root, ok := im.TypeMapping[typ]
+	if !ok {
+		if im.DefaultMapping == nil {
+			im.DefaultMapping = new(DocumentMapping)
+		}
+		root = im.DefaultMapping
+	}
+	added := false
+	for p, template := range templates {
+		dm := root
+		for _, name := range strings.Split(p, ".") {
+			child := dm.Properties[name]
+			if child == nil {
+				// the dynamic mode is inherited from the parent.
+				child = new(DocumentMapping)
+				if dm.Properties == nil {
+					dm.Properties = make(map[string]*DocumentMapping)
+				}
+				dm.Properties[name] = child
+			}
+			dm = child
+		}
+		if len(dm.Fields) == 0 {
+			fm := *template.Mapping
+			dm.Fields = []*FieldMapping{&fm}
+			added = true
+		}
+	}
+	return added
+}
+
+// hasStrict reports whether the mapping or any document mapping is strict.
+func (im *IndexMapping) hasStrict() bool {
+	var strict func(dm *DocumentMapping) bool
+	strict = func(dm *DocumentMapping) bool {
+		if dm == nil {
+			return false
+		}
+		if mode, _ := dynamicMode(dm.Dynamic, ""); mode == dynamicStrict {
+			return true
+		}
+		for _, property := range dm.Properties {
+			if strict(property) {
+				return true
+			}
+		}
+		return false
+	}
+	if mode, _ := dynamicMode(im.Dynamic, ""); mode == dynamicStrict || strict(im.DefaultMapping) {
+		return true
+	}
+	for _, dm := range im.TypeMapping {
+		if strict(dm) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyDocumentMapping copies the document mapping with its own properties and fields.
+func copyDocumentMapping(dm *imapping.DocumentMapping) *imapping.DocumentMapping {
+	copied := *dm
+	copied.Properties = make(map[string]*imapping.DocumentMapping, len(dm.Properties))
+	for name, property := range dm.Properties {
+		copied.Properties[name] = property
+	}
+	copied.Fields = append([]*imapping.FieldMapping(nil), dm.Fields...)
+	return &copied
+}
+
+// dynamicWalker walks the source along the document mapping, the unknown fields are rejected if strict,
+// and the ones matching the dynamic templates are collected by their paths.
+type dynamicWalker struct {
+	im        *IndexMapping
+	typeField string
+	templates map[string]*DynamicTemplate
+}
+
+func (w *dynamicWalker) walkFields(dm *DocumentMapping, dynamic, prefix string, object map[string]interface{}) error {
+	for name, value := range object {
+		p := prefix + name
+		var property *DocumentMapping
+		if dm != nil {
+			property = dm.Properties[name]
+		}
+		mode := dynamic
+		if property != nil {
+			if property.Disabled {
+				continue
+			}
+			var err error
+			if mode, err = dynamicMode(property.Dynamic, dynamic); err != nil {
+				return err
+			}
+		} else {
+			switch dynamic {
+			case dynamicStrict:
+				// the type field is used to choose the type mapping.
+				if p == w.typeField {
+					continue
+				}
+				return fmt.Errorf("%w: [%s]", errors.ErrStrictDynamicMapping, p)
+			case dynamicFalse:
+				continue
+			}
+		}
+		if err := w.walkValue(property, mode, p, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *dynamicWalker) walkValue(property *DocumentMapping, dynamic, p string, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return w.walkFields(property, dynamic, p+".", v)
+	case []interface{}:
+		for _, e := range v {
+			if err := w.walkValue(property, dynamic, p, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		return nil
+	}
+	if property != nil {
+		return nil
+	}
+	if _, ok := w.templates[p]; !ok {
+		if template := w.im.dynamicTemplate(p, value); template != nil {
+			w.templates[p] = template
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"errors"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+const dynamicMapping = `
+{
+    "dynamic": "strict",
+    "default_mapping": {
+        "properties": {
+            "title": {"fields": [{"type": "text"}]},
+            "meta": {"dynamic": false},
+            "attrs": {"dynamic": true}
+        }
+    },
+    "dynamic_templates": [
+        {"match": "*_id", "match_mapping_type": "string", "mapping": {"type": "keyword"}}
+    ]
+}
+`
+
+func TestDynamicMapping(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	im := new(IndexMapping)
+	if err := json.Unmarshal([]byte(dynamicMapping), im); err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	count := func(query string) int {
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(`{"query": `+query+`}`), req); err != nil {
+			t.Fatal(err)
+		}
+		res, err := index.Search(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.Hits)
+	}
+
+	if _, err := index.IndexOrUpdateDocument("1", map[string]interface{}{"title": "Quick Search", "meta": map[string]interface{}{"note": "hidden"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(`{"type": "term", "term": "hidden", "field": "meta.note"}`); n != 0 {
+		t.Errorf("got %d hits of the field not indexed", n)
+	}
+	doc, err := index.GetDocument("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := doc.Source.(map[string]interface{}); source["meta"] == nil {
+		t.Errorf("the field not indexed is not kept in the source: %v", source)
+	}
+
+	_, err = index.IndexOrUpdateDocument("2", map[string]interface{}{"title": "Quick Fox", "extra": map[string]interface{}{"n": 1}})
+	if !errors.Is(err, qerrors.ErrStrictDynamicMapping) || !strings.Contains(err.Error(), "[extra]") {
+		t.Fatalf("index the document with unknown field: %v", err)
+	}
+	if _, err := index.GetDocument("2"); err == nil {
+		t.Error("the rejected document is indexed")
+	}
+	lines := []string{
+		`{"index": {"_id": "3"}}`,
+		`{"title": "Lazy Dog", "attrs": {"user_id": "AB-1", "note": "Hello World"}}`,
+		`{"index": {"_id": "4"}}`,
+		`{"title": "Lazy Cat", "tags": ["cat"]}`,
+	}
+	res, err := Bulk(indexName, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := res.Items[0].result(); r.Status != 201 {
+		t.Errorf("bulk index the document with dynamic fields: %d %v", r.Status, r.Error)
+	}
+	if r := res.Items[1].result(); r.Status != 400 || !strings.Contains(r.Error.(string), "[tags]") {
+		t.Errorf("bulk index the document with unknown field: %d %v", r.Status, r.Error)
+	}
+
+	// the dynamic template maps the field to keyword, the others are indexed by bleve.
+	if n := count(`{"type": "term", "term": "AB-1", "field": "attrs.user_id"}`); n != 1 {
+		t.Errorf("got %d hits of the keyword field mapped by template", n)
+	}
+	if n := count(`{"type": "term", "term": "hello", "field": "attrs.note"}`); n != 1 {
+		t.Errorf("got %d hits of the dynamic text field", n)
+	}
+	fields, err := index.Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range fields {
+		if field.Name == "attrs.user_id" && (field.Type != "keyword" || field.Dynamic) {
+			t.Errorf("the field mapped by template: %+v", field)
+		}
+	}
+	// the field mapped by template is put into the mapping, so the queries analyze the text like the keyword field.
+	if property := index.Mapping.DefaultMapping.Properties["attrs"].Properties["user_id"]; property == nil || property.Fields[0].Type != "keyword" {
+		t.Errorf("the field mapped by template is not put into the mapping: %+v", property)
+	}
+	if n := count(`{"match": "AB-1", "field": "attrs.user_id"}`); n != 1 {
+		t.Errorf("got %d hits of the match query on the keyword field mapped by template", n)
+	}
+
+	if _, err := BuildIndexMappingFromMap(map[string]interface{}{"dynamic": "yes"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildIndexMapping(&IndexMapping{Dynamic: "yes"}); err == nil || !strings.Contains(err.Error(), "invalid dynamic [yes]") {
+		t.Errorf("build the mapping with invalid dynamic: %v", err)
+	}
+	if _, err := buildIndexMapping(&IndexMapping{DynamicTemplates: []*DynamicTemplate{{Match: "*"}}}); err == nil {
+		t.Error("the dynamic template without mapping is built")
+	}
+}
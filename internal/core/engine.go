@@ -2,9 +2,9 @@ package core
 
 import (
 	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/userdict"
 	"github.com/feimingxliu/quicksearch/internal/pkg/storager"
 	"path"
-	"strings"
 	"sync"
 )
 
@@ -14,12 +14,27 @@ var engine *Engine
 func NewEngine() *Engine {
 	return &Engine{
 		indices: make(map[string]*Index),
+		aliases: make(map[string]*Alias),
+		tasks:   make(map[string]*Task),
+		scrolls: make(map[string]*scroll),
+		jobs:    make(map[string]bool),
 	}
 }
 
 type Engine struct {
-	indices map[string]*Index // the opened indexes
-	meta    storager.Storager // metadata storage
+	indices   map[string]*Index // the opened indexes
+	meta      storager.Storager // metadata storage
+	aliases   map[string]*Alias // alias name => alias
+	aliasMeta storager.Storager // alias metadata storage
+	aliasMu   sync.RWMutex
+	tasks     map[string]*Task // task id => task
+	taskMu    sync.RWMutex
+	scrolls   map[string]*scroll // scroll id => scroll
+	scrollMu  sync.RWMutex
+	repoMeta  storager.Storager // snapshot repository metadata storage
+	jobs      map[string]bool   // the running snapshot and restore jobs, see reserveJob
+	jobMu     sync.Mutex
+	refsMu    sync.Mutex // guards the refs of snapshot blobs, see blobStore
 	sync.RWMutex
 }
 
@@ -29,6 +44,12 @@ func (e *Engine) Run() error {
 	if err := e.initMeta(); err != nil {
 		return err
 	}
+	if err := userdict.SetDir(path.Join(config.Global.Storage.DataDir, "dict", "user")); err != nil {
+		return err
+	}
+	if err := e.loadAllAliases(); err != nil {
+		return err
+	}
 	if err := e.loadAllIndices(); err != nil {
 		return err
 	}
@@ -36,12 +57,20 @@ func (e *Engine) Run() error {
 }
 
 func (e *Engine) Stop() error {
+	e.cancelAllTasks()
+	e.closeAllScrolls()
 	if err := e.closeAllIndices(); err != nil {
 		return err
 	}
 	if err := e.meta.Close(); err != nil {
 		return err
 	}
+	if err := e.aliasMeta.Close(); err != nil {
+		return err
+	}
+	if err := e.repoMeta.Close(); err != nil {
+		return err
+	}
 	engine = nil
 	return nil
 }
@@ -65,20 +94,34 @@ func (e *Engine) removeIndex(index *Index) {
 	e.Unlock()
 }
 
+// metaNames are the names of metadata storages under the metadata dir.
+var metaNames = []string{"meta", "alias", "repository"}
+
 func (e *Engine) initMeta() error {
 	var err error
-	switch strings.ToLower(config.Global.Storage.MetaType) {
-	case "bolt":
-		e.meta, err = storager.NewStorager(storager.Bolt, path.Join(config.Global.Storage.DataDir, "metadata", "meta"))
-	default:
-		e.meta, err = storager.NewStorager(storager.Default, path.Join(config.Global.Storage.DataDir, "metadata", "meta"))
+	if e.meta, err = newMetaStorager("meta"); err != nil {
+		return err
 	}
-	if err != nil {
+	if e.aliasMeta, err = newMetaStorager("alias"); err != nil {
+		return err
+	}
+	if e.repoMeta, err = newMetaStorager("repository"); err != nil {
 		return err
 	}
 	return nil
 }
 
+// newMetaStorager opens the metadata storage with the name under metadata dir.
+// The default storage is used if the meta-type is unknown.
+func newMetaStorager(name string) (storager.Storager, error) {
+	st, _ := storager.ParseStorageType(config.Global.Storage.MetaType)
+	return storager.NewStorager(st, path.Join(metaDir(), name))
+}
+
+func metaDir() string {
+	return path.Join(config.Global.Storage.DataDir, "metadata")
+}
+
 func (e *Engine) loadAllIndices() error {
 	indices, err := ListIndices()
 	if err != nil {
@@ -0,0 +1,908 @@
+package core
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	bindex "github.com/blevesearch/bleve_index_api"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseESQuery translates the Elasticsearch Query DSL to bleve query, the error contains
+// the JSON path of the clause which fails to parse.
+func ParseESQuery(input []byte) (query.Query, error) {
+	return parseESQuery(input, "query")
+}
+
+func parseESQuery(input stdjson.RawMessage, path string) (query.Query, error) {
+	var clause map[string]stdjson.RawMessage
+	if err := json.Unmarshal(input, &clause); err != nil {
+		return nil, queryError(path, "query must be an object")
+	}
+	if len(clause) != 1 {
+		return nil, queryError(path, "query must have exactly one type, got %d", len(clause))
+	}
+	for typ, body := range clause {
+		path = path + "." + typ
+		switch typ {
+		case "match_all":
+			return withESBoost(bleve.NewMatchAllQuery(), body, path)
+		case "match_none":
+			return bleve.NewMatchNoneQuery(), nil
+		case "bool":
+			return parseESBoolQuery(body, path)
+		case "constant_score":
+			return parseESConstantScoreQuery(body, path)
+		case "dis_max":
+			return parseESDisMaxQuery(body, path)
+		case "function_score":
+			var clause map[string]stdjson.RawMessage
+			if err := json.Unmarshal(body, &clause); err != nil {
+				return nil, queryError(path, "function_score must be an object")
+			}
+			return parseFunctionScoreQuery(clause, path, parseESQuery)
+		case "match", "match_phrase", "match_phrase_prefix":
+			return parseESMatchQuery(typ, body, path)
+		case "multi_match":
+			return parseESMultiMatchQuery(body, path)
+		case "term", "prefix", "wildcard", "regexp", "fuzzy":
+			return parseESTermLevelQuery(typ, body, path)
+		case "terms":
+			return parseESTermsQuery(body, path)
+		case "range":
+			return parseESRangeQuery(body, path)
+		case "exists":
+			var exists struct {
+				Field string `json:"field"`
+			}
+			if err := json.Unmarshal(body, &exists); err != nil || len(exists.Field) == 0 {
+				return nil, queryError(path, "field is required")
+			}
+			return &ExistsQuery{FieldVal: exists.Field, BoostVal: 1}, nil
+		case "ids":
+			var ids struct {
+				Values []string `json:"values"`
+			}
+			if err := json.Unmarshal(body, &ids); err != nil {
+				return nil, queryError(path, "values must be an array of string")
+			}
+			return bleve.NewDocIDQuery(ids.Values), nil
+		case "query_string", "simple_query_string":
+			var qs struct {
+				Query string   `json:"query"`
+				Boost *float64 `json:"boost"`
+			}
+			if err := json.Unmarshal(body, &qs); err != nil {
+				return nil, queryError(path, err.Error())
+			}
+			// the "*" is used by kibana to match all documents.
+			if strings.TrimSpace(qs.Query) == "*" {
+				return bleve.NewMatchAllQuery(), nil
+			}
+			q := bleve.NewQueryStringQuery(qs.Query)
+			if qs.Boost != nil {
+				q.SetBoost(*qs.Boost)
+			}
+			return q, nil
+		default:
+			return nil, queryError(path, "unknown query [%s]", typ)
+		}
+	}
+	return nil, nil
+}
+
+// parseESQueries parses an object or array of queries.
+func parseESQueries(input stdjson.RawMessage, path string) ([]query.Query, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	var list []stdjson.RawMessage
+	if err := json.Unmarshal(input, &list); err != nil {
+		q, err := parseESQuery(input, path)
+		if err != nil {
+			return nil, err
+		}
+		return []query.Query{q}, nil
+	}
+	queries := make([]query.Query, 0, len(list))
+	for i, raw := range list {
+		q, err := parseESQuery(raw, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+func parseESBoolQuery(input stdjson.RawMessage, path string) (query.Query, error) {
+	var body struct {
+		Must               stdjson.RawMessage `json:"must"`
+		Filter             stdjson.RawMessage `json:"filter"`
+		Should             stdjson.RawMessage `json:"should"`
+		MustNot            stdjson.RawMessage `json:"must_not"`
+		MinimumShouldMatch interface{}        `json:"minimum_should_match"`
+		Boost              *float64           `json:"boost"`
+	}
+	if err := json.Unmarshal(input, &body); err != nil {
+		return nil, queryError(path, err.Error())
+	}
+	must, err := parseESQueries(body.Must, path+".must")
+	if err != nil {
+		return nil, err
+	}
+	// the filter clauses must match as must clauses, but they don't contribute to the score.
+	filter, err := parseESQueries(body.Filter, path+".filter")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range filter {
+		must = append(must, newConstantScoreQuery(f, 0))
+	}
+	should, err := parseESQueries(body.Should, path+".should")
+	if err != nil {
+		return nil, err
+	}
+	mustNot, err := parseESQueries(body.MustNot, path+".must_not")
+	if err != nil {
+		return nil, err
+	}
+	if len(must) == 0 && len(should) == 0 && len(mustNot) == 0 {
+		return bleve.NewMatchAllQuery(), nil
+	}
+	q := bleve.NewBooleanQuery()
+	if len(must) > 0 {
+		q.AddMust(must...)
+	}
+	if len(should) > 0 {
+		q.AddShould(should...)
+		// at least one should clause must match if there is no must clause.
+		min := 0
+		if len(must) == 0 {
+			min = 1
+		}
+		if body.MinimumShouldMatch != nil {
+			if min, err = minimumShouldMatch(body.MinimumShouldMatch, len(should)); err != nil {
+				return nil, queryError(path+".minimum_should_match", err.Error())
+			}
+		}
+		q.SetMinShould(float64(min))
+	}
+	if len(mustNot) > 0 {
+		q.AddMustNot(mustNot...)
+	}
+	if body.Boost != nil {
+		q.SetBoost(*body.Boost)
+	}
+	return q, nil
+}
+
+// minimumShouldMatch parses the integer or percentage like "2", "-1", "75%".
+func minimumShouldMatch(value interface{}, clauses int) (int, error) {
+	var (
+		n   float64
+		err error
+	)
+	switch v := value.(type) {
+	case float64:
+		n = v
+	case string:
+		if strings.HasSuffix(v, "%") {
+			if n, err = strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64); err != nil {
+				return 0, fmt.Errorf("invalid value [%s]", v)
+			}
+			n = math.Trunc(float64(clauses) * n / 100)
+		} else if n, err = strconv.ParseFloat(v, 64); err != nil {
+			return 0, fmt.Errorf("invalid value [%s]", v)
+		}
+	default:
+		return 0, fmt.Errorf("invalid value [%v]", v)
+	}
+	if n < 0 {
+		n += float64(clauses)
+	}
+	return int(math.Max(0, math.Min(n, float64(clauses)))), nil
+}
+
+func parseESConstantScoreQuery(input stdjson.RawMessage, path string) (query.Query, error) {
+	var body struct {
+		Filter stdjson.RawMessage `json:"filter"`
+		Boost  *float64           `json:"boost"`
+	}
+	if err := json.Unmarshal(input, &body); err != nil || len(body.Filter) == 0 {
+		return nil, queryError(path, "filter is required")
+	}
+	q, err := parseESQuery(body.Filter, path+".filter")
+	if err != nil {
+		return nil, err
+	}
+	score := 1.0
+	if body.Boost != nil {
+		score = *body.Boost
+	}
+	return newConstantScoreQuery(q, score), nil
+}
+
+// constantScoreQuery matches the documents of the query and scores all of them by the score.
+type constantScoreQuery struct {
+	Query query.Query `json:"filter"`
+	Score float64     `json:"boost"`
+}
+
+func newConstantScoreQuery(q query.Query, score float64) *constantScoreQuery {
+	return &constantScoreQuery{Query: q, Score: score}
+}
+
+func (q *constantScoreQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	scoreOptions := options
+	scoreOptions.Score = "none"
+	searcher, err := q.Query.Searcher(i, m, scoreOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &constantScoreSearcher{Searcher: searcher, score: q.Score, explain: options.Explain}, nil
+}
+
+// constantScoreSearcher wraps the searcher of query and replaces the scores of matches. Its weight is zero,
+// so it doesn't change the query norm of the searchers around it.
+type constantScoreSearcher struct {
+	search.Searcher
+	score   float64
+	explain bool
+}
+
+func (s *constantScoreSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Next(ctx)
+	return s.next(dm, err)
+}
+
+func (s *constantScoreSearcher) Advance(ctx *search.SearchContext, ID bindex.IndexInternalID) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Advance(ctx, ID)
+	return s.next(dm, err)
+}
+
+func (s *constantScoreSearcher) next(dm *search.DocumentMatch, err error) (*search.DocumentMatch, error) {
+	if err != nil || dm == nil {
+		return dm, err
+	}
+	dm.Score = s.score
+	if s.explain {
+		dm.Expl = &search.Explanation{Value: s.score, Message: "constant score"}
+	}
+	return dm, nil
+}
+
+func (s *constantScoreSearcher) Weight() float64 {
+	return 0
+}
+
+func (s *constantScoreSearcher) SetQueryNorm(float64) {}
+
+func parseESDisMaxQuery(input stdjson.RawMessage, path string) (query.Query, error) {
+	var body struct {
+		Queries []stdjson.RawMessage `json:"queries"`
+		Boost   *float64             `json:"boost"`
+	}
+	if err := json.Unmarshal(input, &body); err != nil || len(body.Queries) == 0 {
+		return nil, queryError(path, "queries is required")
+	}
+	queries := make([]query.Query, 0, len(body.Queries))
+	for i, raw := range body.Queries {
+		q, err := parseESQuery(raw, fmt.Sprintf("%s.queries[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	q := bleve.NewDisjunctionQuery(queries...)
+	if body.Boost != nil {
+		q.SetBoost(*body.Boost)
+	}
+	return q, nil
+}
+
+// esFieldOptions is the options of the query on a field, e.g. {"match": {"title": {"query": "foo", "operator": "and"}}}.
+type esFieldOptions struct {
+	Query        interface{} `json:"query"`
+	Value        interface{} `json:"value"`
+	Operator     string      `json:"operator"`
+	Fuzziness    interface{} `json:"fuzziness"`
+	PrefixLength int         `json:"prefix_length"`
+	Analyzer     string      `json:"analyzer"`
+	Boost        *float64    `json:"boost"`
+	Gt           interface{} `json:"gt"`
+	Gte          interface{} `json:"gte"`
+	Lt           interface{} `json:"lt"`
+	Lte          interface{} `json:"lte"`
+	Format       string      `json:"format"`
+	TimeZone     string      `json:"time_zone"`
+}
+
+// parseESFieldClause parses the clause with the field name as the only key, the value
+// can be a scalar or the options object.
+func parseESFieldClause(input stdjson.RawMessage, path string) (string, *esFieldOptions, error) {
+	var clause map[string]stdjson.RawMessage
+	if err := json.Unmarshal(input, &clause); err != nil {
+		return "", nil, queryError(path, "query must be an object")
+	}
+	delete(clause, "boost")
+	delete(clause, "_name")
+	if len(clause) != 1 {
+		return "", nil, queryError(path, "query must have exactly one field")
+	}
+	for field, raw := range clause {
+		opts := new(esFieldOptions)
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", nil, queryError(path+"."+field, err.Error())
+		}
+		if _, ok := value.(map[string]interface{}); ok {
+			if err := json.Unmarshal(raw, opts); err != nil {
+				return "", nil, queryError(path+"."+field, err.Error())
+			}
+		} else {
+			opts.Query, opts.Value = value, value
+		}
+		if opts.Value == nil {
+			opts.Value = opts.Query
+		}
+		return field, opts, nil
+	}
+	return "", nil, nil
+}
+
+// fuzziness converts the fuzziness like "AUTO", "1" or 2 to the edit distance.
+func (o *esFieldOptions) fuzziness() int {
+	switch v := o.Fuzziness.(type) {
+	case float64:
+		return int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		if strings.HasPrefix(strings.ToUpper(v), "AUTO") {
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseESMatchQuery(typ string, input stdjson.RawMessage, path string) (query.Query, error) {
+	field, opts, err := parseESFieldClause(input, path)
+	if err != nil {
+		return nil, err
+	}
+	text := esValueString(opts.Query)
+	var q query.Query
+	switch typ {
+	case "match":
+		mq := bleve.NewMatchQuery(text)
+		mq.SetField(field)
+		mq.Analyzer = opts.Analyzer
+		mq.Fuzziness = opts.fuzziness()
+		mq.Prefix = opts.PrefixLength
+		if strings.ToLower(opts.Operator) == "and" {
+			mq.SetOperator(query.MatchQueryOperatorAnd)
+		}
+		q = mq
+	case "match_phrase":
+		mq := bleve.NewMatchPhraseQuery(text)
+		mq.SetField(field)
+		mq.Analyzer = opts.Analyzer
+		q = mq
+	case "match_phrase_prefix":
+		// the last term is matched as prefix, the terms before are matched as phrase.
+		terms := strings.Fields(text)
+		if len(terms) == 0 {
+			return bleve.NewMatchNoneQuery(), nil
+		}
+		prefix := bleve.NewPrefixQuery(strings.ToLower(terms[len(terms)-1]))
+		prefix.SetField(field)
+		if len(terms) == 1 {
+			q = prefix
+			break
+		}
+		phrase := bleve.NewMatchPhraseQuery(strings.Join(terms[:len(terms)-1], " "))
+		phrase.SetField(field)
+		q = bleve.NewConjunctionQuery(phrase, prefix)
+	}
+	if opts.Boost != nil {
+		setESBoost(q, *opts.Boost)
+	}
+	return q, nil
+}
+
+func parseESMultiMatchQuery(input stdjson.RawMessage, path string) (query.Query, error) {
+	var body struct {
+		Query    interface{} `json:"query"`
+		Fields   []string    `json:"fields"`
+		Type     string      `json:"type"`
+		Operator string      `json:"operator"`
+		Boost    *float64    `json:"boost"`
+	}
+	if err := json.Unmarshal(input, &body); err != nil {
+		return nil, queryError(path, err.Error())
+	}
+	if len(body.Fields) == 0 {
+		body.Fields = []string{""}
+	}
+	queries := make([]query.Query, 0, len(body.Fields))
+	for _, field := range body.Fields {
+		// the field can be boosted like "title^2".
+		boost := 1.0
+		if i := strings.LastIndex(field, "^"); i >= 0 {
+			var err error
+			if boost, err = strconv.ParseFloat(field[i+1:], 64); err != nil {
+				return nil, queryError(path+".fields", "invalid boost of field [%s]", field)
+			}
+			field = field[:i]
+		}
+		var q query.Query
+		switch body.Type {
+		case "phrase", "phrase_prefix":
+			mq := bleve.NewMatchPhraseQuery(esValueString(body.Query))
+			mq.SetField(field)
+			mq.SetBoost(boost)
+			q = mq
+		case "", "best_fields", "most_fields", "cross_fields", "bool_prefix":
+			mq := bleve.NewMatchQuery(esValueString(body.Query))
+			mq.SetField(field)
+			mq.SetBoost(boost)
+			if strings.ToLower(body.Operator) == "and" {
+				mq.SetOperator(query.MatchQueryOperatorAnd)
+			}
+			q = mq
+		default:
+			return nil, queryError(path+".type", "unknown type [%s]", body.Type)
+		}
+		queries = append(queries, q)
+	}
+	q := bleve.NewDisjunctionQuery(queries...)
+	if body.Boost != nil {
+		q.SetBoost(*body.Boost)
+	}
+	return q, nil
+}
+
+func parseESTermLevelQuery(typ string, input stdjson.RawMessage, path string) (query.Query, error) {
+	field, opts, err := parseESFieldClause(input, path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Value == nil {
+		return nil, queryError(path+"."+field, "value is required")
+	}
+	var q query.Query
+	switch typ {
+	case "term":
+		q = esTermQuery(field, opts.Value)
+	case "prefix":
+		pq := bleve.NewPrefixQuery(esValueString(opts.Value))
+		pq.SetField(field)
+		q = pq
+	case "wildcard":
+		wq := bleve.NewWildcardQuery(esValueString(opts.Value))
+		wq.SetField(field)
+		q = wq
+	case "regexp":
+		rq := bleve.NewRegexpQuery(esValueString(opts.Value))
+		rq.SetField(field)
+		q = rq
+	case "fuzzy":
+		fq := bleve.NewFuzzyQuery(esValueString(opts.Value))
+		fq.SetField(field)
+		fq.SetFuzziness(opts.fuzziness())
+		if opts.Fuzziness == nil {
+			fq.SetFuzziness(1)
+		}
+		fq.SetPrefix(opts.PrefixLength)
+		q = fq
+	}
+	if opts.Boost != nil {
+		setESBoost(q, *opts.Boost)
+	}
+	return q, nil
+}
+
+// esTermQuery matches the exact value of field, the numbers and booleans match the numeric and boolean fields.
+func esTermQuery(field string, value interface{}) query.Query {
+	switch v := value.(type) {
+	case float64:
+		inclusive := true
+		q := bleve.NewNumericRangeInclusiveQuery(&v, &v, &inclusive, &inclusive)
+		q.SetField(field)
+		return q
+	case bool:
+		q := bleve.NewBoolFieldQuery(v)
+		q.SetField(field)
+		return q
+	}
+	q := bleve.NewTermQuery(esValueString(value))
+	q.SetField(field)
+	return q
+}
+
+func parseESTermsQuery(input stdjson.RawMessage, path string) (query.Query, error) {
+	var clause map[string]stdjson.RawMessage
+	if err := json.Unmarshal(input, &clause); err != nil {
+		return nil, queryError(path, "query must be an object")
+	}
+	var boost *float64
+	if raw, ok := clause["boost"]; ok {
+		boost = new(float64)
+		if err := json.Unmarshal(raw, boost); err != nil {
+			return nil, queryError(path+".boost", err.Error())
+		}
+		delete(clause, "boost")
+	}
+	if len(clause) != 1 {
+		return nil, queryError(path, "query must have exactly one field")
+	}
+	for field, raw := range clause {
+		var values []interface{}
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, queryError(path+"."+field, "values must be an array")
+		}
+		queries := make([]query.Query, 0, len(values))
+		for _, value := range values {
+			queries = append(queries, esTermQuery(field, value))
+		}
+		q := bleve.NewDisjunctionQuery(queries...)
+		if boost != nil {
+			q.SetBoost(*boost)
+		}
+		return q, nil
+	}
+	return nil, nil
+}
+
+func parseESRangeQuery(input stdjson.RawMessage, path string) (query.Query, error) {
+	field, opts, err := parseESFieldClause(input, path)
+	if err != nil {
+		return nil, err
+	}
+	path = path + "." + field
+	bounds := map[string]interface{}{"gt": opts.Gt, "gte": opts.Gte, "lt": opts.Lt, "lte": opts.Lte}
+	numeric := !strings.HasPrefix(opts.Format, "epoch_")
+	for name, bound := range bounds {
+		if bound == nil {
+			delete(bounds, name)
+			continue
+		}
+		switch v := bound.(type) {
+		case float64:
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				numeric = false
+			}
+		default:
+			return nil, queryError(path+"."+name, "invalid value [%v]", v)
+		}
+	}
+	if len(bounds) == 0 {
+		return nil, queryError(path, "one of gt, gte, lt, lte is required")
+	}
+	var q query.Query
+	if numeric {
+		var (
+			min, max                   *float64
+			inclusiveMin, inclusiveMax = false, false
+		)
+		for name, bound := range bounds {
+			v, _ := strconv.ParseFloat(esValueString(bound), 64)
+			switch name {
+			case "gt", "gte":
+				min, inclusiveMin = &v, name == "gte"
+			case "lt", "lte":
+				max, inclusiveMax = &v, name == "lte"
+			}
+		}
+		nq := bleve.NewNumericRangeInclusiveQuery(min, max, &inclusiveMin, &inclusiveMax)
+		nq.SetField(field)
+		q = nq
+	} else {
+		loc, err := parseTimeZone(opts.TimeZone)
+		if err != nil {
+			return nil, queryError(path+".time_zone", err.Error())
+		}
+		var (
+			start, end                   time.Time
+			inclusiveStart, inclusiveEnd = false, false
+		)
+		for name, bound := range bounds {
+			// the date math rounding rounds up for gt and lte, e.g. "lte": "now/d" includes the whole day.
+			t, err := parseESDate(bound, opts.Format, loc, name == "gt" || name == "lte")
+			if err != nil {
+				return nil, queryError(path+"."+name, err.Error())
+			}
+			switch name {
+			case "gt", "gte":
+				start, inclusiveStart = t, name == "gte"
+			case "lt", "lte":
+				end, inclusiveEnd = t, name == "lte"
+			}
+		}
+		dq := bleve.NewDateRangeInclusiveQuery(start, end, &inclusiveStart, &inclusiveEnd)
+		dq.SetField(field)
+		q = dq
+	}
+	if opts.Boost != nil {
+		setESBoost(q, *opts.Boost)
+	}
+	return q, nil
+}
+
+// esDateLayouts are the layouts of dates without date math.
+var esDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// esDateMath matches the date math like "now-1d/d" or "2022-01-01||+1M".
+var esDateMath = regexp.MustCompile(`([+-]\d+|/)([yMwdhHms])`)
+
+// parseESDate parses the epoch number, the date string or the date math in loc.
+func parseESDate(value interface{}, format string, loc *time.Location, roundUp bool) (time.Time, error) {
+	if v, ok := value.(float64); ok {
+		if format == "epoch_second" {
+			return time.Unix(0, int64(v*float64(time.Second))), nil
+		}
+		return time.Unix(0, int64(v*float64(time.Millisecond))), nil
+	}
+	s := esValueString(value)
+	if strings.HasPrefix(format, "epoch_") {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch [%s]", s)
+		}
+		return parseESDate(v, format, loc, roundUp)
+	}
+	anchor, expr := s, ""
+	if strings.HasPrefix(s, "now") {
+		anchor, expr = "now", s[len("now"):]
+	} else if i := strings.Index(s, "||"); i >= 0 {
+		anchor, expr = s[:i], s[i+2:]
+	}
+	var t time.Time
+	if anchor == "now" {
+		t = time.Now().In(loc)
+	} else {
+		var err error
+		for _, layout := range esDateLayouts {
+			if t, err = time.ParseInLocation(layout, anchor, loc); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date [%s]", s)
+		}
+	}
+	if len(esDateMath.ReplaceAllString(expr, "")) > 0 {
+		return time.Time{}, fmt.Errorf("invalid date math [%s]", s)
+	}
+	for _, op := range esDateMath.FindAllStringSubmatch(expr, -1) {
+		unit := op[2]
+		if op[1] == "/" {
+			t = roundESDate(t, unit, loc, roundUp)
+			continue
+		}
+		n, _ := strconv.Atoi(op[1])
+		switch unit {
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		case "M":
+			t = t.AddDate(0, n, 0)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "h", "H":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "s":
+			t = t.Add(time.Duration(n) * time.Second)
+		}
+	}
+	return t, nil
+}
+
+// roundESDate rounds t down to the start of the unit, or up to the last millisecond of the unit.
+func roundESDate(t time.Time, unit string, loc *time.Location, roundUp bool) time.Time {
+	if unit == "s" {
+		t = t.Truncate(time.Second)
+		if roundUp {
+			t = t.Add(time.Second - time.Millisecond)
+		}
+		return t
+	}
+	if unit == "H" {
+		unit = "h"
+	}
+	round, next, _ := calendarInterval("1"+unit, loc)
+	if roundUp {
+		return next(t).Add(-time.Millisecond)
+	}
+	return round(t)
+}
+
+// parseESSort translates the sort like ["_score", {"date": "desc"}, {"price": {"order": "asc"}}, "title:desc"]
+// to the bleve sort strings.
+func parseESSort(input stdjson.RawMessage) ([]string, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	var list []stdjson.RawMessage
+	if err := json.Unmarshal(input, &list); err != nil {
+		list = []stdjson.RawMessage{input}
+	}
+	sorts := make([]string, 0, len(list))
+	for i, raw := range list {
+		path := fmt.Sprintf("sort[%d]", i)
+		var field, order string
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			field = s
+			if i := strings.LastIndex(s, ":"); i >= 0 {
+				field, order = s[:i], s[i+1:]
+			}
+		} else {
+			var clause map[string]stdjson.RawMessage
+			if err := json.Unmarshal(raw, &clause); err != nil || len(clause) != 1 {
+				return nil, queryError(path, "sort must be a string or an object with one field")
+			}
+			for field = range clause {
+			}
+			if err := json.Unmarshal(clause[field], &order); err != nil {
+				var opts struct {
+					Order string `json:"order"`
+				}
+				if err := json.Unmarshal(clause[field], &opts); err != nil {
+					return nil, queryError(path+"."+field, err.Error())
+				}
+				order = opts.Order
+			}
+		}
+		switch field {
+		case "_doc":
+			field = "_id"
+		case "_score":
+			// the score is sorted in descending order by default.
+			if len(order) == 0 {
+				order = "desc"
+			}
+		}
+		switch strings.ToLower(order) {
+		case "", "asc":
+			sorts = append(sorts, field)
+		case "desc":
+			sorts = append(sorts, "-"+field)
+		default:
+			return nil, queryError(path, "unknown order [%s]", order)
+		}
+	}
+	return sorts, nil
+}
+
+// parseESAggregations parses the aggregations whose filters are Elasticsearch Query DSL.
+func parseESAggregations(input map[string]stdjson.RawMessage, path string) (map[string]*AggregationRequest, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	aggs := make(map[string]*AggregationRequest, len(input))
+	for name, raw := range input {
+		aggPath := path + "." + name
+		var body map[string]stdjson.RawMessage
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return nil, queryError(aggPath, "aggregation must be an object")
+		}
+		subs := body["aggs"]
+		if subs == nil {
+			subs = body["aggregations"]
+		}
+		delete(body, "aggs")
+		delete(body, "aggregations")
+		delete(body, "meta")
+		agg := new(AggregationRequest)
+		switch {
+		case body["filter"] != nil && len(body) == 1:
+			q, err := parseESQuery(body["filter"], aggPath+".filter")
+			if err != nil {
+				return nil, err
+			}
+			agg.Filter = q
+		case body["filters"] != nil && len(body) == 1:
+			var filters struct {
+				Filters stdjson.RawMessage `json:"filters"`
+			}
+			if err := json.Unmarshal(body["filters"], &filters); err != nil {
+				return nil, queryError(aggPath+".filters", err.Error())
+			}
+			agg.Filters = new(FiltersAggregation)
+			var keyed map[string]stdjson.RawMessage
+			if err := json.Unmarshal(filters.Filters, &keyed); err == nil {
+				agg.Filters.Filters = make(map[string]query.Query, len(keyed))
+				for key, raw := range keyed {
+					q, err := parseESQuery(raw, aggPath+".filters.filters."+key)
+					if err != nil {
+						return nil, err
+					}
+					agg.Filters.Filters[key] = q
+				}
+			} else if agg.Filters.FilterList, err = parseESQueries(filters.Filters, aggPath+".filters.filters"); err != nil {
+				return nil, err
+			}
+		default:
+			if err := json.Unmarshal(mustMarshal(body), agg); err != nil {
+				return nil, queryError(aggPath, err.Error())
+			}
+		}
+		if len(subs) > 0 {
+			var subAggs map[string]stdjson.RawMessage
+			if err := json.Unmarshal(subs, &subAggs); err != nil {
+				return nil, queryError(aggPath+".aggs", "aggregations must be an object")
+			}
+			var err error
+			if agg.Aggregations, err = parseESAggregations(subAggs, aggPath+".aggs"); err != nil {
+				return nil, err
+			}
+		}
+		aggs[name] = agg
+	}
+	return aggs, nil
+}
+
+// withESBoost sets the boost of q if the body has one, e.g. {"match_all": {"boost": 1.2}}.
+func withESBoost(q query.Query, body stdjson.RawMessage, path string) (query.Query, error) {
+	var opts struct {
+		Boost *float64 `json:"boost"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &opts); err != nil {
+			return nil, queryError(path, err.Error())
+		}
+	}
+	if opts.Boost != nil {
+		setESBoost(q, *opts.Boost)
+	}
+	return q, nil
+}
+
+func setESBoost(q query.Query, boost float64) {
+	if bq, ok := q.(query.BoostableQuery); ok {
+		bq.SetBoost(boost)
+	}
+}
+
+// esValueString converts the JSON scalar to string.
+func esValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(value)
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// ESSearchRequest is the search request in Elasticsearch Query DSL, it's translated to SearchRequest.
+type ESSearchRequest struct {
+	SearchRequest
+	noHits      bool          // the size is 0, only the total and aggregations are returned
+	searchAfter []interface{} // the sort values encoded to SearchAfter by the field types
+}
+
+func (r *ESSearchRequest) UnmarshalJSON(input []byte) error {
+	var temp struct {
+		Query        stdjson.RawMessage            `json:"query"`
+		From         int                           `json:"from"`
+		Size         *int                          `json:"size"`
+		Source       *SourceFilter                 `json:"_source"`
+		Sort         stdjson.RawMessage            `json:"sort"`
+		Highlight    *esHighlight                  `json:"highlight"`
+		Explain      bool                          `json:"explain"`
+		SearchAfter  []interface{}                 `json:"search_after"`
+		Aggregations map[string]stdjson.RawMessage `json:"aggregations"`
+		Aggs         map[string]stdjson.RawMessage `json:"aggs"`
+	}
+	if err := json.Unmarshal(input, &temp); err != nil {
+		return err
+	}
+	req := &r.SearchRequest
+	var err error
+	if len(temp.Query) > 0 {
+		if req.Query, err = ParseESQuery(temp.Query); err != nil {
+			return err
+		}
+	} else {
+		req.Query = bleve.NewMatchAllQuery()
+	}
+	req.From = temp.From
+	if req.From < 0 {
+		req.From = 0
+	}
+	req.Size = config.Global.Engine.DefaultSearchResultSize
+	if temp.Size != nil {
+		req.Size = *temp.Size
+		if req.Size <= 0 {
+			// the bleve search needs a positive size, the hits are dropped from the response.
+			r.noHits, req.Size = true, 1
+		}
+	}
+	req.Source = temp.Source
+	req.Explain = temp.Explain
+	if req.Sort, err = parseESSort(temp.Sort); err != nil {
+		return err
+	}
+	if temp.Highlight != nil {
+		req.Highlight = temp.Highlight.fields()
+	}
+	r.searchAfter = temp.SearchAfter
+	aggs := temp.Aggregations
+	if aggs == nil {
+		aggs = temp.Aggs
+	}
+	req.Aggregations, err = parseESAggregations(aggs, "aggs")
+	return err
+}
+
+// esHighlight is the highlight request, the fields can be an object with field names as keys, or an array of them.
+type esHighlight struct {
+	Fields stdjson.RawMessage `json:"fields"`
+}
+
+func (h *esHighlight) fields() []string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(h.Fields, &fields); err == nil {
+		list := make([]string, 0, len(fields))
+		for field := range fields {
+			list = append(list, field)
+		}
+		return list
+	}
+	var list []interface{}
+	_ = json.Unmarshal(h.Fields, &list)
+	names := make([]string, 0, len(list))
+	for _, item := range list {
+		switch v := item.(type) {
+		case string:
+			names = append(names, v)
+		case map[string]interface{}:
+			for field := range v {
+				names = append(names, field)
+			}
+		}
+	}
+	return names
+}
+
+// ESSearchResponse is the search result in the shape of Elasticsearch.
+type ESSearchResponse struct {
+	Took         int64                  `json:"took"` // in milliseconds
+	TimedOut     bool                   `json:"timed_out"`
+	Shards       ESShards               `json:"_shards"`
+	Hits         ESHits                 `json:"hits"`
+	Aggregations map[string]interface{} `json:"aggregations,omitempty"`
+	ScrollID     string                 `json:"_scroll_id,omitempty"`
+}
+
+type ESShards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+type ESHits struct {
+	Total    ESTotal  `json:"total"`
+	MaxScore *float64 `json:"max_score"`
+	Hits     []*ESHit `json:"hits"`
+}
+
+type ESTotal struct {
+	Value    uint64 `json:"value"`
+	Relation string `json:"relation"`
+}
+
+type ESHit struct {
+	Index       string                 `json:"_index"`
+	Type        string                 `json:"_type"`
+	ID          string                 `json:"_id"`
+	Score       *float64               `json:"_score"`
+	Source      map[string]interface{} `json:"_source,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Highlight   map[string][]string    `json:"highlight,omitempty"`
+	Sort        []interface{}          `json:"sort,omitempty"`
+	Explanation interface{}            `json:"_explanation,omitempty"`
+}
+
+// ESSearchIndices performs the search across the indices, or all existing indices if no index specified.
+func ESSearchIndices(req *ESSearchRequest, indices ...*Index) (*ESSearchResponse, error) {
+	var err error
+	if len(indices) == 0 {
+		if indices, err = openAllIndices(); err != nil {
+			return nil, err
+		}
+	}
+	fieldType := esFieldTypes(indices)
+	if len(req.searchAfter) > 0 {
+		if len(req.searchAfter) != len(req.Sort) {
+			return nil, fmt.Errorf("search_after has %d values but sort has %d", len(req.searchAfter), len(req.Sort))
+		}
+		req.SearchAfter = make([]string, 0, len(req.searchAfter))
+		for i, v := range req.searchAfter {
+			req.SearchAfter = append(req.SearchAfter, encodeESSortValue(strings.TrimPrefix(req.Sort[i], "-"), v, fieldType))
+		}
+	}
+	res, err := SearchIndices(&req.SearchRequest, indices...)
+	if err != nil {
+		return nil, err
+	}
+	if req.noHits {
+		res.Hits = Hits{}
+	}
+	return newESSearchResponse(res, fieldType), nil
+}
+
+// NewESSearchResponse converts the SearchResult to ESSearchResponse.
+func NewESSearchResponse(res *SearchResult) *ESSearchResponse {
+	indices := make([]*Index, 0)
+	seen := make(map[string]bool)
+	for _, hit := range res.Hits {
+		if seen[hit.Index] {
+			continue
+		}
+		seen[hit.Index] = true
+		if index, err := GetIndex(hit.Index); err == nil {
+			indices = append(indices, index)
+		}
+	}
+	return newESSearchResponse(res, esFieldTypes(indices))
+}
+
+func newESSearchResponse(res *SearchResult, fieldType func(field string) string) *ESSearchResponse {
+	response := &ESSearchResponse{
+		Took: res.Took.Milliseconds(),
+		Shards: ESShards{
+			Total:      res.Status.Total,
+			Successful: res.Status.Successful,
+			Failed:     res.Status.Failed,
+		},
+		Hits: ESHits{
+			Total: ESTotal{Value: res.TotalHits, Relation: "eq"},
+			Hits:  make([]*ESHit, 0, len(res.Hits)),
+		},
+		Aggregations: res.Aggregations,
+		ScrollID:     res.ScrollID,
+	}
+	var sorts []string
+	if res.Request != nil {
+		sorts = res.Request.Sort
+	}
+	if len(res.Hits) > 0 && len(sorts) == 0 {
+		response.Hits.MaxScore = esScore(res.MaxScore)
+	}
+	for _, hit := range res.Hits {
+		esHit := &ESHit{
+			Index:  hit.Index,
+			Type:   "_doc",
+			ID:     hit.ID,
+			Source: hit.Source,
+			Fields: hit.Fields,
+		}
+		// the score is not returned if sorted by fields.
+		if len(sorts) == 0 {
+			esHit.Score = esScore(float64(hit.Score))
+		} else {
+			for i, value := range hit.Sort {
+				if i < len(sorts) {
+					esHit.Sort = append(esHit.Sort, decodeESSortValue(strings.TrimPrefix(sorts[i], "-"), value, fieldType))
+				}
+			}
+		}
+		if len(hit.Fragments) > 0 {
+			esHit.Highlight = hit.Fragments
+		}
+		if hit.Explanation != nil {
+			esHit.Explanation = hit.Explanation
+		}
+		response.Hits.Hits = append(response.Hits.Hits, esHit)
+	}
+	return response
+}
+
+// esFieldTypes returns the lookup of field type in the mappings of indices.
+func esFieldTypes(indices []*Index) func(field string) string {
+	return func(field string) string {
+		for _, index := range indices {
+			if typ := index.Mapping.fieldType(field); len(typ) > 0 {
+				return typ
+			}
+		}
+		return ""
+	}
+}
+
+// decodeESSortValue decodes the sort value of numeric and datetime fields, the datetime is in milliseconds.
+func decodeESSortValue(field, value string, fieldType func(field string) string) interface{} {
+	if field == "_score" || field == "_id" {
+		return value
+	}
+	i, ok := prefixCodedInt64([]byte(value))
+	if !ok {
+		return value
+	}
+	if fieldType(field) == "datetime" {
+		return i / int64(time.Millisecond)
+	}
+	return numeric.Int64ToFloat64(i)
+}
+
+// encodeESSortValue encodes the sort value returned by decodeESSortValue.
+func encodeESSortValue(field string, value interface{}, fieldType func(field string) string) string {
+	v, ok := value.(float64)
+	if !ok || field == "_score" || field == "_id" {
+		return esValueString(value)
+	}
+	if fieldType(field) == "datetime" {
+		return string(numeric.MustNewPrefixCodedInt64(int64(v)*int64(time.Millisecond), 0))
+	}
+	return string(numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(v), 0))
+}
+
+func esScore(score float64) *float64 {
+	if math.IsNaN(score) {
+		return nil
+	}
+	return &score
+}
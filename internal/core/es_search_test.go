@@ -0,0 +1,189 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestESSearch(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(aggMapping), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "quick brown fox", "tag": "a", "price": 5, "created": "2022-01-01T10:00:00Z"},
+		"2": {"title": "lazy brown dog", "tag": "b", "price": 15, "created": "2022-01-02T10:00:00Z", "sold": true},
+		"3": {"title": "quick red fox", "tag": "a", "price": 25, "created": "2022-01-03T10:00:00Z"},
+		"4": {"title": "lazy red cat", "tag": "c", "price": 35, "created": "2022-01-04T10:00:00Z", "sold": true},
+	}
+	for id, doc := range docs {
+		if _, err := index.IndexOrUpdateDocument(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		body     string
+		expected []string
+		ordered  bool
+	}{
+		{`{}`, []string{"1", "2", "3", "4"}, false},
+		{`{"query": {"match": {"title": {"query": "quick fox", "operator": "and"}}}}`, []string{"1", "3"}, false},
+		{`{"query": {"match_phrase": {"title": "red fox"}}}`, []string{"3"}, false},
+		{`{"query": {"multi_match": {"query": "cat", "fields": ["title^2", "tag"]}}}`, []string{"4"}, false},
+		{`{"query": {"term": {"tag": "a"}}}`, []string{"1", "3"}, false},
+		{`{"query": {"terms": {"tag": ["b", "c"]}}}`, []string{"2", "4"}, false},
+		{`{"query": {"range": {"price": {"gt": 5, "lte": 25}}}}`, []string{"2", "3"}, false},
+		{`{"query": {"range": {"created": {"gte": "2022-01-02", "lt": "2022-01-03||+1d"}}}}`, []string{"2", "3"}, false},
+		{`{"query": {"exists": {"field": "sold"}}}`, []string{"2", "4"}, false},
+		{`{"query": {"bool": {"must": {"match": {"title": "brown"}}, "must_not": [{"term": {"tag": "b"}}]}}}`, []string{"1"}, false},
+		{`{"query": {"bool": {"filter": [{"range": {"price": {"gte": 10}}}], "should": [{"term": {"tag": "a"}}, {"term": {"tag": "c"}}], "minimum_should_match": 1}}}`, []string{"3", "4"}, false},
+		{`{"query": {"bool": {"should": [{"prefix": {"title": "laz"}}, {"wildcard": {"tag": "a*"}}]}}}`, []string{"1", "2", "3", "4"}, false},
+		{`{"query": {"ids": {"values": ["1", "4"]}}}`, []string{"1", "4"}, false},
+		{`{"query": {"match_all": {}}, "sort": [{"price": {"order": "desc"}}], "size": 2}`, []string{"4", "3"}, true},
+		{`{"query": {"match_all": {}}, "sort": ["price"], "from": 1, "size": 2}`, []string{"2", "3"}, true},
+	}
+	for _, c := range cases {
+		req := new(ESSearchRequest)
+		if err := json.Unmarshal([]byte(c.body), req); err != nil {
+			t.Fatalf("parse %s: %s", c.body, err)
+		}
+		res, err := ESSearchIndices(req, index)
+		if err != nil {
+			t.Fatalf("search %s: %s", c.body, err)
+		}
+		ids := make([]string, 0, len(res.Hits.Hits))
+		for _, hit := range res.Hits.Hits {
+			ids = append(ids, hit.ID)
+		}
+		if !c.ordered {
+			sort.Strings(ids)
+		}
+		if !reflect.DeepEqual(ids, c.expected) {
+			t.Errorf("search %s: got %v, expected %v", c.body, ids, c.expected)
+		}
+	}
+
+	// the sort values are decoded and can be used to search after.
+	req := new(ESSearchRequest)
+	if err := json.Unmarshal([]byte(`{"sort": [{"created": "desc"}], "size": 2}`), req); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ESSearchIndices(req, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := res.Hits.Hits[1].Sort
+	if !reflect.DeepEqual(last, []interface{}{int64(1641204000000)}) {
+		t.Errorf("sort values %v, expected the milliseconds of 2022-01-03T10:00:00Z", last)
+	}
+	body, _ := json.Marshal(map[string]interface{}{"sort": []interface{}{map[string]string{"created": "desc"}}, "search_after": last})
+	req = new(ESSearchRequest)
+	if err := json.Unmarshal(body, req); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = ESSearchIndices(req, index); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits.Hits) != 2 || res.Hits.Hits[0].ID != "2" || res.Hits.Hits[1].ID != "1" {
+		t.Errorf("search after %v: %v", last, res.Hits.Hits)
+	}
+
+	// the source filtering, highlight and aggregations.
+	req = new(ESSearchRequest)
+	if err := json.Unmarshal([]byte(`{
+    "query": {"match": {"title": "fox"}},
+    "_source": ["title"],
+    "highlight": {"fields": {"title": {}}},
+    "size": 0,
+    "aggs": {"cheap": {"filter": {"range": {"price": {"lt": 10}}}}}
+}`), req); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = ESSearchIndices(req, index); err != nil {
+		t.Fatal(err)
+	}
+	json.Print("es search", res)
+	if res.Hits.Total.Value != 2 || len(res.Hits.Hits) != 0 {
+		t.Errorf("total %d, hits %d, expected 2 and 0", res.Hits.Total.Value, len(res.Hits.Hits))
+	}
+	if cheap := res.Aggregations["cheap"].(map[string]interface{}); cheap["doc_count"] != int64(1) {
+		t.Errorf("filter aggregation: %v", cheap)
+	}
+	req.noHits, req.Size = false, 10
+	if res, err = ESSearchIndices(req, index); err != nil {
+		t.Fatal(err)
+	}
+	for _, hit := range res.Hits.Hits {
+		if len(hit.Source) != 1 || hit.Source["title"] == nil {
+			t.Errorf("source not filtered: %v", hit.Source)
+		}
+		if len(hit.Highlight["title"]) == 0 {
+			t.Errorf("title not highlighted: %v", hit.Highlight)
+		}
+	}
+
+	// the filter clauses don't contribute to the score, the constant_score scores by the boost.
+	scores := func(body string) map[string]float64 {
+		req := new(ESSearchRequest)
+		if err := json.Unmarshal([]byte(body), req); err != nil {
+			t.Fatalf("parse %s: %s", body, err)
+		}
+		res, err := ESSearchIndices(req, index)
+		if err != nil {
+			t.Fatalf("search %s: %s", body, err)
+		}
+		scores := make(map[string]float64)
+		for _, hit := range res.Hits.Hits {
+			if hit.Score == nil {
+				t.Fatalf("search %s: hit %s has no score", body, hit.ID)
+			}
+			scores[hit.ID] = *hit.Score
+		}
+		return scores
+	}
+	must := scores(`{"query": {"bool": {"must": {"match": {"title": "fox"}}}}}`)
+	filtered := scores(`{"query": {"bool": {"must": {"match": {"title": "fox"}}, "filter": {"range": {"price": {"gte": 10}}}}}}`)
+	if len(filtered) != 1 || filtered["3"] != must["3"] {
+		t.Errorf("filtered scores %v, expected the score of document 3 in %v", filtered, must)
+	}
+	for body, expected := range map[string]map[string]float64{
+		`{"query": {"bool": {"filter": {"term": {"tag": "a"}}}}}`:                         {"1": 0, "3": 0},
+		`{"query": {"constant_score": {"filter": {"term": {"tag": "a"}}}}}`:               {"1": 1, "3": 1},
+		`{"query": {"constant_score": {"filter": {"term": {"tag": "a"}}, "boost": 2.5}}}`: {"1": 2.5, "3": 2.5},
+	} {
+		if got := scores(body); !reflect.DeepEqual(got, expected) {
+			t.Errorf("search %s: scores %v, expected %v", body, got, expected)
+		}
+	}
+
+	// the errors contain the path of bad clause.
+	for body, path := range map[string]string{
+		`{"query": {"bool": {"must": [{"match_all": {}}, {"unknown": {}}]}}}`: "query.bool.must[1].unknown",
+		`{"query": {"range": {"price": {}}}}`:                                 "query.range.price",
+		`{"query": {"term": {"a": 1, "b": 2}}}`:                               "query.term",
+	} {
+		if err := json.Unmarshal([]byte(body), new(ESSearchRequest)); err == nil || !strings.Contains(err.Error(), "["+path+"]") {
+			t.Errorf("parse %s: %v, expected error at %s", body, err, path)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+// the fields added to every document besides the mapped ones.
+var metaFieldTypes = map[string]string{"@timestamp": "datetime"}
+
+// internalFields are not discovered, the other fields prefixed with "_" may be the user's.
+var internalFields = map[string]bool{"_id": true, "_source": true, "_version": true, "_seq_no": true, "_all": true}
+
+// IndexField is a field indexed in the shards. The type is from the mapping, or detected from the
+// documents like bleve does if the field is mapped dynamically.
+type IndexField struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Dynamic bool   `json:"dynamic"` // the field is not declared in the mapping
+	Docs    uint64 `json:"docs"`    // the number of documents having the field, only counted by GetMapping
+
+	searchable bool
+	docValues  bool
+}
+
+// MappingResult is the declared mapping of index and the fields discovered in its shards.
+type MappingResult struct {
+	Mappings *IndexMapping `json:"mappings"`
+	Fields   []*IndexField `json:"fields"`
+}
+
+// FieldCaps is the capabilities of a field in one type across the indices. The capability is true
+// if the field has it in all the indices, otherwise the indices without it are listed.
+type FieldCaps struct {
+	Type                 string   `json:"type"`
+	Searchable           bool     `json:"searchable"`
+	Sortable             bool     `json:"sortable"`
+	Facetable            bool     `json:"facetable"`
+	Indices              []string `json:"indices,omitempty"` // the indices having the field in the type, omitted if all
+	NonSearchableIndices []string `json:"non_searchable_indices,omitempty"`
+	NonSortableIndices   []string `json:"non_sortable_indices,omitempty"`
+	NonFacetableIndices  []string `json:"non_facetable_indices,omitempty"`
+}
+
+type FieldCapsResult struct {
+	Indices []string                         `json:"indices"`
+	Fields  map[string]map[string]*FieldCaps `json:"fields"` // field name -> type -> caps
+}
+
+// GetMapping returns the declared mapping and the discovered fields of index.
+func (index *Index) GetMapping() (*MappingResult, error) {
+	fields, err := index.fields(true)
+	if err != nil {
+		return nil, err
+	}
+	return &MappingResult{Mappings: index.Mapping, Fields: fields}, nil
+}
+
+// Fields returns the fields in the field dictionaries of the shards sorted by name, the internal fields are excluded.
+// The documents having the fields are not counted.
+func (index *Index) Fields() ([]*IndexField, error) {
+	return index.fields(false)
+}
+
+// fields returns the discovered fields, and counts the documents having each field by searching all the shards if
+// countDocs. Otherwise only the dynamic fields are searched for one document until their types are detected.
+func (index *Index) fields(countDocs bool) ([]*IndexField, error) {
+	if index.IsClosed() {
+		return nil, errors.ErrIndexClosed
+	}
+	index.mu.RLock()
+	shards := index.Shards
+	index.mu.RUnlock()
+	mapping, _ := shards[0].Indexer.Mapping().(*imapping.IndexMappingImpl)
+	if mapping == nil {
+		mapping = bleve.NewIndexMapping()
+	}
+	fields := make(map[string]*IndexField)
+	for _, shard := range shards {
+		names, err := shard.Indexer.Fields()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if internalFields[name] {
+				continue
+			}
+			field, ok := fields[name]
+			if !ok {
+				field = newIndexField(mapping, name)
+				fields[name] = field
+			}
+			if !countDocs && len(field.Type) > 0 {
+				continue
+			}
+			// one document is fetched to detect the type of dynamic field.
+			size := 0
+			if len(field.Type) == 0 {
+				size = 1
+			}
+			request := bleve.NewSearchRequestOptions(&ExistsQuery{FieldVal: name}, size, 0, false)
+			request.Fields = documentFields
+			res, err := shard.Indexer.Search(request)
+			if err != nil {
+				return nil, err
+			}
+			if countDocs {
+				field.Docs += res.Total
+			}
+			if len(res.Hits) > 0 {
+				doc, err := index.documentFromHit(res.Hits[0])
+				if err != nil {
+					return nil, err
+				}
+				value := sourceValue(doc.Source, strings.Split(name, "."))
+				field.Type = detectFieldType(mapping, value)
+				// the field is indexed with the dynamic template if matched.
+				if template := index.Mapping.dynamicTemplate(name, value); template != nil {
+					fm := *template.Mapping
+					if tfm, err := buildFieldMapping(&fm); err == nil {
+						field.Type, field.searchable, field.docValues = fm.Type, tfm.Index, tfm.DocValues
+					}
+				}
+			}
+		}
+	}
+	list := make([]*IndexField, 0, len(fields))
+	for _, field := range fields {
+		list = append(list, field)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func (f *IndexField) sortable() bool {
+	return f.searchable && f.docValues
+}
+
+func (f *IndexField) facetable() bool {
+	return f.sortable() && f.Type != "geopoint"
+}
+
+// FieldCapabilities returns the capabilities of the fields matching the patterns across the indices,
+// all the fields are returned if no pattern. The patterns support wildcard "*".
+func FieldCapabilities(patterns []string, indices ...*Index) (*FieldCapsResult, error) {
+	result := &FieldCapsResult{Indices: make([]string, 0, len(indices)), Fields: make(map[string]map[string]*FieldCaps)}
+	// the indices having the field in each type.
+	found := make(map[*FieldCaps][]string)
+	for _, index := range indices {
+		result.Indices = append(result.Indices, index.Name)
+		fields, err := index.Fields()
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if len(patterns) > 0 && !matchFieldPattern(patterns, field.Name) {
+				continue
+			}
+			types, ok := result.Fields[field.Name]
+			if !ok {
+				types = make(map[string]*FieldCaps)
+				result.Fields[field.Name] = types
+			}
+			caps, ok := types[field.Type]
+			if !ok {
+				caps = &FieldCaps{Type: field.Type, Searchable: true, Sortable: true, Facetable: true}
+				types[field.Type] = caps
+			}
+			found[caps] = append(found[caps], index.Name)
+			if !field.searchable {
+				caps.NonSearchableIndices = append(caps.NonSearchableIndices, index.Name)
+			}
+			if !field.sortable() {
+				caps.NonSortableIndices = append(caps.NonSortableIndices, index.Name)
+			}
+			if !field.facetable() {
+				caps.NonFacetableIndices = append(caps.NonFacetableIndices, index.Name)
+			}
+		}
+	}
+	for caps, names := range found {
+		if len(names) < len(indices) {
+			caps.Indices = names
+		}
+		caps.Searchable = len(caps.NonSearchableIndices) == 0
+		caps.Sortable = len(caps.NonSortableIndices) == 0
+		caps.Facetable = len(caps.NonFacetableIndices) == 0
+		// the lists are only useful if the capability differs across the indices.
+		if len(caps.NonSearchableIndices) == len(names) {
+			caps.NonSearchableIndices = nil
+		}
+		if len(caps.NonSortableIndices) == len(names) {
+			caps.NonSortableIndices = nil
+		}
+		if len(caps.NonFacetableIndices) == len(names) {
+			caps.NonFacetableIndices = nil
+		}
+	}
+	return result, nil
+}
+
+func matchFieldPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func newIndexField(mapping *imapping.IndexMappingImpl, name string) *IndexField {
+	if typ, ok := metaFieldTypes[name]; ok {
+		return &IndexField{Name: name, Type: typ, searchable: true, docValues: true}
+	}
+	if fm := declaredFieldMapping(mapping, name); fm != nil {
+		typ := strings.ToLower(fm.Type)
+		if typ == "text" && fm.Analyzer == keyword.Name {
+			typ = "keyword"
+		}
+		return &IndexField{Name: name, Type: typ, searchable: fm.Index, docValues: fm.DocValues}
+	}
+	return &IndexField{Name: name, Dynamic: true, searchable: mapping.IndexDynamic, docValues: mapping.DocValuesDynamic}
+}
+
+// declaredFieldMapping returns the first field mapping of the path in the default mapping or the type mappings.
+func declaredFieldMapping(mapping *imapping.IndexMappingImpl, name string) *imapping.FieldMapping {
+	types := make([]string, 0, len(mapping.TypeMapping))
+	for typ := range mapping.TypeMapping {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	dms := []*imapping.DocumentMapping{mapping.DefaultMapping}
+	for _, typ := range types {
+		dms = append(dms, mapping.TypeMapping[typ])
+	}
+	for _, dm := range dms {
+		for _, p := range strings.Split(name, ".") {
+			if dm == nil {
+				break
+			}
+			dm = dm.Properties[p]
+		}
+		if dm != nil && len(dm.Fields) > 0 {
+			return dm.Fields[0]
+		}
+	}
+	return nil
+}
+
+// sourceValue returns the first value of the path in the source, the arrays are walked through.
+func sourceValue(source interface{}, path []string) interface{} {
+	switch v := source.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if value := sourceValue(e, path); value != nil {
+				return value
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return nil
+		}
+		return sourceValue(v[path[0]], path[1:])
+	}
+	if len(path) > 0 {
+		return nil
+	}
+	return source
+}
+
+// detectFieldType returns the type of the dynamic field value, a string is a datetime if it can be
+// parsed by the default datetime parser of mapping.
+func detectFieldType(mapping *imapping.IndexMappingImpl, value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if mapping == nil {
+			return "text"
+		}
+		if parser := mapping.DateTimeParserNamed(mapping.DefaultDateTimeParser); parser != nil {
+			if _, err := parser.ParseDateTime(v); err == nil {
+				return "datetime"
+			}
+		}
+		return "text"
+	case float64, float32, int, int64, int32, uint, uint64, uint32:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return ""
+}
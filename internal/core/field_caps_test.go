@@ -0,0 +1,106 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestFieldCaps(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	newIndex := func(name, mapping string) *Index {
+		im := new(IndexMapping)
+		if err := json.Unmarshal([]byte(mapping), im); err != nil {
+			t.Fatal(err)
+		}
+		index, err := NewIndex(WithName(name), WithIndexMapping(im), WithShards(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return index
+	}
+	index := newIndex(indexName, `{"default_mapping": {"properties": {"title": {"fields": [{"type": "text"}]}, "tag": {"fields": [{"type": "keyword"}]}, "note": {"fields": [{"type": "text", "index": false, "store": true}]}}}}`)
+	other := newIndex(indexName+"_other", `{"default_mapping": {"properties": {"price": {"fields": [{"type": "keyword"}]}}}}`)
+	defer func() {
+		log.Println("Delete Index.")
+		for _, index := range []*Index{index, other} {
+			if err := index.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	docs := []map[string]interface{}{
+		{"title": "Quick Search", "tag": "search", "note": "stored", "price": 9.9, "published": "2022-06-01T10:00:00Z", "user": []interface{}{map[string]interface{}{"name": "Tom"}}},
+		{"title": "Quick Fox", "active": true},
+		{"title": "Lazy Dog", "_rank": 1},
+	}
+	for i, doc := range docs {
+		if _, err := index.IndexOrUpdateDocument(string(rune('1'+i)), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := other.IndexOrUpdateDocument("1", map[string]interface{}{"price": "cheap"}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := index.GetMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Mappings != index.Mapping {
+		t.Error("the declared mapping is not returned")
+	}
+	fields := make(map[string]IndexField)
+	for _, field := range res.Fields {
+		fields[field.Name] = IndexField{Name: field.Name, Type: field.Type, Dynamic: field.Dynamic, Docs: field.Docs}
+	}
+	expected := map[string]IndexField{
+		"@timestamp": {Name: "@timestamp", Type: "datetime", Docs: 3},
+		"title":      {Name: "title", Type: "text", Docs: 3},
+		"tag":        {Name: "tag", Type: "keyword", Docs: 1},
+		"note":       {Name: "note", Type: "text", Docs: 0},
+		"price":      {Name: "price", Type: "number", Dynamic: true, Docs: 1},
+		"published":  {Name: "published", Type: "datetime", Dynamic: true, Docs: 1},
+		"user.name":  {Name: "user.name", Type: "text", Dynamic: true, Docs: 1},
+		"active":     {Name: "active", Type: "boolean", Dynamic: true, Docs: 1},
+		"_rank":      {Name: "_rank", Type: "number", Dynamic: true, Docs: 1},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		data, _ := json.Marshal(res.Fields)
+		t.Errorf("discovered fields: %s", data)
+	}
+
+	// the fields are discovered without counting the documents.
+	list, err := index.Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(expected) {
+		t.Errorf("got %d fields, expected %d", len(list), len(expected))
+	}
+	for _, field := range list {
+		if e := expected[field.Name]; field.Type != e.Type || field.Docs != 0 {
+			t.Errorf("field %s: type %s, docs %d, expected type %s and no docs", field.Name, field.Type, field.Docs, e.Type)
+		}
+	}
+
+	caps, err := FieldCapabilities([]string{"price", "t*", "note"}, index, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCaps := map[string]map[string]*FieldCaps{
+		"price": {
+			"number":  {Type: "number", Searchable: true, Sortable: true, Facetable: true, Indices: []string{indexName}},
+			"keyword": {Type: "keyword", Searchable: true, Sortable: true, Facetable: true, Indices: []string{indexName + "_other"}},
+		},
+		"title": {"text": {Type: "text", Searchable: true, Sortable: true, Facetable: true, Indices: []string{indexName}}},
+		"tag":   {"keyword": {Type: "keyword", Searchable: true, Sortable: true, Facetable: true, Indices: []string{indexName}}},
+		"note":  {"text": {Type: "text", Indices: []string{indexName}}},
+	}
+	if !reflect.DeepEqual(caps.Fields, expectedCaps) || !reflect.DeepEqual(caps.Indices, []string{indexName, indexName + "_other"}) {
+		data, _ := json.Marshal(caps)
+		t.Errorf("field caps: %s", data)
+	}
+}
@@ -0,0 +1,577 @@
+package core
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/v2/geo"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	bindex "github.com/blevesearch/bleve_index_api"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"time"
+)
+
+// FunctionScoreQuery modifies the scores of documents matching the query by the functions, the scores are
+// computed inside the shard searchers, so the sorting and paging are based on the modified scores.
+type FunctionScoreQuery struct {
+	Query     query.Query      `json:"query,omitempty"` // match all documents if omitted
+	Functions []*ScoreFunction `json:"functions"`
+	ScoreMode string           `json:"score_mode,omitempty"` // how the functions are combined: multiply(default), sum, avg, first, max, min
+	BoostMode string           `json:"boost_mode,omitempty"` // how the query score and function score are combined: multiply(default), replace, sum, avg, max, min
+	MaxBoost  float64          `json:"max_boost,omitempty"`  // the cap of function score
+	MinScore  float64          `json:"min_score,omitempty"`  // the documents scored below are excluded
+	BoostVal  float64          `json:"boost,omitempty"`
+}
+
+// ScoreFunction is applied to the documents matching the filter, or all documents if no filter. The
+// score is the result of function multiplied by the weight, or the weight if no function.
+type ScoreFunction struct {
+	Filter           query.Query       `json:"filter,omitempty"`
+	Weight           *float64          `json:"weight,omitempty"`
+	FieldValueFactor *FieldValueFactor `json:"field_value_factor,omitempty"`
+	Gauss            *DecayFunction    `json:"gauss,omitempty"`
+	Exp              *DecayFunction    `json:"exp,omitempty"`
+	Linear           *DecayFunction    `json:"linear,omitempty"`
+	RandomScore      *RandomScore      `json:"random_score,omitempty"`
+}
+
+// FieldValueFactor scores by modifier(factor * value) of the numeric field.
+type FieldValueFactor struct {
+	Field    string   `json:"field"`
+	Factor   float64  `json:"factor,omitempty"`   // 1 by default
+	Modifier string   `json:"modifier,omitempty"` // none(default), log, log1p, log2p, ln, ln1p, ln2p, square, sqrt, reciprocal
+	Missing  *float64 `json:"missing,omitempty"`  // the value if field missing, or the function isn't applied
+}
+
+// DecayFunction scores by the distance from origin of the numeric, datetime or geopoint field. The
+// score is 1 within offset, and decay at the distance of offset + scale.
+type DecayFunction struct {
+	Field  string      `json:"-"`
+	Origin interface{} `json:"origin,omitempty"` // number, datetime(now by default) or geopoint
+	Scale  interface{} `json:"scale"`            // number, duration like "10d" or distance like "2km"
+	Offset interface{} `json:"offset,omitempty"`
+	Decay  float64     `json:"decay,omitempty"` // 0.5 by default
+
+	kind   string // "number", "datetime" or "geopoint"
+	origin float64
+	point  GeoPoint
+	scale  float64 // the datetime in milliseconds and the distance in meters
+	offset float64
+}
+
+// RandomScore scores the documents uniformly in [0, 1), the scores are the same for the same seed.
+type RandomScore struct {
+	Seed int64 `json:"seed"`
+}
+
+var (
+	scoreModes = map[string]bool{"multiply": true, "sum": true, "avg": true, "first": true, "max": true, "min": true}
+	boostModes = map[string]bool{"multiply": true, "replace": true, "sum": true, "avg": true, "max": true, "min": true}
+	modifiers  = map[string]bool{"none": true, "log": true, "log1p": true, "log2p": true, "ln": true, "ln1p": true, "ln2p": true,
+		"square": true, "sqrt": true, "reciprocal": true}
+)
+
+// parseFunctionScoreQuery parses the function_score query, the query and filters are parsed by parse, so it's
+// shared by the quicksearch and Elasticsearch format. A single function can be defined without "functions".
+func parseFunctionScoreQuery(clause map[string]stdjson.RawMessage, path string, parse func(input stdjson.RawMessage, path string) (query.Query, error)) (query.Query, error) {
+	q := new(FunctionScoreQuery)
+	function := make(map[string]stdjson.RawMessage)
+	for _, key := range sortedClauseKeys(clause) {
+		raw := clause[key]
+		var err error
+		switch key {
+		case "query":
+			q.Query, err = parse(raw, path+".query")
+		case "functions":
+			var list []stdjson.RawMessage
+			if err = stdjson.Unmarshal(raw, &list); err != nil {
+				return nil, queryError(path+".functions", "functions must be an array")
+			}
+			for i, item := range list {
+				f, err := parseScoreFunction(item, fmt.Sprintf("%s.functions[%d]", path, i), parse)
+				if err != nil {
+					return nil, err
+				}
+				q.Functions = append(q.Functions, f)
+			}
+		case "score_mode", "boost_mode", "max_boost", "min_score", "boost":
+			err = decodeQueryFields(map[string]stdjson.RawMessage{key: raw}, q, path)
+		case "weight", "field_value_factor", "gauss", "exp", "linear", "random_score":
+			function[key] = raw
+		default:
+			err = queryError(path+"."+key, "unknown field")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(function) > 0 {
+		if len(q.Functions) > 0 {
+			return nil, queryError(path, "functions and a single function can't be both defined")
+		}
+		f, err := parseScoreFunction(mustMarshal(function), path, parse)
+		if err != nil {
+			return nil, err
+		}
+		q.Functions = append(q.Functions, f)
+	}
+	if len(q.ScoreMode) > 0 && !scoreModes[q.ScoreMode] {
+		return nil, queryError(path+".score_mode", "unknown score mode [%s]", q.ScoreMode)
+	}
+	if len(q.BoostMode) > 0 && !boostModes[q.BoostMode] {
+		return nil, queryError(path+".boost_mode", "unknown boost mode [%s]", q.BoostMode)
+	}
+	return q, nil
+}
+
+func parseScoreFunction(input stdjson.RawMessage, path string, parse func(input stdjson.RawMessage, path string) (query.Query, error)) (*ScoreFunction, error) {
+	var clause map[string]stdjson.RawMessage
+	if err := stdjson.Unmarshal(input, &clause); err != nil {
+		return nil, queryError(path, "function must be an object")
+	}
+	f := new(ScoreFunction)
+	functions := 0
+	for _, key := range sortedClauseKeys(clause) {
+		raw, keyPath := clause[key], path+"."+key
+		var err error
+		switch key {
+		case "filter":
+			f.Filter, err = parse(raw, keyPath)
+		case "weight":
+			err = decodeQueryFields(map[string]stdjson.RawMessage{key: raw}, f, path)
+		case "field_value_factor":
+			f.FieldValueFactor = new(FieldValueFactor)
+			if err = decodeQueryFields(mustDecodeClause(raw), f.FieldValueFactor, keyPath); err == nil {
+				err = f.FieldValueFactor.validate(keyPath)
+			}
+		case "gauss", "exp", "linear":
+			var decay *DecayFunction
+			if decay, err = parseDecayFunction(raw, keyPath); err != nil {
+				break
+			}
+			switch key {
+			case "gauss":
+				f.Gauss = decay
+			case "exp":
+				f.Exp = decay
+			default:
+				f.Linear = decay
+			}
+		case "random_score":
+			f.RandomScore = new(RandomScore)
+			if err = decodeQueryFields(mustDecodeClause(raw), f.RandomScore, keyPath); err == nil {
+				if _, ok := mustDecodeClause(raw)["seed"]; !ok {
+					f.RandomScore.Seed = time.Now().UnixNano()
+				}
+			}
+		default:
+			err = queryError(keyPath, "unknown field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key != "filter" && key != "weight" {
+			functions++
+		}
+	}
+	if functions > 1 {
+		return nil, queryError(path, "function must have at most one type, got %d", functions)
+	}
+	if functions == 0 && f.Weight == nil {
+		return nil, queryError(path, "one of [weight], [field_value_factor], [gauss], [exp], [linear] or [random_score] is required")
+	}
+	return f, nil
+}
+
+// mustDecodeClause decodes the object, the non-object is decoded to an empty clause.
+func mustDecodeClause(input stdjson.RawMessage) map[string]stdjson.RawMessage {
+	clause := make(map[string]stdjson.RawMessage)
+	_ = stdjson.Unmarshal(input, &clause)
+	return clause
+}
+
+func (f *FieldValueFactor) validate(path string) error {
+	if len(f.Field) == 0 {
+		return queryError(path, "[field] is required")
+	}
+	if len(f.Modifier) > 0 && !modifiers[f.Modifier] {
+		return queryError(path+".modifier", "unknown modifier [%s]", f.Modifier)
+	}
+	if f.Factor == 0 {
+		f.Factor = 1
+	}
+	return nil
+}
+
+// parseDecayFunction parses the decay function like {"created": {"origin": "now", "scale": "10d"}}, the kind is
+// geopoint if the origin is a geo point, number if the scale is a number, otherwise datetime.
+func parseDecayFunction(input stdjson.RawMessage, path string) (*DecayFunction, error) {
+	clause := mustDecodeClause(input)
+	delete(clause, "multi_value_mode") // the closest value is always used
+	if len(clause) != 1 {
+		return nil, queryError(path, "decay function must have exactly one field, got %d", len(clause))
+	}
+	d := new(DecayFunction)
+	for field, raw := range clause {
+		d.Field = field
+		path = path + "." + field
+		if err := decodeQueryFields(mustDecodeClause(raw), d, path); err != nil {
+			return nil, err
+		}
+	}
+	if d.Scale == nil {
+		return nil, queryError(path, "[scale] is required")
+	}
+	if d.Decay == 0 {
+		d.Decay = 0.5
+	}
+	if d.Decay < 0 || d.Decay >= 1 {
+		return nil, queryError(path+".decay", "decay must be in (0, 1)")
+	}
+	_, numericScale := d.Scale.(float64)
+	switch {
+	case d.Origin != nil && stdjson.Unmarshal(mustMarshal(d.Origin), &d.point) == nil:
+		d.kind = "geopoint"
+		scale, err := geo.ParseDistance(esValueString(d.Scale))
+		if err != nil {
+			return nil, queryError(path+".scale", err.Error())
+		}
+		d.scale = scale
+		if d.Offset != nil {
+			if d.offset, err = geo.ParseDistance(esValueString(d.Offset)); err != nil {
+				return nil, queryError(path+".offset", err.Error())
+			}
+		}
+	case numericScale:
+		d.kind = "number"
+		origin, ok := d.Origin.(float64)
+		if !ok {
+			return nil, queryError(path+".origin", "origin must be a number for the numeric scale")
+		}
+		d.origin, d.scale = origin, d.Scale.(float64)
+		if d.Offset != nil {
+			if d.offset, ok = d.Offset.(float64); !ok {
+				return nil, queryError(path+".offset", "offset must be a number for the numeric scale")
+			}
+		}
+	default:
+		d.kind = "datetime"
+		origin := d.Origin
+		if origin == nil {
+			origin = "now"
+		}
+		t, err := parseESDate(origin, "", time.UTC, false)
+		if err != nil {
+			return nil, queryError(path+".origin", err.Error())
+		}
+		d.origin = float64(t.UnixNano() / int64(time.Millisecond))
+		scale, err := parseFixedInterval(esValueString(d.Scale))
+		if err != nil {
+			return nil, queryError(path+".scale", err.Error())
+		}
+		d.scale = float64(scale / time.Millisecond)
+		if d.Offset != nil {
+			offset, err := parseFixedInterval(esValueString(d.Offset))
+			if err != nil {
+				return nil, queryError(path+".offset", err.Error())
+			}
+			d.offset = float64(offset / time.Millisecond)
+		}
+	}
+	if d.scale <= 0 {
+		return nil, queryError(path+".scale", "scale must be positive")
+	}
+	return d, nil
+}
+
+func (d *DecayFunction) MarshalJSON() ([]byte, error) {
+	type decay DecayFunction
+	return stdjson.Marshal(map[string]*decay{d.Field: (*decay)(d)})
+}
+
+// distance returns the closest distance from origin of the values, the values are prefix coded int64.
+func (d *DecayFunction) distance(values []int64) float64 {
+	distance := math.Inf(1)
+	for _, v := range values {
+		var dist float64
+		switch d.kind {
+		case "geopoint":
+			lon, lat := geo.MortonUnhashLon(uint64(v)), geo.MortonUnhashLat(uint64(v))
+			dist = geo.Haversin(d.point.Lon, d.point.Lat, lon, lat) * 1000
+		case "datetime":
+			dist = math.Abs(float64(v/int64(time.Millisecond)) - d.origin)
+		default:
+			dist = math.Abs(numeric.Int64ToFloat64(v) - d.origin)
+		}
+		distance = math.Min(distance, dist)
+	}
+	return math.Max(0, distance-d.offset)
+}
+
+func gauss(d *DecayFunction, distance float64) float64 {
+	sigma2 := -d.scale * d.scale / (2 * math.Log(d.Decay))
+	return math.Exp(-distance * distance / (2 * sigma2))
+}
+
+func exp(d *DecayFunction, distance float64) float64 {
+	return math.Exp(math.Log(d.Decay) / d.scale * distance)
+}
+
+func linear(d *DecayFunction, distance float64) float64 {
+	s := d.scale / (1 - d.Decay)
+	return math.Max(0, (s-distance)/s)
+}
+
+func (q *FunctionScoreQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	inner := q.Query
+	if inner == nil {
+		inner = query.NewMatchAllQuery()
+	}
+	searcher, err := inner.Searcher(i, m, options)
+	if err != nil {
+		return nil, err
+	}
+	s := &functionScoreSearcher{Searcher: searcher, query: q, reader: i, explain: options.Explain,
+		filters: make([]map[string]struct{}, len(q.Functions)), values: make(map[string][]int64)}
+	fields := make([]string, 0)
+	for n, f := range q.Functions {
+		if field := f.field(); len(field) > 0 {
+			fields = append(fields, field)
+		}
+		if f.Filter == nil {
+			continue
+		}
+		ids := make(map[string]struct{})
+		if err := visitMatches(i, m, f.Filter, func(id bindex.IndexInternalID) error {
+			ids[string(id)] = struct{}{}
+			return nil
+		}); err != nil {
+			_ = searcher.Close()
+			return nil, err
+		}
+		s.filters[n] = ids
+	}
+	if len(fields) > 0 {
+		if s.docValues, err = i.DocValueReader(fields); err != nil {
+			_ = searcher.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// field returns the field used by the function.
+func (f *ScoreFunction) field() string {
+	if f.FieldValueFactor != nil {
+		return f.FieldValueFactor.Field
+	}
+	for _, d := range []*DecayFunction{f.Gauss, f.Exp, f.Linear} {
+		if d != nil {
+			return d.Field
+		}
+	}
+	return ""
+}
+
+// functionScoreSearcher wraps the searcher of query and modifies the scores of matches.
+type functionScoreSearcher struct {
+	search.Searcher
+	query     *FunctionScoreQuery
+	reader    bindex.IndexReader
+	docValues bindex.DocValueReader
+	filters   []map[string]struct{} // the matches of function filters
+	values    map[string][]int64    // the numeric doc values of current document
+	explain   bool
+}
+
+func (s *functionScoreSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Next(ctx)
+	return s.next(ctx, dm, err)
+}
+
+func (s *functionScoreSearcher) Advance(ctx *search.SearchContext, ID bindex.IndexInternalID) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Advance(ctx, ID)
+	return s.next(ctx, dm, err)
+}
+
+// next scores the match, and skips to the next one if it's scored below min_score.
+func (s *functionScoreSearcher) next(ctx *search.SearchContext, dm *search.DocumentMatch, err error) (*search.DocumentMatch, error) {
+	for err == nil && dm != nil {
+		if err = s.score(dm); err != nil {
+			return nil, err
+		}
+		if s.query.MinScore <= 0 || dm.Score >= s.query.MinScore {
+			return dm, nil
+		}
+		ctx.DocumentMatchPool.Put(dm)
+		dm, err = s.Searcher.Next(ctx)
+	}
+	return dm, err
+}
+
+func (s *functionScoreSearcher) score(dm *search.DocumentMatch) error {
+	if s.docValues != nil {
+		for field := range s.values {
+			s.values[field] = s.values[field][:0]
+		}
+		if err := s.docValues.VisitDocValues(dm.IndexInternalID, func(field string, term []byte) {
+			if i, ok := prefixCodedInt64(term); ok {
+				s.values[field] = append(s.values[field], i)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	scores := make([]float64, 0, len(s.query.Functions))
+	for n, f := range s.query.Functions {
+		if s.filters[n] != nil {
+			if _, ok := s.filters[n][string(dm.IndexInternalID)]; !ok {
+				continue
+			}
+		}
+		score, ok, err := s.function(f, dm)
+		if err != nil {
+			return err
+		}
+		if ok {
+			scores = append(scores, score)
+		}
+	}
+	functionScore := combineScores(s.query.ScoreMode, scores)
+	if s.query.MaxBoost > 0 {
+		functionScore = math.Min(functionScore, s.query.MaxBoost)
+	}
+	queryScore := dm.Score
+	var score float64
+	switch s.query.BoostMode {
+	case "replace":
+		score = functionScore
+	case "sum":
+		score = queryScore + functionScore
+	case "avg":
+		score = (queryScore + functionScore) / 2
+	case "max":
+		score = math.Max(queryScore, functionScore)
+	case "min":
+		score = math.Min(queryScore, functionScore)
+	default:
+		score = queryScore * functionScore
+	}
+	dm.Score = score * float64(newBoost(s.query.BoostVal))
+	if s.explain {
+		dm.Expl = &search.Explanation{
+			Value:   dm.Score,
+			Message: fmt.Sprintf("function score, score mode [%s], boost mode [%s]", s.query.ScoreMode, s.query.BoostMode),
+			Children: []*search.Explanation{
+				dm.Expl,
+				{Value: functionScore, Message: fmt.Sprintf("functions matched %d of %d", len(scores), len(s.query.Functions))},
+			},
+		}
+	}
+	return nil
+}
+
+// function returns the score of function, ok is false if the function isn't applied to the document.
+func (s *functionScoreSearcher) function(f *ScoreFunction, dm *search.DocumentMatch) (score float64, ok bool, err error) {
+	score = 1
+	switch {
+	case f.FieldValueFactor != nil:
+		fvf := f.FieldValueFactor
+		var value float64
+		if values := s.values[fvf.Field]; len(values) > 0 {
+			value = numeric.Int64ToFloat64(values[0])
+		} else if fvf.Missing != nil {
+			value = *fvf.Missing
+		} else {
+			return 0, false, nil
+		}
+		score = modify(fvf.Modifier, fvf.Factor*value)
+	case f.Gauss != nil:
+		score = decay(f.Gauss, s.values[f.Gauss.Field], gauss)
+	case f.Exp != nil:
+		score = decay(f.Exp, s.values[f.Exp.Field], exp)
+	case f.Linear != nil:
+		score = decay(f.Linear, s.values[f.Linear.Field], linear)
+	case f.RandomScore != nil:
+		id, err := s.reader.ExternalID(dm.IndexInternalID)
+		if err != nil {
+			return 0, false, err
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(strconv.FormatInt(f.RandomScore.Seed, 10)))
+		_, _ = h.Write([]byte(id))
+		score = float64(h.Sum64()>>11) / (1 << 53)
+	}
+	if f.Weight != nil {
+		score *= *f.Weight
+	}
+	return score, true, nil
+}
+
+// decay returns the score of decay function, the score is 1 if the field is missing.
+func decay(d *DecayFunction, values []int64, fn func(d *DecayFunction, distance float64) float64) float64 {
+	if len(values) == 0 {
+		return 1
+	}
+	return fn(d, d.distance(values))
+}
+
+func modify(modifier string, v float64) float64 {
+	var score float64
+	switch modifier {
+	case "log":
+		score = math.Log10(v)
+	case "log1p":
+		score = math.Log10(v + 1)
+	case "log2p":
+		score = math.Log10(v + 2)
+	case "ln":
+		score = math.Log(v)
+	case "ln1p":
+		score = math.Log1p(v)
+	case "ln2p":
+		score = math.Log(v + 2)
+	case "square":
+		score = v * v
+	case "sqrt":
+		score = math.Sqrt(v)
+	case "reciprocal":
+		score = 1 / v
+	default:
+		score = v
+	}
+	// the negative and infinite scores break the ranking.
+	if math.IsNaN(score) || math.IsInf(score, 0) || score < 0 {
+		return 0
+	}
+	return score
+}
+
+// combineScores combines the scores of functions by the score mode, it's 1 if no function applied.
+func combineScores(mode string, scores []float64) float64 {
+	if len(scores) == 0 {
+		return 1
+	}
+	result := scores[0]
+	for _, score := range scores[1:] {
+		switch mode {
+		case "sum", "avg":
+			result += score
+		case "first":
+		case "max":
+			result = math.Max(result, score)
+		case "min":
+			result = math.Min(result, score)
+		default:
+			result *= score
+		}
+	}
+	if mode == "avg" {
+		result /= float64(len(scores))
+	}
+	return result
+}
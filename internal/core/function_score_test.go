@@ -0,0 +1,158 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const functionScoreMapping = `{
+    "default_mapping": {
+        "properties": {
+            "tag": {"fields": [{"type": "keyword"}]},
+            "likes": {"fields": [{"type": "number"}]},
+            "created": {"fields": [{"type": "datetime"}]},
+            "location": {"fields": [{"type": "geopoint"}]}
+        }
+    }
+}`
+
+func TestFunctionScore(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(functionScoreMapping), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "red fox", "tag": "a", "likes": 4, "created": "2022-01-01T00:00:00Z", "location": []float64{116.40, 39.90}},
+		"2": {"title": "red dog", "tag": "b", "likes": 16, "created": "2022-01-02T00:00:00Z", "location": []float64{121.47, 31.23}},
+		"3": {"title": "red cat", "tag": "a", "likes": 36, "created": "2022-01-03T00:00:00Z", "location": []float64{113.26, 23.13}},
+	}
+	for id, doc := range docs {
+		if _, err := index.IndexOrUpdateDocument(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func(body string) *SearchResult {
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(body), req); err != nil {
+			t.Fatalf("parse %s: %s", body, err)
+		}
+		res, err := index.Search(req)
+		if err != nil {
+			t.Fatalf("search %s: %s", body, err)
+		}
+		return res
+	}
+	cases := []struct {
+		query    string
+		expected []string
+		scores   []float64
+	}{
+		{
+			`{"type": "function_score", "field_value_factor": {"field": "likes", "modifier": "sqrt"}, "boost_mode": "replace"}`,
+			[]string{"3", "2", "1"}, []float64{6, 4, 2},
+		},
+		{
+			`{"type": "function_score", "gauss": {"created": {"origin": "2022-01-02T06:00:00Z", "scale": "1d", "decay": 0.5}}, "boost_mode": "replace"}`,
+			[]string{"2", "3", "1"}, []float64{math.Pow(0.5, 1.0/16), math.Pow(0.5, 9.0/16), math.Pow(0.5, 25.0/16)},
+		},
+		{
+			`{"type": "function_score", "exp": {"location": {"origin": [116.40, 39.90], "scale": "1000km"}}, "boost_mode": "replace"}`,
+			[]string{"1", "2", "3"}, nil,
+		},
+		{
+			`{"type": "function_score", "linear": {"likes": {"origin": 0, "scale": 20, "decay": 0.5}}, "boost_mode": "replace"}`,
+			[]string{"1", "2", "3"}, []float64{0.9, 0.6, 0.1},
+		},
+		{
+			`{"type": "function_score", "functions": [{"filter": {"type": "term", "term": "a", "field": "tag"}, "weight": 10}, {"filter": {"type": "ids", "ids": ["3"]}, "weight": 5}, {"weight": 2}], "score_mode": "sum", "boost_mode": "replace", "min_score": 5}`,
+			[]string{"3", "1"}, []float64{17, 12},
+		},
+		{
+			`{"type": "function_score", "query": {"type": "match", "match": "cat", "field": "title"}, "field_value_factor": {"field": "likes", "factor": 10, "modifier": "log"}, "boost_mode": "replace", "max_boost": 2}`,
+			[]string{"3"}, []float64{2},
+		},
+	}
+	for _, c := range cases {
+		res := search(`{"query": ` + c.query + `}`)
+		ids, scores := make([]string, 0, len(res.Hits)), make([]float64, 0, len(res.Hits))
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.ID)
+			scores = append(scores, float64(hit.Score))
+		}
+		if !reflect.DeepEqual(ids, c.expected) {
+			t.Errorf("search %s: got %v, expected %v", c.query, ids, c.expected)
+		}
+		for i := range c.scores {
+			if i < len(scores) && math.Abs(scores[i]-c.scores[i]) > 1e-6 {
+				t.Errorf("search %s: got scores %v, expected %v", c.query, scores, c.scores)
+				break
+			}
+		}
+	}
+
+	// the paging is based on the modified scores across shards.
+	res := search(`{"query": {"type": "function_score", "field_value_factor": {"field": "likes"}}, "from": 1, "size": 1}`)
+	if len(res.Hits) != 1 || res.Hits[0].ID != "2" {
+		t.Errorf("the second page: %v", res.Hits)
+	}
+
+	// the random scores are the same for the same seed.
+	random := `{"query": {"type": "function_score", "random_score": {"seed": 42}, "boost_mode": "replace"}}`
+	first, second := search(random), search(random)
+	for i := range first.Hits {
+		if first.Hits[i].ID != second.Hits[i].ID || first.Hits[i].Score != second.Hits[i].Score {
+			t.Errorf("random scores differ: %v, %v", first.Hits, second.Hits)
+		}
+		if first.Hits[i].Score < 0 || first.Hits[i].Score >= 1 {
+			t.Errorf("random score %f out of [0, 1)", first.Hits[i].Score)
+		}
+	}
+
+	// the function_score in Elasticsearch Query DSL.
+	req := new(ESSearchRequest)
+	if err := json.Unmarshal([]byte(`{"query": {"function_score": {"query": {"term": {"tag": "a"}}, "functions": [{"field_value_factor": {"field": "likes"}}], "boost_mode": "replace"}}}`), req); err != nil {
+		t.Fatal(err)
+	}
+	esRes, err := ESSearchIndices(req, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(esRes.Hits.Hits) != 2 || esRes.Hits.Hits[0].ID != "3" || *esRes.Hits.Hits[0].Score != 36 {
+		t.Errorf("es function_score: %v", esRes.Hits.Hits)
+	}
+
+	// the errors contain the path of bad clause.
+	for input, path := range map[string]string{
+		`{"type": "function_score", "functions": [{"weight": 1}, {"filter": {"type": "match_all"}}]}`:    "query.functions[1]",
+		`{"type": "function_score", "functions": [{"gauss": {"created": {"origin": "now"}}}]}`:           "query.functions[0].gauss.created",
+		`{"type": "function_score", "field_value_factor": {"field": "likes", "modifier": "cube"}}`:       "query.field_value_factor.modifier",
+		`{"type": "function_score", "weight": 1, "boost_mode": "unknown"}`:                               "query.boost_mode",
+		`{"type": "function_score", "functions": [{"filter": {"type": "term"}, "weight": 1}], "bad": 1}`: "query.bad",
+		`{"type": "function_score", "exp": {"likes": {"origin": "a", "scale": 10}}}`:                     "query.exp.likes.origin",
+	} {
+		_, err := ParseQuery([]byte(input))
+		if err == nil || !strings.Contains(err.Error(), "["+path+"]") {
+			t.Errorf("parse %s: %v, expected error at %s", input, err, path)
+		}
+	}
+}
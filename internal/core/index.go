@@ -3,6 +3,7 @@ package core
 import (
 	"fmt"
 	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
 	"github.com/feimingxliu/quicksearch/internal/config"
 	_ "github.com/feimingxliu/quicksearch/internal/pkg/analyzer"
 	"github.com/feimingxliu/quicksearch/pkg/errors"
@@ -10,20 +11,22 @@ import (
 	"github.com/feimingxliu/quicksearch/pkg/util/uuid"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"time"
 )
 
 type Index struct {
-	UID            string        `json:"uid"`
-	Name           string        `json:"name"`
-	Mapping        *IndexMapping `json:"mapping"`
-	DocNum         uint64        `json:"doc_num"`          // number of docs
-	StorageSize    uint64        `json:"storage_size"`     // bytes on disk
-	NumberOfShards int           `json:"number_of_shards"` // number of shards
-	Shards         []*IndexShard `json:"shards"`
-	CreateAt       time.Time     `json:"create_at"`
-	UpdateAt       time.Time     `json:"update_at"`
+	UID            string            `json:"uid"`
+	Name           string            `json:"name"`
+	Mapping        *IndexMapping     `json:"mapping"`
+	DocNum         uint64            `json:"doc_num"`          // number of docs
+	StorageSize    uint64            `json:"storage_size"`     // bytes on disk
+	NumberOfShards int               `json:"number_of_shards"` // number of shards
+	Translog       *TranslogSettings `json:"translog,omitempty"`
+	Shards         []*IndexShard     `json:"shards"`
+	CreateAt       time.Time         `json:"create_at"`
+	UpdateAt       time.Time         `json:"update_at"`
 	closed         bool
 	mu             sync.RWMutex
 }
@@ -32,6 +35,7 @@ type options struct {
 	name        string
 	mapping     *IndexMapping
 	numOfShards int
+	translog    *TranslogSettings
 }
 
 type Option func(*options)
@@ -54,6 +58,13 @@ func WithShards(num int) Option {
 	}
 }
 
+// WithTranslog specifies the durability of the translog, see TranslogSettings.
+func WithTranslog(settings *TranslogSettings) Option {
+	return func(o *options) {
+		o.translog = settings
+	}
+}
+
 // NewIndex return an Index, which is opened and appended to engine.indices.
 func NewIndex(opts ...Option) (*Index, error) {
 	// the default will be replaced by opts
@@ -61,15 +72,25 @@ func NewIndex(opts ...Option) (*Index, error) {
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	if !validIndexName(cfg.name) {
+		return nil, errors.ErrInvalidIndexName
+	}
 	if index, err := GetIndex(cfg.name); err == nil && index != nil {
 		return index, nil
 	}
+	if engine.getAlias(cfg.name) != nil {
+		return nil, errors.ErrIndexAliasConflict
+	}
+	if err := cfg.translog.validate(); err != nil {
+		return nil, err
+	}
 	uid := uuid.GetXID()
 	index := &Index{
 		UID:            uid,
 		Name:           cfg.name,
 		Mapping:        cfg.mapping,
 		NumberOfShards: cfg.numOfShards,
+		Translog:       cfg.translog,
 		CreateAt:       time.Now(),
 		UpdateAt:       time.Now(),
 	}
@@ -155,18 +176,40 @@ func GetIndex(name string) (*Index, error) {
 // Open open the index, append it to the engine.indices.
 func (index *Index) Open() error {
 	index.mu.Lock()
+	if err := index.openShards(); err != nil {
+		index.mu.Unlock()
+		return err
+	}
+	index.closed = false
+	engine.addIndex(index)
+	index.mu.Unlock()
+	// update metadata after open
+	if err := index.UpdateMetadata(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// openShards creates or opens the shards which are not opened, the caller must hold index.mu.
+// The shards opened by it are closed again if it fails.
+func (index *Index) openShards() (err error) {
+	opened := make([]*IndexShard, 0, index.NumberOfShards)
+	defer func() {
+		if err != nil {
+			for _, shard := range opened {
+				shard.close()
+			}
+		}
+	}()
 	if index.Shards == nil {
-		index.Shards = make([]*IndexShard, 0, index.NumberOfShards)
+		shards := make([]*IndexShard, 0, index.NumberOfShards)
 		for i := 0; i < index.NumberOfShards; i++ {
 			mapping, err := buildIndexMapping(index.Mapping)
 			if err != nil {
-				index.mu.Unlock()
 				return err
-
 			}
-			indexer, err := bleve.New(index.shardDir(i), mapping)
+			indexer, err := index.newIndexer(i, mapping)
 			if err != nil {
-				index.mu.Unlock()
 				return err
 			}
 			indexer.SetName(index.Name)
@@ -174,32 +217,41 @@ func (index *Index) Open() error {
 				ID:      i,
 				Indexer: indexer,
 			}
-			index.Shards = append(index.Shards, shard)
-		}
-	} else {
-		for _, shard := range index.Shards {
-			if shard.Indexer != nil {
-				continue
-			}
-			indexer, err := bleve.Open(index.shardDir(shard.ID))
-			if err != nil {
-				index.mu.Unlock()
+			opened = append(opened, shard)
+			if err = index.loadShard(shard); err != nil {
 				return err
 			}
-			indexer.SetName(index.Name)
-			shard.Indexer = indexer
+			shards = append(shards, shard)
 		}
+		index.Shards = shards
+		return nil
 	}
-	index.closed = false
-	engine.addIndex(index)
-	index.mu.Unlock()
-	// update metadata after open
-	if err := index.UpdateMetadata(); err != nil {
-		return err
+	for _, shard := range index.Shards {
+		if shard.Indexer != nil {
+			continue
+		}
+		indexer, err := index.openIndexer(shard.ID)
+		if err != nil {
+			return err
+		}
+		indexer.SetName(index.Name)
+		shard.Indexer = indexer
+		opened = append(opened, shard)
+		if err = index.loadShard(shard); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// loadShard loads the sequence number of the opened shard and replays its translog.
+func (index *Index) loadShard(shard *IndexShard) error {
+	if err := shard.loadSeqNo(); err != nil {
+		return err
+	}
+	return index.openTranslog(shard)
+}
+
 // Close closes index and release the related resource, including remove from engine.indices.
 func (index *Index) Close() error {
 	// update metadata before close
@@ -207,23 +259,23 @@ func (index *Index) Close() error {
 		return err
 	}
 	index.mu.Lock()
+	defer index.mu.Unlock()
+	return index.close()
+}
+
+// close removes the index from engine.indices and closes the shards, the caller must hold index.mu.
+func (index *Index) close() error {
 	engine.removeIndex(index)
 	for _, shard := range index.Shards {
-		if shard.Indexer == nil {
-			continue
-		}
 		// cleanup cgo allocated heap memory
 		//if az := shard.Indexer.Mapping().AnalyzerNamed("gojieba"); az != nil {
 		//	az.Tokenizer.(*jieba.JiebaTokenizer).Free()
 		//}
-		if err := shard.Indexer.Close(); err != nil {
-			index.mu.Unlock()
+		if err := shard.close(); err != nil {
 			return err
 		}
-		shard.Indexer = nil
 	}
 	index.closed = true
-	index.mu.Unlock()
 	return nil
 }
 
@@ -243,11 +295,18 @@ func (index *Index) Delete() error {
 	if err := os.RemoveAll(index.dir()); err != nil {
 		return err
 	}
+	// the aliases should not point to the deleted index.
+	if err := engine.removeIndexFromAliases(index.Name); err != nil {
+		return err
+	}
 	return nil
 }
 
 // Clone clones the entire index to a new index.
 func (index *Index) Clone(name string) error {
+	if !validIndexName(name) {
+		return errors.ErrInvalidIndexName
+	}
 	// check if cloned index is valid
 	if _, err := GetIndex(name); err == nil {
 		return errors.ErrIndexAlreadyExists
@@ -256,6 +315,9 @@ func (index *Index) Clone(name string) error {
 			return err
 		}
 	}
+	if engine.getAlias(name) != nil {
+		return errors.ErrIndexAliasConflict
+	}
 	uid := uuid.GetXID()
 	clone := &Index{
 		UID:            uid,
@@ -264,6 +326,7 @@ func (index *Index) Clone(name string) error {
 		DocNum:         index.DocNum,
 		StorageSize:    index.StorageSize,
 		NumberOfShards: index.NumberOfShards,
+		Translog:       index.Translog,
 		Shards:         make([]*IndexShard, 0, index.NumberOfShards),
 		CreateAt:       time.Now(),
 		UpdateAt:       time.Now(),
@@ -349,11 +412,33 @@ func (index *Index) IsClosed() bool {
 	return b
 }
 
+// validIndexName reports whether the name can be used as an index name, which is also a dir name.
+func validIndexName(name string) bool {
+	return len(name) > 0 && len(name) <= 255 && !strings.ContainsAny(name[:1], "_-+.") &&
+		!strings.ContainsAny(name, `/\,*?"<>| #:`)
+}
+
 // returns the index storage dir.
 func (index *Index) dir() string {
 	return path.Join(config.Global.Storage.DataDir, "indices", index.Name)
 }
 
+// newIndexer creates the bleve index of shard, see indexerConfig.
+func (index *Index) newIndexer(shard int, mapping imapping.IndexMapping) (bleve.Index, error) {
+	return bleve.NewUsing(index.shardDir(shard), mapping, bleve.Config.DefaultIndexType, bleve.Config.DefaultKVStore, index.indexerConfig())
+}
+
+// openIndexer opens the bleve index of shard, see indexerConfig.
+func (index *Index) openIndexer(shard int) (bleve.Index, error) {
+	return bleve.OpenUsing(index.shardDir(shard), index.indexerConfig())
+}
+
+// indexerConfig returns the runtime config of the bleve index of shards, the batches return before persisted
+// with the async durability of translog.
+func (index *Index) indexerConfig() map[string]interface{} {
+	return map[string]interface{}{"unsafe_batch": index.Translog.async()}
+}
+
 // returns the index shard storage dir.
 func (index *Index) shardDir(shard int) string {
 	return path.Join(index.dir(), fmt.Sprintf("%s_%d", index.UID, shard))
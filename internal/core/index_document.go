@@ -14,49 +14,24 @@ import (
 type Document struct {
 	Index       string      `json:"_index"`
 	ID          string      `json:"_id"`
-	Version     int64       `json:"_version"`
-	SeqNo       int64       `json:"_seq_no"`
-	PrimaryTerm int64       `json:"_primary_term"`
+	Version     int64       `json:"_version,omitempty"` // omitted if the document is not found
+	SeqNo       int64       `json:"_seq_no,omitempty"`
+	PrimaryTerm int64       `json:"_primary_term,omitempty"`
 	Found       bool        `json:"found"`
 	Source      interface{} `json:"_source"`
+	Error       string      `json:"error,omitempty"`
 }
 
 // IndexOrUpdateDocument indexes or update a document refers to `index`.
-func (index *Index) IndexOrUpdateDocument(docID string, source map[string]interface{}) error {
-	if index.IsClosed() {
-		return errors.ErrIndexClosed
-	}
-	shard := index.getDocShard(docID)
-	doc, err := index.buildBleveDocument(docID, source, nil)
-	if err != nil {
-		return err
-	}
-	idx, err := shard.Indexer.Advanced()
-	if err != nil {
-		return err
-	}
-	return idx.Update(doc)
+func (index *Index) IndexOrUpdateDocument(docID string, source map[string]interface{}, opts ...WriteOption) (*BulkActionResult, error) {
+	return index.executeWrite(newWriteOp(opIndex, index.Name, docID, source, opts...))
 }
 
-// UpdateDocumentPartially can update part fields of indexed document.
-func (index *Index) UpdateDocumentPartially(docID string, fields map[string]interface{}) error {
-	if index.IsClosed() {
-		return errors.ErrIndexClosed
-	}
-	// check if exists
-	doc, err := index.GetDocument(docID)
-	if err != nil {
-		return err
-	}
-	if !doc.Found {
-		return errors.ErrDocumentNotFound
-	}
-	// this assert must success
-	source := doc.Source.(map[string]interface{})
-	for k, v := range fields {
-		source[k] = v
-	}
-	return index.IndexOrUpdateDocument(docID, source)
+// UpdateDocumentPartially can update part fields of indexed document. The fields are merged into the document
+// deeply, i.e. the nested objects are merged recursively and the other values are replaced as a whole.
+// The result is "noop" and the document is not written if the fields don't change it.
+func (index *Index) UpdateDocumentPartially(docID string, fields map[string]interface{}, opts ...WriteOption) (*BulkActionResult, error) {
+	return index.executeWrite(newWriteOp(opUpdate, index.Name, docID, fields, opts...))
 }
 
 // GetDocument returns the doc associated with docID.
@@ -65,12 +40,9 @@ func (index *Index) GetDocument(docID string) (*Document, error) {
 		return nil, errors.ErrIndexClosed
 	}
 	doc := &Document{
-		Index:       index.Name,
-		ID:          docID,
-		Version:     1,
-		SeqNo:       1,
-		PrimaryTerm: 1,
-		Found:       false,
+		Index: index.Name,
+		ID:    docID,
+		Found: false,
 	}
 	shard := index.getDocShard(docID)
 	bdoc, err := shard.Indexer.Document(docID)
@@ -80,24 +52,16 @@ func (index *Index) GetDocument(docID string) (*Document, error) {
 	if bdoc == nil {
 		return doc, errors.ErrDocumentNotFound
 	}
-	source := make(map[string]interface{})
-	bdoc.VisitFields(func(field bindex.Field) {
-		if field.Name() == "_source" {
-			err = json.Unmarshal(field.Value(), &source)
-		}
-	})
-	doc.Source = source
+	doc.Version, doc.SeqNo = documentVersion(bdoc)
+	doc.PrimaryTerm = primaryTerm
+	doc.Source, err = documentSource(bdoc)
 	doc.Found = true
 	return doc, err
 }
 
-// DeleteDocument try to delete the document from index, do not check if it exists.
-func (index *Index) DeleteDocument(docID string) error {
-	if index.IsClosed() {
-		return errors.ErrIndexClosed
-	}
-	shard := index.getDocShard(docID)
-	return shard.Indexer.Delete(docID)
+// DeleteDocument deletes the document from index, errors.ErrDocumentNotFound is returned if it doesn't exist.
+func (index *Index) DeleteDocument(docID string, opts ...WriteOption) (*BulkActionResult, error) {
+	return index.executeWrite(newWriteOp(opDelete, index.Name, docID, nil, opts...))
 }
 
 func (index *Index) buildBleveDocument(docID string, source map[string]interface{}, mapping imapping.IndexMapping) (*document.Document, error) {
@@ -116,19 +80,19 @@ func (index *Index) buildBleveDocument(docID string, source map[string]interface
 	doc.AddField(dtf)
 	//cf := document.NewCompositeFieldWithIndexingOptions("_all", true, nil, []string{"_id", "_index", "_source", "@timestamp"}, bindex.IndexField)
 	//doc.AddField(cf)
-	if mapping != nil {
-		if err = mapping.MapDocument(doc, source); err != nil {
-			return nil, err
+	if mapping == nil {
+		if index.Mapping == nil {
+			mapping = bleve.NewIndexMapping()
+		} else {
+			mapping, err = buildIndexMapping(index.Mapping)
+			if err != nil {
+				return nil, err
+			}
 		}
-		return doc, nil
 	}
-	if index.Mapping == nil {
-		mapping = bleve.NewIndexMapping()
-	} else {
-		mapping, err = buildIndexMapping(index.Mapping)
-		if err != nil {
-			return nil, err
-		}
+	// the strict mapping rejects the unknown fields, and the dynamic templates are applied.
+	if mapping, err = index.Mapping.dynamicMapping(mapping, source); err != nil {
+		return nil, err
 	}
 	if err = mapping.MapDocument(doc, source); err != nil {
 		return nil, err
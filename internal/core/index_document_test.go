@@ -8,6 +8,7 @@ import (
 	"github.com/feimingxliu/quicksearch/pkg/util/json"
 	"log"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -84,7 +85,7 @@ func indexSomeDocs(t *testing.T, num int) {
 			if i == 0 {
 				firstDocID = doc["id"].(string)
 			}
-			err := index.IndexOrUpdateDocument(doc["id"].(string), doc)
+			_, err := index.IndexOrUpdateDocument(doc["id"].(string), doc)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -248,7 +249,7 @@ func TestDeleteDocument(t *testing.T) {
 	json.Print("before delete, document", doc)
 
 	// delete document
-	err = index.DeleteDocument(firstDocID)
+	_, err = index.DeleteDocument(firstDocID)
 	if err != nil {
 		t.Errorf("index.DeleteDocument: %s", err)
 	}
@@ -288,7 +289,7 @@ func TestUpdateDocument(t *testing.T) {
 	json.Print("before update, document", doc)
 
 	// delete document
-	err = index.UpdateDocumentPartially(firstDocID, map[string]interface{}{"text": "updated partially"})
+	_, err = index.UpdateDocumentPartially(firstDocID, map[string]interface{}{"text": "updated partially"})
 	if err != nil {
 		t.Errorf("index.UpdateDocumentPartially: %s", err)
 	}
@@ -304,3 +305,120 @@ func TestUpdateDocument(t *testing.T) {
 	}
 	json.Print("after update, document", doc)
 }
+
+func TestUpdateDocumentPartiallyMerge(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	source := map[string]interface{}{"title": "v1", "tags": []interface{}{"a", "b"}, "user": map[string]interface{}{"name": "a", "city": "Beijing"}}
+	if _, err := index.IndexOrUpdateDocument("1", source); err != nil {
+		t.Fatal(err)
+	}
+
+	// the nested objects are merged deeply, the other values are replaced as a whole.
+	res, err := index.UpdateDocumentPartially("1", map[string]interface{}{"tags": []interface{}{"c"}, "user": map[string]interface{}{"city": "Shanghai"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != "updated" || res.Version != 2 {
+		t.Errorf("unexpected result of update: %+v", res)
+	}
+	expected := map[string]interface{}{"title": "v1", "tags": []interface{}{"c"}, "user": map[string]interface{}{"name": "a", "city": "Shanghai"}}
+	doc, err := index.GetDocument("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc.Source, expected) {
+		t.Errorf("document: %v, expected %v", doc.Source, expected)
+	}
+
+	// the update changes nothing is a noop, the document is not written.
+	if res, err = index.UpdateDocumentPartially("1", map[string]interface{}{"user": map[string]interface{}{"city": "Shanghai"}}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != "noop" || res.Status != 200 || res.Version != 2 {
+		t.Errorf("unexpected result of noop update: %+v", res)
+	}
+	if doc, err = index.GetDocument("1"); err != nil || doc.Version != 2 {
+		t.Errorf("document version %d after noop update: %v", doc.Version, err)
+	}
+}
+
+func TestDocumentVersion(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	res, err := index.IndexOrUpdateDocument("1", map[string]interface{}{"text": "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != "created" || res.Version != 1 {
+		t.Errorf("unexpected result of create: %+v", res)
+	}
+	res, err = index.UpdateDocumentPartially("1", map[string]interface{}{"text": "v2"}, IfSeqNo(res.SeqNo, res.PrimaryTerm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != "updated" || res.Version != 2 {
+		t.Errorf("unexpected result of update: %+v", res)
+	}
+	doc, err := index.GetDocument("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("document", doc)
+	if doc.Version != 2 || doc.SeqNo != res.SeqNo {
+		t.Errorf("document version %d, seq_no %d, expected 2, %d", doc.Version, doc.SeqNo, res.SeqNo)
+	}
+
+	// the stale preconditions fail.
+	if _, err := index.IndexOrUpdateDocument("1", map[string]interface{}{"text": "v3"}, IfSeqNo(res.SeqNo-1, 1)); err != errors.ErrVersionConflict {
+		t.Errorf("index.IndexOrUpdateDocument: %v", err)
+	}
+	if _, err := index.DeleteDocument("1", IfVersion(1)); err != errors.ErrVersionConflict {
+		t.Errorf("index.DeleteDocument: %v", err)
+	}
+	if res, err = index.DeleteDocument("1", IfVersion(2)); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != "deleted" || res.Version != 3 {
+		t.Errorf("unexpected result of delete: %+v", res)
+	}
+	if res, err = index.DeleteDocument("1"); err != errors.ErrDocumentNotFound || res.Result != "not_found" {
+		t.Errorf("index.DeleteDocument: %v", err)
+	}
+
+	// the sequence numbers are persisted across reopen.
+	lastSeqNo := index.getDocShard("1").seqNo
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = index.IndexOrUpdateDocument("1", map[string]interface{}{"text": "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if res.SeqNo != lastSeqNo+1 {
+		t.Errorf("seq_no %d after reopen, expected %d", res.SeqNo, lastSeqNo+1)
+	}
+}
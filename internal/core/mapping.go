@@ -14,6 +14,10 @@ type IndexMapping struct {
 	TypeField       *string                     `json:"type_field" mapstructure:"type_field"`
 	DefaultType     *string                     `json:"default_type" mapstructure:"default_type"`
 	DefaultAnalyzer *string                     `json:"default_analyzer" mapstructure:"default_analyzer"` // standard
+	Analysis        *Analysis                   `json:"analysis,omitempty" mapstructure:"analysis"`
+	// Dynamic is true(default), false or "strict", see dynamicMode. It's inherited by the document mappings.
+	Dynamic          interface{}        `json:"dynamic,omitempty" mapstructure:"dynamic"`
+	DynamicTemplates []*DynamicTemplate `json:"dynamic_templates,omitempty" mapstructure:"dynamic_templates"`
 }
 
 type DocumentMapping struct {
@@ -21,6 +25,7 @@ type DocumentMapping struct {
 	Properties      map[string]*DocumentMapping `json:"properties,omitempty" mapstructure:"properties"`
 	Fields          []*FieldMapping             `json:"fields,omitempty" mapstructure:"fields"`
 	DefaultAnalyzer string                      `json:"default_analyzer,omitempty" mapstructure:"default_analyzer"`
+	Dynamic         interface{}                 `json:"dynamic,omitempty" mapstructure:"dynamic"` // inherited from the parent if omitted
 }
 
 type FieldMapping struct {
@@ -47,16 +52,20 @@ func buildIndexMapping(im *IndexMapping) (imapping.IndexMapping, error) {
 	if im == nil {
 		return indexMapping, nil
 	}
+	dynamic, err := dynamicMode(im.Dynamic, dynamicTrue)
+	if err != nil {
+		return nil, err
+	}
 	typeMapping := make(map[string]*imapping.DocumentMapping, len(im.TypeMapping))
 	for t, dm := range im.TypeMapping {
-		if idm, err := buildDocumentMapping(dm); err != nil {
+		if idm, err := buildDocumentMapping(dm, dynamic); err != nil {
 			return nil, err
 		} else {
 			typeMapping[t] = idm
 		}
 	}
 	indexMapping.TypeMapping = typeMapping
-	if ddm, err := buildDocumentMapping(im.DefaultMapping); err != nil {
+	if ddm, err := buildDocumentMapping(im.DefaultMapping, dynamic); err != nil {
 		return nil, err
 	} else {
 		indexMapping.DefaultMapping = ddm
@@ -67,22 +76,39 @@ func buildIndexMapping(im *IndexMapping) (imapping.IndexMapping, error) {
 	if im.DefaultType != nil {
 		indexMapping.DefaultType = *im.DefaultType
 	}
+	if err := im.Analysis.register(indexMapping); err != nil {
+		return nil, err
+	}
 	if im.DefaultAnalyzer != nil {
-		if err := setDefaultAnalyzerForMapping(indexMapping, *im.DefaultAnalyzer, nil); err != nil {
+		if err := setDefaultAnalyzerForMapping(indexMapping, *im.DefaultAnalyzer); err != nil {
 			return nil, err
 		}
 	}
+	if err := resolveAnalyzers(indexMapping); err != nil {
+		return nil, err
+	}
+	if err := validateDynamicTemplates(indexMapping, im.DynamicTemplates); err != nil {
+		return nil, err
+	}
 	return indexMapping, nil
 }
 
-func buildDocumentMapping(dm *DocumentMapping) (*imapping.DocumentMapping, error) {
+// buildDocumentMapping builds the document mapping with the dynamic mode inherited from the parent.
+func buildDocumentMapping(dm *DocumentMapping, dynamic string) (*imapping.DocumentMapping, error) {
 	documentMapping := bleve.NewDocumentMapping()
+	// the unknown fields are not indexed by bleve unless dynamic, the strict mode is checked before indexing.
+	documentMapping.Dynamic = dynamic == dynamicTrue
 	if dm == nil {
 		return documentMapping, nil
 	}
+	dynamic, err := dynamicMode(dm.Dynamic, dynamic)
+	if err != nil {
+		return nil, err
+	}
+	documentMapping.Dynamic = dynamic == dynamicTrue
 	properties := make(map[string]*imapping.DocumentMapping, len(dm.Properties))
 	for name, vdm := range dm.Properties {
-		if rdm, err := buildDocumentMapping(vdm); err != nil {
+		if rdm, err := buildDocumentMapping(vdm, dynamic); err != nil {
 			return nil, err
 		} else {
 			properties[name] = rdm
@@ -136,85 +162,70 @@ func buildFieldMapping(fm *FieldMapping) (*imapping.FieldMapping, error) {
 	return fieldMapping, nil
 }
 
-func setDefaultAnalyzerForMapping(mapping *imapping.IndexMappingImpl, analyzer string, config map[string]interface{}) error {
-	if mapping == nil {
-		mapping = bleve.NewIndexMapping()
-	}
+func setDefaultAnalyzerForMapping(mapping *imapping.IndexMappingImpl, analyzer string) error {
 	switch strings.ToLower(analyzer) {
-	case "default", "standard":
+	case "", "default", "standard":
 		mapping.DefaultAnalyzer = "standard"
 		return nil
-	//case "jieba":
-	//	err = mapping.AddCustomTokenizer("gojieba",
-	//		map[string]interface{}{
-	//			"dict_path":      "",
-	//			"hmm_path":       "",
-	//			"user_dict_path": "",
-	//			"idf":            "",
-	//			"stop_words":     "",
-	//			"type":           "gojieba",
-	//		},
-	//	)
-	//	if err != nil {
-	//		return err
-	//	}
-	//	err = mapping.AddCustomAnalyzer("gojieba",
-	//		map[string]interface{}{
-	//			"type":      "gojieba",
-	//			"tokenizer": "gojieba",
-	//		},
-	//	)
-	//	if err != nil {
-	//		return err
-	//	}
-	//	mapping.DefaultAnalyzer = "gojieba"
-	//	return nil
-	//case "gse":
-	//	err = mapping.AddCustomTokenizer("gse",
-	//		map[string]interface{}{
-	//			"type":       "gse",
-	//			"dict_path":  "",
-	//			"stop_words": "",
-	//			"alpha":      false,
-	//		},
-	//	)
-	//	if err != nil {
-	//		return err
-	//	}
-	//	err = mapping.AddCustomAnalyzer("gse",
-	//		map[string]interface{}{
-	//			"type":      "gse",
-	//			"tokenizer": "gse",
-	//		},
-	//	)
-	//	if err != nil {
-	//		return err
-	//	}
-	//	mapping.DefaultAnalyzer = "gse"
-	//	return nil
-	//case "sego":
-	//	err = mapping.AddCustomTokenizer("sego",
-	//		map[string]interface{}{
-	//			"type":      "sego",
-	//			"dict_path": "",
-	//		},
-	//	)
-	//	if err != nil {
-	//		return err
-	//	}
-	//	err = mapping.AddCustomAnalyzer("sego",
-	//		map[string]interface{}{
-	//			"type":      "sego",
-	//			"tokenizer": "sego",
-	//		},
-	//	)
-	//	if err != nil {
-	//		return err
-	//	}
-	//	mapping.DefaultAnalyzer = "sego"
-	//	return nil
-	default:
-		mapping.DefaultAnalyzer = "standard"
+	}
+	name, err := resolveAnalyzer(mapping, analyzer)
+	if err != nil {
+		return err
+	}
+	mapping.DefaultAnalyzer = name
+	return nil
+}
+
+// resolveAnalyzers resolves the analyzers used by the document mappings.
+func resolveAnalyzers(mapping *imapping.IndexMappingImpl) error {
+	var resolve func(dm *imapping.DocumentMapping) error
+	resolve = func(dm *imapping.DocumentMapping) error {
+		if dm == nil {
+			return nil
+		}
+		var err error
+		if len(dm.DefaultAnalyzer) > 0 {
+			if dm.DefaultAnalyzer, err = resolveAnalyzer(mapping, dm.DefaultAnalyzer); err != nil {
+				return err
+			}
+		}
+		for _, fm := range dm.Fields {
+			if len(fm.Analyzer) > 0 {
+				if fm.Analyzer, err = resolveAnalyzer(mapping, fm.Analyzer); err != nil {
+					return err
+				}
+			}
+		}
+		for _, property := range dm.Properties {
+			if err := resolve(property); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
+	for _, dm := range mapping.TypeMapping {
+		if err := resolve(dm); err != nil {
+			return err
+		}
+	}
+	return resolve(mapping.DefaultMapping)
+}
+
+// resolveAnalyzer returns the name of analyzer defined in analysis or registered in bleve, the chinese
+// analyzers(gse, jieba, gojieba, sego) are registered with default options if not defined.
+func resolveAnalyzer(mapping *imapping.IndexMappingImpl, analyzer string) (string, error) {
+	if _, ok := mapping.CustomAnalysis.Analyzers[analyzer]; ok {
+		return analyzer, nil
+	}
+	name := builtinName(analyzerNames, analyzer)
+	if _, ok := chineseAnalyzers[name]; ok {
+		if err := registerChineseAnalyzer(mapping, name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	if mapping.AnalyzerNamed(name) == nil {
+		return "", fmt.Errorf("unknown analyzer [%s]", analyzer)
+	}
+	return name, nil
 }
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"fmt"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// the key of mapping stored in the bleve index, which is loaded when the shard opened. It's private to bleve,
+// see TestMappingInternalKey.
+var mappingInternalKey = []byte("_mapping")
+
+// UpdateMapping replaces the mapping of index. The additive changes, e.g. new fields, properties and analysis
+// components, are applied to the shards directly. The other changes don't match the indexed documents, so they
+// are rejected with errors.ErrIncompatibleMapping unless reindex, in which case the mapping is applied and a
+// background task reindexing the documents in place is returned.
+func (index *Index) UpdateMapping(mapping *IndexMapping, reindex bool) (*Task, error) {
+	if index.IsClosed() {
+		return nil, errors.ErrIndexClosed
+	}
+	bm, err := buildIndexMapping(mapping)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errors.ErrInvalidMapping, err)
+	}
+	changes := mappingChanges(index.Mapping, mapping)
+	if len(changes) > 0 && !reindex {
+		return nil, fmt.Errorf("%w: %s", errors.ErrIncompatibleMapping, strings.Join(changes, "; "))
+	}
+	data, err := json.Marshal(bm)
+	if err != nil {
+		return nil, err
+	}
+	index.mu.Lock()
+	err = index.setMapping(mapping, data)
+	index.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := index.UpdateMetadata(); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return reindexInPlace(fmt.Sprintf("reindex [%s] for the mapping update", index.Name), index), nil
+}
+
+// setMapping applies the mapping to the shards, data is the built mapping in json. The caller must hold index.mu.
+func (index *Index) setMapping(mapping *IndexMapping, data []byte) error {
+	for _, shard := range index.Shards {
+		if err := index.setShardMapping(shard, data); err != nil {
+			// the shard can't be used without the indexer, the index is closed so it can be opened again.
+			if shard.Indexer == nil {
+				if cerr := index.close(); cerr != nil {
+					err = fmt.Errorf("%w, and the index is not closed: %v", err, cerr)
+				}
+			}
+			return err
+		}
+	}
+	index.Mapping = mapping
+	return nil
+}
+
+// setShardMapping stores the mapping in the shard and reopens it, so the mapping is loaded by bleve. The caller
+// must hold index.mu, so the shard is not searched while reopened, and the writes of shard are blocked.
+// The indexer of shard is nil if it's closed but not reopened.
+func (index *Index) setShardMapping(shard *IndexShard, data []byte) error {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.Indexer == nil {
+		return errors.ErrIndexClosed
+	}
+	if err := shard.Indexer.SetInternal(mappingInternalKey, data); err != nil {
+		return err
+	}
+	// the shard dir is locked by the indexer, so it's closed before reopened.
+	if err := shard.Indexer.Close(); err != nil {
+		shard.Indexer = nil
+		return err
+	}
+	indexer, err := index.openIndexer(shard.ID)
+	if err != nil {
+		shard.Indexer = nil
+		return err
+	}
+	indexer.SetName(index.Name)
+	shard.Indexer = indexer
+	return nil
+}
+
+// mappingChanges returns the changes from from to to mapping which are incompatible with the indexed documents,
+// i.e. the changes other than adding fields, properties, types and analysis components.
+func mappingChanges(from, to *IndexMapping) []string {
+	if from == nil {
+		from = new(IndexMapping)
+	}
+	if to == nil {
+		to = new(IndexMapping)
+	}
+	changes := make([]string, 0)
+	for _, option := range []struct {
+		name     string
+		from, to *string
+	}{
+		{"default_analyzer", from.DefaultAnalyzer, to.DefaultAnalyzer},
+		{"type_field", from.TypeField, to.TypeField},
+		{"default_type", from.DefaultType, to.DefaultType},
+	} {
+		if o, n := stringValue(option.from), stringValue(option.to); o != n {
+			changes = append(changes, fmt.Sprintf("[%s] changed from [%s] to [%s]", option.name, o, n))
+		}
+	}
+	if from.Analysis != nil {
+		toAnalysis := to.Analysis
+		if toAnalysis == nil {
+			toAnalysis = new(Analysis)
+		}
+		for _, kind := range []struct {
+			name     string
+			from, to map[string]map[string]interface{}
+		}{
+			{"char_filters", from.Analysis.CharFilters, toAnalysis.CharFilters},
+			{"tokenizers", from.Analysis.Tokenizers, toAnalysis.Tokenizers},
+			{"token_filters", from.Analysis.TokenFilters, toAnalysis.TokenFilters},
+			{"analyzers", from.Analysis.Analyzers, toAnalysis.Analyzers},
+		} {
+			for _, name := range sortedNames(kind.from) {
+				if component, ok := kind.to[name]; !ok {
+					changes = append(changes, fmt.Sprintf("[analysis.%s.%s] removed", kind.name, name))
+				} else if !reflect.DeepEqual(jsonValue(component), jsonValue(kind.from[name])) {
+					changes = append(changes, fmt.Sprintf("[analysis.%s.%s] changed", kind.name, name))
+				}
+			}
+		}
+	}
+	changes = append(changes, documentMappingChanges("default_mapping", from.DefaultMapping, to.DefaultMapping)...)
+	types := make([]string, 0, len(from.TypeMapping))
+	for typ := range from.TypeMapping {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		if _, ok := to.TypeMapping[typ]; !ok {
+			changes = append(changes, fmt.Sprintf("[types.%s] removed", typ))
+			continue
+		}
+		changes = append(changes, documentMappingChanges("types."+typ, from.TypeMapping[typ], to.TypeMapping[typ])...)
+	}
+	return changes
+}
+
+func documentMappingChanges(path string, from, to *DocumentMapping) []string {
+	changes := make([]string, 0)
+	if from == nil {
+		return changes
+	}
+	if to == nil {
+		return append(changes, fmt.Sprintf("[%s] removed", path))
+	}
+	if from.Disabled != to.Disabled {
+		changes = append(changes, fmt.Sprintf("[%s.disabled] changed from [%t] to [%t]", path, from.Disabled, to.Disabled))
+	}
+	if from.DefaultAnalyzer != to.DefaultAnalyzer {
+		changes = append(changes, fmt.Sprintf("[%s.default_analyzer] changed from [%s] to [%s]", path, from.DefaultAnalyzer, to.DefaultAnalyzer))
+	}
+	if len(from.Fields) > 0 {
+		if len(from.Fields) != len(to.Fields) {
+			changes = append(changes, fmt.Sprintf("[%s.fields] changed from %d fields to %d fields", path, len(from.Fields), len(to.Fields)))
+		} else {
+			for i := range from.Fields {
+				changes = append(changes, fieldMappingChanges(fmt.Sprintf("%s.fields[%d]", path, i), from.Fields[i], to.Fields[i])...)
+			}
+		}
+	}
+	names := make([]string, 0, len(from.Properties))
+	for name := range from.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := to.Properties[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("[%s.properties.%s] removed", path, name))
+			continue
+		}
+		changes = append(changes, documentMappingChanges(path+".properties."+name, from.Properties[name], property)...)
+	}
+	return changes
+}
+
+func fieldMappingChanges(path string, from, to *FieldMapping) []string {
+	changes := make([]string, 0)
+	if o, n := strings.ToLower(from.Type), strings.ToLower(to.Type); o != n {
+		changes = append(changes, fmt.Sprintf("[%s.type] changed from [%s] to [%s]", path, o, n))
+	}
+	if o, n := stringValue(from.Analyzer), stringValue(to.Analyzer); o != n {
+		changes = append(changes, fmt.Sprintf("[%s.analyzer] changed from [%s] to [%s]", path, o, n))
+	}
+	for _, option := range []struct {
+		name     string
+		from, to *bool
+	}{{"store", from.Store, to.Store}, {"index", from.Index, to.Index}} {
+		if !reflect.DeepEqual(option.from, option.to) {
+			changes = append(changes, fmt.Sprintf("[%s.%s] changed", path, option.name))
+		}
+	}
+	return changes
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// jsonValue converts the value decoded from json or mapstructure to the json types, so they can be compared.
+func jsonValue(v interface{}) interface{} {
+	data, _ := json.Marshal(v)
+	var value interface{}
+	_ = json.Unmarshal(data, &value)
+	return value
+}
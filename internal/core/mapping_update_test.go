@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUpdateMapping(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	parse := func(body string) *IndexMapping {
+		mapping := new(IndexMapping)
+		if err := json.Unmarshal([]byte(body), mapping); err != nil {
+			t.Fatal(err)
+		}
+		return mapping
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(parse(`{"default_mapping": {"properties": {"title": {"fields": [{"type": "text"}]}}}}`)), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := index.IndexOrUpdateDocument("1", map[string]interface{}{"title": "Quick Search", "tag": "Search Engine"}); err != nil {
+		t.Fatal(err)
+	}
+	count := func(field, term string) int {
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(`{"query": {"type": "term", "term": "`+term+`", "field": "`+field+`"}}`), req); err != nil {
+			t.Fatal(err)
+		}
+		res, err := index.Search(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.Hits)
+	}
+
+	// the additive change is applied to the shards directly.
+	task, err := index.UpdateMapping(parse(`{"default_mapping": {"properties": {"title": {"fields": [{"type": "text"}]}, "tag": {"fields": [{"type": "keyword"}]}}}}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task != nil {
+		t.Error("the additive change should not reindex")
+	}
+	if _, err := index.IndexOrUpdateDocument("2", map[string]interface{}{"title": "Quick Fox", "tag": "Search Engine"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("tag", "Search Engine"); n != 1 {
+		t.Errorf("got %d hits of the new keyword field", n)
+	}
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("tag", "Search Engine"); n != 1 {
+		t.Errorf("got %d hits of the new keyword field after reopened", n)
+	}
+
+	// the searches during the update see the shards either before or after reopened.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(`{"query": {"match_all": {}}}`), req); err != nil {
+			errs <- err
+			return
+		}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if res, err := index.Search(req); err != nil || res.TotalHits != 2 {
+				errs <- fmt.Errorf("search during the update: %v, %+v", err, res)
+				return
+			}
+		}
+	}()
+	properties := `"title": {"fields": [{"type": "text"}]}, "tag": {"fields": [{"type": "keyword"}]}`
+	for i := 0; i < 20; i++ {
+		properties += fmt.Sprintf(`, "f%d": {"fields": [{"type": "keyword"}]}`, i)
+		if _, err := index.UpdateMapping(parse(`{"default_mapping": {"properties": {`+properties+`}}}`), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// the incompatible change is rejected without reindex.
+	keyword := parse(`{"default_mapping": {"properties": {"title": {"fields": [{"type": "keyword"}]}, "tag": {"fields": [{"type": "keyword"}]}}}}`)
+	_, err = index.UpdateMapping(keyword, false)
+	if !errors.Is(err, qerrors.ErrIncompatibleMapping) || !strings.Contains(err.Error(), "[default_mapping.properties.title.fields[0].type] changed from [text] to [keyword]") {
+		t.Fatalf("update the field type: %v", err)
+	}
+	if n := count("title", "quick"); n != 2 {
+		t.Errorf("got %d hits after the rejected change", n)
+	}
+	task, err = index.UpdateMapping(keyword, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil {
+		t.Fatal("the incompatible change should reindex")
+	}
+	task.Wait()
+	if len(task.Error) > 0 {
+		t.Fatal(task.Error)
+	}
+	if n := count("title", "Quick Search"); n != 1 {
+		t.Errorf("got %d hits of the keyword title after reindex", n)
+	}
+	if n := count("title", "quick"); n != 0 {
+		t.Errorf("got %d hits of the analyzed title after reindex", n)
+	}
+}
+
+// TestMappingInternalKey pins the private key which bleve loads the mapping from when opened.
+func TestMappingInternalKey(t *testing.T) {
+	dir := path.Join(t.TempDir(), "index")
+	indexer, err := bleve.New(dir, bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := indexer.GetInternal(mappingInternalKey); err != nil || len(data) == 0 {
+		t.Fatalf("the mapping is not stored with the key [%s]: %v", mappingInternalKey, err)
+	}
+	mapping := bleve.NewIndexMapping()
+	mapping.DefaultAnalyzer = "keyword"
+	data, err := json.Marshal(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := indexer.SetInternal(mappingInternalKey, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if indexer, err = bleve.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close()
+	if m, ok := indexer.Mapping().(*imapping.IndexMappingImpl); !ok || m.DefaultAnalyzer != "keyword" {
+		t.Errorf("the mapping stored with the key [%s] is not loaded", mappingInternalKey)
+	}
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/internal/pkg/storager"
+	"os"
+	"path"
+)
+
+type MigrateMetaResult struct {
+	Pairs  map[string]int `json:"pairs"`  // metadata storage name => the number of pairs migrated
+	Backup string         `json:"backup"` // the dir keeping the old metadata
+}
+
+// MigrateMeta migrates the metadata under dataDir from the storage type from to the storage type to, the engine
+// must not be running. The metadata is copied to a temp dir and verified by the counts and checksums, then the
+// metadata dir is swapped with it, the old metadata is kept in the backup dir. Nothing is changed if failed.
+func MigrateMeta(dataDir, from, to string) (*MigrateMetaResult, error) {
+	fromType, err := storager.ParseStorageType(from)
+	if err != nil {
+		return nil, err
+	}
+	toType, err := storager.ParseStorageType(to)
+	if err != nil {
+		return nil, err
+	}
+	if fromType == toType {
+		return nil, fmt.Errorf("the metadata is already stored in [%s]", from)
+	}
+	dir := path.Join(dataDir, "metadata")
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	result := &MigrateMetaResult{Pairs: make(map[string]int), Backup: dir + "." + from + ".bak"}
+	if _, err := os.Stat(result.Backup); err == nil {
+		return nil, fmt.Errorf("the backup dir [%s] already exists", result.Backup)
+	}
+	tmp := dir + ".migrating"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	for _, name := range metaNames {
+		src := path.Join(dir, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		n, err := migrateStorager(fromType, src, toType, path.Join(tmp, name))
+		if err != nil {
+			_ = os.RemoveAll(tmp)
+			return nil, fmt.Errorf("migrate [%s]: %w", name, err)
+		}
+		result.Pairs[name] = n
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, err
+	}
+	// each rename is atomic, the old metadata is restored if the second one fails.
+	if err := os.Rename(dir, result.Backup); err != nil {
+		_ = os.RemoveAll(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if rerr := os.Rename(result.Backup, dir); rerr != nil {
+			return nil, fmt.Errorf("%s, and the old metadata is left in [%s]: %s", err, result.Backup, rerr)
+		}
+		_ = os.RemoveAll(tmp)
+		return nil, err
+	}
+	return result, nil
+}
+
+// migrateStorager copies the pairs from the storage at src to a new storage at dst, and verifies them
+// after the new storage reopened.
+func migrateStorager(fromType storager.StorageType, src string, toType storager.StorageType, dst string) (int, error) {
+	from, err := storager.NewStorager(fromType, src)
+	if err != nil {
+		return 0, err
+	}
+	defer from.Close()
+	to, err := storager.NewStorager(toType, dst)
+	if err != nil {
+		return 0, err
+	}
+	n, err := storager.Copy(from, to, config.Global.Engine.DefaultBatchSize)
+	if err != nil {
+		_ = to.Close()
+		return 0, err
+	}
+	if err := to.Close(); err != nil {
+		return 0, err
+	}
+	if to, err = storager.NewStorager(toType, dst); err != nil {
+		return 0, err
+	}
+	defer to.Close()
+	expectedCount, expectedSum, err := storager.Checksum(from)
+	if err != nil {
+		return 0, err
+	}
+	count, sum, err := storager.Checksum(to)
+	if err != nil {
+		return 0, err
+	}
+	if count != expectedCount || !bytes.Equal(sum, expectedSum) {
+		return 0, fmt.Errorf("verify failed, %d pairs with checksum %x, expected %d pairs with checksum %x", count, sum, expectedCount, expectedSum)
+	}
+	return n, nil
+}
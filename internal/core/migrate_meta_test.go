@@ -0,0 +1,70 @@
+package core
+
+import (
+	"fmt"
+	"github.com/feimingxliu/quicksearch/internal/pkg/storager"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateMeta(t *testing.T) {
+	dataDir := t.TempDir()
+	dir := path.Join(dataDir, "metadata")
+	meta, err := storager.NewStorager(storager.Bolt, path.Join(dir, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, 2500)
+	values := make([][]byte, 0, 2500)
+	for i := 0; i < 2500; i++ {
+		keys = append(keys, fmt.Sprintf("index-%04d", i))
+		values = append(values, []byte(fmt.Sprintf(`{"name": "index-%04d"}`, i)))
+	}
+	if err := meta.Batch(keys, values); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateMeta(dataDir, "bolt", "bolt"); err == nil {
+		t.Error("migrate to the same storage type")
+	}
+	if _, err := MigrateMeta(dataDir, "bolt", "unknown"); err == nil {
+		t.Error("migrate to the unknown storage type")
+	}
+	res, err := MigrateMeta(dataDir, "bolt", "leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Pairs, map[string]int{"meta": 2500}) || res.Backup != dir+".bolt.bak" {
+		t.Errorf("migrate result: %+v", res)
+	}
+	if _, err := os.Stat(path.Join(res.Backup, "meta")); err != nil {
+		t.Errorf("the old metadata is not kept: %s", err)
+	}
+	if _, err := os.Stat(dir + ".migrating"); !os.IsNotExist(err) {
+		t.Errorf("the temp dir is left: %v", err)
+	}
+	// migrate again to check the migrated storage.
+	if res, err = MigrateMeta(dataDir, "leveldb", "badger"); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := storager.NewStorager(storager.Badger, path.Join(dir, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+	list, err := migrated.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, values) {
+		t.Errorf("got %d values after migrated", len(list))
+	}
+	if _, err := MigrateMeta(dataDir, "badger", "bolt"); err == nil {
+		t.Error("migrate while the storage is opened")
+	}
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+)
+
+type MultiGetRequest struct {
+	Docs   []*MultiGetDoc `json:"docs"`
+	IDs    []string       `json:"ids"`     // the ids of documents in the target index
+	Source *SourceFilter  `json:"_source"` // the default source filter of the docs
+}
+
+type MultiGetDoc struct {
+	Index  string        `json:"_index"` // optional, the target index is used if omitted
+	ID     string        `json:"_id"`
+	Source *SourceFilter `json:"_source"`
+}
+
+type MultiGetResult struct {
+	Docs []*Document `json:"docs"`
+}
+
+// MultiGet fetches the documents in one request, the lookups in the same shard share one index reader.
+// The Docs of result are in the same order as requested, each one has the `found` flag or the error.
+func MultiGet(targetIndex string, req *MultiGetRequest) (*MultiGetResult, error) {
+	docs := make([]*MultiGetDoc, 0, len(req.Docs)+len(req.IDs))
+	docs = append(docs, req.Docs...)
+	for _, id := range req.IDs {
+		docs = append(docs, &MultiGetDoc{ID: id})
+	}
+	result := &MultiGetResult{Docs: make([]*Document, len(docs))}
+	// the positions of docs grouped by shard.
+	shards := make(map[*IndexShard][]int)
+	for i, d := range docs {
+		indexName := d.Index
+		if len(indexName) == 0 {
+			indexName = targetIndex
+		}
+		doc := &Document{
+			Index: indexName,
+			ID:    d.ID,
+		}
+		result.Docs[i] = doc
+		if len(indexName) == 0 {
+			doc.Error = errors.ErrIndexNotFound.Error()
+			continue
+		}
+		if len(d.ID) == 0 {
+			doc.Error = errors.ErrDocumentNotFound.Error()
+			continue
+		}
+		// the documents are read from the write index if the index is an alias.
+		indexName, err := ResolveWriteIndex(indexName)
+		if err != nil {
+			doc.Error = err.Error()
+			continue
+		}
+		index, err := GetIndex(indexName)
+		if err != nil {
+			doc.Error = err.Error()
+			continue
+		}
+		if index.IsClosed() {
+			doc.Error = errors.ErrIndexClosed.Error()
+			continue
+		}
+		doc.Index = index.Name
+		shard := index.getDocShard(d.ID)
+		shards[shard] = append(shards[shard], i)
+	}
+	for shard, positions := range shards {
+		if err := getShardDocuments(shard, positions, docs, result.Docs, req.Source); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// getShardDocuments fills the documents at positions with the same index reader of shard.
+func getShardDocuments(shard *IndexShard, positions []int, docs []*MultiGetDoc, results []*Document, defaultFilter *SourceFilter) error {
+	idx, err := shard.Indexer.Advanced()
+	if err != nil {
+		return err
+	}
+	reader, err := idx.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, i := range positions {
+		doc := results[i]
+		bdoc, err := reader.Document(doc.ID)
+		if err != nil {
+			doc.Error = err.Error()
+			continue
+		}
+		if bdoc == nil {
+			continue
+		}
+		source, err := documentSource(bdoc)
+		if err != nil {
+			doc.Error = err.Error()
+			continue
+		}
+		filter := docs[i].Source
+		if filter == nil {
+			filter = defaultFilter
+		}
+		if source = filter.Apply(source); source != nil {
+			doc.Source = source
+		}
+		doc.Version, doc.SeqNo = documentVersion(bdoc)
+		doc.PrimaryTerm = primaryTerm
+		doc.Found = true
+	}
+	return nil
+}
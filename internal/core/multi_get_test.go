@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestMultiGet(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for _, id := range []string{"1", "2", "3"} {
+		source := map[string]interface{}{"id": id, "user": map[string]interface{}{"name": "user" + id, "age": 18}}
+		if _, err := index.IndexOrUpdateDocument(id, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := index.UpdateDocumentPartially("3", map[string]interface{}{"id": "three"}); err != nil {
+		t.Fatal(err)
+	}
+	req := &MultiGetRequest{
+		Docs: []*MultiGetDoc{
+			{Index: "not-exists", ID: "1"},
+			{ID: "2", Source: &SourceFilter{Disabled: true}},
+		},
+		IDs:    []string{"3", "4"},
+		Source: &SourceFilter{Includes: []string{"user.*"}, Excludes: []string{"user.age"}},
+	}
+	res, err := MultiGet(indexName, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("multi get", res)
+	if len(res.Docs) != 4 {
+		t.Fatalf("got %d docs, expected 4", len(res.Docs))
+	}
+	if doc := res.Docs[0]; doc.Found || doc.Error == "" {
+		t.Errorf("doc in not exists index: %+v", doc)
+	}
+	if doc := res.Docs[1]; !doc.Found || doc.Source != nil {
+		t.Errorf("doc with source disabled: %+v", doc)
+	}
+	expected := map[string]interface{}{"user": map[string]interface{}{"name": "user3"}}
+	if doc := res.Docs[2]; !doc.Found || !reflect.DeepEqual(doc.Source, expected) || doc.Version != 2 || doc.SeqNo == 0 {
+		t.Errorf("doc with source filtered: %+v", doc)
+	}
+	if doc := res.Docs[3]; doc.Found || doc.Error != "" || doc.Version != 0 || doc.SeqNo != 0 {
+		t.Errorf("not exists doc: %+v", doc)
+	}
+	// the scanned documents have the versions too.
+	versions := make(map[string]int64)
+	err = index.scanDocuments(context.Background(), nil, 2, func(docs []*Document) error {
+		for _, doc := range docs {
+			versions[doc.ID] = doc.Version
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, map[string]int64{"1": 1, "2": 1, "3": 2}) {
+		t.Errorf("got versions of the scanned docs: %v", versions)
+	}
+}
+
+func TestSourceFilterUnmarshal(t *testing.T) {
+	cases := map[string]SourceFilter{
+		`false`:                                {Disabled: true},
+		`"a, b"`:                               {Includes: []string{"a", "b"}},
+		`["a", "b"]`:                           {Includes: []string{"a", "b"}},
+		`{"includes": "a", "excludes": ["b"]}`: {Includes: []string{"a"}, Excludes: []string{"b"}},
+	}
+	for input, expected := range cases {
+		filter := SourceFilter{}
+		if err := json.Unmarshal([]byte(input), &filter); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(filter, expected) {
+			t.Errorf("unmarshal %s: %+v", input, filter)
+		}
+	}
+}
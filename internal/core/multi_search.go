@@ -0,0 +1,246 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	stdjson "encoding/json"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MultiSearchResult struct {
+	Took      time.Duration          `json:"took"`
+	Responses []*MultiSearchResponse `json:"responses"`
+}
+
+// MultiSearchResponse is either the SearchResult or the error of the search.
+type MultiSearchResponse struct {
+	*SearchResult
+	Status int    `json:"status"` // 200 => succeeded	400 => bad request	404 => index not found
+	Error  string `json:"error,omitempty"`
+}
+
+// MultiSearchHeader specifies the indices to search, the Index can be a string,
+// a comma separated string or an array of string. Each of them can be an alias.
+type MultiSearchHeader struct {
+	Index stdjson.RawMessage `json:"index"`
+}
+
+type multiSearchItem struct {
+	indices []*Index
+	body    []byte
+	err     error
+}
+
+// MultiSearch reads the searches from reader and executes them concurrently, at most
+// maxConcurrent(runtime.NumCPU() if <= 0) searches are executed at the same time.
+// The reader contains a header line followed by a body line for each search, the header
+// looks like {"index": "$index"}, and the body is the SearchRequest. If the index in header
+// is omitted, the targetIndex will be used, and all indices are searched if both are empty.
+// The responses are in the same order as the searches, a failed search don't fail the others.
+func MultiSearch(targetIndex string, reader io.Reader, maxConcurrent int) (*MultiSearchResult, error) {
+	startTime := time.Now()
+	items, err := readMultiSearch(targetIndex, reader)
+	if err != nil {
+		return nil, err
+	}
+	result := &MultiSearchResult{Responses: make([]*MultiSearchResponse, len(items))}
+	runMultiSearch(items, maxConcurrent, func(i int, item *multiSearchItem) {
+		if item.err != nil {
+			result.Responses[i] = newMultiSearchError(item.err)
+			return
+		}
+		request := new(SearchRequest)
+		if err := json.Unmarshal(item.body, request); err != nil {
+			result.Responses[i] = newMultiSearchError(err)
+			return
+		}
+		var res *SearchResult
+		if len(item.indices) == 0 {
+			res, err = Search(request)
+		} else {
+			res, err = SearchIndices(request, item.indices...)
+		}
+		if err != nil {
+			result.Responses[i] = newMultiSearchError(err)
+			return
+		}
+		result.Responses[i] = &MultiSearchResponse{SearchResult: res, Status: 200}
+	})
+	result.Took = time.Since(startTime)
+	return result, nil
+}
+
+// ESMultiSearchResult is the result of multi search in the shape of Elasticsearch.
+type ESMultiSearchResult struct {
+	Took      int64                    `json:"took"` // in milliseconds
+	Responses []*ESMultiSearchResponse `json:"responses"`
+}
+
+// ESMultiSearchResponse is either the ESSearchResponse or the error of the search.
+type ESMultiSearchResponse struct {
+	*ESSearchResponse
+	Status int      `json:"status"`
+	Error  *ESError `json:"error,omitempty"`
+}
+
+// ESError is the error in the shape of Elasticsearch.
+type ESError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// ESMultiSearch is the MultiSearch whose bodies are the ESSearchRequest, the responses are in the shape of
+// Elasticsearch.
+func ESMultiSearch(targetIndex string, reader io.Reader, maxConcurrent int) (*ESMultiSearchResult, error) {
+	startTime := time.Now()
+	items, err := readMultiSearch(targetIndex, reader)
+	if err != nil {
+		return nil, err
+	}
+	result := &ESMultiSearchResult{Responses: make([]*ESMultiSearchResponse, len(items))}
+	runMultiSearch(items, maxConcurrent, func(i int, item *multiSearchItem) {
+		if item.err != nil {
+			result.Responses[i] = newESMultiSearchError(item.err)
+			return
+		}
+		request := new(ESSearchRequest)
+		if err := request.UnmarshalJSON(item.body); err != nil {
+			result.Responses[i] = newESMultiSearchError(err)
+			return
+		}
+		res, err := ESSearchIndices(request, item.indices...)
+		if err != nil {
+			result.Responses[i] = newESMultiSearchError(err)
+			return
+		}
+		result.Responses[i] = &ESMultiSearchResponse{ESSearchResponse: res, Status: 200}
+	})
+	result.Took = time.Since(startTime).Milliseconds()
+	return result, nil
+}
+
+// readMultiSearch reads the header and body pairs of the searches, the indices in header are resolved.
+// The blank lines are skipped, and the failure of single search is recorded in its item.
+func readMultiSearch(targetIndex string, reader io.Reader) ([]*multiSearchItem, error) {
+	items := make([]*multiSearchItem, 0)
+	// the lines are read without the length limit of bufio.Scanner, since the body may be large.
+	br := bufio.NewReader(reader)
+	var header []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if header == nil {
+				header = line
+			} else {
+				items = append(items, newMultiSearchItem(targetIndex, header, line))
+				header = nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	// the body of the last search is missing.
+	if header != nil {
+		return nil, errors.ErrMultiSearchDataFormat
+	}
+	return items, nil
+}
+
+func newMultiSearchItem(targetIndex string, header, body []byte) *multiSearchItem {
+	item := &multiSearchItem{body: body}
+	h := new(MultiSearchHeader)
+	if item.err = json.Unmarshal(header, h); item.err == nil {
+		item.indices, item.err = h.resolveIndices(targetIndex)
+	}
+	return item
+}
+
+// runMultiSearch calls search for the items concurrently, at most maxConcurrent(runtime.NumCPU() if <= 0)
+// searches are executed at the same time. The items failed to read are passed to search too.
+func runMultiSearch(items []*multiSearchItem, maxConcurrent int, search func(i int, item *multiSearchItem)) {
+	if maxConcurrent <= 0 {
+		maxConcurrent = runtime.NumCPU()
+	}
+	limiter := make(chan struct{}, maxConcurrent)
+	wg := sync.WaitGroup{}
+	for i, item := range items {
+		if item.err != nil {
+			search(i, item)
+			continue
+		}
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(i int, item *multiSearchItem) {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			search(i, item)
+		}(i, item)
+	}
+	wg.Wait()
+}
+
+// resolveIndices returns the indices specified in header, nil means all indices.
+func (h *MultiSearchHeader) resolveIndices(targetIndex string) ([]*Index, error) {
+	var names []string
+	if len(h.Index) > 0 && string(h.Index) != "null" {
+		var name string
+		if err := json.Unmarshal(h.Index, &name); err == nil {
+			names = strings.Split(name, ",")
+		} else if err = json.Unmarshal(h.Index, &names); err != nil {
+			return nil, err
+		}
+	}
+	if len(strings.Join(names, "")) == 0 && len(targetIndex) > 0 {
+		names = strings.Split(targetIndex, ",")
+	}
+	indices := make([]*Index, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name = strings.TrimSpace(name); len(name) == 0 {
+			continue
+		}
+		resolved, err := ResolveIndices(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range resolved {
+			if !seen[index.Name] {
+				seen[index.Name] = true
+				indices = append(indices, index)
+			}
+		}
+	}
+	if len(strings.Join(names, "")) > 0 && len(indices) == 0 {
+		return nil, errors.ErrIndexNotFound
+	}
+	return indices, nil
+}
+
+func newMultiSearchError(err error) *MultiSearchResponse {
+	var status = 400
+	if err == errors.ErrIndexNotFound {
+		status = 404
+	}
+	return &MultiSearchResponse{Status: status, Error: err.Error()}
+}
+
+func newESMultiSearchError(err error) *ESMultiSearchResponse {
+	res := newMultiSearchError(err)
+	typ := "illegal_argument_exception"
+	if res.Status == 404 {
+		typ = "index_not_found_exception"
+	}
+	return &ESMultiSearchResponse{Status: res.Status, Error: &ESError{Type: typ, Reason: err.Error()}}
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"fmt"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestMultiSearch(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := index.IndexOrUpdateDocument(fmt.Sprintf("%03d", i), map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := []string{
+		`{}`,
+		`{"query":{"match_all":{}},"size":3}`,
+		fmt.Sprintf(`{"index":"%s"}`, indexName),
+		`{"query":{"ids":["001","002"]}}`,
+		`{"index":"not-exists"}`,
+		`{"query":{"match_all":{}}}`,
+		`{}`,
+		`{"query":`,
+		fmt.Sprintf(`{"index":["%s"]}`, indexName),
+		`{"query":{"min":5,"inclusive_min":true,"field":"n"},"size":100}`,
+	}
+	res, err := MultiSearch(indexName, strings.NewReader(strings.Join(lines, "\n")), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("multi search", res)
+	if len(res.Responses) != 5 {
+		t.Fatalf("got %d responses, expected 5", len(res.Responses))
+	}
+	for i, expected := range []struct {
+		status int
+		hits   uint64
+	}{{200, 20}, {200, 2}, {404, 0}, {400, 0}, {200, 15}} {
+		response := res.Responses[i]
+		if response.Status != expected.status {
+			t.Errorf("response %d: status %d, expected %d", i, response.Status, expected.status)
+		}
+		if expected.status == 200 && response.TotalHits != expected.hits {
+			t.Errorf("response %d: %d hits, expected %d", i, response.TotalHits, expected.hits)
+		}
+	}
+
+	if _, err := MultiSearch(indexName, strings.NewReader(`{}`), 0); err == nil {
+		t.Error("MultiSearch: expected error for the header without body")
+	}
+}
+
+func TestMultiSearchLines(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := index.IndexOrUpdateDocument(fmt.Sprintf("%03d", i), map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the body larger than the 64KB limit of bufio.Scanner.
+	ids := make([]string, 0, 15000)
+	for i := 0; i < 15000; i++ {
+		ids = append(ids, fmt.Sprintf(`"%03d"`, i))
+	}
+	large := fmt.Sprintf(`{"query":{"ids":[%s]}}`, strings.Join(ids, ","))
+	if len(large) <= 64*1024 {
+		t.Fatalf("the body has only %d bytes", len(large))
+	}
+	lines := []string{
+		``,
+		`{}`,
+		large,
+		``,
+		`  `,
+		fmt.Sprintf(`{"index":"%s"}`, indexName),
+		`{"query":{"ids":["001","002"]}}`,
+		``,
+		``,
+	}
+	res, err := MultiSearch(indexName, strings.NewReader(strings.Join(lines, "\r\n")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Responses) != 2 {
+		t.Fatalf("got %d responses, expected 2", len(res.Responses))
+	}
+	for i, hits := range []uint64{20, 2} {
+		if response := res.Responses[i]; response.Status != 200 || response.TotalHits != hits {
+			t.Errorf("response %d: status %d, %d hits, expected %d", i, response.Status, response.TotalHits, hits)
+		}
+	}
+}
+
+func TestESMultiSearch(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := index.IndexOrUpdateDocument(fmt.Sprintf("%03d", i), map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := []string{
+		`{}`,
+		`{"query":{"range":{"n":{"gte":5}}},"size":2,"track_total_hits":true}`,
+		fmt.Sprintf(`{"index":"%s","preference":"_local"}`, indexName),
+		`{"query":{"ids":{"values":["001","002"]}},"sort":[{"n":"desc"}]}`,
+		`{"index":"not-exists"}`,
+		`{}`,
+		`{}`,
+		`{"query":{"unknown":{}}}`,
+		`{}`,
+		`{}`,
+	}
+	res, err := ESMultiSearch(indexName, strings.NewReader(strings.Join(lines, "\n")), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Print("es multi search", res)
+	if len(res.Responses) != 5 {
+		t.Fatalf("got %d responses, expected 5", len(res.Responses))
+	}
+	for i, expected := range []struct {
+		status int
+		total  uint64
+		hits   int
+	}{{200, 15, 2}, {200, 2, 2}, {404, 0, 0}, {400, 0, 0}, {200, 20, 10}} {
+		response := res.Responses[i]
+		if response.Status != expected.status {
+			t.Errorf("response %d: status %d, expected %d", i, response.Status, expected.status)
+			continue
+		}
+		if expected.status != 200 {
+			if response.Error == nil || response.ESSearchResponse != nil {
+				t.Errorf("response %d: got %+v, expected the error", i, response)
+			}
+			continue
+		}
+		if response.Hits.Total.Value != expected.total || len(response.Hits.Hits) != expected.hits {
+			t.Errorf("response %d: %d of %d hits, expected %d of %d", i, len(response.Hits.Hits), response.Hits.Total.Value, expected.hits, expected.total)
+		}
+	}
+	// the sort values are decoded in the shape of Elasticsearch.
+	if hits := res.Responses[1].Hits.Hits; len(hits) == 2 && (hits[0].ID != "002" || hits[0].Sort[0] != float64(2)) {
+		t.Errorf("got the sorted hit: %+v", hits[0])
+	}
+}
@@ -1,21 +1,32 @@
 package core
 
 import (
+	"fmt"
 	imapping "github.com/blevesearch/bleve/v2/mapping"
 	"github.com/blevesearch/bleve/v2/search"
 	"github.com/blevesearch/bleve/v2/search/query"
 	bindex "github.com/blevesearch/bleve_index_api"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// newBoost returns the boost of query, the boost is 1 if not set.
+func newBoost(b float64) query.Boost {
+	if b == 0 {
+		return 1
+	}
+	return query.Boost(b)
+}
+
 type QueryStringQuery struct {
 	Query string  `json:"query"`
 	Boost float64 `json:"boost,omitempty"`
 }
 
 func (q *QueryStringQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(q.Boost)
+	boost := newBoost(q.Boost)
 	qq := query.QueryStringQuery{
 		Query:    q.Query,
 		BoostVal: &boost,
@@ -30,7 +41,7 @@ type TermQuery struct {
 }
 
 func (t *TermQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(t.BoostVal)
+	boost := newBoost(t.BoostVal)
 	tq := query.TermQuery{
 		Term:     t.Term,
 		FieldVal: t.FieldVal,
@@ -50,7 +61,7 @@ type MatchQuery struct {
 }
 
 func (mq *MatchQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(mq.BoostVal)
+	boost := newBoost(mq.BoostVal)
 	q := query.MatchQuery{
 		Match:     mq.Match,
 		FieldVal:  mq.FieldVal,
@@ -79,7 +90,7 @@ type PhraseQuery struct {
 }
 
 func (p *PhraseQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(p.BoostVal)
+	boost := newBoost(p.BoostVal)
 	pq := query.PhraseQuery{
 		Terms:    p.Terms,
 		Field:    p.Field,
@@ -96,7 +107,7 @@ type MatchPhraseQuery struct {
 }
 
 func (mp *MatchPhraseQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(mp.BoostVal)
+	boost := newBoost(mp.BoostVal)
 	mpq := query.MatchPhraseQuery{
 		MatchPhrase: mp.MatchPhrase,
 		FieldVal:    mp.FieldVal,
@@ -115,7 +126,7 @@ type FuzzyQuery struct {
 }
 
 func (f *FuzzyQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(f.BoostVal)
+	boost := newBoost(f.BoostVal)
 	fq := query.FuzzyQuery{
 		Term:      f.Term,
 		Prefix:    f.Prefix,
@@ -132,7 +143,7 @@ type ConjunctionQuery struct {
 }
 
 func (c *ConjunctionQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(c.BoostVal)
+	boost := newBoost(c.BoostVal)
 	cq := query.ConjunctionQuery{
 		Conjuncts: c.Conjuncts,
 		BoostVal:  &boost,
@@ -147,7 +158,7 @@ type DisjunctionQuery struct {
 }
 
 func (d *DisjunctionQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(d.BoostVal)
+	boost := newBoost(d.BoostVal)
 	dq := query.DisjunctionQuery{
 		Disjuncts: d.Disjuncts,
 		BoostVal:  &boost,
@@ -164,7 +175,7 @@ type BooleanQuery struct {
 }
 
 func (b *BooleanQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(b.BoostVal)
+	boost := newBoost(b.BoostVal)
 	bq := query.BooleanQuery{
 		Must:     b.Must,
 		Should:   b.Should,
@@ -184,7 +195,7 @@ type NumericRangeQuery struct {
 }
 
 func (n *NumericRangeQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(n.BoostVal)
+	boost := newBoost(n.BoostVal)
 	nrq := query.NumericRangeQuery{
 		Min:          n.Min,
 		Max:          n.Max,
@@ -206,10 +217,10 @@ type DateRangeQuery struct {
 }
 
 func (d *DateRangeQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(d.BoostVal)
+	boost := newBoost(d.BoostVal)
 	drq := query.DateRangeQuery{
-		Start:          query.BleveQueryTime{d.Start},
-		End:            query.BleveQueryTime{d.End},
+		Start:          query.BleveQueryTime{Time: d.Start},
+		End:            query.BleveQueryTime{Time: d.End},
 		InclusiveStart: d.InclusiveStart,
 		InclusiveEnd:   d.InclusiveEnd,
 		FieldVal:       d.FieldVal,
@@ -223,7 +234,7 @@ type MatchAllQuery struct {
 }
 
 func (mq *MatchAllQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(mq.BoostVal)
+	boost := newBoost(mq.BoostVal)
 	maq := query.MatchAllQuery{BoostVal: &boost}
 	return maq.Searcher(i, m, options)
 }
@@ -233,7 +244,7 @@ type MatchNoneQuery struct {
 }
 
 func (mq *MatchNoneQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(mq.BoostVal)
+	boost := newBoost(mq.BoostVal)
 	mnq := query.MatchNoneQuery{BoostVal: &boost}
 	return mnq.Searcher(i, m, options)
 }
@@ -244,10 +255,170 @@ type DocIDQuery struct {
 }
 
 func (d *DocIDQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
-	boost := query.Boost(d.BoostVal)
+	boost := newBoost(d.BoostVal)
 	dq := query.DocIDQuery{
 		IDs:      d.IDs,
 		BoostVal: &boost,
 	}
 	return dq.Searcher(i, m, options)
 }
+
+type ExistsQuery struct {
+	FieldVal string  `json:"field"`
+	BoostVal float64 `json:"boost,omitempty"`
+}
+
+// Searcher matches the documents which have any term in the field.
+func (e *ExistsQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	boost := newBoost(e.BoostVal)
+	tq := query.TermRangeQuery{
+		FieldVal: e.FieldVal,
+		BoostVal: &boost,
+	}
+	return tq.Searcher(i, m, options)
+}
+
+type TermsQuery struct {
+	Terms    []string `json:"terms"`
+	FieldVal string   `json:"field,omitempty"`
+	BoostVal float64  `json:"boost,omitempty"`
+}
+
+// Searcher matches the documents which have any of the terms in the field.
+func (t *TermsQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	boost := newBoost(t.BoostVal)
+	terms := make([]query.Query, 0, len(t.Terms))
+	for _, term := range t.Terms {
+		terms = append(terms, &query.TermQuery{Term: term, FieldVal: t.FieldVal})
+	}
+	dq := query.DisjunctionQuery{
+		Disjuncts: terms,
+		BoostVal:  &boost,
+	}
+	return dq.Searcher(i, m, options)
+}
+
+type WildcardQuery struct {
+	Wildcard string  `json:"wildcard"`
+	FieldVal string  `json:"field,omitempty"`
+	BoostVal float64 `json:"boost,omitempty"`
+}
+
+func (w *WildcardQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	boost := newBoost(w.BoostVal)
+	wq := query.WildcardQuery{
+		Wildcard: w.Wildcard,
+		FieldVal: w.FieldVal,
+		BoostVal: &boost,
+	}
+	return wq.Searcher(i, m, options)
+}
+
+type RegexpQuery struct {
+	Regexp   string  `json:"regexp"`
+	FieldVal string  `json:"field,omitempty"`
+	BoostVal float64 `json:"boost,omitempty"`
+}
+
+func (r *RegexpQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	boost := newBoost(r.BoostVal)
+	rq := query.RegexpQuery{
+		Regexp:   r.Regexp,
+		FieldVal: r.FieldVal,
+		BoostVal: &boost,
+	}
+	return rq.Searcher(i, m, options)
+}
+
+type PrefixQuery struct {
+	Prefix   string  `json:"prefix"`
+	FieldVal string  `json:"field,omitempty"`
+	BoostVal float64 `json:"boost,omitempty"`
+}
+
+func (p *PrefixQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	boost := newBoost(p.BoostVal)
+	pq := query.PrefixQuery{
+		Prefix:   p.Prefix,
+		FieldVal: p.FieldVal,
+		BoostVal: &boost,
+	}
+	return pq.Searcher(i, m, options)
+}
+
+// GeoPoint can be unmarshalled from an object like {"lon": 116.4, "lat": 39.9},
+// an array like [116.4, 39.9] or a string like "39.9,116.4"(lat,lon).
+type GeoPoint struct {
+	Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
+}
+
+func (p *GeoPoint) UnmarshalJSON(input []byte) error {
+	var lonLat []float64
+	if err := json.Unmarshal(input, &lonLat); err == nil {
+		if len(lonLat) != 2 {
+			return fmt.Errorf("geo point must be [lon, lat]")
+		}
+		p.Lon, p.Lat = lonLat[0], lonLat[1]
+		return nil
+	}
+	var latLon string
+	if err := json.Unmarshal(input, &latLon); err == nil {
+		parts := strings.Split(latLon, ",")
+		if len(parts) != 2 {
+			return fmt.Errorf("geo point must be \"lat,lon\"")
+		}
+		var err1, err2 error
+		p.Lat, err1 = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		p.Lon, err2 = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("geo point must be \"lat,lon\"")
+		}
+		return nil
+	}
+	var point struct {
+		Lon *float64 `json:"lon"`
+		Lat *float64 `json:"lat"`
+	}
+	if err := json.Unmarshal(input, &point); err != nil || point.Lon == nil || point.Lat == nil {
+		return fmt.Errorf("geo point must have lon and lat")
+	}
+	p.Lon, p.Lat = *point.Lon, *point.Lat
+	return nil
+}
+
+type GeoDistanceQuery struct {
+	Location GeoPoint `json:"location"`
+	Distance string   `json:"distance"` // e.g. "10km", "500m"
+	FieldVal string   `json:"field,omitempty"`
+	BoostVal float64  `json:"boost,omitempty"`
+}
+
+func (g *GeoDistanceQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	boost := newBoost(g.BoostVal)
+	gq := query.GeoDistanceQuery{
+		Location: []float64{g.Location.Lon, g.Location.Lat},
+		Distance: g.Distance,
+		FieldVal: g.FieldVal,
+		BoostVal: &boost,
+	}
+	return gq.Searcher(i, m, options)
+}
+
+type GeoBoundingBoxQuery struct {
+	TopLeft     GeoPoint `json:"top_left"`
+	BottomRight GeoPoint `json:"bottom_right"`
+	FieldVal    string   `json:"field,omitempty"`
+	BoostVal    float64  `json:"boost,omitempty"`
+}
+
+func (g *GeoBoundingBoxQuery) Searcher(i bindex.IndexReader, m imapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	boost := newBoost(g.BoostVal)
+	gq := query.GeoBoundingBoxQuery{
+		TopLeft:     []float64{g.TopLeft.Lon, g.TopLeft.Lat},
+		BottomRight: []float64{g.BottomRight.Lon, g.BottomRight.Lat},
+		FieldVal:    g.FieldVal,
+		BoostVal:    &boost,
+	}
+	return gq.Searcher(i, m, options)
+}
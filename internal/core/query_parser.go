@@ -0,0 +1,305 @@
+package core
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// queryTypes creates the query of the type discriminator, e.g. {"type": "match", "match": "foo", "field": "title"}.
+var queryTypes = map[string]func() query.Query{
+	"query_string":     func() query.Query { return new(QueryStringQuery) },
+	"term":             func() query.Query { return new(TermQuery) },
+	"terms":            func() query.Query { return new(TermsQuery) },
+	"match":            func() query.Query { return new(MatchQuery) },
+	"phrase":           func() query.Query { return new(PhraseQuery) },
+	"match_phrase":     func() query.Query { return new(MatchPhraseQuery) },
+	"fuzzy":            func() query.Query { return new(FuzzyQuery) },
+	"numeric_range":    func() query.Query { return new(NumericRangeQuery) },
+	"date_range":       func() query.Query { return new(DateRangeQuery) },
+	"match_all":        func() query.Query { return new(MatchAllQuery) },
+	"match_none":       func() query.Query { return new(MatchNoneQuery) },
+	"ids":              func() query.Query { return new(DocIDQuery) },
+	"exists":           func() query.Query { return new(ExistsQuery) },
+	"wildcard":         func() query.Query { return new(WildcardQuery) },
+	"regexp":           func() query.Query { return new(RegexpQuery) },
+	"prefix":           func() query.Query { return new(PrefixQuery) },
+	"geo_distance":     func() query.Query { return new(GeoDistanceQuery) },
+	"geo_bounding_box": func() query.Query { return new(GeoBoundingBoxQuery) },
+}
+
+// requiredFields are the fields must be set for the query types, the fields separated by "|" means one of them.
+var requiredFields = map[string][]string{
+	"query_string":     {"query"},
+	"term":             {"term"},
+	"terms":            {"terms"},
+	"match":            {"match"},
+	"phrase":           {"terms"},
+	"match_phrase":     {"match_phrase"},
+	"fuzzy":            {"term"},
+	"numeric_range":    {"min|max"},
+	"date_range":       {"start|end"},
+	"ids":              {"ids"},
+	"exists":           {"field"},
+	"wildcard":         {"wildcard"},
+	"regexp":           {"regexp"},
+	"prefix":           {"prefix"},
+	"geo_distance":     {"location", "distance", "field"},
+	"geo_bounding_box": {"top_left", "bottom_right", "field"},
+}
+
+// ParseQuery parses the query. The quicksearch queries are discriminated by the "type" field, the compound
+// queries are "conjunction", "disjunction", "bool" and "function_score", the others are the types in queryTypes.
+// The query without "type" is parsed in bleve format. The error contains the JSON path of the bad clause.
+func ParseQuery(input []byte) (query.Query, error) {
+	return parseQuery(input, "query")
+}
+
+// queryError is the error of parsing the clause at the path.
+func queryError(path string, format string, args ...interface{}) error {
+	return fmt.Errorf("failed to parse [%s]: %s", path, fmt.Sprintf(format, args...))
+}
+
+func parseQuery(input []byte, path string) (query.Query, error) {
+	if len(input) == 0 || string(input) == "null" {
+		return nil, queryError(path, "query is required")
+	}
+	var clause map[string]stdjson.RawMessage
+	if err := stdjson.Unmarshal(input, &clause); err != nil {
+		return nil, queryError(path, "query must be an object")
+	}
+	raw, ok := clause["type"]
+	if !ok {
+		return parseBleveQuery(input, clause, path)
+	}
+	var typ string
+	if err := stdjson.Unmarshal(raw, &typ); err != nil {
+		return nil, queryError(path+".type", "type must be a string")
+	}
+	delete(clause, "type")
+	switch typ {
+	case "conjunction", "disjunction", "bool":
+		return parseCompoundQuery(typ, clause, path)
+	case "function_score":
+		return parseFunctionScoreQuery(clause, path, func(input stdjson.RawMessage, path string) (query.Query, error) {
+			return parseQuery(input, path)
+		})
+	}
+	newQuery, ok := queryTypes[typ]
+	if !ok {
+		return nil, queryError(path+".type", "unknown query type [%s]", typ)
+	}
+	for _, required := range requiredFields[typ] {
+		found := false
+		for _, field := range strings.Split(required, "|") {
+			_, found = clause[field]
+			if found {
+				break
+			}
+		}
+		if !found {
+			return nil, queryError(path, "[%s] is required for query type [%s]", strings.ReplaceAll(required, "|", "] or ["), typ)
+		}
+	}
+	q := newQuery()
+	if err := decodeQueryFields(clause, q, path); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// parseQueries parses an array of queries, or a single query as an array of one.
+func parseQueries(input stdjson.RawMessage, path string) ([]query.Query, error) {
+	var list []stdjson.RawMessage
+	if err := stdjson.Unmarshal(input, &list); err != nil {
+		q, err := parseQuery(input, path)
+		if err != nil {
+			return nil, err
+		}
+		return []query.Query{q}, nil
+	}
+	queries := make([]query.Query, 0, len(list))
+	for i, raw := range list {
+		q, err := parseQuery(raw, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+// parseCompoundQuery parses the quicksearch compound queries, the sub queries of "bool" can be a query or an array.
+func parseCompoundQuery(typ string, clause map[string]stdjson.RawMessage, path string) (query.Query, error) {
+	var compound struct {
+		Conjuncts stdjson.RawMessage `json:"conjuncts"`
+		Disjuncts stdjson.RawMessage `json:"disjuncts"`
+		Min       float64            `json:"min"`
+		Must      stdjson.RawMessage `json:"must"`
+		Should    stdjson.RawMessage `json:"should"`
+		MustNot   stdjson.RawMessage `json:"must_not"`
+		MinShould float64            `json:"min_should"`
+		Boost     float64            `json:"boost"`
+	}
+	allowed := map[string][]string{
+		"conjunction": {"conjuncts", "boost"},
+		"disjunction": {"disjuncts", "min", "boost"},
+		"bool":        {"must", "should", "must_not", "min_should", "boost"},
+	}[typ]
+	for _, key := range sortedClauseKeys(clause) {
+		found := false
+		for _, field := range allowed {
+			found = found || field == key
+		}
+		if !found {
+			return nil, queryError(path+"."+key, "unknown field for query type [%s]", typ)
+		}
+	}
+	if err := decodeQueryFields(clause, &compound, path); err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "conjunction":
+		if len(compound.Conjuncts) == 0 {
+			return nil, queryError(path, "[conjuncts] is required for query type [%s]", typ)
+		}
+		conjuncts, err := parseQueries(compound.Conjuncts, path+".conjuncts")
+		if err != nil {
+			return nil, err
+		}
+		return &ConjunctionQuery{Conjuncts: conjuncts, BoostVal: compound.Boost}, nil
+	case "disjunction":
+		if len(compound.Disjuncts) == 0 {
+			return nil, queryError(path, "[disjuncts] is required for query type [%s]", typ)
+		}
+		disjuncts, err := parseQueries(compound.Disjuncts, path+".disjuncts")
+		if err != nil {
+			return nil, err
+		}
+		return &DisjunctionQuery{Disjuncts: disjuncts, Min: compound.Min, BoostVal: compound.Boost}, nil
+	}
+	q := &BooleanQuery{BoostVal: compound.Boost}
+	if len(compound.Must) > 0 {
+		must, err := parseQueries(compound.Must, path+".must")
+		if err != nil {
+			return nil, err
+		}
+		q.Must = &ConjunctionQuery{Conjuncts: must}
+	}
+	if len(compound.Should) > 0 {
+		should, err := parseQueries(compound.Should, path+".should")
+		if err != nil {
+			return nil, err
+		}
+		q.Should = &DisjunctionQuery{Disjuncts: should, Min: compound.MinShould}
+	}
+	if len(compound.MustNot) > 0 {
+		mustNot, err := parseQueries(compound.MustNot, path+".must_not")
+		if err != nil {
+			return nil, err
+		}
+		q.MustNot = &DisjunctionQuery{Disjuncts: mustNot}
+	}
+	if q.Must == nil && q.Should == nil && q.MustNot == nil {
+		return nil, queryError(path, "one of [must], [should] or [must_not] is required for query type [bool]")
+	}
+	return q, nil
+}
+
+// parseBleveQuery parses the query in bleve format, the sub queries of compound queries are
+// parsed by parseQuery, so they can be quicksearch queries.
+func parseBleveQuery(input []byte, clause map[string]stdjson.RawMessage, path string) (query.Query, error) {
+	var compound struct {
+		Conjuncts stdjson.RawMessage `json:"conjuncts"`
+		Disjuncts stdjson.RawMessage `json:"disjuncts"`
+		Min       float64            `json:"min"`
+		Must      stdjson.RawMessage `json:"must"`
+		Should    stdjson.RawMessage `json:"should"`
+		MustNot   stdjson.RawMessage `json:"must_not"`
+		Boost     *query.Boost       `json:"boost"`
+	}
+	_, conjunction := clause["conjuncts"]
+	_, disjunction := clause["disjuncts"]
+	_, must := clause["must"]
+	_, should := clause["should"]
+	_, mustNot := clause["must_not"]
+	if !conjunction && !disjunction && !must && !should && !mustNot {
+		q, err := query.ParseQuery(input)
+		if err != nil {
+			return nil, queryError(path, err.Error())
+		}
+		return q, nil
+	}
+	if err := stdjson.Unmarshal(input, &compound); err != nil {
+		return nil, queryError(path, err.Error())
+	}
+	switch {
+	case conjunction:
+		conjuncts, err := parseQueries(compound.Conjuncts, path+".conjuncts")
+		if err != nil {
+			return nil, err
+		}
+		return &query.ConjunctionQuery{Conjuncts: conjuncts, BoostVal: compound.Boost}, nil
+	case disjunction:
+		disjuncts, err := parseQueries(compound.Disjuncts, path+".disjuncts")
+		if err != nil {
+			return nil, err
+		}
+		return &query.DisjunctionQuery{Disjuncts: disjuncts, Min: compound.Min, BoostVal: compound.Boost}, nil
+	}
+	q := &query.BooleanQuery{BoostVal: compound.Boost}
+	for _, sub := range []struct {
+		name  string
+		raw   stdjson.RawMessage
+		query *query.Query
+	}{{"must", compound.Must, &q.Must}, {"should", compound.Should, &q.Should}, {"must_not", compound.MustNot, &q.MustNot}} {
+		if len(sub.raw) == 0 || string(sub.raw) == "null" {
+			continue
+		}
+		sq, err := parseQuery(sub.raw, path+"."+sub.name)
+		if err != nil {
+			return nil, err
+		}
+		*sub.query = sq
+	}
+	return q, nil
+}
+
+// decodeQueryFields decodes the fields of clause to the struct q by the json tags,
+// so the error can be reported with the path of field.
+func decodeQueryFields(clause map[string]stdjson.RawMessage, q interface{}, path string) error {
+	v := reflect.ValueOf(q).Elem()
+	fields := make(map[string]int, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if len(name) > 0 && name != "-" {
+			fields[name] = i
+		}
+	}
+	for _, key := range sortedClauseKeys(clause) {
+		i, ok := fields[key]
+		if !ok {
+			return queryError(path+"."+key, "unknown field")
+		}
+		if err := stdjson.Unmarshal(clause[key], v.Field(i).Addr().Interface()); err != nil {
+			if typeErr, ok := err.(*stdjson.UnmarshalTypeError); ok {
+				return queryError(path+"."+key, "expected %s, got %s", typeErr.Type, typeErr.Value)
+			}
+			return queryError(path+"."+key, err.Error())
+		}
+	}
+	return nil
+}
+
+// sortedClauseKeys returns the keys in order, so the first bad field is always reported.
+func sortedClauseKeys(clause map[string]stdjson.RawMessage) []string {
+	keys := make([]string, 0, len(clause))
+	for key := range clause {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
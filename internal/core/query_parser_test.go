@@ -0,0 +1,120 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const queryMapping = `{
+    "default_mapping": {
+        "properties": {
+            "tag": {"fields": [{"type": "keyword"}]},
+            "price": {"fields": [{"type": "number"}]},
+            "location": {"fields": [{"type": "geopoint"}]}
+        }
+    }
+}`
+
+func TestParseQuery(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(queryMapping), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "quick brown fox", "tag": "apple", "price": 5, "location": []float64{116.40, 39.90}},
+		"2": {"title": "lazy brown dog", "tag": "banana", "price": 15, "location": []float64{121.47, 31.23}, "sold": true},
+		"3": {"title": "quick red fox", "tag": "cherry", "price": 25, "location": []float64{113.26, 23.13}},
+	}
+	for id, doc := range docs {
+		if _, err := index.IndexOrUpdateDocument(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{`{"type": "match", "match": "fox", "field": "title"}`, []string{"1", "3"}},
+		{`{"type": "terms", "terms": ["apple", "cherry"], "field": "tag"}`, []string{"1", "3"}},
+		{`{"type": "exists", "field": "sold"}`, []string{"2"}},
+		{`{"type": "wildcard", "wildcard": "ba*", "field": "tag"}`, []string{"2"}},
+		{`{"type": "regexp", "regexp": "ch.*y", "field": "tag"}`, []string{"3"}},
+		{`{"type": "prefix", "prefix": "app", "field": "tag"}`, []string{"1"}},
+		{`{"type": "ids", "ids": ["2", "3"]}`, []string{"2", "3"}},
+		{`{"type": "numeric_range", "min": 10, "field": "price"}`, []string{"2", "3"}},
+		{`{"type": "geo_distance", "location": "39.9,116.4", "distance": "100km", "field": "location"}`, []string{"1"}},
+		{`{"type": "geo_bounding_box", "top_left": [110, 35], "bottom_right": {"lon": 125, "lat": 20}, "field": "location"}`, []string{"2", "3"}},
+		{`{"type": "bool", "must": {"type": "match", "match": "brown", "field": "title"}, "must_not": [{"type": "term", "term": "banana", "field": "tag"}]}`, []string{"1"}},
+		{`{"type": "bool", "should": [{"type": "ids", "ids": ["1"]}, {"type": "ids", "ids": ["2"]}, {"type": "ids", "ids": ["3"]}], "min_should": 1}`, []string{"1", "2", "3"}},
+		{`{"type": "disjunction", "disjuncts": [{"type": "prefix", "prefix": "la", "field": "title"}, {"type": "ids", "ids": ["3"]}]}`, []string{"2", "3"}},
+		// the typed queries can be nested in the bleve format.
+		{`{"conjuncts": [{"match": "fox", "field": "title"}, {"type": "numeric_range", "max": 10, "field": "price"}]}`, []string{"1"}},
+		{`{"must": {"conjuncts": [{"type": "exists", "field": "tag"}]}, "must_not": {"disjuncts": [{"type": "ids", "ids": ["1"]}]}}`, []string{"2", "3"}},
+		{`{"query": "+title:quick"}`, []string{"1", "3"}},
+	}
+	for _, c := range cases {
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(`{"query": `+c.query+`}`), req); err != nil {
+			t.Fatalf("parse %s: %s", c.query, err)
+		}
+		res, err := index.Search(req)
+		if err != nil {
+			t.Fatalf("search %s: %s", c.query, err)
+		}
+		ids := make([]string, 0, len(res.Hits))
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.ID)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, c.expected) {
+			t.Errorf("search %s: got %v, expected %v", c.query, ids, c.expected)
+		}
+	}
+
+	// the query defaults to match all documents.
+	req := new(SearchRequest)
+	if err := json.Unmarshal([]byte(`{}`), req); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := index.Search(req); err != nil || res.TotalHits != 3 {
+		t.Errorf("search without query: %v, %v", res, err)
+	}
+
+	// the errors contain the path of bad clause.
+	for input, path := range map[string]string{
+		`{"type": "unknown"}`:                              "query.type",
+		`{"type": "exists"}`:                               "query",
+		`{"type": "terms", "terms": "a", "field": "tag"}`:  "query.terms",
+		`{"type": "match", "match": "a", "fields": "tag"}`: "query.fields",
+		`{"type": "bool", "must": [{"type": "match_all"}, {"type": "term", "term": 1}]}`:                                  "query.must[1].term",
+		`{"type": "bool", "filter": {"type": "match_all"}}`:                                                               "query.filter",
+		`{"conjuncts": [{"match_all": {}}, {"type": "geo_distance", "field": "l", "distance": "1km", "location": true}]}`: "query.conjuncts[1].location",
+		`{"must": {"disjuncts": [{"type": "ids"}]}}`:                                                                      "query.must.disjuncts[0]",
+		`{"must": {"unknown": 1}}`:                                                                                        "query.must",
+	} {
+		_, err := ParseQuery([]byte(input))
+		if err == nil || !strings.Contains(err.Error(), "["+path+"]") {
+			t.Errorf("parse %s: %v, expected error at %s", input, err, path)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"time"
+)
+
+type ReindexRequest struct {
+	Source            ReindexSource `json:"source"`
+	Dest              ReindexDest   `json:"dest"`
+	Size              int           `json:"size"`                // the number of documents copied per batch
+	RequestsPerSecond float64       `json:"requests_per_second"` // throttles the documents copied per second, <= 0 means no throttling
+}
+
+type ReindexSource struct {
+	Index string      `json:"index"`
+	Query query.Query `json:"query"` // optional, copies all documents if omitted
+}
+
+type ReindexDest struct {
+	Index string `json:"index"`
+}
+
+type ReindexStatus struct {
+	Total             uint64   `json:"total"`   // the number of documents to copy
+	Created           uint64   `json:"created"` // the number of documents copied
+	Failed            uint64   `json:"failed"`  // the number of documents failed to copy
+	Batches           uint64   `json:"batches"`
+	RequestsPerSecond float64  `json:"requests_per_second"`
+	Failures          []string `json:"failures,omitempty"`
+}
+
+func (s *ReindexSource) UnmarshalJSON(input []byte) error {
+	var temp struct {
+		Index string             `json:"index"`
+		Q     stdjson.RawMessage `json:"query"`
+	}
+	err := json.Unmarshal(input, &temp)
+	if err != nil {
+		return err
+	}
+	s.Index = temp.Index
+	if len(temp.Q) == 0 {
+		s.Query = nil
+		return nil
+	}
+	s.Query, err = parseQuery(temp.Q, "source.query")
+	return err
+}
+
+// Reindex starts a background task which copies the documents from the source index
+// to the dest index. The documents are rebuilt with dest mapping, so it can be used to
+// change the mapping or the number of shards. The dest index will be created if not exists.
+func Reindex(req *ReindexRequest) (*Task, error) {
+	if req.Source.Index == "" || req.Dest.Index == "" {
+		return nil, errors.ErrIndexNotFound
+	}
+	sources, err := ResolveIndices(req.Source.Index)
+	if err != nil {
+		return nil, err
+	}
+	destName, err := ResolveWriteIndex(req.Dest.Index)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		if source.Name == destName {
+			return nil, errors.ErrReindexSameIndex
+		}
+	}
+	dest, err := NewIndex(WithName(destName))
+	if err != nil {
+		return nil, err
+	}
+	if req.Size <= 0 {
+		req.Size = config.Global.Engine.DefaultBatchSize
+	}
+	status := &ReindexStatus{RequestsPerSecond: req.RequestsPerSecond}
+	task := newTask("reindex", fmt.Sprintf("reindex from [%s] to [%s]", req.Source.Index, destName), status)
+	task.run(func(ctx context.Context) error {
+		total, err := countDocuments(req.Source.Query, sources...)
+		if err != nil {
+			return err
+		}
+		task.update(func() {
+			status.Total = total
+		})
+		for _, source := range sources {
+			if err = reindex(ctx, task, status, source, dest, req); err != nil {
+				return err
+			}
+		}
+		return dest.UpdateMetadata()
+	})
+	return task, nil
+}
+
+func reindex(ctx context.Context, task *Task, status *ReindexStatus, source, dest *Index, req *ReindexRequest) error {
+	return source.scanDocuments(ctx, req.Source.Query, req.Size, func(docs []*Document) error {
+		start := time.Now()
+		batch := newBulkBatch()
+		ops := make([]*writeOp, 0, len(docs))
+		for _, doc := range docs {
+			op := newWriteOp(opIndex, dest.Name, doc.ID, doc.Source.(map[string]interface{}))
+			batch.add(dest, op)
+			ops = append(ops, op)
+		}
+		if err := batch.execute(); err != nil {
+			return err
+		}
+		var created, failed uint64
+		failures := make([]string, 0)
+		for _, op := range ops {
+			if op.err != nil {
+				failed++
+				failures = append(failures, fmt.Sprintf("[%s]: %s", op.docID, op.err))
+				continue
+			}
+			created++
+		}
+		task.update(func() {
+			status.Created += created
+			status.Failed += failed
+			status.Batches++
+			status.Failures = append(status.Failures, failures...)
+		})
+		return throttle(ctx, start, len(docs), req.RequestsPerSecond)
+	})
+}
+
+// throttle sleeps until the n documents processed since start don't exceed the rate limit.
+func throttle(ctx context.Context, start time.Time, n int, requestsPerSecond float64) error {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	wait := time.Duration(float64(n)/requestsPerSecond*float64(time.Second)) - time.Since(start)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestReindex(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	source, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	destName := indexName + "-reindex"
+	defer func() {
+		log.Println("Delete Index.")
+		if err := source.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if dest, err := GetIndex(destName); err == nil {
+			if err := dest.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	docs := make([]map[string]interface{}, 0, 100)
+	for i := 0; i < 100; i++ {
+		docs = append(docs, map[string]interface{}{"title": fmt.Sprintf("doc %d", i), "even": i%2 == 0})
+	}
+	if err := source.BulkIndex(docs); err != nil {
+		t.Fatal(err)
+	}
+
+	q := bleve.NewBoolFieldQuery(true)
+	q.SetField("even")
+	task, err := Reindex(&ReindexRequest{
+		Source: ReindexSource{Index: indexName, Query: q},
+		Dest:   ReindexDest{Index: destName},
+		Size:   7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	json.Print("reindex task", task)
+	if task.Error != "" {
+		t.Fatal(task.Error)
+	}
+	status := task.Status.(*ReindexStatus)
+	if status.Total != 50 || status.Created != 50 {
+		t.Errorf("reindex copied %d of %d documents, expected 50", status.Created, status.Total)
+	}
+	dest, err := GetIndex(destName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := countDocuments(nil, dest); err != nil || n != 50 {
+		t.Errorf("dest index has %d documents, expected 50", n)
+	}
+
+	if _, err := Reindex(&ReindexRequest{Source: ReindexSource{Index: indexName}, Dest: ReindexDest{Index: indexName}}); err != errors.ErrReindexSameIndex {
+		t.Errorf("Reindex: %v", err)
+	}
+}
+
+func TestCancelReindex(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	source, err := NewIndex(WithName(indexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	destName := indexName + "-reindex"
+	defer func() {
+		log.Println("Delete Index.")
+		if err := source.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if dest, err := GetIndex(destName); err == nil {
+			if err := dest.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	docs := make([]map[string]interface{}, 0, 100)
+	for i := 0; i < 100; i++ {
+		docs = append(docs, map[string]interface{}{"title": fmt.Sprintf("doc %d", i)})
+	}
+	if err := source.BulkIndex(docs); err != nil {
+		t.Fatal(err)
+	}
+	// copies 10 docs per second, so it can't complete before cancelled.
+	task, err := Reindex(&ReindexRequest{
+		Source:            ReindexSource{Index: indexName},
+		Dest:              ReindexDest{Index: destName},
+		Size:              10,
+		RequestsPerSecond: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	json.Print("cancelled reindex task", task)
+	if !task.Cancelled || task.Error != "" {
+		t.Errorf("task not cancelled: %+v", task)
+	}
+	if err := task.Cancel(); err != errors.ErrTaskCompleted {
+		t.Errorf("Cancel: %v", err)
+	}
+}
+
+func TestCloseDuringReindex(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	source, err := NewIndex(WithName(indexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	destName := indexName + "-reindex"
+	defer func() {
+		log.Println("Delete Index.")
+		if err := source.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if dest, err := GetIndex(destName); err == nil {
+			if err := dest.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	docs := make([]map[string]interface{}, 0, 100)
+	for i := 0; i < 100; i++ {
+		docs = append(docs, map[string]interface{}{"title": fmt.Sprintf("doc %d", i)})
+	}
+	if err := source.BulkIndex(docs); err != nil {
+		t.Fatal(err)
+	}
+	// copies 10 docs per second, so the source is closed while it's running.
+	task, err := Reindex(&ReindexRequest{
+		Source:            ReindexSource{Index: indexName},
+		Dest:              ReindexDest{Index: destName},
+		Size:              10,
+		RequestsPerSecond: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	if task.Error != errors.ErrIndexClosed.Error() {
+		t.Errorf("got task error %q after the source closed", task.Error)
+	}
+}
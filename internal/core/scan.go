@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/collector"
+	"github.com/blevesearch/bleve/v2/search/query"
+	bindex "github.com/blevesearch/bleve_index_api"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+)
+
+// scanDocuments walks through all the documents matching q shard by shard, fn is called
+// with at most batchSize documents each time until all the matched documents are visited.
+// The documents are read from the readers of shards taken at the start, and sorted by `_id` in each shard,
+// so it's safe to modify the visited documents in fn. errors.ErrIndexClosed is returned if the index is closed.
+func (index *Index) scanDocuments(ctx context.Context, q query.Query, batchSize int, fn func(docs []*Document) error) error {
+	if q == nil {
+		q = bleve.NewMatchAllQuery()
+	}
+	shards, err := index.pinShards()
+	if err != nil {
+		return err
+	}
+	defer closePinnedShards(shards)
+	sort := search.SortOrder{&search.SortDocID{}}
+	for _, shard := range shards {
+		var after []string
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if index.IsClosed() {
+				return errors.ErrIndexClosed
+			}
+			hits, _, err := shard.search(ctx, q, batchSize, sort, after, documentFields)
+			if err != nil {
+				return err
+			}
+			if len(hits) == 0 {
+				break
+			}
+			docs := make([]*Document, 0, len(hits))
+			for _, hit := range hits {
+				doc, err := index.documentFromHit(hit)
+				if err != nil {
+					return err
+				}
+				docs = append(docs, doc)
+			}
+			if err = fn(docs); err != nil {
+				return err
+			}
+			if len(hits) < batchSize {
+				break
+			}
+			after = hits[len(hits)-1].Sort
+		}
+	}
+	return nil
+}
+
+// countDocuments returns the number of documents matching q in the indices.
+func countDocuments(q query.Query, indices ...*Index) (uint64, error) {
+	if q == nil {
+		q = bleve.NewMatchAllQuery()
+	}
+	var total uint64
+	for _, index := range indices {
+		shards, err := index.pinShards()
+		if err != nil {
+			return 0, err
+		}
+		for _, shard := range shards {
+			_, n, err := shard.search(context.Background(), q, 0, search.SortOrder{&search.SortDocID{}}, nil, nil)
+			if err != nil {
+				closePinnedShards(shards)
+				return 0, err
+			}
+			total += n
+		}
+		closePinnedShards(shards)
+	}
+	return total, nil
+}
+
+// pinnedShard is the reader of shard taken at a point in time, the later writes and the close of shard
+// don't affect it.
+type pinnedShard struct {
+	reader  bindex.IndexReader
+	mapping imapping.IndexMapping
+}
+
+// pinShards takes the readers of all shards of index with the writes blocked, so they are a point-in-time view
+// across shards. The caller must release them by closePinnedShards.
+func (index *Index) pinShards() ([]*pinnedShard, error) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	if index.closed {
+		return nil, errors.ErrIndexClosed
+	}
+	readers, err := index.shardReaders()
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]*pinnedShard, 0, len(readers))
+	for i, shard := range index.Shards {
+		shards = append(shards, &pinnedShard{reader: readers[i], mapping: shard.Indexer.Mapping()})
+	}
+	return shards, nil
+}
+
+func closePinnedShards(shards []*pinnedShard) {
+	for _, shard := range shards {
+		_ = shard.reader.Close()
+	}
+}
+
+// search returns the top size hits of q after the sort values after, the fields of hits are loaded.
+// The total number of hits is returned too.
+func (shard *pinnedShard) search(ctx context.Context, q query.Query, size int, sort search.SortOrder, after []string, fields []string) (search.DocumentMatchCollection, uint64, error) {
+	var coll *collector.TopNCollector
+	if after != nil {
+		coll = collector.NewTopNCollectorAfter(size, sort, after)
+	} else {
+		coll = collector.NewTopNCollector(size, 0, sort)
+	}
+	searcher, err := q.Searcher(shard.reader, shard.mapping, search.SearcherOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer searcher.Close()
+	if err = coll.Collect(ctx, searcher, shard.reader); err != nil {
+		return nil, 0, err
+	}
+	hits := coll.Results()
+	if len(fields) > 0 {
+		req := &bleve.SearchRequest{Fields: fields}
+		for _, hit := range hits {
+			if err := bleve.LoadAndHighlightFields(hit, req, "", shard.reader, nil); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return hits, coll.Total(), nil
+}
+
+// documentFields are the stored fields loaded by the hits for documentFromHit.
+var documentFields = []string{"_source", "_version", "_seq_no"}
+
+// documentFromHit builds the Document from the hit which loads the documentFields.
+func (index *Index) documentFromHit(hit *search.DocumentMatch) (*Document, error) {
+	doc := &Document{
+		Index:       index.Name,
+		ID:          hit.ID,
+		PrimaryTerm: primaryTerm,
+		Found:       true,
+	}
+	doc.Version, doc.SeqNo = hitVersion(hit)
+	source := make(map[string]interface{})
+	if s, ok := hit.Fields["_source"].(string); ok {
+		if err := json.Unmarshal([]byte(s), &source); err != nil {
+			return nil, err
+		}
+	}
+	doc.Source = source
+	return doc, nil
+}
@@ -0,0 +1,306 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/collector"
+	"github.com/blevesearch/bleve/v2/search/highlight"
+	bindex "github.com/blevesearch/bleve_index_api"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/uuid"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// scroll keeps the index readers of all shards opened at the time it's created,
+// so the pages fetched later see a consistent snapshot regardless of the concurrent writes.
+type scroll struct {
+	id        string
+	request   *SearchRequest
+	bRequest  *bleve.SearchRequest
+	sort      search.SortOrder
+	shards    []*scrollShard
+	total     uint64
+	keepAlive time.Duration
+	timer     *time.Timer
+	closed    bool
+	mu        sync.Mutex
+}
+
+type scrollShard struct {
+	index   string
+	reader  bindex.IndexReader
+	mapping imapping.IndexMapping
+	after   []string // the sort values of the last returned hit of the shard
+}
+
+type scrollHit struct {
+	hit   *search.DocumentMatch
+	shard *scrollShard
+}
+
+// NewScroll performs search in indices(all indices if none is specified) and returns the first page,
+// the following pages can be fetched by ScrollNext with the returned ScrollID until no hits returned.
+// The scroll is released if it's not accessed within keepAlive.
+// At most config.Engine.MaxOpenScrolls scrolls are opened at the same time.
+func NewScroll(req *SearchRequest, keepAlive time.Duration, indices ...*Index) (*SearchResult, error) {
+	var err error
+	if len(indices) == 0 {
+		if indices, err = openAllIndices(); err != nil {
+			return nil, err
+		}
+	}
+	s := &scroll{
+		id:        uuid.GetXID(),
+		request:   req,
+		bRequest:  newBleveSearchRequest(req),
+		keepAlive: keepAlive,
+	}
+	// the hits with the same sort values are ordered by `_id` and then the index name,
+	// each shard continues after its own last returned hit, so no hit is skipped or repeated between pages.
+	s.sort = s.bRequest.Sort
+	if !s.sort.RequiresDocID() {
+		s.sort = append(s.sort.Copy(), &search.SortDocID{})
+	}
+	s.bRequest.Sort = s.sort
+	s.bRequest.From = 0
+	for _, index := range indices {
+		if index.IsClosed() {
+			s.close()
+			return nil, errors.ErrIndexClosed
+		}
+		for _, shard := range index.Shards {
+			idx, err := shard.Indexer.Advanced()
+			if err != nil {
+				s.close()
+				return nil, err
+			}
+			reader, err := idx.Reader()
+			if err != nil {
+				s.close()
+				return nil, err
+			}
+			s.shards = append(s.shards, &scrollShard{index: index.Name, reader: reader, mapping: shard.Indexer.Mapping()})
+		}
+	}
+	if len(s.shards) == 0 {
+		return nil, errors.ErrIndexNotFound
+	}
+	if err := engine.addScroll(s); err != nil {
+		s.close()
+		return nil, err
+	}
+	result, err := s.next(true)
+	if err != nil {
+		engine.removeScroll(s.id)
+		return nil, err
+	}
+	s.mu.Lock()
+	s.timer = time.AfterFunc(keepAlive, func() {
+		engine.removeScroll(s.id)
+	})
+	s.mu.Unlock()
+	return result, nil
+}
+
+// ScrollNext returns the next page of the scroll and extends its keepAlive.
+func ScrollNext(scrollID string, keepAlive time.Duration) (*SearchResult, error) {
+	s := engine.getScroll(scrollID)
+	if s == nil {
+		return nil, errors.ErrScrollNotFound
+	}
+	s.mu.Lock()
+	if keepAlive > 0 {
+		s.keepAlive = keepAlive
+	}
+	if s.timer != nil {
+		s.timer.Reset(s.keepAlive)
+	}
+	s.mu.Unlock()
+	return s.next(false)
+}
+
+// ClearScroll releases the scroll.
+func ClearScroll(scrollID string) error {
+	if s := engine.getScroll(scrollID); s == nil {
+		return errors.ErrScrollNotFound
+	}
+	engine.removeScroll(scrollID)
+	return nil
+}
+
+// next collects the next page from all shards and merges them.
+func (s *scroll) next(first bool) (*SearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.ErrScrollNotFound
+	}
+	start := time.Now()
+	size := s.bRequest.Size
+	cachedScoring := s.sort.CacheIsScore()
+	cachedDesc := s.sort.CacheDescending()
+	hits := make([]*scrollHit, 0, size)
+	var total uint64
+	for _, shard := range s.shards {
+		shardHits, shardTotal, err := s.collect(shard)
+		if err != nil {
+			return nil, err
+		}
+		total += shardTotal
+		hits = append(hits, shardHits...)
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if c := s.sort.Compare(cachedScoring, cachedDesc, hits[i].hit, hits[j].hit); c != 0 {
+			return c < 0
+		}
+		return hits[i].shard.index < hits[j].shard.index
+	})
+	if len(hits) > size {
+		hits = hits[:size]
+	}
+	if first {
+		s.total = total
+	}
+	var highlighter highlight.Highlighter
+	if s.bRequest.Highlight != nil {
+		var err error
+		if highlighter, err = bleve.Config.Cache.HighlighterNamed(bleve.Config.DefaultHighlighter); err != nil {
+			return nil, err
+		}
+	}
+	searchResult := &bleve.SearchResult{
+		Status: &bleve.SearchStatus{
+			Total:      len(s.shards),
+			Successful: len(s.shards),
+		},
+		Request: s.bRequest,
+		Hits:    make(search.DocumentMatchCollection, 0, len(hits)),
+		Total:   s.total,
+	}
+	for _, sh := range hits {
+		if err := bleve.LoadAndHighlightFields(sh.hit, s.bRequest, sh.shard.index, sh.shard.reader, highlighter); err != nil {
+			return nil, err
+		}
+		if sh.hit.Score > searchResult.MaxScore {
+			searchResult.MaxScore = sh.hit.Score
+		}
+		searchResult.Hits = append(searchResult.Hits, sh.hit)
+	}
+	for _, sh := range hits {
+		sh.shard.after = s.sortValues(sh.hit)
+	}
+	searchResult.Took = time.Since(start)
+	result, err := newSearchResult(s.request, searchResult)
+	if err != nil {
+		return nil, err
+	}
+	result.ScrollID = s.id
+	return result, nil
+}
+
+// collect returns the top hits after shard.after in the shard.
+func (s *scroll) collect(shard *scrollShard) ([]*scrollHit, uint64, error) {
+	var coll *collector.TopNCollector
+	if shard.after != nil {
+		coll = collector.NewTopNCollectorAfter(s.bRequest.Size, s.sort, shard.after)
+	} else {
+		coll = collector.NewTopNCollector(s.bRequest.Size, 0, s.sort)
+	}
+	searcher, err := s.bRequest.Query.Searcher(shard.reader, shard.mapping, search.SearcherOptions{
+		Explain:            s.bRequest.Explain,
+		IncludeTermVectors: s.bRequest.IncludeLocations || s.bRequest.Highlight != nil,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer searcher.Close()
+	if err = coll.Collect(context.Background(), searcher, shard.reader); err != nil {
+		return nil, 0, err
+	}
+	hits := make([]*scrollHit, 0, len(coll.Results()))
+	for _, hit := range coll.Results() {
+		hit.Index = shard.index
+		hits = append(hits, &scrollHit{hit: hit, shard: shard})
+	}
+	return hits, coll.Total(), nil
+}
+
+// sortValues returns the values to search after the hit, the score is
+// represented by a placeholder in hit.Sort, so it's replaced with the real score.
+func (s *scroll) sortValues(hit *search.DocumentMatch) []string {
+	values := make([]string, len(hit.Sort))
+	copy(values, hit.Sort)
+	for i, so := range s.sort {
+		if so.RequiresScoring() {
+			values[i] = strconv.FormatFloat(hit.Score, 'g', -1, 64)
+		}
+	}
+	return values
+}
+
+// close releases the index readers.
+func (s *scroll) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	for _, shard := range s.shards {
+		_ = shard.reader.Close()
+	}
+}
+
+// defaultMaxOpenScrolls is used if config.Engine.MaxOpenScrolls is not positive.
+const defaultMaxOpenScrolls = 500
+
+func (e *Engine) addScroll(s *scroll) error {
+	limit := config.Global.Engine.MaxOpenScrolls
+	if limit <= 0 {
+		limit = defaultMaxOpenScrolls
+	}
+	e.scrollMu.Lock()
+	defer e.scrollMu.Unlock()
+	if len(e.scrolls) >= limit {
+		return fmt.Errorf("%w, must be less than or equal to [%d]", errors.ErrTooManyScrolls, limit)
+	}
+	e.scrolls[s.id] = s
+	return nil
+}
+
+func (e *Engine) getScroll(id string) *scroll {
+	e.scrollMu.RLock()
+	s := e.scrolls[id]
+	e.scrollMu.RUnlock()
+	return s
+}
+
+func (e *Engine) removeScroll(id string) {
+	e.scrollMu.Lock()
+	s := e.scrolls[id]
+	delete(e.scrolls, id)
+	e.scrollMu.Unlock()
+	if s != nil {
+		s.close()
+	}
+}
+
+func (e *Engine) closeAllScrolls() {
+	e.scrollMu.Lock()
+	scrolls := e.scrolls
+	e.scrolls = make(map[string]*scroll)
+	e.scrollMu.Unlock()
+	for _, s := range scrolls {
+		s.close()
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestScroll(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := index.IndexOrUpdateDocument(fmt.Sprintf("%03d", i), map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := &SearchRequest{Query: &MatchAllQuery{}, Size: 15, Sort: []string{"-n"}}
+	res, err := NewScroll(req, time.Minute, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the writes after scroll opened are invisible to the scroll.
+	if _, err := index.IndexOrUpdateDocument("new", map[string]interface{}{"n": 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.DeleteDocument("000"); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, 100)
+	for len(res.Hits) > 0 {
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.ID)
+		}
+		if res, err = ScrollNext(res.ScrollID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ids) != 100 {
+		t.Fatalf("scroll returns %d hits, expected 100", len(ids))
+	}
+	for i, id := range ids {
+		if id != fmt.Sprintf("%03d", 99-i) {
+			t.Fatalf("scroll returns %s at %d", id, i)
+		}
+	}
+	if err := ClearScroll(res.ScrollID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ScrollNext(res.ScrollID, 0); err != qerrors.ErrScrollNotFound {
+		t.Errorf("ScrollNext: %v", err)
+	}
+
+	// the scroll is released after keepalive.
+	res, err = NewScroll(req, 10*time.Millisecond, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := ScrollNext(res.ScrollID, 0); err != qerrors.ErrScrollNotFound {
+		t.Errorf("ScrollNext: %v", err)
+	}
+}
+
+func TestScrollIndicesWithSameIDs(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	indices := make([]*Index, 0, 2)
+	for _, name := range []string{indexName, "other_" + indexName} {
+		index, err := NewIndex(WithName(name), WithShards(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		indices = append(indices, index)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		for _, index := range indices {
+			if err := index.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	for _, index := range indices {
+		for i := 0; i < 10; i++ {
+			if _, err := index.IndexOrUpdateDocument(fmt.Sprintf("%02d", i), map[string]interface{}{"n": i % 2}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the pages end between the hits with the same sort values and ids in both indices.
+	req := &SearchRequest{Query: &MatchAllQuery{}, Size: 3, Sort: []string{"n"}}
+	res, err := NewScroll(req, time.Minute, indices...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ClearScroll(res.ScrollID)
+	seen := make(map[string]bool)
+	for len(res.Hits) > 0 {
+		for _, hit := range res.Hits {
+			key := hit.Index + "/" + hit.ID
+			if seen[key] {
+				t.Fatalf("scroll repeats %s", key)
+			}
+			seen[key] = true
+		}
+		if res, err = ScrollNext(res.ScrollID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != 20 {
+		t.Errorf("scroll returns %d hits, expected 20", len(seen))
+	}
+}
+
+func TestMaxOpenScrolls(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	defer func(limit int) {
+		config.Global.Engine.MaxOpenScrolls = limit
+	}(config.Global.Engine.MaxOpenScrolls)
+	config.Global.Engine.MaxOpenScrolls = 1
+	req := &SearchRequest{Query: &MatchAllQuery{}}
+	res, err := NewScroll(req, time.Minute, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScroll(req, time.Minute, index); !errors.Is(err, qerrors.ErrTooManyScrolls) {
+		t.Errorf("open scroll beyond the limit: %v, expected %v", err, qerrors.ErrTooManyScrolls)
+	}
+	if err := ClearScroll(res.ScrollID); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = NewScroll(req, time.Minute, index); err != nil {
+		t.Fatalf("open scroll after clear: %v", err)
+	}
+	if err := ClearScroll(res.ScrollID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,191 +2,111 @@ package core
 
 import (
 	"github.com/blevesearch/bleve/v2"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
 	"github.com/blevesearch/bleve/v2/search"
 	"github.com/feimingxliu/quicksearch/internal/config"
 	"github.com/feimingxliu/quicksearch/pkg/errors"
 	"github.com/feimingxliu/quicksearch/pkg/util/json"
 	"github.com/feimingxliu/quicksearch/pkg/util/slices"
+	"sort"
 )
 
 // Search performs search in specified index.
 func (index *Index) Search(req *SearchRequest) (*SearchResult, error) {
-	if index.IsClosed() {
-		return nil, errors.ErrIndexClosed
+	return SearchIndices(req, index)
+}
+
+// Search performs search in all existing indices.
+func Search(req *SearchRequest) (*SearchResult, error) {
+	indices, err := openAllIndices()
+	if err != nil {
+		return nil, err
 	}
-	request := &bleve.SearchRequest{
-		Query:            req.Query,
-		Size:             req.Size,
-		From:             req.From,
-		Fields:           []string{"*"},
-		Explain:          req.Explain,
-		Sort:             search.SortOrder{&search.SortScore{Desc: true}},
-		IncludeLocations: req.IncludeLocations,
-		SearchAfter: func() []string {
-			if len(req.SearchAfter) > 0 {
-				return req.SearchAfter
-			}
-			return nil
-		}(),
-		SearchBefore: func() []string {
-			if len(req.SearchBefore) > 0 {
-				return req.SearchBefore
-			}
-			return nil
-		}(),
+	return SearchIndices(req, indices...)
+}
+
+// openAllIndices returns all existing indices, the closed indices are opened.
+func openAllIndices() ([]*Index, error) {
+	// to get all indices(some may close), fetch all from meta db.
+	indices, err := ListIndices()
+	if err != nil {
+		return nil, err
 	}
-	source := true
-	fields := make(map[string]bool, len(request.Fields))
-	if len(req.Fields) > 0 {
-		request.Fields = req.Fields
-		request.Fields = append(request.Fields, "@timestamp")
-		if !slices.ContainsStr(request.Fields, "*") && !slices.ContainsStr(request.Fields, "_all") {
-			source = false
-			for _, f := range request.Fields {
-				fields[f] = true
-			}
+	for i := range indices {
+		// this will search in cache first, some index may already open and exist in the engine cache.
+		// the returned index is opened.
+		if indices[i], err = GetIndex(indices[i].Name); err != nil {
+			return nil, err
 		}
 	}
-	if req.From <= 0 {
-		request.From = 0
-	}
-	if req.Size <= 0 {
-		request.Size = config.Global.Engine.DefaultSearchResultSize
+	return indices, nil
+}
+
+// SearchIndices performs search across the specified indices, the results are merged as they're in one index.
+func SearchIndices(req *SearchRequest, indices ...*Index) (*SearchResult, error) {
+	// the indices are locked during the search, so the shards are not closed or reopened, e.g. by the mapping
+	// updates, while being searched. They're locked in the order of names to avoid the deadlock with writers.
+	locked := make(map[*Index]bool, len(indices))
+	sorted := make([]*Index, 0, len(indices))
+	for _, index := range indices {
+		if !locked[index] {
+			locked[index] = true
+			sorted = append(sorted, index)
+		}
 	}
-	if len(req.Highlight) > 0 {
-		highlight := bleve.NewHighlight()
-		highlight.Fields = req.Highlight
-		request.Highlight = highlight
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	for _, index := range sorted {
+		index.mu.RLock()
+		defer index.mu.RUnlock()
 	}
-	if len(req.Facets) > 0 {
-		facets := make(map[string]*bleve.FacetRequest, len(req.Facets))
-		for name, fr := range req.Facets {
-			facet := bleve.NewFacetRequest(fr.Field, fr.Size)
-			for _, nr := range fr.NumericRanges {
-				facet.AddNumericRange(nr.Name, &nr.Min, &nr.Max)
-			}
-			for _, dr := range fr.DateTimeRanges {
-				facet.AddDateTimeRange(dr.Name, dr.Start, dr.End)
+	indexes := make([]bleve.Index, 0)
+	// the index mappings by the mappings of shards, the aggregations resolve the field types by them.
+	mappings := make(map[imapping.IndexMapping]*IndexMapping)
+	for _, index := range indices {
+		if index.closed {
+			return nil, errors.ErrIndexClosed
+		}
+		for _, shard := range index.Shards {
+			indexer := shard.Indexer
+			if indexer == nil {
+				return nil, errors.ErrIndexClosed
 			}
-			facets[name] = facet
+			indexes = append(indexes, indexer)
+			mappings[indexer.Mapping()] = index.Mapping
 		}
-		request.Facets = facets
 	}
-	if len(req.Sort) > 0 {
-		so := search.ParseSortOrderStrings(req.Sort)
-		request.Sort = so
+	if len(indexes) == 0 {
+		return nil, errors.ErrIndexNotFound
 	}
-	indexes := make([]bleve.Index, 0, index.NumberOfShards)
-	for _, shard := range index.Shards {
-		indexes = append(indexes, shard.Indexer)
+	request := newBleveSearchRequest(req)
+	// the aggregations are collected by the searchers of the query.
+	var agg *aggregation
+	if len(req.Aggregations) > 0 {
+		var err error
+		if agg, err = newAggregation(req.Query, req.Aggregations, mappings); err != nil {
+			return nil, err
+		}
+		request.Query = agg
 	}
 	indexAlias := bleve.NewIndexAlias(indexes...)
 	searchResult, err := indexAlias.Search(request)
 	if err != nil {
 		return nil, err
 	}
-	result := &SearchResult{
-		Status: Status{
-			Total:      searchResult.Status.Total,
-			Failed:     searchResult.Status.Failed,
-			Successful: searchResult.Status.Successful,
-		},
-		Request:   req,
-		Hits:      make([]*Hit, 0, len(searchResult.Hits)),
-		TotalHits: searchResult.Total,
-		MaxScore:  searchResult.MaxScore,
-		Took:      searchResult.Took,
-	}
-	for _, dm := range searchResult.Hits {
-		hit := &Hit{
-			Index:       dm.Index,
-			ID:          dm.ID,
-			Score:       f64(dm.Score),
-			Sort:        dm.Sort,
-			Explanation: dm.Expl,
-			Fragments:   dm.Fragments,
-		}
-		if req.IncludeLocations {
-			hit.Locations = dm.Locations
-		}
-		if source {
-			hit.Source = map[string]interface{}{}
-		} else {
-			hit.Fields = map[string]interface{}{}
-		}
-		for k, v := range dm.Fields {
-			switch k {
-			case "@timestamp":
-				if t, ok := v.(string); ok {
-					hit.Timestamp = t
-				}
-			case "_source":
-				if source {
-					if s, ok := v.(string); ok {
-						if err = json.Unmarshal([]byte(s), &hit.Source); err != nil {
-							return nil, err
-						}
-					}
-				}
-			default:
-				if !source && fields[k] {
-					hit.Fields[k] = v
-				}
-			}
-		}
-		result.Hits = append(result.Hits, hit)
+	result, err := newSearchResult(req, searchResult)
+	if err != nil {
+		return nil, err
 	}
-	if searchResult.Facets != nil {
-		facets := make(map[string]*FacetResult, len(searchResult.Facets))
-		for name, fr := range searchResult.Facets {
-			facet := &FacetResult{
-				Field:   fr.Field,
-				Total:   fr.Total,
-				Missing: fr.Missing,
-				Other:   fr.Other,
-			}
-			if fr.DateRanges != nil {
-				facet.DateRanges = make([]DateRangeFacet, 0, len(fr.DateRanges))
-				for _, drf := range fr.DateRanges {
-					facet.DateRanges = append(facet.DateRanges, DateRangeFacet{
-						Name:  drf.Name,
-						Start: drf.Start,
-						End:   drf.End,
-						Count: drf.Count,
-					})
-				}
-			}
-			if fr.NumericRanges != nil {
-				facet.NumericRanges = make([]NumericRangeFacet, 0, len(fr.NumericRanges))
-				for _, nr := range fr.NumericRanges {
-					facet.NumericRanges = append(facet.NumericRanges, NumericRangeFacet{
-						Name:  nr.Name,
-						Min:   nr.Min,
-						Max:   nr.Max,
-						Count: nr.Count,
-					})
-				}
-			}
-			if fr.Terms != nil {
-				terms := fr.Terms.Terms()
-				facet.Terms = make([]TermFacet, 0, len(terms))
-				for _, term := range terms {
-					facet.Terms = append(facet.Terms, TermFacet{
-						Term:  term.Term,
-						Count: term.Count,
-					})
-				}
-			}
-			facets[name] = facet
+	if agg != nil {
+		if result.Aggregations, err = agg.results(); err != nil {
+			return nil, err
 		}
-		result.Facets = facets
 	}
-
 	return result, nil
 }
 
-// Search performs search in all existing indices.
-func Search(req *SearchRequest) (*SearchResult, error) {
+// newBleveSearchRequest converts the SearchRequest to bleve.SearchRequest.
+func newBleveSearchRequest(req *SearchRequest) *bleve.SearchRequest {
 	request := &bleve.SearchRequest{
 		Query:            req.Query,
 		Size:             req.Size,
@@ -208,17 +128,9 @@ func Search(req *SearchRequest) (*SearchResult, error) {
 			return nil
 		}(),
 	}
-	source := true
-	fields := make(map[string]bool, len(request.Fields))
 	if len(req.Fields) > 0 {
 		request.Fields = req.Fields
 		request.Fields = append(request.Fields, "@timestamp")
-		if !slices.ContainsStr(request.Fields, "*") && !slices.ContainsStr(request.Fields, "_all") {
-			source = false
-			for _, f := range request.Fields {
-				fields[f] = true
-			}
-		}
 	}
 	if req.From <= 0 {
 		request.From = 0
@@ -228,7 +140,10 @@ func Search(req *SearchRequest) (*SearchResult, error) {
 	}
 	if len(req.Highlight) > 0 {
 		highlight := bleve.NewHighlight()
-		highlight.Fields = req.Highlight
+		// all the fields are highlighted if "*" specified.
+		if !slices.ContainsStr(req.Highlight, "*") {
+			highlight.Fields = req.Highlight
+		}
 		request.Highlight = highlight
 	}
 	if len(req.Facets) > 0 {
@@ -249,30 +164,20 @@ func Search(req *SearchRequest) (*SearchResult, error) {
 		so := search.ParseSortOrderStrings(req.Sort)
 		request.Sort = so
 	}
-	// to get all indices(some may close), fetch all from meta db.
-	indices, err := ListIndices()
-	if err != nil {
-		return nil, err
-	}
-	indexes := make([]bleve.Index, 0)
-	for _, index := range indices {
-		// this will search in cache first, some index may already open and exist in the engine cache.
-		// the returned index is opened.
-		if index, err = GetIndex(index.Name); err != nil {
-			return nil, err
-		}
-		for _, shard := range index.Shards {
-			indexes = append(indexes, shard.Indexer)
+	return request
+}
+
+// newSearchResult converts the bleve.SearchResult to SearchResult.
+func newSearchResult(req *SearchRequest, searchResult *bleve.SearchResult) (*SearchResult, error) {
+	var err error
+	source := true
+	fields := make(map[string]bool, len(req.Fields))
+	if len(req.Fields) > 0 && !slices.ContainsStr(req.Fields, "*") && !slices.ContainsStr(req.Fields, "_all") {
+		source = false
+		for _, f := range req.Fields {
+			fields[f] = true
 		}
 	}
-	if len(indexes) == 0 {
-		return nil, errors.ErrIndexNotFound
-	}
-	indexAlias := bleve.NewIndexAlias(indexes...)
-	searchResult, err := indexAlias.Search(request)
-	if err != nil {
-		return nil, err
-	}
 	result := &SearchResult{
 		Status: Status{
 			Total:      searchResult.Status.Total,
@@ -314,6 +219,7 @@ func Search(req *SearchRequest) (*SearchResult, error) {
 						if err = json.Unmarshal([]byte(s), &hit.Source); err != nil {
 							return nil, err
 						}
+						hit.Source = req.Source.Apply(hit.Source)
 					}
 				}
 			default:
@@ -9,32 +9,37 @@ import (
 )
 
 type SearchRequest struct {
-	Query            query.Query              `json:"query"`
-	Size             int                      `json:"size"`
-	From             int                      `json:"from"`
-	Highlight        []string                 `json:"highlight"`
-	Fields           []string                 `json:"fields"`
-	Facets           map[string]*FacetRequest `json:"facets"`
-	Explain          bool                     `json:"explain"`
-	Sort             []string                 `json:"sort"`
-	IncludeLocations bool                     `json:"includeLocations"`
-	SearchAfter      []string                 `json:"search_after"`
-	SearchBefore     []string                 `json:"search_before"`
+	Query            query.Query                    `json:"query"`
+	Size             int                            `json:"size"`
+	From             int                            `json:"from"`
+	Highlight        []string                       `json:"highlight"`
+	Fields           []string                       `json:"fields"`
+	Facets           map[string]*FacetRequest       `json:"facets"`
+	Explain          bool                           `json:"explain"`
+	Sort             []string                       `json:"sort"`
+	IncludeLocations bool                           `json:"includeLocations"`
+	SearchAfter      []string                       `json:"search_after"`
+	SearchBefore     []string                       `json:"search_before"`
+	Aggregations     map[string]*AggregationRequest `json:"aggregations,omitempty"`
+	Source           *SourceFilter                  `json:"_source,omitempty"`
 }
 
 func (r *SearchRequest) UnmarshalJSON(input []byte) error {
 	var temp struct {
-		Q                stdjson.RawMessage       `json:"query"`
-		Size             int                      `json:"size"`
-		From             int                      `json:"from"`
-		Highlight        []string                 `json:"highlight"`
-		Fields           []string                 `json:"fields"`
-		Facets           map[string]*FacetRequest `json:"facets"`
-		Explain          bool                     `json:"explain"`
-		Sort             []string                 `json:"sort"`
-		IncludeLocations bool                     `json:"includeLocations"`
-		SearchAfter      []string                 `json:"search_after"`
-		SearchBefore     []string                 `json:"search_before"`
+		Q                stdjson.RawMessage             `json:"query"`
+		Size             int                            `json:"size"`
+		From             int                            `json:"from"`
+		Highlight        []string                       `json:"highlight"`
+		Fields           []string                       `json:"fields"`
+		Facets           map[string]*FacetRequest       `json:"facets"`
+		Explain          bool                           `json:"explain"`
+		Sort             []string                       `json:"sort"`
+		IncludeLocations bool                           `json:"includeLocations"`
+		SearchAfter      []string                       `json:"search_after"`
+		SearchBefore     []string                       `json:"search_before"`
+		Aggregations     map[string]*AggregationRequest `json:"aggregations"`
+		Aggs             map[string]*AggregationRequest `json:"aggs"`
+		Source           *SourceFilter                  `json:"_source"`
 	}
 	err := json.Unmarshal(input, &temp)
 	if err != nil {
@@ -54,8 +59,15 @@ func (r *SearchRequest) UnmarshalJSON(input []byte) error {
 	r.SearchAfter = temp.SearchAfter
 	r.SearchBefore = temp.SearchBefore
 	r.Sort = temp.Sort
-	r.Query, err = query.ParseQuery(temp.Q)
-	if err != nil {
+	r.Source = temp.Source
+	r.Aggregations = temp.Aggregations
+	if r.Aggregations == nil {
+		r.Aggregations = temp.Aggs
+	}
+	// the query defaults to match all documents.
+	if len(temp.Q) == 0 || string(temp.Q) == "null" {
+		r.Query = query.NewMatchAllQuery()
+	} else if r.Query, err = ParseQuery(temp.Q); err != nil {
 		return err
 	}
 	if r.From < 0 {
@@ -15,6 +15,9 @@ type SearchResult struct {
 	MaxScore  float64                 `json:"max_score"`
 	Took      time.Duration           `json:"took"`
 	Facets    map[string]*FacetResult `json:"facets,omitempty"`
+	ScrollID  string                  `json:"_scroll_id,omitempty"`
+	// Aggregations are computed on all the matched documents across the shards.
+	Aggregations map[string]interface{} `json:"aggregations,omitempty"`
 }
 
 type Status struct {
@@ -2,6 +2,7 @@ package core
 
 import (
 	"github.com/blevesearch/bleve/v2"
+	"sync"
 )
 
 type IndexShard struct {
@@ -9,4 +10,27 @@ type IndexShard struct {
 	DocNum      uint64      `json:"doc_num"`      // doc's number in shard
 	StorageSize uint64      `json:"storage_size"` // shard file size
 	Indexer     bleve.Index `json:"-"`            // a shard map to a bleve index
+	seqNo       int64       // the last sequence number assigned to the writes in shard
+	mu          sync.Mutex  // serializes the writes, see executeWrites
+	translog    *translog   // records the writes before they are written to bleve
+}
+
+// close closes the translog and bleve index of shard, it waits for the running writes.
+func (shard *IndexShard) close() error {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.translog != nil {
+		if err := shard.translog.close(); err != nil {
+			return err
+		}
+		shard.translog = nil
+	}
+	if shard.Indexer == nil {
+		return nil
+	}
+	if err := shard.Indexer.Close(); err != nil {
+		return err
+	}
+	shard.Indexer = nil
+	return nil
 }
@@ -0,0 +1,767 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	bindex "github.com/blevesearch/bleve_index_api"
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"github.com/feimingxliu/quicksearch/pkg/util/uuid"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Repository is a local dir which stores the snapshots.
+type Repository struct {
+	Name     string             `json:"name"`
+	Type     string             `json:"type"` // only "fs" is supported
+	Settings RepositorySettings `json:"settings"`
+}
+
+type RepositorySettings struct {
+	Location string `json:"location"` // the relative location is under the data dir
+}
+
+const (
+	snapshotInProgress = "IN_PROGRESS"
+	snapshotSuccess    = "SUCCESS"
+	snapshotFailed     = "FAILED" // the snapshot interrupted by the restart
+)
+
+// Snapshot is a copy of the shards and the metadata of indices, it's stored in the repository as
+// <snapshot>/snapshot.json, <snapshot>/indices/<index>/index.json and <snapshot>/indices/<index>/shard_<id>.json
+// which lists the files of shard stored in the blobs, see blobStore.
+type Snapshot struct {
+	Snapshot    string    `json:"snapshot"`
+	UUID        string    `json:"uuid"`
+	Repository  string    `json:"repository"`
+	Indices     []string  `json:"indices"`
+	State       string    `json:"state"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Shards      int       `json:"shards"`  // the number of shards copied
+	DocNum      uint64    `json:"doc_num"` // the number of docs copied
+	StorageSize uint64    `json:"storage_size"`
+	Files       int       `json:"files"`     // the number of files in the snapshot
+	NewFiles    int       `json:"new_files"` // the number of files not stored by the previous snapshots
+	NewBytes    int64     `json:"new_bytes"` // the bytes of the new files
+}
+
+type SnapshotRequest struct {
+	Indices string `json:"indices"` // comma separated indices or aliases, all indices if empty
+}
+
+type RestoreRequest struct {
+	Indices           string `json:"indices"`            // comma separated indices in the snapshot, all indices if empty
+	RenamePattern     string `json:"rename_pattern"`     // the regexp matching the index names to rename
+	RenameReplacement string `json:"rename_replacement"` // the replacement of the rename pattern, e.g. "restored_$1"
+}
+
+type SnapshotStatus struct {
+	Indices []string `json:"indices"` // the indices to snapshot or restore
+	Total   int      `json:"total"`   // the number of shards to copy
+	Done    int      `json:"done"`    // the number of shards copied
+}
+
+// PutRepository registers or updates the repository, the location dir is created if not exists.
+func PutRepository(name string, repo *Repository) error {
+	if !validSnapshotName(name) {
+		return errors.ErrInvalidSnapshotName
+	}
+	if repo == nil || repo.Type != "fs" || len(repo.Settings.Location) == 0 {
+		return errors.ErrInvalidRepository
+	}
+	repo.Name = name
+	if err := os.MkdirAll(repo.dir(), 0755); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(repo)
+	return engine.repoMeta.Set(name, b)
+}
+
+func GetRepository(name string) (*Repository, error) {
+	b, err := engine.repoMeta.Get(name)
+	if err != nil {
+		if err == errors.ErrKeyNotFound || err == errors.ErrEmptyKey {
+			return nil, errors.ErrRepositoryNotFound
+		}
+		return nil, err
+	}
+	repo := new(Repository)
+	if err := json.Unmarshal(b, repo); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// ListRepositories returns all repositories sorted by name.
+func ListRepositories() ([]*Repository, error) {
+	repos := make([]*Repository, 0)
+	err := engine.repoMeta.Range(func(key string, value []byte) error {
+		repo := new(Repository)
+		if err := json.Unmarshal(value, repo); err != nil {
+			return err
+		}
+		repos = append(repos, repo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// DeleteRepository unregisters the repository, the snapshots in it are kept on disk.
+func DeleteRepository(name string) error {
+	if _, err := GetRepository(name); err != nil {
+		return err
+	}
+	return engine.repoMeta.Delete(name)
+}
+
+// CreateSnapshot starts a background task which copies the indices into the repository. The writes of an index
+// are blocked while its shard readers are taken, so the copy of each index is a point-in-time view across shards.
+// The snapshot is incremental, only the files not stored by the previous snapshots are written.
+// The closed indices are opened to copy, and closed again after copied.
+func CreateSnapshot(repoName, name string, req *SnapshotRequest) (_ *Task, err error) {
+	repo, err := GetRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+	if !validSnapshotName(name) || name == "blobs" {
+		return nil, errors.ErrInvalidSnapshotName
+	}
+	closed, err := closedIndices()
+	if err != nil {
+		return nil, err
+	}
+	// the resolution opens the closed indices.
+	defer func() {
+		if err != nil {
+			closeIndices(closed)
+		}
+	}()
+	indices, err := ResolveIndexList(req.Indices)
+	if err != nil {
+		return nil, err
+	}
+	dir := repo.snapshotDir(name)
+	job := snapshotJob(dir)
+	if !engine.reserveJob(job, repo.exclusiveJobs()) {
+		return nil, errors.ErrSnapshotInProgress
+	}
+	known, err := repo.knownFiles()
+	if err != nil {
+		engine.releaseJob(job)
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err == nil || !os.IsNotExist(err) {
+		engine.releaseJob(job)
+		if err == nil {
+			return nil, errors.ErrSnapshotAlreadyExists
+		}
+		return nil, err
+	}
+	snapshot := &Snapshot{
+		Snapshot:   name,
+		UUID:       uuid.GetXID(),
+		Repository: repoName,
+		Indices:    make([]string, 0, len(indices)),
+		State:      snapshotInProgress,
+		StartTime:  time.Now(),
+	}
+	status := new(SnapshotStatus)
+	for _, index := range indices {
+		snapshot.Indices = append(snapshot.Indices, index.Name)
+		status.Total += index.NumberOfShards
+	}
+	status.Indices = snapshot.Indices
+	if err := writeSnapshot(dir, snapshot); err != nil {
+		engine.releaseJob(job)
+		return nil, err
+	}
+	task := newTask("snapshot", fmt.Sprintf("snapshot %v to [%s/%s]", snapshot.Indices, repoName, name), status)
+	task.run(func(ctx context.Context) error {
+		defer engine.releaseJob(job)
+		defer closeIndices(closed)
+		store := repo.blobs()
+		err := func() error {
+			files := make([]*blobFile, 0)
+			for _, index := range indices {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				copied, manifests, err := index.snapshot(path.Join(dir, "indices", index.Name), store, known, func() {
+					task.update(func() {
+						status.Done++
+					})
+				})
+				if err != nil {
+					return fmt.Errorf("snapshot index [%s]: %w", index.Name, err)
+				}
+				snapshot.Shards += copied.NumberOfShards
+				snapshot.DocNum += copied.DocNum
+				snapshot.StorageSize += copied.StorageSize
+				for _, manifest := range manifests {
+					files = append(files, manifest.Files...)
+				}
+			}
+			for _, file := range files {
+				if snapshot.Files++; file.New {
+					snapshot.NewFiles++
+					snapshot.NewBytes += file.Size
+				}
+			}
+			// the blobs are referenced before the snapshot completes, so the deletion of the snapshot in any
+			// state never releases the blobs not referenced by it, see DeleteSnapshot.
+			if err := store.addRefs(files); err != nil {
+				return err
+			}
+			snapshot.State, snapshot.EndTime = snapshotSuccess, time.Now()
+			return writeSnapshot(dir, snapshot)
+		}()
+		if err != nil {
+			// the partial snapshot is useless, the blobs written are removed by the next deletion.
+			_ = os.RemoveAll(dir)
+		}
+		return err
+	})
+	return task, nil
+}
+
+// ListSnapshots returns the snapshots in the repository sorted by start time.
+func ListSnapshots(repoName string) ([]*Snapshot, error) {
+	repo, err := GetRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(repo.dir())
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*Snapshot, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		snapshot, err := repo.getSnapshot(entry.Name())
+		if err != nil {
+			if err == errors.ErrSnapshotNotFound || err == errors.ErrInvalidSnapshotName {
+				continue
+			}
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].StartTime.Before(snapshots[j].StartTime)
+	})
+	return snapshots, nil
+}
+
+func GetSnapshot(repoName, name string) (*Snapshot, error) {
+	repo, err := GetRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+	return repo.getSnapshot(name)
+}
+
+// DeleteSnapshot removes the snapshot from the repository and the blobs no longer referenced. It can't run with
+// the snapshots or restores in progress in the repository.
+func DeleteSnapshot(repoName, name string) error {
+	repo, err := GetRepository(repoName)
+	if err != nil {
+		return err
+	}
+	job, ok := repo.reserveExclusive(name)
+	if !ok {
+		return errors.ErrSnapshotInProgress
+	}
+	defer engine.releaseJob(job)
+	snapshot, err := repo.getSnapshot(name)
+	if err != nil {
+		return err
+	}
+	dir := repo.snapshotDir(name)
+	manifests, err := readManifests(dir)
+	if err != nil {
+		return err
+	}
+	// the snapshot is invisible once the snapshot.json removed, the blobs are leaked rather than released twice
+	// if the deletion is interrupted.
+	if err := os.Remove(path.Join(dir, "snapshot.json")); err != nil {
+		return err
+	}
+	files := make([]*blobFile, 0)
+	// only the completed snapshot references its blobs.
+	if snapshot.State == snapshotSuccess {
+		for _, manifest := range manifests {
+			files = append(files, manifest.Files...)
+		}
+	}
+	if _, err := repo.blobs().release(files); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// VerifyRepository starts a background task which re-hashes all blobs in the repository, and checks the blobs
+// referenced by the snapshots exist. It can't run with the snapshots or restores in progress in the repository.
+func VerifyRepository(repoName string) (*Task, error) {
+	repo, err := GetRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+	job, ok := repo.reserveExclusive("_verify")
+	if !ok {
+		return nil, errors.ErrSnapshotInProgress
+	}
+	manifests, err := repo.manifests()
+	if err != nil {
+		engine.releaseJob(job)
+		return nil, err
+	}
+	status := new(VerifyStatus)
+	task := newTask("verify_repository", fmt.Sprintf("verify the blobs of [%s]", repoName), status)
+	task.run(func(ctx context.Context) error {
+		defer engine.releaseJob(job)
+		return repo.blobs().verify(ctx, task, status, manifests)
+	})
+	return task, nil
+}
+
+// RestoreSnapshot starts a background task which restores the indices from the snapshot. The indices are renamed
+// by the rename pattern if given, and the restored indices must not exist.
+func RestoreSnapshot(repoName, name string, req *RestoreRequest) (*Task, error) {
+	repo, err := GetRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := repo.getSnapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.State != snapshotSuccess {
+		return nil, fmt.Errorf("can't restore the snapshot in state [%s]", snapshot.State)
+	}
+	sources := splitFields(req.Indices)
+	if len(sources) == 0 {
+		sources = snapshot.Indices
+	}
+	var rename *regexp.Regexp
+	if len(req.RenamePattern) > 0 {
+		if rename, err = regexp.Compile(req.RenamePattern); err != nil {
+			return nil, err
+		}
+	}
+	contained := make(map[string]bool, len(snapshot.Indices))
+	for _, index := range snapshot.Indices {
+		contained[index] = true
+	}
+	targets := make([]string, 0, len(sources))
+	seen := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		if !contained[source] {
+			return nil, fmt.Errorf("the index [%s] is not in the snapshot", source)
+		}
+		target := source
+		if rename != nil {
+			target = rename.ReplaceAllString(source, req.RenameReplacement)
+		}
+		if !validIndexName(target) {
+			return nil, fmt.Errorf("%w: [%s]", errors.ErrInvalidIndexName, target)
+		}
+		if seen[target] || indexExists(target) {
+			return nil, fmt.Errorf("%w: [%s]", errors.ErrIndexAlreadyExists, target)
+		}
+		if engine.getAlias(target) != nil {
+			return nil, errors.ErrIndexAliasConflict
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+	dir := repo.snapshotDir(name)
+	// the snapshot can't be deleted and the targets can't be restored again during restoring.
+	jobs := []string{snapshotJob(dir)}
+	for _, target := range targets {
+		jobs = append(jobs, "restore:"+target)
+	}
+	for i, job := range jobs {
+		if !engine.reserveJob(job, repo.exclusiveJobs()) {
+			engine.releaseJob(jobs[:i]...)
+			return nil, errors.ErrSnapshotInProgress
+		}
+	}
+	status := &SnapshotStatus{Indices: targets}
+	for _, source := range sources {
+		b, err := os.ReadFile(path.Join(dir, "indices", source, "index.json"))
+		if err != nil {
+			engine.releaseJob(jobs...)
+			return nil, err
+		}
+		index := new(Index)
+		if err := json.Unmarshal(b, index); err != nil {
+			engine.releaseJob(jobs...)
+			return nil, err
+		}
+		status.Total += index.NumberOfShards
+	}
+	task := newTask("restore", fmt.Sprintf("restore %v from [%s/%s]", targets, repoName, name), status)
+	task.run(func(ctx context.Context) error {
+		defer engine.releaseJob(jobs...)
+		for i, source := range sources {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := restoreIndex(path.Join(dir, "indices", source), targets[i], repo.blobs(), func() {
+				task.update(func() {
+					status.Done++
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("restore index [%s] to [%s]: %w", source, targets[i], err)
+			}
+		}
+		return nil
+	})
+	return task, nil
+}
+
+// snapshot copies the shards and the metadata of index to dir, the files of shards are written to the store and
+// listed by the manifests. The done is called after each shard copied.
+func (index *Index) snapshot(dir string, store *blobStore, known map[string]string, done func()) (*Index, []*shardManifest, error) {
+	// open the index first if it is closed by others meanwhile.
+	if err := index.Open(); err != nil {
+		return nil, nil, err
+	}
+	if err := index.UpdateMetadata(); err != nil {
+		return nil, nil, err
+	}
+	// the readers are copied without index.mu, so the copy doesn't block the index.
+	shards, err := index.pinShards()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closePinnedShards(shards)
+	index.mu.RLock()
+	metadata, _ := json.Marshal(index)
+	index.mu.RUnlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	manifests := make([]*shardManifest, 0, len(index.Shards))
+	for i, shard := range index.Shards {
+		copyable, ok := shards[i].reader.(bleve.IndexCopyable)
+		if !ok {
+			return nil, nil, errors.ErrIndexCloneNotSupported
+		}
+		d := store.newDirectory(index.shardDir(shard.ID), index.UID, shard.ID, known)
+		err := copyable.CopyTo(d)
+		// the reader doesn't copy the index meta file of bleve.
+		if err == nil {
+			err = d.addFile("index_meta.json", path.Join(index.shardDir(shard.ID), "index_meta.json"))
+		}
+		var manifest *shardManifest
+		if err == nil {
+			manifest, err = d.finish()
+		}
+		if err != nil {
+			d.abort()
+			return nil, nil, err
+		}
+		b, _ := json.Marshal(manifest)
+		if err := os.WriteFile(path.Join(dir, manifestName(shard.ID)), b, 0644); err != nil {
+			return nil, nil, err
+		}
+		manifests = append(manifests, manifest)
+		done()
+	}
+	if err := os.WriteFile(path.Join(dir, "index.json"), metadata, 0644); err != nil {
+		return nil, nil, err
+	}
+	copied := new(Index)
+	if err := json.Unmarshal(metadata, copied); err != nil {
+		return nil, nil, err
+	}
+	return copied, manifests, nil
+}
+
+// closedIndices returns the names of the indices which are closed.
+func closedIndices() (map[string]bool, error) {
+	indices, err := ListIndices()
+	if err != nil {
+		return nil, err
+	}
+	closed := make(map[string]bool)
+	for _, index := range indices {
+		if engine.getIndex(index.Name) == nil {
+			closed[index.Name] = true
+		}
+	}
+	return closed, nil
+}
+
+// closeIndices closes the indices in names if they are opened, the errors are ignored as the indices are
+// opened again on the next access.
+func closeIndices(names map[string]bool) {
+	for name := range names {
+		if index := engine.getIndex(name); index != nil {
+			_ = index.Close()
+		}
+	}
+}
+
+// shardReaders takes the readers of all shards with the writes blocked, the caller must hold index.mu.
+func (index *Index) shardReaders() ([]bindex.IndexReader, error) {
+	for _, shard := range index.Shards {
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+	}
+	readers := make([]bindex.IndexReader, 0, len(index.Shards))
+	for _, shard := range index.Shards {
+		var reader bindex.IndexReader
+		err := errors.ErrIndexClosed
+		if shard.Indexer != nil {
+			reader, err = shardReader(shard.Indexer)
+		}
+		if err != nil {
+			closeReaders(readers)
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+	return readers, nil
+}
+
+func shardReader(indexer bleve.Index) (bindex.IndexReader, error) {
+	advanced, err := indexer.Advanced()
+	if err != nil {
+		return nil, err
+	}
+	return advanced.Reader()
+}
+
+func closeReaders(readers []bindex.IndexReader) {
+	for _, reader := range readers {
+		_ = reader.Close()
+	}
+}
+
+// restoreIndex restores the index copied in dir as the index with name, done is called after each shard restored.
+func restoreIndex(dir, name string, store *blobStore, done func()) error {
+	b, err := os.ReadFile(path.Join(dir, "index.json"))
+	if err != nil {
+		return err
+	}
+	index := new(Index)
+	if err := json.Unmarshal(b, index); err != nil {
+		return err
+	}
+	index.UID, index.Name, index.UpdateAt = uuid.GetXID(), name, time.Now()
+	for _, shard := range index.Shards {
+		if err := restoreShard(path.Join(dir, manifestName(shard.ID)), store, index.shardDir(shard.ID)); err != nil {
+			_ = os.RemoveAll(index.dir())
+			return err
+		}
+		done()
+	}
+	if err := index.Open(); err != nil {
+		_ = index.Delete()
+		_ = os.RemoveAll(index.dir())
+		return err
+	}
+	return nil
+}
+
+// restoreShard copies the files listed by the manifest from the store to the shard dir.
+func restoreShard(manifestPath string, store *blobStore, dir string) error {
+	b, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	manifest := new(shardManifest)
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if err := store.copyTo(file.Blob, path.Join(dir, file.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func manifestName(shard int) string {
+	return fmt.Sprintf("shard_%d.json", shard)
+}
+
+// readManifests reads the manifests of all shards in the snapshot dir.
+func readManifests(dir string) ([]*shardManifest, error) {
+	names, err := filepath.Glob(path.Join(dir, "indices", "*", "shard_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	manifests := make([]*shardManifest, 0, len(names))
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		manifest := new(shardManifest)
+		if err := json.Unmarshal(b, manifest); err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// manifests returns the manifests of the completed snapshots in the repository.
+func (r *Repository) manifests() ([]*shardManifest, error) {
+	snapshots, err := ListSnapshots(r.Name)
+	if err != nil {
+		return nil, err
+	}
+	manifests := make([]*shardManifest, 0)
+	for _, snapshot := range snapshots {
+		if snapshot.State != snapshotSuccess {
+			continue
+		}
+		list, err := readManifests(r.snapshotDir(snapshot.Snapshot))
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, list...)
+	}
+	return manifests, nil
+}
+
+// knownFiles returns the files stored by the completed snapshots, see blobDirectory.
+func (r *Repository) knownFiles() (map[string]string, error) {
+	manifests, err := r.manifests()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]string)
+	for _, manifest := range manifests {
+		for _, file := range manifest.Files {
+			known[knownKey(manifest.UID, manifest.Shard, file.Name, file.Size)] = file.Blob
+		}
+	}
+	return known, nil
+}
+
+func (r *Repository) blobs() *blobStore {
+	return newBlobStore(r.dir())
+}
+
+// exclusiveJobs returns the prefix of the jobs which run exclusively in the repository, i.e. the deletion and
+// verification. The snapshots and restores run concurrently.
+func (r *Repository) exclusiveJobs() string {
+	return "exclusive:" + r.dir() + "/"
+}
+
+// reserveExclusive reserves the exclusive job with name, which conflicts with any other job in the repository.
+func (r *Repository) reserveExclusive(name string) (string, bool) {
+	job := r.exclusiveJobs() + name
+	return job, engine.reserveJob(job, r.exclusiveJobs(), snapshotJob(r.dir()+"/"))
+}
+
+func (r *Repository) dir() string {
+	if path.IsAbs(r.Settings.Location) {
+		return r.Settings.Location
+	}
+	return path.Join(config.Global.Storage.DataDir, r.Settings.Location)
+}
+
+func (r *Repository) snapshotDir(name string) string {
+	return path.Join(r.dir(), name)
+}
+
+func (r *Repository) getSnapshot(name string) (*Snapshot, error) {
+	if !validSnapshotName(name) {
+		return nil, errors.ErrInvalidSnapshotName
+	}
+	dir := r.snapshotDir(name)
+	b, err := os.ReadFile(path.Join(dir, "snapshot.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.ErrSnapshotNotFound
+		}
+		return nil, err
+	}
+	snapshot := new(Snapshot)
+	if err := json.Unmarshal(b, snapshot); err != nil {
+		return nil, err
+	}
+	// the repository may be registered with another name.
+	snapshot.Repository = r.Name
+	if snapshot.State == snapshotInProgress && !engine.isJobRunning(snapshotJob(dir)) {
+		snapshot.State = snapshotFailed
+	}
+	return snapshot, nil
+}
+
+// writeSnapshot writes the snapshot info to dir atomically.
+func writeSnapshot(dir string, snapshot *Snapshot) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(snapshot)
+	tmp := path.Join(dir, "snapshot.json.tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(dir, "snapshot.json"))
+}
+
+func snapshotJob(dir string) string {
+	return "snapshot:" + dir
+}
+
+// validSnapshotName reports whether the name can be used as a repository or snapshot name, which is also a dir name.
+func validSnapshotName(name string) bool {
+	return len(name) > 0 && !strings.HasPrefix(name, "_") && !strings.HasPrefix(name, ".") &&
+		!strings.ContainsAny(name, `/\,*?"<>| #`)
+}
+
+// reserveJob marks the job as running, it returns false if the job or any job with the conflicting prefixes
+// is already running.
+func (e *Engine) reserveJob(job string, conflicts ...string) bool {
+	e.jobMu.Lock()
+	defer e.jobMu.Unlock()
+	if e.jobs[job] {
+		return false
+	}
+	for running := range e.jobs {
+		for _, prefix := range conflicts {
+			if strings.HasPrefix(running, prefix) {
+				return false
+			}
+		}
+	}
+	e.jobs[job] = true
+	return true
+}
+
+func (e *Engine) releaseJob(jobs ...string) {
+	e.jobMu.Lock()
+	for _, job := range jobs {
+		delete(e.jobs, job)
+	}
+	e.jobMu.Unlock()
+}
+
+func (e *Engine) isJobRunning(job string) bool {
+	e.jobMu.Lock()
+	defer e.jobMu.Unlock()
+	return e.jobs[job]
+}
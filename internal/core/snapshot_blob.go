@@ -0,0 +1,463 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"github.com/feimingxliu/quicksearch/pkg/util/uuid"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// blobStore stores the files of snapshots in <location>/blobs content-addressed by the sha256, so the files shared
+// by snapshots are stored once. The blobs are counted by the references of the completed snapshots in refs.json.
+type blobStore struct {
+	dir string
+}
+
+// shardManifest lists the files of a shard in a snapshot, it's stored as <snapshot>/indices/<index>/shard_<id>.json.
+type shardManifest struct {
+	UID   string      `json:"uid"` // the uid of the index snapshotted
+	Shard int         `json:"shard"`
+	Files []*blobFile `json:"files"`
+}
+
+type blobFile struct {
+	Name string `json:"name"` // the path relative to the shard dir
+	Blob string `json:"blob"` // the sha256 of the content
+	Size int64  `json:"size"`
+	New  bool   `json:"-"` // whether the blob is written by this snapshot
+}
+
+type VerifyStatus struct {
+	Total        int      `json:"total"`    // the number of blobs to verify
+	Verified     int      `json:"verified"` // the number of blobs verified
+	Bytes        int64    `json:"bytes"`    // the bytes of blobs verified
+	Corrupted    []string `json:"corrupted"`
+	Missing      []string `json:"missing"`      // the blobs referenced by the snapshots but not found
+	Unreferenced []string `json:"unreferenced"` // the blobs left by the failed snapshots, removed by the next deletion
+}
+
+func newBlobStore(location string) *blobStore {
+	return &blobStore{dir: path.Join(location, "blobs")}
+}
+
+func (s *blobStore) path(blob string) string {
+	return path.Join(s.dir, blob[:2], blob)
+}
+
+func (s *blobStore) exists(blob string) bool {
+	_, err := os.Stat(s.path(blob))
+	return err == nil
+}
+
+func (s *blobStore) tmpDir() string {
+	return path.Join(s.dir, "tmp")
+}
+
+func (s *blobStore) createTmp() (*os.File, error) {
+	if err := os.MkdirAll(s.tmpDir(), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path.Join(s.tmpDir(), uuid.GetXID()))
+}
+
+// put moves the tmp file to the blob, the tmp file is removed if the blob exists.
+func (s *blobStore) put(tmp, blob string) (bool, error) {
+	if s.exists(blob) {
+		return false, os.Remove(tmp)
+	}
+	if err := os.MkdirAll(path.Dir(s.path(blob)), 0755); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, s.path(blob))
+}
+
+func (s *blobStore) refsPath() string {
+	return path.Join(s.dir, "refs.json")
+}
+
+func (s *blobStore) loadRefs() (map[string]int, error) {
+	refs := make(map[string]int)
+	b, err := os.ReadFile(s.refsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func (s *blobStore) saveRefs(refs map[string]int) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(refs)
+	tmp := s.refsPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.refsPath())
+}
+
+// addRefs increases the references of the files, it's called before the snapshot completes.
+func (s *blobStore) addRefs(files []*blobFile) error {
+	engine.refsMu.Lock()
+	defer engine.refsMu.Unlock()
+	refs, err := s.loadRefs()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		refs[file.Blob]++
+	}
+	return s.saveRefs(refs)
+}
+
+// release decreases the references of the files and removes the blobs no longer referenced, including the ones
+// left by the failed snapshots. It must not run with the snapshots in progress. It returns the number of removed blobs.
+func (s *blobStore) release(files []*blobFile) (int, error) {
+	engine.refsMu.Lock()
+	defer engine.refsMu.Unlock()
+	refs, err := s.loadRefs()
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if refs[file.Blob]--; refs[file.Blob] <= 0 {
+			delete(refs, file.Blob)
+		}
+	}
+	// the refs are saved first, the blobs failed to remove are left unreferenced.
+	if err := s.saveRefs(refs); err != nil {
+		return 0, err
+	}
+	blobs, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, blob := range blobs {
+		if refs[blob] > 0 {
+			continue
+		}
+		if err := os.Remove(s.path(blob)); err != nil {
+			return removed, err
+		}
+		removed++
+		// the dir is removed once empty.
+		_ = os.Remove(path.Dir(s.path(blob)))
+	}
+	return removed, os.RemoveAll(s.tmpDir())
+}
+
+// list returns all blobs sorted.
+func (s *blobStore) list() ([]string, error) {
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	blobs := make([]string, 0)
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		entries, err := os.ReadDir(path.Join(s.dir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), dir.Name()) {
+				blobs = append(blobs, entry.Name())
+			}
+		}
+	}
+	sort.Strings(blobs)
+	return blobs, nil
+}
+
+// copyTo copies the blob to dst, and verifies the content.
+func (s *blobStore) copyTo(blob, dst string) error {
+	src, err := os.Open(s.path(blob))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), src); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != blob {
+		return fmt.Errorf("the blob [%s] is corrupted", blob)
+	}
+	return nil
+}
+
+// verify re-hashes all blobs, and checks the blobs referenced by manifests exist.
+func (s *blobStore) verify(ctx context.Context, task *Task, status *VerifyStatus, manifests []*shardManifest) error {
+	blobs, err := s.list()
+	if err != nil {
+		return err
+	}
+	refs, err := s.loadRefs()
+	if err != nil {
+		return err
+	}
+	task.update(func() {
+		status.Total = len(blobs)
+	})
+	found := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		found[blob] = true
+		sum, n, err := hashFile(s.path(blob))
+		if err != nil {
+			return err
+		}
+		task.update(func() {
+			status.Verified++
+			status.Bytes += n
+			if sum != blob {
+				status.Corrupted = append(status.Corrupted, blob)
+			}
+			if refs[blob] <= 0 {
+				status.Unreferenced = append(status.Unreferenced, blob)
+			}
+		})
+	}
+	missing := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, file := range manifest.Files {
+			if !found[file.Blob] && !missing[file.Blob] {
+				missing[file.Blob] = true
+				task.update(func() {
+					status.Missing = append(status.Missing, file.Blob)
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func hashFile(name string) (string, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// blobDirectory is the bleve index.Directory which writes the files of a shard to the blob store. The segment files
+// stored by the previous snapshots are looked up in known by "<uid>/<shard>/<name>/<size>", since they are immutable,
+// they are hashed and verified but not written again. The other files are hashed while written to the tmp files,
+// which are dropped on close if the blobs exist, so each file is read once.
+type blobDirectory struct {
+	store  *blobStore
+	source string // the shard dir
+	uid    string
+	shard  int
+	known  map[string]string
+	files  []*blobFile
+	tmps   map[string]*os.File // the files which must be written as *os.File, e.g. store/root.bolt
+	err    error               // the first error occurred in closing the writers
+	mu     sync.Mutex
+}
+
+func (s *blobStore) newDirectory(source, uid string, shard int, known map[string]string) *blobDirectory {
+	return &blobDirectory{
+		store:  s,
+		source: source,
+		uid:    uid,
+		shard:  shard,
+		known:  known,
+		tmps:   make(map[string]*os.File),
+	}
+}
+
+func (d *blobDirectory) GetWriter(name string) (io.WriteCloser, error) {
+	// the root bolt is opened by name.
+	if name == path.Join("store", "root.bolt") {
+		f, err := d.store.createTmp()
+		if err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		d.tmps[name] = f
+		d.mu.Unlock()
+		return f, nil
+	}
+	w := &blobWriter{dir: d, name: name, hash: sha256.New(), expected: d.storedBlob(name)}
+	if len(w.expected) == 0 {
+		f, err := d.store.createTmp()
+		if err != nil {
+			return nil, err
+		}
+		w.file = f
+	}
+	return w, nil
+}
+
+// storedBlob returns the blob of the file in the source shard dir if it's stored by the previous snapshots.
+func (d *blobDirectory) storedBlob(name string) string {
+	info, err := os.Stat(path.Join(d.source, name))
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	d.mu.Lock()
+	blob := d.known[knownKey(d.uid, d.shard, name, info.Size())]
+	d.mu.Unlock()
+	if len(blob) == 0 || !d.store.exists(blob) {
+		return ""
+	}
+	return blob
+}
+
+// addFile adds the file in the local path to the store.
+func (d *blobDirectory) addFile(name, local string) error {
+	sum, n, err := hashFile(local)
+	if err != nil {
+		return err
+	}
+	file := &blobFile{Name: name, Blob: sum, Size: n}
+	if !d.store.exists(sum) {
+		f, err := d.store.createTmp()
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(local)
+		if err == nil {
+			_, err = io.Copy(f, src)
+			_ = src.Close()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			file.New, err = d.store.put(f.Name(), sum)
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return err
+		}
+	}
+	d.mu.Lock()
+	d.files = append(d.files, file)
+	d.mu.Unlock()
+	return nil
+}
+
+// finish puts the tmp files written by bleve to the store and returns the manifest.
+func (d *blobDirectory) finish() (*shardManifest, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	for name, f := range d.tmps {
+		if err := d.addFile(name, f.Name()); err != nil {
+			return nil, err
+		}
+		_ = os.Remove(f.Name())
+		delete(d.tmps, name)
+	}
+	sort.Slice(d.files, func(i, j int) bool {
+		return d.files[i].Name < d.files[j].Name
+	})
+	return &shardManifest{UID: d.uid, Shard: d.shard, Files: d.files}, nil
+}
+
+func knownKey(uid string, shard int, name string, size int64) string {
+	return fmt.Sprintf("%s/%d/%s/%d", uid, shard, name, size)
+}
+
+// abort removes the tmp files, the blobs written are removed by the next deletion of snapshot.
+func (d *blobDirectory) abort() {
+	for _, f := range d.tmps {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+}
+
+func (d *blobDirectory) fail(err error) {
+	d.mu.Lock()
+	if d.err == nil {
+		d.err = err
+	}
+	d.mu.Unlock()
+}
+
+// blobWriter hashes the content written, and writes it to a tmp file unless the blob is known to be stored.
+// The tmp file is renamed to the blob by the digest on close, or removed if the blob exists.
+type blobWriter struct {
+	dir      *blobDirectory
+	name     string
+	hash     hash.Hash
+	expected string   // the blob already stored
+	file     *os.File // the tmp file
+	size     int64
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	w.hash.Write(p)
+	w.size += int64(len(p))
+	if w.file != nil {
+		return w.file.Write(p)
+	}
+	return len(p), nil
+}
+
+// Close puts the tmp file to the store, the error is recorded in the directory since bleve ignores it.
+func (w *blobWriter) Close() error {
+	sum := hex.EncodeToString(w.hash.Sum(nil))
+	file := &blobFile{Name: w.name, Blob: sum, Size: w.size}
+	var err error
+	if w.file != nil {
+		if err = w.file.Close(); err == nil {
+			file.New, err = w.dir.store.put(w.file.Name(), sum)
+		}
+		if err != nil {
+			_ = os.Remove(w.file.Name())
+		}
+	} else if sum != w.expected {
+		err = fmt.Errorf("the file [%s] changed during snapshot", w.name)
+	}
+	if err != nil {
+		w.dir.fail(err)
+		return err
+	}
+	w.dir.mu.Lock()
+	w.dir.files = append(w.dir.files, file)
+	w.dir.mu.Unlock()
+	return nil
+}
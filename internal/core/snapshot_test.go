@@ -0,0 +1,300 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredName := "restored_" + indexName
+	defer func() {
+		log.Println("Delete Index.")
+		for _, name := range []string{indexName, restoredName} {
+			if index, err := GetIndex(name); err == nil {
+				if err := index.Delete(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := DeleteRepository("backup"); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	docs := make([]map[string]interface{}, 0, 100)
+	for i := 0; i < 100; i++ {
+		docs = append(docs, map[string]interface{}{"title": fmt.Sprintf("doc %d", i)})
+	}
+	if err := index.BulkIndex(docs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.IndexOrUpdateDocument("updated", map[string]interface{}{"title": "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.IndexOrUpdateDocument("updated", map[string]interface{}{"title": "v2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PutRepository("backup", &Repository{Type: "s3"}); err != qerrors.ErrInvalidRepository {
+		t.Errorf("put the invalid repository: %v", err)
+	}
+	if err := PutRepository("backup", &Repository{Type: "fs", Settings: RepositorySettings{Location: t.TempDir()}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateSnapshot("unknown", "snap-1", &SnapshotRequest{}); err != qerrors.ErrRepositoryNotFound {
+		t.Errorf("snapshot to the unknown repository: %v", err)
+	}
+	task, err := CreateSnapshot("backup", "snap-1", &SnapshotRequest{Indices: indexName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	json.Print("snapshot task", task)
+	if task.Error != "" {
+		t.Fatal(task.Error)
+	}
+	if _, err := CreateSnapshot("backup", "snap-1", &SnapshotRequest{}); err != qerrors.ErrSnapshotAlreadyExists {
+		t.Errorf("snapshot with the existing name: %v", err)
+	}
+	snapshot, err := GetSnapshot("backup", "snap-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.State != snapshotSuccess || snapshot.Shards != 3 || snapshot.DocNum != 101 {
+		t.Errorf("got snapshot: %+v", snapshot)
+	}
+	// the closed index is closed again after the snapshot.
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if task, err = CreateSnapshot("backup", "snap-closed", &SnapshotRequest{Indices: indexName}); err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	if task.Error != "" {
+		t.Fatal(task.Error)
+	}
+	if !index.IsClosed() {
+		t.Error("the closed index is opened by the snapshot")
+	}
+	if err := index.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteSnapshot("backup", "snap-closed"); err != nil {
+		t.Fatal(err)
+	}
+	// the writes after the snapshot are not restored.
+	if _, err := index.IndexOrUpdateDocument("later", map[string]interface{}{"title": "later"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RestoreSnapshot("backup", "snap-1", &RestoreRequest{}); !errors.Is(err, qerrors.ErrIndexAlreadyExists) {
+		t.Errorf("restore to the existing index: %v", err)
+	}
+	if _, err := RestoreSnapshot("backup", "snap-1", &RestoreRequest{RenamePattern: "(.+)", RenameReplacement: "../$1"}); !errors.Is(err, qerrors.ErrInvalidIndexName) {
+		t.Errorf("restore to the index outside the data dir: %v", err)
+	}
+	task, err = RestoreSnapshot("backup", "snap-1", &RestoreRequest{RenamePattern: "(.+)", RenameReplacement: "restored_$1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	if task.Error != "" {
+		t.Fatal(task.Error)
+	}
+	restored, err := GetIndex(restoredName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := countDocuments(nil, restored); err != nil || n != 101 {
+		t.Errorf("restored index has %d documents, expected 101", n)
+	}
+	doc, err := restored.GetDocument("updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 2 || doc.Source.(map[string]interface{})["title"] != "v2" {
+		t.Errorf("got restored document: %+v", doc)
+	}
+	if _, err := restored.GetDocument("later"); err == nil {
+		t.Error("the document written after the snapshot is restored")
+	}
+	// the restored index accepts the writes.
+	res, err := restored.IndexOrUpdateDocument("updated", map[string]interface{}{"title": "v3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != 3 {
+		t.Errorf("got version %d after restored", res.Version)
+	}
+
+	// restore the deleted index with its own name.
+	if err := index.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if task, err = RestoreSnapshot("backup", "snap-1", &RestoreRequest{Indices: indexName}); err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	if task.Error != "" {
+		t.Fatal(task.Error)
+	}
+	if index, err = GetIndex(indexName); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := countDocuments(nil, index); err != nil || n != 101 {
+		t.Errorf("restored index has %d documents, expected 101", n)
+	}
+
+	snapshots, err := ListSnapshots("backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Snapshot != "snap-1" {
+		t.Errorf("got %d snapshots", len(snapshots))
+	}
+	if err := DeleteSnapshot("backup", "snap-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSnapshot("backup", "snap-1"); err != qerrors.ErrSnapshotNotFound {
+		t.Errorf("get the deleted snapshot: %v", err)
+	}
+}
+
+func TestIncrementalSnapshot(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		for _, name := range []string{indexName, "restored"} {
+			if index, err := GetIndex(name); err == nil {
+				if err := index.Delete(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := DeleteRepository("backup"); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	location := t.TempDir()
+	if err := PutRepository("backup", &Repository{Type: "fs", Settings: RepositorySettings{Location: location}}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := func(name string) *Snapshot {
+		task, err := CreateSnapshot("backup", name, &SnapshotRequest{Indices: indexName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		task.Wait()
+		if task.Error != "" {
+			t.Fatal(task.Error)
+		}
+		snapshot, err := GetSnapshot("backup", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.Print("snapshot "+name, snapshot)
+		return snapshot
+	}
+	verify := func() *VerifyStatus {
+		task, err := VerifyRepository("backup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		task.Wait()
+		if task.Error != "" {
+			t.Fatal(task.Error)
+		}
+		return task.Status.(*VerifyStatus)
+	}
+	docs := make([]map[string]interface{}, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		docs = append(docs, map[string]interface{}{"title": fmt.Sprintf("doc %d", i)})
+	}
+	if err := index.BulkIndex(docs); err != nil {
+		t.Fatal(err)
+	}
+	first := snapshot("snap-1")
+	if first.NewFiles == 0 || first.NewBytes == 0 {
+		t.Errorf("the first snapshot writes nothing: %+v", first)
+	}
+	// nothing changed except the root bolt.
+	second := snapshot("snap-2")
+	if second.Files != first.Files || second.NewFiles > index.NumberOfShards || second.NewBytes >= first.NewBytes {
+		t.Errorf("the second snapshot is not incremental: %+v", second)
+	}
+	if _, err := index.IndexOrUpdateDocument("new", map[string]interface{}{"title": "new doc"}); err != nil {
+		t.Fatal(err)
+	}
+	third := snapshot("snap-3")
+	if third.DocNum != 1001 || third.NewBytes >= first.NewBytes {
+		t.Errorf("the third snapshot is not incremental: %+v", third)
+	}
+
+	store := newBlobStore(location)
+	blobs, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := verify(); status.Verified != len(blobs) || len(status.Corrupted)+len(status.Missing)+len(status.Unreferenced) != 0 {
+		t.Errorf("verify the repository: %+v", status)
+	}
+	// the blobs shared with the later snapshots are kept.
+	if err := DeleteSnapshot("backup", "snap-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteSnapshot("backup", "snap-2"); err != nil {
+		t.Fatal(err)
+	}
+	left, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) >= len(blobs) {
+		t.Errorf("%d of %d blobs left after deleted", len(left), len(blobs))
+	}
+	task, err := RestoreSnapshot("backup", "snap-3", &RestoreRequest{RenamePattern: ".+", RenameReplacement: "restored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	if task.Error != "" {
+		t.Fatal(task.Error)
+	}
+	restored, err := GetIndex("restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := countDocuments(nil, restored); err != nil || n != 1001 {
+		t.Errorf("restored index has %d documents, expected 1001", n)
+	}
+
+	// corrupt a blob.
+	if err := os.WriteFile(store.path(left[0]), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status := verify(); len(status.Corrupted) != 1 || status.Corrupted[0] != left[0] {
+		t.Errorf("verify the corrupted repository: %+v", status)
+	}
+	if err := DeleteSnapshot("backup", "snap-3"); err != nil {
+		t.Fatal(err)
+	}
+	if left, err = store.list(); err != nil || len(left) != 0 {
+		t.Errorf("%d blobs left after all snapshots deleted: %v", len(left), err)
+	}
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	stdjson "encoding/json"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"github.com/feimingxliu/quicksearch/pkg/util/maps"
+	"strings"
+)
+
+// SourceFilter controls which fields of `_source` are returned. It can be unmarshalled from
+// a bool(whether returns `_source`), a comma separated string or an array of the fields to include,
+// or an object like {"includes": [...], "excludes": [...]}. The fields support wildcard "*".
+type SourceFilter struct {
+	Disabled bool     `json:"-"`
+	Includes []string `json:"includes,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+}
+
+// NewSourceFilter parses the filter from comma separated strings,
+// the source is disabled if the source is "false".
+func NewSourceFilter(source, includes, excludes string) *SourceFilter {
+	if len(source) == 0 && len(includes) == 0 && len(excludes) == 0 {
+		return nil
+	}
+	filter := &SourceFilter{Includes: splitFields(includes), Excludes: splitFields(excludes)}
+	switch source {
+	case "", "true":
+	case "false":
+		filter.Disabled = true
+	default:
+		filter.Includes = append(filter.Includes, splitFields(source)...)
+	}
+	return filter
+}
+
+func (f *SourceFilter) UnmarshalJSON(input []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(input, &enabled); err == nil {
+		f.Disabled = !enabled
+		return nil
+	}
+	var fields string
+	if err := json.Unmarshal(input, &fields); err == nil {
+		f.Includes = splitFields(fields)
+		return nil
+	}
+	if err := json.Unmarshal(input, &f.Includes); err == nil {
+		return nil
+	}
+	var temp struct {
+		Includes stdjson.RawMessage `json:"includes"`
+		Excludes stdjson.RawMessage `json:"excludes"`
+	}
+	if err := json.Unmarshal(input, &temp); err != nil {
+		return err
+	}
+	var err error
+	if f.Includes, err = unmarshalFields(temp.Includes); err != nil {
+		return err
+	}
+	f.Excludes, err = unmarshalFields(temp.Excludes)
+	return err
+}
+
+// Apply returns the filtered source, nil if the source is disabled.
+func (f *SourceFilter) Apply(source map[string]interface{}) map[string]interface{} {
+	if f == nil {
+		return source
+	}
+	if f.Disabled {
+		return nil
+	}
+	if len(f.Includes) == 0 && len(f.Excludes) == 0 {
+		return source
+	}
+	return maps.Filter(source, f.Includes, f.Excludes)
+}
+
+// unmarshalFields unmarshals the fields from a string or an array of string.
+func unmarshalFields(input []byte) ([]string, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	var fields string
+	if err := json.Unmarshal(input, &fields); err == nil {
+		return splitFields(fields), nil
+	}
+	var list []string
+	err := json.Unmarshal(input, &list)
+	return list, err
+}
+
+func splitFields(fields string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	list := make([]string, 0)
+	for _, field := range strings.Split(fields, ",") {
+		if field = strings.TrimSpace(field); len(field) > 0 {
+			list = append(list, field)
+		}
+	}
+	return list
+}
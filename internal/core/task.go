@@ -0,0 +1,189 @@
+package core
+
+import (
+	"context"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"github.com/feimingxliu/quicksearch/pkg/util/uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// completedTaskTTL is how long the completed tasks are kept.
+	completedTaskTTL = time.Hour
+	// maxCompletedTasks is the max number of completed tasks kept, the earliest completed ones are evicted first.
+	maxCompletedTasks = 1000
+)
+
+// Task is a long-running operation executed in background, e.g. reindex.
+type Task struct {
+	ID          string        `json:"id"`
+	Action      string        `json:"action"`
+	Description string        `json:"description"`
+	StartTime   time.Time     `json:"start_time"`
+	RunningTime time.Duration `json:"running_time"`
+	Completed   bool          `json:"completed"`
+	Cancelled   bool          `json:"cancelled"`
+	Status      interface{}   `json:"status"` // the progress of task, which is action specific
+	Error       string        `json:"error,omitempty"`
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	endTime     time.Time
+	mu          sync.RWMutex
+}
+
+// newTask creates a task and registers it to engine, the task is not started.
+func newTask(action, description string, status interface{}) *Task {
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &Task{
+		ID:          uuid.GetXID(),
+		Action:      action,
+		Description: description,
+		StartTime:   time.Now(),
+		Status:      status,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	engine.addTask(task)
+	return task
+}
+
+// run executes f in a new goroutine, the ctx is cancelled when the task is cancelled.
+func (t *Task) run(f func(ctx context.Context) error) {
+	go func() {
+		err := f(t.ctx)
+		t.cancel()
+		t.mu.Lock()
+		t.Completed = true
+		t.endTime = time.Now()
+		t.RunningTime = t.endTime.Sub(t.StartTime)
+		if err != nil && !(t.Cancelled && err == context.Canceled) {
+			t.Error = err.Error()
+		}
+		t.mu.Unlock()
+		close(t.done)
+	}()
+}
+
+// update modifies the task status under the task's lock.
+func (t *Task) update(f func()) {
+	t.mu.Lock()
+	f()
+	t.mu.Unlock()
+}
+
+// Cancel cancels the running task.
+func (t *Task) Cancel() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Completed {
+		return errors.ErrTaskCompleted
+	}
+	t.Cancelled = true
+	t.cancel()
+	return nil
+}
+
+// completedAt returns the time when the task completed, false if it's running.
+func (t *Task) completedAt() (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.endTime, t.Completed
+}
+
+// Wait blocks until the task completes.
+func (t *Task) Wait() {
+	<-t.done
+}
+
+func (t *Task) MarshalJSON() ([]byte, error) {
+	type task Task
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.Completed {
+		t.RunningTime = time.Since(t.StartTime)
+	}
+	return json.Marshal((*task)(t))
+}
+
+// GetTask returns the task with id.
+func GetTask(id string) (*Task, error) {
+	engine.taskMu.RLock()
+	task := engine.tasks[id]
+	engine.taskMu.RUnlock()
+	if task == nil {
+		return nil, errors.ErrTaskNotFound
+	}
+	return task, nil
+}
+
+// ListTasks returns all tasks sorted by start time.
+func ListTasks() []*Task {
+	engine.taskMu.RLock()
+	tasks := make([]*Task, 0, len(engine.tasks))
+	for _, task := range engine.tasks {
+		tasks = append(tasks, task)
+	}
+	engine.taskMu.RUnlock()
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].StartTime.Before(tasks[j].StartTime)
+	})
+	return tasks
+}
+
+// addTask registers the task, and evicts the completed tasks, see evictTasks.
+func (e *Engine) addTask(task *Task) {
+	e.taskMu.Lock()
+	e.evictTasks()
+	e.tasks[task.ID] = task
+	e.taskMu.Unlock()
+}
+
+// evictTasks removes the completed tasks older than completedTaskTTL, and the earliest completed ones exceeding
+// maxCompletedTasks. The caller must hold taskMu.
+func (e *Engine) evictTasks() {
+	type completedTask struct {
+		id      string
+		endTime time.Time
+	}
+	completed := make([]completedTask, 0)
+	for id, task := range e.tasks {
+		endTime, ok := task.completedAt()
+		if !ok {
+			continue
+		}
+		if time.Since(endTime) > completedTaskTTL {
+			delete(e.tasks, id)
+			continue
+		}
+		completed = append(completed, completedTask{id: id, endTime: endTime})
+	}
+	if len(completed) <= maxCompletedTasks {
+		return
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].endTime.Before(completed[j].endTime)
+	})
+	for _, task := range completed[:len(completed)-maxCompletedTasks] {
+		delete(e.tasks, task.id)
+	}
+}
+
+// cancelAllTasks cancels the running tasks and waits them to exit.
+func (e *Engine) cancelAllTasks() {
+	e.taskMu.RLock()
+	tasks := make([]*Task, 0, len(e.tasks))
+	for _, task := range e.tasks {
+		tasks = append(tasks, task)
+	}
+	e.taskMu.RUnlock()
+	for _, task := range tasks {
+		if err := task.Cancel(); err == nil {
+			task.Wait()
+		}
+	}
+}
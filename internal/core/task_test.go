@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestEvictTasks(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	defer func(ttl time.Duration, max int) {
+		completedTaskTTL, maxCompletedTasks = ttl, max
+	}(completedTaskTTL, maxCompletedTasks)
+	maxCompletedTasks = 2
+	run := func() *Task {
+		task := newTask("test", "test task", nil)
+		task.run(func(ctx context.Context) error {
+			return nil
+		})
+		task.Wait()
+		return task
+	}
+	first, second, third := run(), run(), run()
+	running := newTask("test", "running task", nil)
+	running.run(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	defer running.Cancel()
+	// the earliest completed task is evicted.
+	if _, err := GetTask(first.ID); err != qerrors.ErrTaskNotFound {
+		t.Errorf("get the evicted task: %v", err)
+	}
+	for _, task := range []*Task{second, third, running} {
+		if _, err := GetTask(task.ID); err != nil {
+			t.Errorf("get task [%s]: %v", task.Description, err)
+		}
+	}
+	// the expired tasks are evicted, the running one is kept.
+	completedTaskTTL = time.Nanosecond
+	run()
+	if tasks := ListTasks(); len(tasks) != 2 || tasks[0] != running {
+		t.Errorf("got %d tasks after expired", len(tasks))
+	}
+}
@@ -0,0 +1,349 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DurabilityRequest acknowledges the write after its batch is persisted by the index.
+	DurabilityRequest = "request"
+	// DurabilityAsync acknowledges the write once its batch is searchable, the batch is persisted by the index
+	// in the background and the translog is fsynced every sync interval, so the writes acknowledged in the
+	// interval may be lost if the machine crashes.
+	DurabilityAsync = "async"
+
+	defaultTranslogSyncInterval = 5 * time.Second
+)
+
+// TranslogSettings specifies when the writes of shards are acknowledged.
+type TranslogSettings struct {
+	Durability   string `json:"durability"`              // "request" or "async", defaults to "request"
+	SyncInterval string `json:"sync_interval,omitempty"` // the fsync interval of the async durability, e.g. "5s"
+}
+
+// validate returns errors.ErrInvalidTranslogSettings if the settings are invalid.
+func (s *TranslogSettings) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.Durability != "" && s.Durability != DurabilityRequest && s.Durability != DurabilityAsync {
+		return fmt.Errorf("%w: unknown durability %q", errors.ErrInvalidTranslogSettings, s.Durability)
+	}
+	if s.SyncInterval != "" {
+		if d, err := time.ParseDuration(s.SyncInterval); err != nil || d <= 0 {
+			return fmt.Errorf("%w: invalid sync interval %q", errors.ErrInvalidTranslogSettings, s.SyncInterval)
+		}
+	}
+	return nil
+}
+
+func (s *TranslogSettings) async() bool {
+	return s != nil && s.Durability == DurabilityAsync
+}
+
+func (s *TranslogSettings) syncInterval() time.Duration {
+	if s != nil && s.SyncInterval != "" {
+		if d, err := time.ParseDuration(s.SyncInterval); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultTranslogSyncInterval
+}
+
+// translogEntry is the ops of a batch, SeqNo is the sequence number of shard after the batch.
+type translogEntry struct {
+	SeqNo int64         `json:"seq_no"`
+	Ops   []*translogOp `json:"ops"`
+}
+
+// translogOp is the resolved write of document, which is replayed as is.
+type translogOp struct {
+	SeqNo   int64                  `json:"seq_no"`
+	ID      string                 `json:"id"`
+	Version int64                  `json:"version"`
+	Delete  bool                   `json:"delete,omitempty"`
+	Source  map[string]interface{} `json:"source,omitempty"`
+}
+
+// translog records the writes of shard before they are written to bleve, the entries left in it when the shard
+// opened are the writes which may be lost. It's truncated once all its entries are persisted by bleve, the
+// entries persisted already are skipped by the replay. Each entry is framed with its length and crc32, the torn
+// entry at the tail is dropped.
+//
+// With the request durability the bleve batch returns after persisted, which fsyncs the writes, so the entries are
+// not fsynced, they cover the batch in flight if the process crashes. With the async durability the bleve batch
+// returns before persisted, the entries are fsynced every sync interval and truncated by the persisted callback of
+// the batches.
+type translog struct {
+	file      *os.File
+	async     bool
+	size      int64 // the size of the entries
+	last      int64 // the size before the last entry, the failed batch rolls back to it
+	seqNo     int64 // the sequence number of the last entry
+	persisted int64 // the sequence number persisted by bleve
+	dirty     bool  // the entries are not fsynced
+	stale     bool  // the file is longer than size since the truncate failed, it's cut before the next append
+	closed    bool
+	err       error // the error of the background fsync, returned by the next append
+	stop      chan struct{}
+	mu        sync.Mutex
+}
+
+// openTranslog opens the translog file and returns the entries in it.
+func openTranslog(file string, settings *TranslogSettings) (*translog, []*translogEntry, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, size := readTranslog(f)
+	// drop the torn entry, the new entries are appended after the last valid one.
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	t := &translog{file: f, async: settings.async(), size: size, last: size}
+	if len(entries) > 0 {
+		t.seqNo = entries[len(entries)-1].SeqNo
+	}
+	if t.async {
+		t.stop = make(chan struct{})
+		go t.syncEvery(settings.syncInterval())
+	}
+	return t, entries, nil
+}
+
+// readTranslog reads the entries from r until EOF or the first torn entry, the size of valid entries is returned.
+func readTranslog(r io.Reader) ([]*translogEntry, int64) {
+	entries := make([]*translogEntry, 0)
+	br := bufio.NewReader(r)
+	var size int64
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			break
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[:4]))
+		if _, err := io.ReadFull(br, data); err != nil || crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		entry := new(translogEntry)
+		if err := json.Unmarshal(data, entry); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+		size += int64(len(header) + len(data))
+	}
+	return entries, size
+}
+
+// append writes the entry, the caller must call rollback if the batch of entry fails.
+func (t *translog) append(entry *translogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(data))
+	buf = append(buf, data...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		err, t.err = t.err, nil
+		return err
+	}
+	if t.stale {
+		if err = t.file.Truncate(t.size); err != nil {
+			return err
+		}
+		t.stale = false
+	}
+	if _, err = t.file.Write(buf); err != nil {
+		// cut the partial entry.
+		t.stale = true
+		return err
+	}
+	t.last, t.size = t.size, t.size+int64(len(buf))
+	t.seqNo = entry.SeqNo
+	t.dirty = true
+	return nil
+}
+
+// rollback removes the last entry whose batch fails, the failed ops are not acknowledged so they should not be
+// replayed. The entry is removed before the next append if it fails.
+func (t *translog) rollback(seqNo int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.size, t.seqNo = t.last, seqNo
+	return t.cut()
+}
+
+// persist records that the writes up to seqNo are persisted by bleve, the entries are truncated if all of
+// them are persisted.
+func (t *translog) persist(seqNo int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seqNo > t.persisted {
+		t.persisted = seqNo
+	}
+	if t.closed || t.size == 0 || t.persisted < t.seqNo {
+		return
+	}
+	t.size, t.last = 0, 0
+	// the entry left is skipped by the replay since its sequence numbers are persisted.
+	if err := t.cut(); err != nil {
+		log.Printf("truncate the translog %s: %s", t.file.Name(), err)
+	}
+}
+
+// cut truncates the file to size, the caller must hold t.mu.
+func (t *translog) cut() error {
+	if err := t.file.Truncate(t.size); err != nil {
+		t.stale = true
+		return err
+	}
+	if t.size == 0 {
+		t.dirty = false
+	}
+	t.stale = false
+	return nil
+}
+
+// sync fsyncs the entries appended since the last sync.
+func (t *translog) sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty || t.closed {
+		return nil
+	}
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+func (t *translog) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.sync(); err != nil {
+				t.mu.Lock()
+				t.err = err
+				t.mu.Unlock()
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// close fsyncs the entries and closes the file, the entries are kept for the replay.
+func (t *translog) close() error {
+	if t.stop != nil {
+		close(t.stop)
+	}
+	err := t.sync()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// translogFile returns the translog file of shard.
+func (index *Index) translogFile(shard int) string {
+	return path.Join(index.dir(), fmt.Sprintf("%s_%d.translog", index.UID, shard))
+}
+
+// openTranslog opens the translog of shard and replays the writes which are not persisted by bleve,
+// the translog is truncated once the writes replayed are persisted.
+func (index *Index) openTranslog(shard *IndexShard) error {
+	tlog, entries, err := openTranslog(index.translogFile(shard.ID), index.Translog)
+	if err != nil {
+		return err
+	}
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.translog = tlog
+	if err = index.replay(shard, entries); err != nil {
+		tlog.close()
+		shard.translog = nil
+		return err
+	}
+	return nil
+}
+
+// replay writes the ops of entries which are not persisted by the shard, the caller must hold shard.mu.
+func (index *Index) replay(shard *IndexShard, entries []*translogEntry) error {
+	// the entries are truncated here if all of them are persisted.
+	shard.translog.persist(shard.seqNo)
+	mapping := shard.Indexer.Mapping()
+	batch := shard.Indexer.NewBatch()
+	seqNo := shard.seqNo
+	for _, entry := range entries {
+		for _, op := range entry.Ops {
+			// the op is persisted already.
+			if op.SeqNo <= seqNo {
+				continue
+			}
+			if op.Delete {
+				batch.Delete(op.ID)
+			} else {
+				bdoc, err := index.buildBleveDocument(op.ID, op.Source, mapping)
+				if err != nil {
+					return err
+				}
+				addVersionFields(bdoc, op.Version, op.SeqNo)
+				if err = batch.IndexAdvanced(bdoc); err != nil {
+					return err
+				}
+			}
+			seqNo = op.SeqNo
+		}
+	}
+	if seqNo > shard.seqNo {
+		return shard.writeBatch(batch, seqNo)
+	}
+	return nil
+}
+
+// writeBatch writes the batch of the writes up to seqNo, the translog is truncated once the batch is persisted.
+// The caller must hold shard.mu.
+func (shard *IndexShard) writeBatch(batch *bleve.Batch, seqNo int64) error {
+	tlog := shard.translog
+	batch.SetInternal(seqNoKey, []byte(strconv.FormatInt(seqNo, 10)))
+	if tlog.async {
+		// the callback is called by the persister of bleve in order.
+		batch.SetPersistedCallback(func(err error) {
+			if err == nil {
+				tlog.persist(seqNo)
+			}
+		})
+	}
+	if err := shard.Indexer.Batch(batch); err != nil {
+		return err
+	}
+	shard.seqNo = seqNo
+	if !tlog.async {
+		tlog.persist(seqNo)
+	}
+	return nil
+}
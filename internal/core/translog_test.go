@@ -0,0 +1,233 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestTranslogReplay(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	if _, err := NewIndex(WithName(indexName), WithTranslog(&TranslogSettings{Durability: "fsync"})); !errors.Is(err, qerrors.ErrInvalidTranslogSettings) {
+		t.Errorf("create the index with invalid durability: %v", err)
+	}
+	index, err := NewIndex(WithName(indexName), WithShards(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := index.IndexOrUpdateDocument("kept", map[string]interface{}{"title": "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.IndexOrUpdateDocument("deleted", map[string]interface{}{"title": "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	file := index.translogFile(0)
+	if info, err := os.Stat(file); err != nil || info.Size() != 0 {
+		t.Fatalf("the translog is not truncated after the batch persisted: %v", err)
+	}
+
+	// simulate the crash before the batch persisted, the first op is persisted already.
+	shard := index.Shards[0]
+	entry := &translogEntry{SeqNo: shard.seqNo + 2, Ops: []*translogOp{
+		{SeqNo: shard.seqNo, ID: "kept", Version: 1, Source: map[string]interface{}{"title": "stale"}},
+		{SeqNo: shard.seqNo + 1, ID: "lost", Version: 1, Source: map[string]interface{}{"title": "lost"}},
+		{SeqNo: shard.seqNo + 2, ID: "deleted", Version: 2, Delete: true},
+	}}
+	if err := shard.translog.append(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// append a torn entry.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1, 0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if index, err = GetIndex(indexName); err != nil {
+		t.Fatal(err)
+	}
+	if index.Shards[0].seqNo != 4 {
+		t.Errorf("got sequence number %d after replayed, expected 4", index.Shards[0].seqNo)
+	}
+	doc, err := index.GetDocument("lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 1 || doc.SeqNo != 3 || doc.Source.(map[string]interface{})["title"] != "lost" {
+		t.Errorf("got replayed document: %+v", doc)
+	}
+	if doc, err = index.GetDocument("kept"); err != nil || doc.Source.(map[string]interface{})["title"] != "v1" {
+		t.Errorf("the persisted op is replayed: %+v, %v", doc, err)
+	}
+	if _, err := index.GetDocument("deleted"); err == nil {
+		t.Error("the replayed delete is not applied")
+	}
+	if info, err := os.Stat(file); err != nil || info.Size() != 0 {
+		t.Errorf("the translog is not truncated after replayed: %v", err)
+	}
+	res, err := index.IndexOrUpdateDocument("lost", map[string]interface{}{"title": "found"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != 2 || res.SeqNo != 5 {
+		t.Errorf("got version %d and sequence number %d after replayed", res.Version, res.SeqNo)
+	}
+}
+
+func TestCloseWhileWriting(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	done := make(chan error)
+	go func() {
+		for i := 0; ; i++ {
+			_, err := index.IndexOrUpdateDocument(fmt.Sprintf("doc-%d", i), map[string]interface{}{"title": "doc"})
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != qerrors.ErrIndexClosed {
+		t.Errorf("write to the closed index: %v", err)
+	}
+}
+
+func TestTranslogAsync(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	index, err := NewIndex(WithName(indexName), WithShards(1), WithTranslog(&TranslogSettings{Durability: DurabilityAsync, SyncInterval: "50ms"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if _, err := index.IndexOrUpdateDocument(fmt.Sprintf("doc-%d", i), map[string]interface{}{"title": "doc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the writes are searchable when acknowledged, and the translog is truncated once they are persisted.
+	if n, err := countDocuments(nil, index); err != nil || n != 10 {
+		t.Errorf("got %d documents, expected 10: %v", n, err)
+	}
+	file := index.translogFile(0)
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the translog is not truncated after the batches persisted")
+		}
+	}
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if index, err = GetIndex(indexName); err != nil {
+		t.Fatal(err)
+	}
+	if index.Shards[0].seqNo != 10 {
+		t.Errorf("got sequence number %d after reopened, expected 10", index.Shards[0].seqNo)
+	}
+	if doc, err := index.GetDocument("doc-9"); err != nil || doc.Version != 1 {
+		t.Errorf("got document %+v after reopened: %v", doc, err)
+	}
+}
+
+func TestTranslogRollbackFailure(t *testing.T) {
+	tlog, _, err := openTranslog(path.Join(t.TempDir(), "0.translog"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tlog.append(&translogEntry{SeqNo: 1, Ops: []*translogOp{{SeqNo: 1, ID: "doc"}}}); err != nil {
+		t.Fatal(err)
+	}
+	// the rollback fails on the closed file, the shard accepts no writes until the entry removed.
+	tlog.file.Close()
+	if err := tlog.rollback(0); err == nil || !tlog.stale {
+		t.Fatalf("roll back the closed translog: %v", err)
+	}
+	if err := tlog.append(&translogEntry{SeqNo: 1}); err == nil {
+		t.Error("append to the translog not rolled back")
+	}
+}
+
+func TestTranslogReplayFailure(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	im := new(IndexMapping)
+	if err := json.Unmarshal([]byte(dynamicMapping), im); err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	// the op rejected by the strict mapping fails the replay.
+	entry := &translogEntry{SeqNo: 1, Ops: []*translogOp{{SeqNo: 1, ID: "1", Version: 1, Source: map[string]interface{}{"unknown": "field"}}}}
+	if err := index.Shards[0].translog.append(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetIndex(indexName); !errors.Is(err, qerrors.ErrStrictDynamicMapping) {
+		t.Fatalf("open the index with the invalid translog: %v", err)
+	}
+	// the shard is closed after the failed replay, so it can be opened again.
+	if err := os.Remove(index.translogFile(0)); err != nil {
+		t.Fatal(err)
+	}
+	if index, err = GetIndex(indexName); err != nil {
+		t.Fatal(err)
+	}
+	if index.Shards[0].seqNo != 0 {
+		t.Errorf("got sequence number %d, expected 0", index.Shards[0].seqNo)
+	}
+}
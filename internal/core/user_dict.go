@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	imapping "github.com/blevesearch/bleve/v2/mapping"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/userdict"
+	"sort"
+	"strings"
+)
+
+// userDict returns the user dict of the chinese analyzer(gse, jieba, gojieba, sego).
+func userDict(analyzer string) (*userdict.Dict, error) {
+	name := builtinName(analyzerNames, analyzer)
+	if _, ok := chineseAnalyzers[name]; !ok {
+		return nil, fmt.Errorf("analyzer [%s] has no user dict", analyzer)
+	}
+	return userdict.Get(name)
+}
+
+// GetUserDict returns the entries of the user dict of analyzer.
+func GetUserDict(analyzer string) ([]userdict.Entry, error) {
+	dict, err := userDict(analyzer)
+	if err != nil {
+		return nil, err
+	}
+	return dict.Entries(), nil
+}
+
+// UpdateUserDict appends the entries to the user dict of analyzer, or replaces all the entries if replace.
+// The segmenters of the analyzer are rebuilt and swapped into the tokenizers before it returns, the analyzing isn't
+// blocked by the rebuild. The indexed documents are not changed until they are reindexed, see ReindexForUserDict.
+func UpdateUserDict(analyzer string, entries []userdict.Entry, replace bool) error {
+	dict, err := userDict(analyzer)
+	if err != nil {
+		return err
+	}
+	if replace {
+		return dict.Replace(entries...)
+	}
+	return dict.Append(entries...)
+}
+
+// ReindexForUserDict starts a background task which reindexes all the documents of the opened indices
+// using the tokenizer of analyzer, it returns nil if no index is affected.
+func ReindexForUserDict(analyzer string) (*Task, error) {
+	if _, err := userDict(analyzer); err != nil {
+		return nil, err
+	}
+	indices := indicesUsingTokenizer(builtinName(analyzerNames, analyzer))
+	if len(indices) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(indices))
+	for _, index := range indices {
+		names = append(names, index.Name)
+	}
+	return reindexInPlace(fmt.Sprintf("reindex [%s] for the user dict of [%s]", strings.Join(names, ", "), analyzer), indices...), nil
+}
+
+// indicesUsingTokenizer returns the opened indices which have a tokenizer of the type, sorted by name.
+func indicesUsingTokenizer(typ string) []*Index {
+	engine.RLock()
+	defer engine.RUnlock()
+	indices := make([]*Index, 0)
+	for _, index := range engine.indices {
+		if index.IsClosed() {
+			continue
+		}
+		mapping, ok := index.Shards[0].Indexer.Mapping().(*imapping.IndexMappingImpl)
+		if !ok {
+			continue
+		}
+		for _, tokenizer := range mapping.CustomAnalysis.Tokenizers {
+			if tokenizer["type"] == typ {
+				indices = append(indices, index)
+				break
+			}
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i].Name < indices[j].Name
+	})
+	return indices
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"github.com/feimingxliu/quicksearch/internal/config"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/userdict"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"log"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestUserDict(t *testing.T) {
+	prepare(t)
+	defer clean(t)
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(`{"default_mapping": {"properties": {"content": {"fields": [{"type": "text", "analyzer": "jieba"}]}}}}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	im, err := BuildIndexMappingFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewIndex(WithName(indexName), WithIndexMapping(im), WithShards(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		log.Println("Delete Index.")
+		if err := index.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	defer func() {
+		if err := UpdateUserDict("jieba", nil, true); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if err := UpdateUserDict("jieba", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.IndexOrUpdateDocument("1", map[string]interface{}{"content": "新品快搜引擎发布"}); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		req := new(SearchRequest)
+		if err := json.Unmarshal([]byte(`{"query": {"type": "term", "term": "快搜引擎", "field": "content"}}`), req); err != nil {
+			t.Fatal(err)
+		}
+		res, err := index.Search(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.Hits)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("got %d hits before the user dict updated", n)
+	}
+
+	entry, err := userdict.ParseEntry("快搜引擎 10 nz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateUserDict("jieba", []userdict.Entry{entry}, false); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := GetUserDict("gojieba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, []userdict.Entry{{Word: "快搜引擎", Freq: 10, Pos: "nz"}}) {
+		t.Errorf("user dict entries: %v", entries)
+	}
+	// the analyzer reloads the user dict, but the indexed documents are unchanged until reindexed.
+	res, err := index.Analyze(&AnalyzeRequest{Text: "新品快搜引擎发布", Field: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, token := range res.Tokens {
+		found = found || token.Token == "快搜引擎"
+	}
+	if !found {
+		t.Errorf("the user dict is not reloaded: %v", res.Tokens)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("got %d hits before reindex", n)
+	}
+	task, err := ReindexForUserDict("jieba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil {
+		t.Fatal("no index affected")
+	}
+	task.Wait()
+	if len(task.Error) > 0 {
+		t.Fatal(task.Error)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("got %d hits after reindex", n)
+	}
+
+	// the user dict is reloaded from the file.
+	if err := userdict.SetDir(path.Join(config.Global.Storage.DataDir, "dict", "user")); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := GetUserDict("jieba"); len(entries) != 1 {
+		t.Errorf("the user dict is not persisted: %v", entries)
+	}
+	if task, err := ReindexForUserDict("sego"); err != nil || task != nil {
+		t.Errorf("reindex for sego: %v, %v", task, err)
+	}
+	if _, err := GetUserDict("standard"); err == nil {
+		t.Error("the standard analyzer has no user dict")
+	}
+}
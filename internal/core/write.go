@@ -0,0 +1,316 @@
+package core
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/v2/document"
+	"github.com/blevesearch/bleve/v2/search"
+	bindex "github.com/blevesearch/bleve_index_api"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"github.com/feimingxliu/quicksearch/pkg/util/maps"
+	"reflect"
+	"strconv"
+)
+
+// primaryTerm is always 1 since the shards have no replica.
+const primaryTerm int64 = 1
+
+// seqNoKey is the internal key which persists the last sequence number of shard.
+var seqNoKey = []byte("_seq_no")
+
+type writeOpType int
+
+const (
+	opIndex writeOpType = iota
+	opCreate
+	opUpdate
+	opDelete
+)
+
+// WriteOption specifies the precondition of a write, the write fails with
+// errors.ErrVersionConflict if the current document doesn't satisfy it.
+type WriteOption func(c *writeCondition)
+
+type writeCondition struct {
+	ifSeqNo       *int64
+	ifPrimaryTerm *int64
+	version       *int64
+}
+
+// IfSeqNo requires the document to have the sequence number and primary term.
+func IfSeqNo(seqNo, term int64) WriteOption {
+	return func(c *writeCondition) {
+		c.ifSeqNo = &seqNo
+		c.ifPrimaryTerm = &term
+	}
+}
+
+// IfVersion requires the document to have the version.
+func IfVersion(version int64) WriteOption {
+	return func(c *writeCondition) {
+		c.version = &version
+	}
+}
+
+// writeOp is a write of document, its result is filled when executed.
+type writeOp struct {
+	typ        writeOpType
+	docID      string
+	source     map[string]interface{} // the document for opIndex and opCreate, the fields to merge for opUpdate
+	upsert     map[string]interface{} // the document indexed by opUpdate if the document doesn't exist
+	detectNoop bool                   // skips writing the document if opUpdate doesn't change it
+	cond       writeCondition
+	result     *BulkActionResult
+	err        error
+}
+
+func newWriteOp(typ writeOpType, indexName, docID string, source map[string]interface{}, opts ...WriteOption) *writeOp {
+	op := &writeOp{
+		typ:        typ,
+		docID:      docID,
+		source:     source,
+		detectNoop: true,
+		result: &BulkActionResult{
+			Index:       indexName,
+			ID:          docID,
+			PrimaryTerm: primaryTerm,
+			Shards: &BulkShards{
+				Total:      1,
+				Successful: 1,
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(&op.cond)
+	}
+	return op
+}
+
+// fail sets the error of op.
+func (op *writeOp) fail(err error, status int64) {
+	op.err = err
+	op.result.Error = err.Error()
+	op.result.Status = status
+	op.result.Shards.Successful = 0
+	op.result.Shards.Failed = 1
+}
+
+// docState is the version of document and its source.
+type docState struct {
+	found   bool
+	version int64
+	seqNo   int64
+	source  map[string]interface{}
+}
+
+// check returns errors.ErrVersionConflict if the document doesn't satisfy the condition.
+func (s *docState) check(c *writeCondition) error {
+	if c.ifSeqNo != nil && (!s.found || s.seqNo != *c.ifSeqNo) {
+		return errors.ErrVersionConflict
+	}
+	if c.ifPrimaryTerm != nil && *c.ifPrimaryTerm != primaryTerm {
+		return errors.ErrVersionConflict
+	}
+	if c.version != nil && (!s.found || s.version != *c.version) {
+		return errors.ErrVersionConflict
+	}
+	return nil
+}
+
+// executeWrites executes the ops of documents in shard as one batch. The writes in the shard are serialized,
+// so each op sees the document written by the ops before it and gets a new sequence number of the shard.
+// The resolved ops are appended to the translog of shard before the batch, see translog.
+// The failure of single op is recorded in the op, the returned error means the batch fails.
+func (index *Index) executeWrites(shard *IndexShard, ops []*writeOp) error {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	// the shard is closed while the writes wait for it.
+	if shard.Indexer == nil || shard.translog == nil {
+		return errors.ErrIndexClosed
+	}
+	// the shard mapping is the same as index.Mapping, and it's built once.
+	mapping := shard.Indexer.Mapping()
+	batch := shard.Indexer.NewBatch()
+	seqNo := shard.seqNo
+	pending := make(map[string]*docState)
+	entry := &translogEntry{Ops: make([]*translogOp, 0, len(ops))}
+	for _, op := range ops {
+		state := pending[op.docID]
+		if state == nil {
+			var err error
+			if state, err = shard.getDocState(op.docID, op.typ == opUpdate); err != nil {
+				op.fail(err, 500)
+				continue
+			}
+		}
+		if err := state.check(&op.cond); err != nil {
+			op.fail(err, 409)
+			continue
+		}
+		source := op.source
+		switch op.typ {
+		case opDelete:
+			// the missing document is reported by the result and status, the result has no error.
+			if !state.found {
+				op.err = errors.ErrDocumentNotFound
+				op.result.Result, op.result.Status = "not_found", 404
+				continue
+			}
+			batch.Delete(op.docID)
+			op.result.Result, op.result.Status = "deleted", 200
+			seqNo++
+			entry.Ops = append(entry.Ops, &translogOp{SeqNo: seqNo, ID: op.docID, Version: state.version + 1, Delete: true})
+			pending[op.docID] = &docState{version: state.version + 1, seqNo: seqNo}
+			op.result.Version, op.result.SeqNo = state.version+1, seqNo
+			continue
+		case opUpdate:
+			if !state.found {
+				if op.upsert == nil {
+					op.fail(errors.ErrDocumentNotFound, 404)
+					continue
+				}
+				source = op.upsert
+				op.result.Result, op.result.Status = "created", 201
+				break
+			}
+			source = maps.Merge(state.source, op.source)
+			// the document is not written if nothing changed.
+			if op.detectNoop && reflect.DeepEqual(source, state.source) {
+				op.result.Result, op.result.Status = "noop", 200
+				op.result.Version, op.result.SeqNo = state.version, state.seqNo
+				continue
+			}
+			op.result.Result, op.result.Status = "updated", 200
+		case opCreate:
+			if state.found {
+				op.fail(errors.ErrDocumentAlreadyExists, 409)
+				continue
+			}
+			op.result.Result, op.result.Status = "created", 201
+		case opIndex:
+			if state.found {
+				op.result.Result, op.result.Status = "updated", 200
+			} else {
+				op.result.Result, op.result.Status = "created", 201
+			}
+		}
+		version := int64(1)
+		if state.found {
+			version = state.version + 1
+		}
+		bdoc, err := index.buildBleveDocument(op.docID, source, mapping)
+		if err == nil {
+			addVersionFields(bdoc, version, seqNo+1)
+			err = batch.IndexAdvanced(bdoc)
+		}
+		if err != nil {
+			op.fail(err, 400)
+			op.result.Result = ""
+			continue
+		}
+		seqNo++
+		entry.Ops = append(entry.Ops, &translogOp{SeqNo: seqNo, ID: op.docID, Version: version, Source: source})
+		pending[op.docID] = &docState{found: true, version: version, seqNo: seqNo, source: source}
+		op.result.Version, op.result.SeqNo = version, seqNo
+	}
+	if seqNo == shard.seqNo {
+		return nil
+	}
+	// the ops are recorded before written, so they can be replayed if the batch is not persisted.
+	entry.SeqNo = seqNo
+	if err := shard.translog.append(entry); err != nil {
+		return err
+	}
+	if err := shard.writeBatch(batch, seqNo); err != nil {
+		if terr := shard.translog.rollback(shard.seqNo); terr != nil {
+			return fmt.Errorf("%w, and the translog is not rolled back: %v", err, terr)
+		}
+		return err
+	}
+	return nil
+}
+
+// executeWrite executes the single op in its shard.
+func (index *Index) executeWrite(op *writeOp) (*BulkActionResult, error) {
+	if err := index.putTemplateFields([]*writeOp{op}); err != nil {
+		return nil, err
+	}
+	if err := index.executeWrites(index.getDocShard(op.docID), []*writeOp{op}); err != nil {
+		return nil, err
+	}
+	return op.result, op.err
+}
+
+// getDocState returns the current version of the document, the source is loaded if withSource.
+func (shard *IndexShard) getDocState(docID string, withSource bool) (*docState, error) {
+	bdoc, err := shard.Indexer.Document(docID)
+	if err != nil {
+		return nil, err
+	}
+	state := &docState{}
+	if bdoc == nil {
+		return state, nil
+	}
+	state.found = true
+	state.version, state.seqNo = documentVersion(bdoc)
+	if withSource {
+		state.source, err = documentSource(bdoc)
+	}
+	return state, err
+}
+
+// loadSeqNo loads the last sequence number of shard.
+func (shard *IndexShard) loadSeqNo() error {
+	val, err := shard.Indexer.GetInternal(seqNoKey)
+	if err != nil || len(val) == 0 {
+		return err
+	}
+	shard.seqNo, err = strconv.ParseInt(string(val), 10, 64)
+	return err
+}
+
+// addVersionFields stores the version and sequence number with the document.
+func addVersionFields(doc *document.Document, version, seqNo int64) {
+	doc.AddField(document.NewTextFieldWithIndexingOptions("_version", nil, []byte(strconv.FormatInt(version, 10)), bindex.StoreField))
+	doc.AddField(document.NewTextFieldWithIndexingOptions("_seq_no", nil, []byte(strconv.FormatInt(seqNo, 10)), bindex.StoreField))
+}
+
+// documentVersion returns the version and sequence number of the bleve document,
+// the documents indexed before versioning are treated as version 1 and sequence number 0.
+func documentVersion(bdoc bindex.Document) (version, seqNo int64) {
+	version = 1
+	bdoc.VisitFields(func(field bindex.Field) {
+		switch field.Name() {
+		case "_version":
+			version, _ = strconv.ParseInt(string(field.Value()), 10, 64)
+		case "_seq_no":
+			seqNo, _ = strconv.ParseInt(string(field.Value()), 10, 64)
+		}
+	})
+	return
+}
+
+// hitVersion returns the version and sequence number of the hit which loads the `_version` and `_seq_no` fields,
+// the same as documentVersion.
+func hitVersion(hit *search.DocumentMatch) (version, seqNo int64) {
+	version = 1
+	if s, ok := hit.Fields["_version"].(string); ok {
+		version, _ = strconv.ParseInt(s, 10, 64)
+	}
+	if s, ok := hit.Fields["_seq_no"].(string); ok {
+		seqNo, _ = strconv.ParseInt(s, 10, 64)
+	}
+	return
+}
+
+// documentSource returns the `_source` field of the bleve document.
+func documentSource(bdoc bindex.Document) (map[string]interface{}, error) {
+	var err error
+	source := make(map[string]interface{})
+	bdoc.VisitFields(func(field bindex.Field) {
+		if field.Name() == "_source" {
+			err = json.Unmarshal(field.Value(), &source)
+		}
+	})
+	return source, err
+}
@@ -1,3 +1,44 @@
 package analyzer
 
 // this package just import the underlying analyzer for init()
+import (
+	_ "github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	_ "github.com/blevesearch/bleve/v2/analysis/analyzer/simple"
+	_ "github.com/blevesearch/bleve/v2/analysis/char/asciifolding"
+	_ "github.com/blevesearch/bleve/v2/analysis/char/html"
+	_ "github.com/blevesearch/bleve/v2/analysis/char/regexp"
+	_ "github.com/blevesearch/bleve/v2/analysis/char/zerowidthnonjoiner"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/da"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/de"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/es"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/fi"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/fr"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/hu"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/it"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/nl"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/no"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/pt"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/ro"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/ru"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/sv"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/tr"
+	_ "github.com/blevesearch/bleve/v2/analysis/token/edgengram"
+	_ "github.com/blevesearch/bleve/v2/analysis/token/length"
+	_ "github.com/blevesearch/bleve/v2/analysis/token/ngram"
+	_ "github.com/blevesearch/bleve/v2/analysis/token/reverse"
+	_ "github.com/blevesearch/bleve/v2/analysis/token/shingle"
+	_ "github.com/blevesearch/bleve/v2/analysis/token/truncate"
+	_ "github.com/blevesearch/bleve/v2/analysis/token/unique"
+	_ "github.com/blevesearch/bleve/v2/analysis/tokenizer/letter"
+	_ "github.com/blevesearch/bleve/v2/analysis/tokenizer/regexp"
+	_ "github.com/blevesearch/bleve/v2/analysis/tokenizer/web"
+	_ "github.com/blevesearch/bleve/v2/analysis/tokenizer/whitespace"
+	_ "github.com/blevesearch/bleve/v2/analysis/tokenmap"
+	_ "github.com/feimingxliu/quicksearch/internal/pkg/analyzer/gse"
+	_ "github.com/feimingxliu/quicksearch/internal/pkg/analyzer/jieba"
+	_ "github.com/feimingxliu/quicksearch/internal/pkg/analyzer/pinyin"
+	_ "github.com/feimingxliu/quicksearch/internal/pkg/analyzer/sego"
+	_ "github.com/feimingxliu/quicksearch/internal/pkg/analyzer/stconvert"
+	_ "github.com/feimingxliu/quicksearch/internal/pkg/analyzer/synonym"
+)
@@ -2,19 +2,37 @@ package gse
 
 import (
 	"errors"
+	"fmt"
 	"github.com/blevesearch/bleve/v2/analysis"
 	"github.com/blevesearch/bleve/v2/registry"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/userdict"
 	"github.com/go-ego/gse"
 )
 
 const Name = "gse"
 
 type GseTokenizer struct {
-	seg *gse.Segmenter
+	seg *userdict.Segmenter
 }
 
-// NewGseTokenizer create a gse cut tokenizer
+// NewGseTokenizer create a gse cut tokenizer, the entries of user dict are added to the segmenter
+// which is rebuilt when the user dict changes.
 func NewGseTokenizer(dictPath, stopPath string, alpha bool) (*GseTokenizer, error) {
+	dict, err := userdict.Get(Name)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := dict.Segmenter(fmt.Sprintf("%s|%s|%t", dictPath, stopPath, alpha), func(entries []userdict.Entry) (interface{}, error) {
+		return newSegmenter(dictPath, stopPath, alpha, entries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GseTokenizer{seg: seg}, nil
+}
+
+// newSegmenter creates a segmenter with the entries of user dict.
+func newSegmenter(dictPath, stopPath string, alpha bool, entries []userdict.Entry) (*gse.Segmenter, error) {
 	var (
 		seg gse.Segmenter
 		err error
@@ -48,16 +66,26 @@ func NewGseTokenizer(dictPath, stopPath string, alpha bool) (*GseTokenizer, erro
 			return nil, err
 		}
 	}
-	return &GseTokenizer{seg: &seg}, nil
+
+	if len(entries) > 0 {
+		for _, entry := range entries {
+			if err = seg.AddToken(entry.Word, float64(entry.Freq), entry.Pos); err != nil {
+				return nil, err
+			}
+		}
+		seg.CalcToken()
+	}
+	return &seg, nil
 }
 
 // Tokenize cut the text to bleve token stream
 func (g *GseTokenizer) Tokenize(text []byte) analysis.TokenStream {
+	seg := g.seg.Load().(*gse.Segmenter)
 	result := make(analysis.TokenStream, 0)
 	t := string(text)
-	cuts := g.seg.Trim(g.seg.CutSearch(t, true))
+	cuts := seg.Trim(seg.CutSearch(t, true))
 	// fmt.Println("cuts: ", cuts)
-	azs := g.seg.Analyze(cuts, t)
+	azs := seg.Analyze(cuts, t)
 	for _, az := range azs {
 		typ := analysis.Ideographic
 		alphaNumeric := true
@@ -74,7 +102,7 @@ func (g *GseTokenizer) Tokenize(text []byte) analysis.TokenStream {
 			Term:     []byte(az.Text),
 			Start:    az.Start,
 			End:      az.End,
-			Position: az.Position,
+			Position: az.Position + 1, // the positions of bleve start from 1
 			Type:     typ,
 		}
 		result = append(result, &token)
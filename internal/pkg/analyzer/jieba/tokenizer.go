@@ -3,46 +3,87 @@ package jieba
 import (
 	"errors"
 	"path"
+	"runtime"
+	"strings"
 
 	"github.com/blevesearch/bleve/v2/analysis"
 	"github.com/blevesearch/bleve/v2/registry"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/userdict"
+	"github.com/feimingxliu/quicksearch/pkg/util"
 	"github.com/yanyiwu/gojieba"
 )
 
 const Name = "gojieba"
 
 type JiebaTokenizer struct {
-	handle *gojieba.Jieba
+	handle *userdict.Segmenter
 }
 
-func NewJiebaTokenizer(dictpath, hmmpath, userdictpath, idf, stop_words string) *JiebaTokenizer {
+// jieba is freed when it's unreachable, since it's shared by the tokenizers and replaced when the user
+// dict changes.
+type jieba struct {
+	*gojieba.Jieba
+}
+
+// NewJiebaTokenizer creates the tokenizer, the words of user dict are added to the jieba which is
+// rebuilt when the user dict changes.
+func NewJiebaTokenizer(dictpath, hmmpath, userdictpath, idf, stop_words string) (*JiebaTokenizer, error) {
 	if dictpath == "" {
-		dictpath = path.Join(DictDir, "jieba.dict.utf8")
+		dictpath = defaultDictPath("jieba.dict.utf8", gojieba.DICT_PATH)
 	}
 	if hmmpath == "" {
-		hmmpath = path.Join(DictDir, "hmm_model.utf8")
+		hmmpath = defaultDictPath("hmm_model.utf8", gojieba.HMM_PATH)
 	}
 	if userdictpath == "" {
-		userdictpath = path.Join(DictDir, "user.dict.utf8")
+		userdictpath = defaultDictPath("user.dict.utf8", gojieba.USER_DICT_PATH)
 	}
 	if idf == "" {
-		idf = path.Join(DictDir, "idf.utf8")
+		idf = defaultDictPath("idf.utf8", gojieba.IDF_PATH)
 	}
 	if stop_words == "" {
-		stop_words = path.Join(DictDir, "stop_words.utf8")
+		stop_words = defaultDictPath("stop_words.utf8", gojieba.STOP_WORDS_PATH)
+	}
+	dict, err := userdict.Get(Name)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{dictpath, hmmpath, userdictpath, idf, stop_words}
+	handle, err := dict.Segmenter(strings.Join(paths, "|"), func(entries []userdict.Entry) (interface{}, error) {
+		handle := &jieba{gojieba.NewJieba(paths...)}
+		for _, entry := range entries {
+			handle.AddWord(entry.Word)
+		}
+		runtime.SetFinalizer(handle, func(handle *jieba) {
+			handle.Free()
+		})
+		return handle, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &JiebaTokenizer{handle: handle}, nil
+}
+
+// defaultDictPath returns the dict released to DictDir, or the dict of gojieba if not embedded.
+func defaultDictPath(name, fallback string) string {
+	p := path.Join(DictDir, name)
+	if exist, err := util.FileExists(p); err != nil || !exist {
+		return fallback
 	}
-	x := gojieba.NewJieba(dictpath, hmmpath, userdictpath, idf, stop_words)
-	return &JiebaTokenizer{x}
+	return p
 }
 
+// Free does nothing, the jieba is shared by the tokenizers with the same dicts and freed when it's unreachable.
 func (x *JiebaTokenizer) Free() {
-	x.handle.Free()
 }
 
 func (x *JiebaTokenizer) Tokenize(sentence []byte) analysis.TokenStream {
+	handle := x.handle.Load().(*jieba)
 	result := make(analysis.TokenStream, 0)
 	pos := 1
-	words := x.handle.Tokenize(string(sentence), gojieba.SearchMode, true)
+	words := handle.Tokenize(string(sentence), gojieba.SearchMode, true)
+	// the jieba is not freed while tokenizing.
+	runtime.KeepAlive(handle)
 	for _, word := range words {
 		typ := analysis.Ideographic
 		alphaNumeric := true
@@ -89,7 +130,7 @@ func tokenizerConstructor(config map[string]interface{}, cache *registry.Cache)
 	if !ok {
 		return nil, errors.New("config stop_words not found")
 	}
-	return NewJiebaTokenizer(dictpath, hmmpath, userdictpath, idf, stop_words), nil
+	return NewJiebaTokenizer(dictpath, hmmpath, userdictpath, idf, stop_words)
 }
 
 func init() {
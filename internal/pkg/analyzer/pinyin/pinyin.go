@@ -0,0 +1,131 @@
+package pinyin
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/registry"
+	"github.com/mozillazg/go-pinyin"
+)
+
+const Name = "pinyin"
+
+// PinyinFilter adds the pinyin of the tokens containing chinese characters at the same position, e.g. the
+// token "北京" has the joined full pinyin "beijing", the first letters "bj" and the full pinyin "bei", "jing".
+// The other characters are kept in the pinyin.
+type PinyinFilter struct {
+	keepOriginal         bool
+	keepJoinedFullPinyin bool
+	keepFirstLetter      bool
+	keepFullPinyin       bool
+	args                 pinyin.Args
+}
+
+func NewPinyinFilter(keepOriginal, keepJoinedFullPinyin, keepFirstLetter, keepFullPinyin bool) *PinyinFilter {
+	args := pinyin.NewArgs()
+	args.Fallback = func(r rune, a pinyin.Args) []string {
+		return []string{string(unicode.ToLower(r))}
+	}
+	return &PinyinFilter{
+		keepOriginal:         keepOriginal,
+		keepJoinedFullPinyin: keepJoinedFullPinyin,
+		keepFirstLetter:      keepFirstLetter,
+		keepFullPinyin:       keepFullPinyin,
+		args:                 args,
+	}
+}
+
+func (f *PinyinFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	output := make(analysis.TokenStream, 0, len(input))
+	for _, token := range input {
+		if !hasHan(token.Term) {
+			output = append(output, token)
+			continue
+		}
+		terms := make([]string, 0, 4)
+		if f.keepOriginal {
+			terms = append(terms, string(token.Term))
+		}
+		pys := pinyin.LazyPinyin(string(token.Term), f.args)
+		if f.keepJoinedFullPinyin {
+			terms = append(terms, strings.Join(pys, ""))
+		}
+		if f.keepFirstLetter {
+			var sb strings.Builder
+			for _, py := range pys {
+				r := []rune(py)
+				sb.WriteRune(r[0])
+			}
+			terms = append(terms, sb.String())
+		}
+		if f.keepFullPinyin {
+			for _, py := range pys {
+				if hasLetter(py) {
+					terms = append(terms, py)
+				}
+			}
+		}
+		seen := make(map[string]struct{}, len(terms))
+		for _, term := range terms {
+			if _, ok := seen[term]; ok {
+				continue
+			}
+			seen[term] = struct{}{}
+			output = append(output, &analysis.Token{
+				Term:     []byte(term),
+				Start:    token.Start,
+				End:      token.End,
+				Position: token.Position,
+				Type:     token.Type,
+				KeyWord:  token.KeyWord,
+			})
+		}
+	}
+	return output
+}
+
+func hasHan(term []byte) bool {
+	for _, r := range string(term) {
+		if unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLetter(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterConstructor(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+	options := map[string]bool{
+		"keep_original":           true,
+		"keep_joined_full_pinyin": true,
+		"keep_first_letter":       true,
+		"keep_full_pinyin":        false,
+	}
+	for option := range options {
+		if v, ok := config[option]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, errors.New("config " + option + " must be a bool")
+			}
+			options[option] = b
+		}
+	}
+	if !options["keep_joined_full_pinyin"] && !options["keep_first_letter"] && !options["keep_full_pinyin"] {
+		return nil, errors.New("one of keep_joined_full_pinyin, keep_first_letter or keep_full_pinyin must be true")
+	}
+	return NewPinyinFilter(options["keep_original"], options["keep_joined_full_pinyin"], options["keep_first_letter"], options["keep_full_pinyin"]), nil
+}
+
+func init() {
+	registry.RegisterTokenFilter(Name, filterConstructor)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/blevesearch/bleve/v2/analysis"
 	"github.com/blevesearch/bleve/v2/registry"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/userdict"
 	"github.com/huichen/sego"
 	"path"
 )
@@ -11,22 +12,40 @@ import (
 const Name = "sego"
 
 type SegoTokenizer struct {
-	seg *sego.Segmenter
+	seg *userdict.Segmenter
 }
 
-func NewSegoTokenizer(dictPath string) *SegoTokenizer {
+// NewSegoTokenizer creates the tokenizer, the user dict is loaded before the dict, so its words take
+// precedence, and the segmenter is rebuilt when the user dict changes.
+func NewSegoTokenizer(dictPath string) (*SegoTokenizer, error) {
 	if dictPath == "" {
 		dictPath = path.Join(DictDir, "dictionary.txt")
 	}
-	seg := new(sego.Segmenter)
-	seg.LoadDictionary(dictPath)
-	return &SegoTokenizer{seg: seg}
+	dict, err := userdict.Get(Name)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := dict.Segmenter(dictPath, func(entries []userdict.Entry) (interface{}, error) {
+		// the segmenter is created with the dict file of user dict.
+		files := dictPath
+		if len(entries) > 0 {
+			files = dict.Path() + "," + files
+		}
+		seg := new(sego.Segmenter)
+		seg.LoadDictionary(files)
+		return seg, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SegoTokenizer{seg: seg}, nil
 }
 
-func (s SegoTokenizer) Tokenize(text []byte) analysis.TokenStream {
+func (s *SegoTokenizer) Tokenize(text []byte) analysis.TokenStream {
+	seg := s.seg.Load().(*sego.Segmenter)
 	result := make(analysis.TokenStream, 0)
 	pos := 1
-	segments := s.seg.Segment(text)
+	segments := seg.Segment(text)
 	for _, segment := range segments {
 		typ := analysis.Ideographic
 		alphaNumeric := true
@@ -57,7 +76,7 @@ func tokenizerConstructor(config map[string]interface{}, cache *registry.Cache)
 	if !ok {
 		return nil, errors.New("config dict_path not found")
 	}
-	return NewSegoTokenizer(dictpath), nil
+	return NewSegoTokenizer(dictpath)
 }
 
 func init() {
@@ -0,0 +1,78 @@
+package stconvert
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/registry"
+	"github.com/longbridgeapp/opencc"
+)
+
+const Name = "stconvert"
+
+// STConvertFilter converts the tokens between the traditional and simplified chinese, so the tokens of both
+// scripts are normalized to the same form. The original tokens are kept at the same position if keepBoth.
+type STConvertFilter struct {
+	cc       *opencc.OpenCC
+	keepBoth bool
+}
+
+// NewSTConvertFilter creates the filter of the convert type, "t2s" or "s2t".
+func NewSTConvertFilter(convertType string, keepBoth bool) (*STConvertFilter, error) {
+	if convertType != "t2s" && convertType != "s2t" {
+		return nil, fmt.Errorf("unknown convert_type [%s]", convertType)
+	}
+	cc, err := opencc.New(convertType)
+	if err != nil {
+		return nil, err
+	}
+	return &STConvertFilter{cc: cc, keepBoth: keepBoth}, nil
+}
+
+func (f *STConvertFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	output := make(analysis.TokenStream, 0, len(input))
+	for _, token := range input {
+		converted, err := f.cc.Convert(string(token.Term))
+		if err != nil || converted == string(token.Term) {
+			output = append(output, token)
+			continue
+		}
+		if f.keepBoth {
+			output = append(output, token)
+		}
+		output = append(output, &analysis.Token{
+			Term:     []byte(converted),
+			Start:    token.Start,
+			End:      token.End,
+			Position: token.Position,
+			Type:     token.Type,
+			KeyWord:  token.KeyWord,
+		})
+	}
+	return output
+}
+
+func filterConstructor(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+	convertType := "t2s"
+	if v, ok := config["convert_type"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("config convert_type must be a string")
+		}
+		convertType = s
+	}
+	keepBoth := false
+	if v, ok := config["keep_both"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.New("config keep_both must be a bool")
+		}
+		keepBoth = b
+	}
+	return NewSTConvertFilter(convertType, keepBoth)
+}
+
+func init() {
+	registry.RegisterTokenFilter(Name, filterConstructor)
+}
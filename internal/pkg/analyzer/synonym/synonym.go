@@ -0,0 +1,149 @@
+package synonym
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/registry"
+)
+
+const Name = "synonym"
+
+// SynonymFilter replaces the tokens with their synonyms at the same position. The rules are in solr format:
+// "a, b, c" means the terms are equivalent, "a, b => c" means a and b are replaced by c. Only the single term
+// synonyms are supported, the rules should be lowercased if the filter is after a lowercase filter.
+type SynonymFilter struct {
+	synonyms map[string][]string
+}
+
+// NewSynonymFilter parses the rules, the equivalent terms are replaced by all of them if expand, otherwise by
+// the first one.
+func NewSynonymFilter(rules []string, expand bool) (*SynonymFilter, error) {
+	synonyms := make(map[string][]string)
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" || strings.HasPrefix(rule, "#") {
+			continue
+		}
+		if parts := strings.Split(rule, "=>"); len(parts) == 2 {
+			from, to := splitTerms(parts[0]), splitTerms(parts[1])
+			if len(from) == 0 || len(to) == 0 {
+				return nil, fmt.Errorf("invalid synonym rule [%s]", rule)
+			}
+			for _, term := range from {
+				synonyms[term] = appendUnique(synonyms[term], to...)
+			}
+			continue
+		} else if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid synonym rule [%s]", rule)
+		}
+		terms := splitTerms(rule)
+		for _, term := range terms {
+			if expand {
+				synonyms[term] = appendUnique(synonyms[term], terms...)
+			} else {
+				synonyms[term] = appendUnique(synonyms[term], terms[0])
+			}
+		}
+	}
+	return &SynonymFilter{synonyms: synonyms}, nil
+}
+
+func splitTerms(s string) []string {
+	terms := make([]string, 0)
+	for _, term := range strings.Split(s, ",") {
+		if term = strings.TrimSpace(term); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func appendUnique(terms []string, added ...string) []string {
+	for _, term := range added {
+		found := false
+		for _, t := range terms {
+			if t == term {
+				found = true
+				break
+			}
+		}
+		if !found {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func (f *SynonymFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	output := make(analysis.TokenStream, 0, len(input))
+	for _, token := range input {
+		synonyms, ok := f.synonyms[string(token.Term)]
+		if !ok {
+			output = append(output, token)
+			continue
+		}
+		for _, synonym := range synonyms {
+			output = append(output, &analysis.Token{
+				Term:     []byte(synonym),
+				Start:    token.Start,
+				End:      token.End,
+				Position: token.Position,
+				Type:     token.Type,
+				KeyWord:  token.KeyWord,
+			})
+		}
+	}
+	return output
+}
+
+// loadRules reads the rules from file, one rule per line.
+func loadRules(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rules = append(rules, scanner.Text())
+	}
+	return rules, scanner.Err()
+}
+
+func filterConstructor(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+	rules := make([]string, 0)
+	if synonyms, ok := config["synonyms"].([]interface{}); ok {
+		for _, synonym := range synonyms {
+			rule, ok := synonym.(string)
+			if !ok {
+				return nil, errors.New("config synonyms must be an array of string")
+			}
+			rules = append(rules, rule)
+		}
+	}
+	if path, ok := config["synonyms_path"].(string); ok && path != "" {
+		loaded, err := loadRules(path)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, loaded...)
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("config synonyms or synonyms_path not found")
+	}
+	expand, ok := config["expand"].(bool)
+	if !ok {
+		expand = true
+	}
+	return NewSynonymFilter(rules, expand)
+}
+
+func init() {
+	registry.RegisterTokenFilter(Name, filterConstructor)
+}
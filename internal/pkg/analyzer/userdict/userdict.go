@@ -0,0 +1,288 @@
+package userdict
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultFreq is the frequency of the entry without frequency.
+const DefaultFreq = 100
+
+// Entry is a word of user dict, it's in the format "word [freq] [pos]" in the dict file.
+type Entry struct {
+	Word string `json:"word"`
+	Freq int    `json:"freq"`
+	Pos  string `json:"pos,omitempty"`
+}
+
+// ParseEntry parses the line in the format "word [freq] [pos]".
+func ParseEntry(line string) (Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 3 {
+		return Entry{}, fmt.Errorf("invalid user dict entry [%s]", line)
+	}
+	entry := Entry{Word: fields[0], Freq: DefaultFreq}
+	if len(fields) > 1 {
+		freq, err := strconv.Atoi(fields[1])
+		if err != nil || freq <= 0 {
+			return Entry{}, fmt.Errorf("invalid frequency of user dict entry [%s]", line)
+		}
+		entry.Freq = freq
+	}
+	if len(fields) > 2 {
+		entry.Pos = fields[2]
+	}
+	return entry, nil
+}
+
+func (e Entry) String() string {
+	if len(e.Pos) > 0 {
+		return fmt.Sprintf("%s %d %s", e.Word, e.Freq, e.Pos)
+	}
+	return fmt.Sprintf("%s %d", e.Word, e.Freq)
+}
+
+// Dict is the user dict of a tokenizer type, the version is increased when the entries change.
+type Dict struct {
+	name       string
+	version    uint64
+	entries    []Entry
+	mu         sync.RWMutex
+	segmenters map[string]*Segmenter // the segmenters built with the dict by the tokenizer configs
+	segMu      sync.Mutex
+}
+
+var (
+	dir   = path.Join("data", "dict", "user")
+	dirMu sync.RWMutex
+	dicts = make(map[string]*Dict)
+	mu    sync.Mutex
+)
+
+// SetDir sets the dir of dict files, the loaded dicts are reloaded from the dir.
+func SetDir(d string) error {
+	dirMu.Lock()
+	dir = d
+	dirMu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	for _, dict := range dicts {
+		if err := dict.load(); err != nil {
+			return err
+		}
+		dict.rebuild()
+	}
+	return nil
+}
+
+// Get returns the user dict of the tokenizer type, which is loaded from file at first time.
+func Get(name string) (*Dict, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if dict, ok := dicts[name]; ok {
+		return dict, nil
+	}
+	dict := &Dict{name: name, segmenters: make(map[string]*Segmenter)}
+	if err := dict.load(); err != nil {
+		return nil, err
+	}
+	dicts[name] = dict
+	return dict, nil
+}
+
+func (d *Dict) Version() uint64 {
+	return atomic.LoadUint64(&d.version)
+}
+
+// Entries returns a copy of the entries.
+func (d *Dict) Entries() []Entry {
+	entries, _ := d.snapshot()
+	return entries
+}
+
+// snapshot returns a copy of the entries and their version.
+func (d *Dict) snapshot() ([]Entry, uint64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]Entry(nil), d.entries...), d.Version()
+}
+
+// Path returns the dict file, which exists only if the entries have been saved.
+func (d *Dict) Path() string {
+	dirMu.RLock()
+	defer dirMu.RUnlock()
+	return path.Join(dir, d.name+".txt")
+}
+
+// Append adds the entries, the existing words are updated. It returns after the segmenters are rebuilt.
+func (d *Dict) Append(entries ...Entry) error {
+	d.mu.Lock()
+	merged := append([]Entry(nil), d.entries...)
+	for _, entry := range entries {
+		found := false
+		for i := range merged {
+			if merged[i].Word == entry.Word {
+				merged[i], found = entry, true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, entry)
+		}
+	}
+	err := d.save(merged)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	d.rebuild()
+	return nil
+}
+
+// Replace replaces all the entries. It returns after the segmenters are rebuilt.
+func (d *Dict) Replace(entries ...Entry) error {
+	d.mu.Lock()
+	err := d.save(append([]Entry(nil), entries...))
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	d.rebuild()
+	return nil
+}
+
+// save writes the entries to a temp file and renames it, so the tokenizers never read a partial file.
+// The caller must hold d.mu.
+func (d *Dict) save(entries []Entry) error {
+	p := d.Path()
+	if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(entry.String())
+		sb.WriteByte('\n')
+	}
+	if err := ioutil.WriteFile(p+".tmp", []byte(sb.String()), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(p+".tmp", p); err != nil {
+		return err
+	}
+	d.entries = entries
+	atomic.AddUint64(&d.version, 1)
+	return nil
+}
+
+// load reads the entries from the dict file.
+func (d *Dict) load() error {
+	entries := make([]Entry, 0)
+	f, err := os.Open(d.Path())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				entry, err := ParseEntry(line)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, entry)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	d.entries = entries
+	atomic.AddUint64(&d.version, 1)
+	d.mu.Unlock()
+	return nil
+}
+
+// Segmenter holds the segmenter of a tokenizer config built with the entries of dict. It's rebuilt in the
+// background when the dict changes and swapped atomically, so the tokenizers only load the current one while
+// analyzing. The tokenizers with the same config share the segmenter.
+type Segmenter struct {
+	dict    *Dict
+	build   func(entries []Entry) (interface{}, error)
+	current atomic.Value
+	version uint64     // the version of dict which the current segmenter is built with
+	mu      sync.Mutex // serializes the builds
+}
+
+// Segmenter returns the segmenter of the tokenizer config key, which is built by build with the entries of dict
+// at first time. The build must return the same type each time.
+func (d *Dict) Segmenter(key string, build func(entries []Entry) (interface{}, error)) (*Segmenter, error) {
+	d.segMu.Lock()
+	s, ok := d.segmenters[key]
+	if !ok {
+		s = &Segmenter{dict: d, build: build}
+		d.segmenters[key] = s
+	}
+	d.segMu.Unlock()
+	if s.Load() != nil {
+		return s, nil
+	}
+	if err := s.rebuild(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load returns the current segmenter.
+func (s *Segmenter) Load() interface{} {
+	return s.current.Load()
+}
+
+// rebuild builds the segmenter with the current entries of dict if they changed, the current segmenter is
+// kept if failed.
+func (s *Segmenter) rebuild() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, version := s.dict.snapshot()
+	if s.Load() != nil && s.version == version {
+		return nil
+	}
+	seg, err := s.build(entries)
+	if err != nil {
+		return err
+	}
+	s.current.Store(seg)
+	s.version = version
+	return nil
+}
+
+// rebuild rebuilds the segmenters of dict concurrently and waits for them. The segmenter failed to rebuild
+// keeps the current one, and retries after the next change.
+func (d *Dict) rebuild() {
+	d.segMu.Lock()
+	segmenters := make([]*Segmenter, 0, len(d.segmenters))
+	for _, s := range d.segmenters {
+		segmenters = append(segmenters, s)
+	}
+	d.segMu.Unlock()
+	var wg sync.WaitGroup
+	for _, s := range segmenters {
+		wg.Add(1)
+		go func(s *Segmenter) {
+			defer wg.Done()
+			if err := s.rebuild(); err != nil {
+				log.Printf("rebuild the segmenter of user dict [%s]: %s", d.name, err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
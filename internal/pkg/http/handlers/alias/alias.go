@@ -0,0 +1,39 @@
+package alias
+
+import (
+	"github.com/feimingxliu/quicksearch/internal/core"
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func Update(ctx *gin.Context) {
+	body := new(UpdateAliases)
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	if err := core.UpdateAliases(body.Actions); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, types.Common{Acknowledged: true})
+}
+
+func Get(ctx *gin.Context) {
+	aliasName := ctx.Param("alias")
+	if len(aliasName) == 0 {
+		ctx.JSON(http.StatusBadRequest, "alias required!")
+		return
+	}
+	alias, err := core.GetAlias(aliasName)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, alias)
+}
+
+func List(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, core.ListAliases())
+}
@@ -0,0 +1,7 @@
+package alias
+
+import "github.com/feimingxliu/quicksearch/internal/core"
+
+type UpdateAliases struct {
+	Actions []*core.AliasAction `json:"actions"`
+}
@@ -1,12 +1,13 @@
 package document
 
 import (
+	"context"
 	"github.com/feimingxliu/quicksearch/internal/core"
 	"github.com/feimingxliu/quicksearch/internal/pkg/http/types"
 	"github.com/feimingxliu/quicksearch/pkg/util/uuid"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"sync/atomic"
+	"strconv"
 )
 
 func Index(ctx *gin.Context) {
@@ -23,12 +24,12 @@ func Index(ctx *gin.Context) {
 	if docID = ctx.Param("id"); docID == "" {
 		docID = uuid.GetUUID()
 	}
-	err := index.IndexOrUpdateDocument(docID, source)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+	opts, ok := getWriteOptions(ctx)
+	if !ok {
 		return
 	}
-	ctx.JSON(http.StatusOK, core.NewBulkActionResult(index.Name, docID, "created", 201, nil, getSeqNo()))
+	res, err := index.IndexOrUpdateDocument(docID, source, opts...)
+	writeResult(ctx, res, err)
 }
 
 func Update(ctx *gin.Context) {
@@ -46,12 +47,12 @@ func Update(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
 		return
 	}
-	err := index.UpdateDocumentPartially(docID, fields)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+	opts, ok := getWriteOptions(ctx)
+	if !ok {
 		return
 	}
-	ctx.JSON(http.StatusOK, core.NewBulkActionResult(index.Name, docID, "updated", 200, nil, getSeqNo()))
+	res, err := index.UpdateDocumentPartially(docID, fields, opts...)
+	writeResult(ctx, res, err)
 }
 
 func Bulk(ctx *gin.Context) {
@@ -66,6 +67,23 @@ func Bulk(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, res)
 }
 
+func MultiGet(ctx *gin.Context) {
+	body := new(core.MultiGetRequest)
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	if body.Source == nil {
+		body.Source = core.NewSourceFilter(ctx.Query("_source"), ctx.Query("_source_includes"), ctx.Query("_source_excludes"))
+	}
+	res, err := core.MultiGet(ctx.Param("index"), body)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
 func Get(ctx *gin.Context) {
 	index, ok := getIndex(ctx)
 	if !ok {
@@ -94,12 +112,40 @@ func Delete(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, "doc ID required!")
 		return
 	}
-	err := index.DeleteDocument(docID)
+	opts, ok := getWriteOptions(ctx)
+	if !ok {
+		return
+	}
+	res, err := index.DeleteDocument(docID, opts...)
+	writeResult(ctx, res, err)
+}
+
+func DeleteByQuery(ctx *gin.Context) {
+	byQuery(ctx, core.DeleteByQuery)
+}
+
+func UpdateByQuery(ctx *gin.Context) {
+	byQuery(ctx, core.UpdateByQuery)
+}
+
+func byQuery(ctx *gin.Context, f func(context.Context, *core.ByQueryRequest, ...*core.Index) (*core.ByQueryResult, error)) {
+	body := new(core.ByQueryRequest)
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	// the indexName may be an alias refers to several indices.
+	indices, err := core.ResolveIndices(ctx.Param("index"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	res, err := f(ctx.Request.Context(), body, indices...)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusOK, core.NewBulkActionResult(index.Name, docID, "deleted", 200, nil, getSeqNo()))
+	ctx.JSON(http.StatusOK, res)
 }
 
 func getIndex(ctx *gin.Context) (*core.Index, bool) {
@@ -108,6 +154,12 @@ func getIndex(ctx *gin.Context) (*core.Index, bool) {
 		ctx.JSON(http.StatusBadRequest, "index required!")
 		return nil, false
 	}
+	// the documents are written to or read from the write index if indexName is an alias.
+	indexName, err := core.ResolveWriteIndex(indexName)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return nil, false
+	}
 	index, err := core.NewIndex(core.WithName(indexName))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
@@ -116,10 +168,43 @@ func getIndex(ctx *gin.Context) (*core.Index, bool) {
 	return index, true
 }
 
-var seqNo int64
+// getWriteOptions parses the precondition of write from query `if_seq_no`, `if_primary_term` and `version`.
+func getWriteOptions(ctx *gin.Context) ([]core.WriteOption, bool) {
+	opts := make([]core.WriteOption, 0)
+	params := make(map[string]int64)
+	for _, key := range []string{"if_seq_no", "if_primary_term", "version"} {
+		if value := ctx.Query(key); len(value) > 0 {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+				return nil, false
+			}
+			params[key] = n
+		}
+	}
+	if seqNo, ok := params["if_seq_no"]; ok {
+		term, ok := params["if_primary_term"]
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, "if_primary_term required with if_seq_no!")
+			return nil, false
+		}
+		opts = append(opts, core.IfSeqNo(seqNo, term))
+	}
+	if version, ok := params["version"]; ok {
+		opts = append(opts, core.IfVersion(version))
+	}
+	return opts, true
+}
 
-func getSeqNo() int64 {
-	res := seqNo
-	atomic.AddInt64(&seqNo, 1)
-	return res
+// writeResult responds the result of write with its status, e.g. 201 for the created document,
+// 409 for version conflict and 404 for document not found.
+func writeResult(ctx *gin.Context, res *core.BulkActionResult, err error) {
+	if res == nil {
+		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+		return
+	}
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	ctx.JSON(int(res.Status), res)
 }
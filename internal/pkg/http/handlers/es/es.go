@@ -0,0 +1,106 @@
+package es
+
+import (
+	"github.com/blevesearch/bleve/v2"
+	"github.com/feimingxliu/quicksearch/internal/core"
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Search performs the search in Elasticsearch Query DSL and responds in the shape of Elasticsearch.
+func Search(ctx *gin.Context) {
+	body, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	req := new(core.ESSearchRequest)
+	// calls UnmarshalJSON directly so the error isn't wrapped by the json decoder.
+	if err := req.UnmarshalJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	// the URI search parameters.
+	if q := ctx.Query("q"); len(q) > 0 {
+		req.Query = bleve.NewQueryStringQuery(q)
+	}
+	if from, err := strconv.Atoi(ctx.Query("from")); err == nil && from >= 0 {
+		req.From = from
+	}
+	if size, err := strconv.Atoi(ctx.Query("size")); err == nil && size > 0 {
+		req.Size = size
+	}
+	var indices []*core.Index
+	if indexName := ctx.Param("index"); len(indexName) > 0 {
+		// the indexName may be an alias refers to several indices.
+		if indices, err = core.ResolveIndices(indexName); err != nil {
+			ctx.JSON(http.StatusNotFound, types.Common{Error: err.Error()})
+			return
+		}
+	}
+	// open a scroll if the keepalive specified.
+	if keepAlive := ctx.Query("scroll"); len(keepAlive) > 0 {
+		duration, err := time.ParseDuration(keepAlive)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+		res, err := core.NewScroll(&req.SearchRequest, duration, indices...)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, core.NewESSearchResponse(res))
+		return
+	}
+	res, err := core.ESSearchIndices(req, indices...)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// MultiSearch performs the searches in Elasticsearch Query DSL and responds in the shape of Elasticsearch.
+func MultiSearch(ctx *gin.Context) {
+	body := ctx.Request.Body
+	defer body.Close()
+	maxConcurrent, _ := strconv.Atoi(ctx.Query("max_concurrent_searches"))
+	res, err := core.ESMultiSearch(ctx.Param("index"), body, maxConcurrent)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// Scroll fetches the next page of scroll and responds in the shape of Elasticsearch.
+func Scroll(ctx *gin.Context) {
+	body := new(ScrollRequest)
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	var (
+		duration time.Duration
+		err      error
+	)
+	if len(body.Scroll) > 0 {
+		if duration, err = time.ParseDuration(body.Scroll); err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+	}
+	res, err := core.ScrollNext(body.ScrollID, duration)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, core.NewESSearchResponse(res))
+}
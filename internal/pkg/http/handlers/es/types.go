@@ -0,0 +1,6 @@
+package es
+
+type ScrollRequest struct {
+	Scroll   string `json:"scroll"` // extends the keepalive of scroll, e.g. "1m"
+	ScrollID string `json:"scroll_id"`
+}
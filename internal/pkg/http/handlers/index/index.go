@@ -3,10 +3,12 @@ package index
 import (
 	"fmt"
 	"github.com/feimingxliu/quicksearch/internal/core"
+	"github.com/feimingxliu/quicksearch/internal/pkg/analyzer/userdict"
 	"github.com/feimingxliu/quicksearch/internal/pkg/http/types"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
+	"strings"
 )
 
 func Create(ctx *gin.Context) {
@@ -24,7 +26,7 @@ func Create(ctx *gin.Context) {
 	}
 	options := make([]core.Option, 0)
 	if body.Settings != nil {
-		options = append(options, core.WithShards(body.Settings.NumberOfShards))
+		options = append(options, core.WithShards(body.Settings.NumberOfShards), core.WithTranslog(body.Settings.Translog))
 	}
 	if body.Mappings != nil {
 		options = append(options, core.WithIndexMapping(body.Mappings))
@@ -137,13 +139,190 @@ func UpdateMapping(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
 		return
 	}
-	_ = index.SetMapping(mapping)
-	err := index.UpdateMetadata()
+	task, err := index.UpdateMapping(mapping, ctx.Query("reindex") == "true")
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, types.Common{Acknowledged: false, Error: fmt.Sprintf("%+v", err)})
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusOK, types.Common{Acknowledged: true})
+	res := UpdateMappingResult{Acknowledged: true}
+	if task != nil {
+		res.Task = task.ID
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func GetMapping(ctx *gin.Context) {
+	indices, err := core.ResolveIndexList(ctx.Param("index"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	res := make(map[string]*core.MappingResult, len(indices))
+	for _, index := range indices {
+		if res[index.Name], err = index.GetMapping(); err != nil {
+			ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func FieldCaps(ctx *gin.Context) {
+	indices, err := core.ResolveIndexList(ctx.Param("index"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	var fields []string
+	for _, field := range strings.Split(ctx.Query("fields"), ",") {
+		if field = strings.TrimSpace(field); len(field) > 0 {
+			fields = append(fields, field)
+		}
+	}
+	res, err := core.FieldCapabilities(fields, indices...)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func Reindex(ctx *gin.Context) {
+	body := new(core.ReindexRequest)
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	task, err := core.Reindex(body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	if ctx.Query("wait_for_completion") == "true" {
+		task.Wait()
+		ctx.JSON(http.StatusOK, task)
+		return
+	}
+	ctx.JSON(http.StatusOK, ReindexResult{Task: task.ID})
+}
+
+// Analyze analyzes the text without index.
+func Analyze(ctx *gin.Context) {
+	req, ok := bindAnalyzeRequest(ctx)
+	if !ok {
+		return
+	}
+	res, err := core.Analyze(req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// AnalyzeIndex analyzes the text with the analyzers of index.
+func AnalyzeIndex(ctx *gin.Context) {
+	index, ok := getIndex(ctx)
+	if !ok {
+		return
+	}
+	req, ok := bindAnalyzeRequest(ctx)
+	if !ok {
+		return
+	}
+	res, err := index.Analyze(req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// bindAnalyzeRequest binds the body, or the query parameters if no body, e.g. ?text=foo&analyzer=standard.
+func bindAnalyzeRequest(ctx *gin.Context) (*core.AnalyzeRequest, bool) {
+	req := new(core.AnalyzeRequest)
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		if err != io.EOF {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return nil, false
+		}
+		if texts := ctx.QueryArray("text"); len(texts) > 0 {
+			req.Text = texts
+		}
+		req.Analyzer, req.Field = ctx.Query("analyzer"), ctx.Query("field")
+		if tokenizer := ctx.Query("tokenizer"); len(tokenizer) > 0 {
+			req.Tokenizer = tokenizer
+		}
+		for _, filter := range ctx.QueryArray("char_filters") {
+			req.CharFilters = append(req.CharFilters, filter)
+		}
+		for _, filter := range ctx.QueryArray("token_filters") {
+			req.TokenFilters = append(req.TokenFilters, filter)
+		}
+	}
+	return req, true
+}
+
+func GetUserDict(ctx *gin.Context) {
+	entries, err := core.GetUserDict(ctx.Param("analyzer"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, entries)
+}
+
+// UpdateUserDict appends the entries to the user dict, PUT replaces all the entries. The entries are in the
+// JSON body or the text body of one entry per line. The affected indices are reindexed if `?reindex=true`.
+func UpdateUserDict(ctx *gin.Context) {
+	lines := make([]string, 0)
+	if ctx.ContentType() == "text/plain" {
+		data, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+		lines = strings.Split(string(data), "\n")
+	} else {
+		body := new(UserDict)
+		if err := ctx.ShouldBindJSON(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+		lines = body.Entries
+	}
+	entries := make([]userdict.Entry, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := userdict.ParseEntry(line)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+		entries = append(entries, entry)
+	}
+	analyzer := ctx.Param("analyzer")
+	if err := core.UpdateUserDict(analyzer, entries, ctx.Request.Method == http.MethodPut); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	res := UserDictResult{Acknowledged: true}
+	if all, err := core.GetUserDict(analyzer); err == nil {
+		res.Entries = len(all)
+	}
+	if ctx.Query("reindex") == "true" {
+		task, err := core.ReindexForUserDict(analyzer)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+			return
+		}
+		if task != nil {
+			res.Task = task.ID
+		}
+	}
+	ctx.JSON(http.StatusOK, res)
 }
 
 func getIndex(ctx *gin.Context) (*core.Index, bool) {
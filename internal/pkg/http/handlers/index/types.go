@@ -8,5 +8,25 @@ type CreateIndex struct {
 }
 
 type Settings struct {
-	NumberOfShards int `json:"number_of_shards"`
+	NumberOfShards int                    `json:"number_of_shards"`
+	Translog       *core.TranslogSettings `json:"translog"`
+}
+
+type ReindexResult struct {
+	Task string `json:"task"`
+}
+
+type UpdateMappingResult struct {
+	Acknowledged bool   `json:"_acknowledged"`
+	Task         string `json:"task,omitempty"` // the task reindexing the index for the incompatible changes
+}
+
+type UserDict struct {
+	Entries []string `json:"entries"` // in the format "word [freq] [pos]"
+}
+
+type UserDictResult struct {
+	Acknowledged bool   `json:"_acknowledged"`
+	Entries      int    `json:"entries"`        // the number of entries in the user dict
+	Task         string `json:"task,omitempty"` // the task reindexing the affected indices
 }
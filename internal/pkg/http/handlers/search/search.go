@@ -5,6 +5,8 @@ import (
 	"github.com/feimingxliu/quicksearch/internal/pkg/http/types"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func Search(ctx *gin.Context) {
@@ -14,26 +16,42 @@ func Search(ctx *gin.Context) {
 		return
 	}
 	var (
-		index *core.Index
-		res   *core.SearchResult
-		err   error
+		indices []*core.Index
+		res     *core.SearchResult
+		err     error
 	)
 	indexName := ctx.Param("index")
 	if len(indexName) > 0 {
-		index, err = core.GetIndex(indexName)
+		// the indexName may be an alias refers to several indices.
+		indices, err = core.ResolveIndices(indexName)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
 			return
 		}
 	}
-	if index == nil {
+	// open a scroll if the keepalive specified.
+	if keepAlive := ctx.Query("scroll"); len(keepAlive) > 0 {
+		duration, err := time.ParseDuration(keepAlive)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+		res, err = core.NewScroll(searchRequest, duration, indices...)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+	if indices == nil {
 		res, err = core.Search(searchRequest)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
 			return
 		}
 	} else {
-		res, err = index.Search(searchRequest)
+		res, err = core.SearchIndices(searchRequest, indices...)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
 			return
@@ -41,3 +59,57 @@ func Search(ctx *gin.Context) {
 	}
 	ctx.JSON(http.StatusOK, res)
 }
+
+func MultiSearch(ctx *gin.Context) {
+	body := ctx.Request.Body
+	defer body.Close()
+	maxConcurrent, _ := strconv.Atoi(ctx.Query("max_concurrent_searches"))
+	res, err := core.MultiSearch(ctx.Param("index"), body, maxConcurrent)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func Scroll(ctx *gin.Context) {
+	body := new(ScrollRequest)
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	var (
+		duration time.Duration
+		err      error
+	)
+	if len(body.Scroll) > 0 {
+		duration, err = time.ParseDuration(body.Scroll)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+			return
+		}
+	}
+	res, err := core.ScrollNext(body.ScrollID, duration)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func ClearScroll(ctx *gin.Context) {
+	body := new(ClearScrollRequest)
+	if scrollID := ctx.Param("id"); len(scrollID) > 0 {
+		body.ScrollID = []string{scrollID}
+	} else if err := ctx.ShouldBindJSON(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	for _, scrollID := range body.ScrollID {
+		if err := core.ClearScroll(scrollID); err != nil {
+			ctx.JSON(http.StatusNotFound, types.Common{Error: err.Error()})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, types.Common{Acknowledged: true})
+}
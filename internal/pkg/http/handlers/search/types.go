@@ -0,0 +1,10 @@
+package search
+
+type ScrollRequest struct {
+	Scroll   string `json:"scroll"` // extends the keepalive of scroll, e.g. "1m"
+	ScrollID string `json:"scroll_id"`
+}
+
+type ClearScrollRequest struct {
+	ScrollID []string `json:"scroll_id"`
+}
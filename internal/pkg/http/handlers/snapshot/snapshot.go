@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"github.com/feimingxliu/quicksearch/internal/core"
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/types"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+)
+
+func PutRepository(ctx *gin.Context) {
+	repo := new(core.Repository)
+	if err := ctx.ShouldBindJSON(repo); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	if err := core.PutRepository(ctx.Param("repo"), repo); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, types.Common{Acknowledged: true})
+}
+
+func GetRepository(ctx *gin.Context) {
+	repo, err := core.GetRepository(ctx.Param("repo"))
+	if err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, repo)
+}
+
+func ListRepositories(ctx *gin.Context) {
+	repos, err := core.ListRepositories()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, repos)
+}
+
+func DeleteRepository(ctx *gin.Context) {
+	if err := core.DeleteRepository(ctx.Param("repo")); err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, types.Common{Acknowledged: true})
+}
+
+// Create starts the snapshot task, it waits for the task to complete if `?wait_for_completion=true`.
+func Create(ctx *gin.Context) {
+	req := new(core.SnapshotRequest)
+	if err := ctx.ShouldBindJSON(req); err != nil && err != io.EOF {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	task, err := core.CreateSnapshot(ctx.Param("repo"), ctx.Param("snapshot"), req)
+	if err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	respondTask(ctx, task)
+}
+
+func Get(ctx *gin.Context) {
+	snapshot, err := core.GetSnapshot(ctx.Param("repo"), ctx.Param("snapshot"))
+	if err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, snapshot)
+}
+
+func List(ctx *gin.Context) {
+	snapshots, err := core.ListSnapshots(ctx.Param("repo"))
+	if err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, snapshots)
+}
+
+func Delete(ctx *gin.Context) {
+	if err := core.DeleteSnapshot(ctx.Param("repo"), ctx.Param("snapshot")); err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, types.Common{Acknowledged: true})
+}
+
+// Restore starts the restore task, it waits for the task to complete if `?wait_for_completion=true`.
+func Restore(ctx *gin.Context) {
+	req := new(core.RestoreRequest)
+	if err := ctx.ShouldBindJSON(req); err != nil && err != io.EOF {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	task, err := core.RestoreSnapshot(ctx.Param("repo"), ctx.Param("snapshot"), req)
+	if err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	respondTask(ctx, task)
+}
+
+// Verify starts the task re-hashing all blobs in the repository, it waits for the task to complete if
+// `?wait_for_completion=true`.
+func Verify(ctx *gin.Context) {
+	task, err := core.VerifyRepository(ctx.Param("repo"))
+	if err != nil {
+		ctx.JSON(errorStatus(err), types.Common{Error: err.Error()})
+		return
+	}
+	respondTask(ctx, task)
+}
+
+func respondTask(ctx *gin.Context, task *core.Task) {
+	if ctx.Query("wait_for_completion") == "true" {
+		task.Wait()
+		ctx.JSON(http.StatusOK, task)
+		return
+	}
+	ctx.JSON(http.StatusOK, TaskResult{Task: task.ID})
+}
+
+func errorStatus(err error) int {
+	if err == errors.ErrRepositoryNotFound || err == errors.ErrSnapshotNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
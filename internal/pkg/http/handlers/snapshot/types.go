@@ -0,0 +1,5 @@
+package snapshot
+
+type TaskResult struct {
+	Task string `json:"task"`
+}
@@ -0,0 +1,49 @@
+package task
+
+import (
+	"github.com/feimingxliu/quicksearch/internal/core"
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func Get(ctx *gin.Context) {
+	task, ok := getTask(ctx)
+	if !ok {
+		return
+	}
+	if ctx.Query("wait_for_completion") == "true" {
+		task.Wait()
+	}
+	ctx.JSON(http.StatusOK, task)
+}
+
+func List(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, core.ListTasks())
+}
+
+func Cancel(ctx *gin.Context) {
+	task, ok := getTask(ctx)
+	if !ok {
+		return
+	}
+	if err := task.Cancel(); err != nil {
+		ctx.JSON(http.StatusBadRequest, types.Common{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, types.Common{Acknowledged: true})
+}
+
+func getTask(ctx *gin.Context) (*core.Task, bool) {
+	taskID := ctx.Param("id")
+	if len(taskID) == 0 {
+		ctx.JSON(http.StatusBadRequest, "task ID required!")
+		return nil, false
+	}
+	task, err := core.GetTask(taskID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, types.Common{Error: err.Error()})
+		return nil, false
+	}
+	return task, true
+}
@@ -0,0 +1,15 @@
+package routers
+
+import (
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/handlers/alias"
+	"github.com/gin-gonic/gin"
+)
+
+func registerAliasApi(r *gin.RouterGroup) {
+	// add or remove aliases atomically
+	r.POST("/_aliases", alias.Update)
+	// list aliases
+	r.GET("/_aliases", alias.List)
+	// get alias
+	r.GET("/_alias/:alias", alias.Get)
+}
@@ -16,6 +16,14 @@ func registerDocumentApi(r *gin.RouterGroup) {
 	r.POST("/_bulk", document.Bulk)
 	// get document
 	r.GET("/:index/_doc/:id", document.Get)
+	// get multiple documents
+	r.GET("/:index/_mget", document.MultiGet)
+	r.POST("/:index/_mget", document.MultiGet)
+	r.GET("/_mget", document.MultiGet)
+	r.POST("/_mget", document.MultiGet)
 	// delete document
 	r.DELETE("/:index/_doc/:id", document.Delete)
+	// delete or update the documents matching query
+	r.POST("/:index/_delete_by_query", document.DeleteByQuery)
+	r.POST("/:index/_update_by_query", document.UpdateByQuery)
 }
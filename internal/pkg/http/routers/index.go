@@ -10,6 +10,14 @@ func registerIndexApi(r *gin.RouterGroup) {
 	r.POST("/:index", index.Create)
 	// update index mapping
 	r.PUT("/:index/_mapping", index.UpdateMapping)
+	// get index mapping and the discovered fields
+	r.GET("/_mapping", index.GetMapping)
+	r.GET("/:index/_mapping", index.GetMapping)
+	// the capabilities of fields across indices
+	r.GET("/_field_caps", index.FieldCaps)
+	r.POST("/_field_caps", index.FieldCaps)
+	r.GET("/:index/_field_caps", index.FieldCaps)
+	r.POST("/:index/_field_caps", index.FieldCaps)
 	// delete index
 	r.DELETE("/:index", index.Delete)
 	// get index
@@ -22,4 +30,15 @@ func registerIndexApi(r *gin.RouterGroup) {
 	r.POST("/:index/_close", index.Close)
 	// list indices
 	r.GET("/_all", index.List)
+	// copy documents between indices
+	r.POST("/_reindex", index.Reindex)
+	// analyze text
+	r.GET("/_analyze", index.Analyze)
+	r.POST("/_analyze", index.Analyze)
+	r.GET("/:index/_analyze", index.AnalyzeIndex)
+	r.POST("/:index/_analyze", index.AnalyzeIndex)
+	// the user dict of chinese analyzer
+	r.GET("/_user_dict/:analyzer", index.GetUserDict)
+	r.POST("/_user_dict/:analyzer", index.UpdateUserDict)
+	r.PUT("/_user_dict/:analyzer", index.UpdateUserDict)
 }
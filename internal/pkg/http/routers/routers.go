@@ -34,6 +34,9 @@ func RegisterRoutes(engine *gin.Engine) {
 		registerIndexApi(index)
 		registerDocumentApi(index)
 		registerSearchApi(index)
+		registerAliasApi(index)
+		registerTaskApi(index)
+		registerSnapshotApi(index)
 	}
 	es := v1.Group("es")
 	registerESRoutes(es)
@@ -51,5 +54,8 @@ func registerESRoutes(r *gin.RouterGroup) {
 	})
 	registerIndexApi(r)
 	registerDocumentApi(r)
-	registerSearchApi(r)
+	registerESSearchApi(r)
+	registerAliasApi(r)
+	registerTaskApi(r)
+	registerSnapshotApi(r)
 }
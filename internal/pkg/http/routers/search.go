@@ -1,6 +1,7 @@
 package routers
 
 import (
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/handlers/es"
 	"github.com/feimingxliu/quicksearch/internal/pkg/http/handlers/search"
 	"github.com/gin-gonic/gin"
 )
@@ -11,4 +12,31 @@ func registerSearchApi(r *gin.RouterGroup) {
 	r.POST("/:index/_search", search.Search)
 	r.GET("_search", search.Search)
 	r.POST("_search", search.Search)
+	// multi search
+	r.GET("/:index/_msearch", search.MultiSearch)
+	r.POST("/:index/_msearch", search.MultiSearch)
+	r.GET("_msearch", search.MultiSearch)
+	r.POST("_msearch", search.MultiSearch)
+	// fetch next page of scroll
+	r.GET("/_search/scroll", search.Scroll)
+	r.POST("/_search/scroll", search.Scroll)
+	// clear scroll
+	r.DELETE("/_search/scroll", search.ClearScroll)
+	r.DELETE("/_search/scroll/:id", search.ClearScroll)
+}
+
+// registerESSearchApi registers the search api which accepts Elasticsearch Query DSL and responds in its shape.
+func registerESSearchApi(r *gin.RouterGroup) {
+	r.GET("/:index/_search", es.Search)
+	r.POST("/:index/_search", es.Search)
+	r.GET("_search", es.Search)
+	r.POST("_search", es.Search)
+	r.GET("/:index/_msearch", es.MultiSearch)
+	r.POST("/:index/_msearch", es.MultiSearch)
+	r.GET("_msearch", es.MultiSearch)
+	r.POST("_msearch", es.MultiSearch)
+	r.GET("/_search/scroll", es.Scroll)
+	r.POST("/_search/scroll", es.Scroll)
+	r.DELETE("/_search/scroll", search.ClearScroll)
+	r.DELETE("/_search/scroll/:id", search.ClearScroll)
 }
@@ -0,0 +1,31 @@
+package routers
+
+import (
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/handlers/snapshot"
+	"github.com/gin-gonic/gin"
+)
+
+func registerSnapshotApi(r *gin.RouterGroup) {
+	// register or update repository
+	r.PUT("/_snapshot/:repo", snapshot.PutRepository)
+	r.POST("/_snapshot/:repo", snapshot.PutRepository)
+	// list repositories
+	r.GET("/_snapshot", snapshot.ListRepositories)
+	// get repository
+	r.GET("/_snapshot/:repo", snapshot.GetRepository)
+	// unregister repository
+	r.DELETE("/_snapshot/:repo", snapshot.DeleteRepository)
+	// verify the blobs in repository
+	r.POST("/_snapshot/:repo/_verify", snapshot.Verify)
+	// create snapshot
+	r.PUT("/_snapshot/:repo/:snapshot", snapshot.Create)
+	r.POST("/_snapshot/:repo/:snapshot", snapshot.Create)
+	// list snapshots
+	r.GET("/_snapshot/:repo/_all", snapshot.List)
+	// get snapshot
+	r.GET("/_snapshot/:repo/:snapshot", snapshot.Get)
+	// delete snapshot
+	r.DELETE("/_snapshot/:repo/:snapshot", snapshot.Delete)
+	// restore snapshot
+	r.POST("/_snapshot/:repo/:snapshot/_restore", snapshot.Restore)
+}
@@ -0,0 +1,15 @@
+package routers
+
+import (
+	"github.com/feimingxliu/quicksearch/internal/pkg/http/handlers/task"
+	"github.com/gin-gonic/gin"
+)
+
+func registerTaskApi(r *gin.RouterGroup) {
+	// list tasks
+	r.GET("/_tasks", task.List)
+	// get task
+	r.GET("/_tasks/:id", task.Get)
+	// cancel task
+	r.POST("/_tasks/:id/_cancel", task.Cancel)
+}
@@ -0,0 +1,198 @@
+package storager
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/feimingxliu/quicksearch/pkg/errors"
+	"os"
+	"path"
+)
+
+func newBadger(dbPath string) (*badgerdb, error) {
+	if err := os.MkdirAll(path.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+	db, err := openBadger(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return &badgerdb{db: db}, nil
+}
+
+// openBadger opens the badger db in the dir dbPath, the logs of badger are discarded.
+// The metadata stores are tiny, so the memtables, caches and value log files are much smaller than
+// the defaults which are sized for the large db. The memtable keeps the batch up to 15% of its size.
+func openBadger(dbPath string) (*badger.DB, error) {
+	opts := badger.DefaultOptions(dbPath).
+		WithLogger(nil).
+		WithMemTableSize(16 << 20).
+		WithNumMemtables(2).
+		WithNumLevelZeroTables(2).
+		WithNumLevelZeroTablesStall(4).
+		WithValueLogFileSize(16 << 20).
+		WithBlockCacheSize(4 << 20).
+		WithIndexCacheSize(1 << 20)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return db, nil
+}
+
+type badgerdb struct {
+	db *badger.DB
+}
+
+func (b *badgerdb) List() ([][]byte, error) {
+	data := make([][]byte, 0)
+	err := b.db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			v, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			data = append(data, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+func (b *badgerdb) Get(key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.ErrEmptyKey
+	}
+	var data []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return errors.ErrKeyNotFound
+			}
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	return data, err
+}
+
+func (b *badgerdb) Set(key string, value []byte) error {
+	if len(key) == 0 {
+		return errors.ErrEmptyKey
+	}
+	err := b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), value)
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Batch sets the pairs in one transaction, so none of them is set if failed.
+func (b *badgerdb) Batch(keys []string, values [][]byte) error {
+	if len(keys) != len(values) {
+		return errors.ErrKeyValueNotMatch
+	}
+	return b.db.Update(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			if len(key) == 0 {
+				return errors.ErrEmptyKey
+			}
+			if err := txn.Set([]byte(key), values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *badgerdb) Delete(key string) error {
+	if len(key) == 0 {
+		return errors.ErrEmptyKey
+	}
+	err := b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (b *badgerdb) DeleteAll() error {
+	if err := b.db.DropAll(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (b *badgerdb) Range(f func(key string, value []byte) error) error {
+	return b.db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			err := item.Value(func(v []byte) error {
+				return f(string(item.Key()), v)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CloneDatabase copies all the pairs to a new badger db in the dir newPath.
+func (b *badgerdb) CloneDatabase(newPath string) error {
+	if err := os.MkdirAll(path.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	db, err := openBadger(newPath)
+	if err != nil {
+		return err
+	}
+	wb := db.NewWriteBatch()
+	err = b.db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := wb.Set(item.KeyCopy(nil), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = wb.Flush()
+	} else {
+		wb.Cancel()
+	}
+	if err != nil {
+		_ = db.Close()
+		return errors.WithStack(err)
+	}
+	return db.Close()
+}
+
+func (b *badgerdb) Type() string {
+	return "badger"
+}
+
+func (b *badgerdb) Close() error {
+	if err := b.db.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
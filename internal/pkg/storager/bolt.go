@@ -153,6 +153,18 @@ func (b *bolt) DeleteAll() error {
 	return nil
 }
 
+func (b *bolt) Range(f func(key string, value []byte) error) error {
+	return b.db.View(func(txn *bbolt.Tx) error {
+		b := txn.Bucket(defaultBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return f(string(k), v)
+		})
+	})
+}
+
 func (b *bolt) CloneDatabase(path string) error {
 	err := b.db.View(func(tx *bbolt.Tx) error {
 		return tx.CopyFile(path, 0600)
@@ -23,7 +23,17 @@ type goleveldb struct {
 }
 
 func (l goleveldb) List() ([][]byte, error) {
-	return nil, nil
+	data := make([][]byte, 0)
+	err := l.Range(func(key string, value []byte) error {
+		v := make([]byte, len(value))
+		copy(v, value)
+		data = append(data, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 func (l goleveldb) Get(key string) ([]byte, error) {
@@ -53,6 +63,9 @@ func (l goleveldb) Batch(keys []string, values [][]byte) error {
 	}
 	batch := new(leveldb.Batch)
 	for i := range keys {
+		if len(keys[i]) == 0 {
+			return errors.ErrEmptyKey
+		}
 		batch.Put([]byte(keys[i]), values[i])
 	}
 	return l.db.Write(batch, nil)
@@ -77,6 +90,17 @@ func (l goleveldb) DeleteAll() error {
 	return l.db.Write(batch, nil)
 }
 
+func (l goleveldb) Range(f func(key string, value []byte) error) error {
+	iter := l.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := f(string(iter.Key()), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
 func (l goleveldb) CloneDatabase(newPath string) error {
 	if err := os.MkdirAll(path.Dir(newPath), 0755); err != nil {
 		return err
@@ -95,8 +119,9 @@ func (l goleveldb) CloneDatabase(newPath string) error {
 		batch.Put(k, v)
 	}
 	iter.Release()
-	err = l.db.Write(batch, nil)
+	err = db.Write(batch, nil)
 	if err != nil {
+		_ = db.Close()
 		return err
 	}
 	return db.Close()
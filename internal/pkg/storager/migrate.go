@@ -0,0 +1,67 @@
+package storager
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// Copy copies all the key, value pairs from src to dst in batches of batchSize,
+// it returns the number of pairs copied.
+func Copy(src, dst Storager, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	keys := make([]string, 0, batchSize)
+	values := make([][]byte, 0, batchSize)
+	total := 0
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := dst.Batch(keys, values); err != nil {
+			return err
+		}
+		total += len(keys)
+		keys, values = keys[:0], values[:0]
+		return nil
+	}
+	err := src.Range(func(key string, value []byte) error {
+		v := make([]byte, len(value))
+		copy(v, value)
+		keys, values = append(keys, key), append(values, v)
+		if len(keys) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	return total, err
+}
+
+// Checksum returns the number of key, value pairs in s and the sha256 checksum of them, which only
+// depends on the pairs, so the storages of different types can be compared.
+func Checksum(s Storager) (int, []byte, error) {
+	h := sha256.New()
+	count := 0
+	err := s.Range(func(key string, value []byte) error {
+		writeChunk(h, []byte(key))
+		writeChunk(h, value)
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, h.Sum(nil), nil
+}
+
+// writeChunk writes the length prefixed data, so the boundaries of keys and values are kept.
+func writeChunk(h hash.Hash, data []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(data)))
+	h.Write(size[:])
+	h.Write(data)
+}
@@ -1,34 +1,52 @@
 package storager
 
+import (
+	"fmt"
+	"strings"
+)
+
 type StorageType int
 
-// TODO: support badger.
 // types of storage
 const (
 	Bolt StorageType = iota
 	Leveldb
+	Badger
 	Default = Bolt
 )
 
+var storageTypes = map[string]StorageType{"bolt": Bolt, "leveldb": Leveldb, "badger": Badger}
+
+// ParseStorageType returns the storage type of the name, which is one of "bolt", "leveldb" and "badger".
+func ParseStorageType(name string) (StorageType, error) {
+	if st, ok := storageTypes[strings.ToLower(name)]; ok {
+		return st, nil
+	}
+	return Default, fmt.Errorf("unknown storage type [%s]", name)
+}
+
 func NewStorager(st StorageType, dbPath string) (Storager, error) {
 	switch st {
 	case Bolt:
 		return newBolt(dbPath)
 	case Leveldb:
 		return newLeveldb(dbPath)
+	case Badger:
+		return newBadger(dbPath)
 	default:
 		return newBolt(dbPath)
 	}
 }
 
 type Storager interface {
-	List() ([][]byte, error)                    // list all values
-	Get(key string) ([]byte, error)             // get a value along with key
-	Set(key string, value []byte) error         // set a key, value pair
-	Batch(keys []string, values [][]byte) error // batch set key, value pairs
-	Delete(key string) error                    // delete a key, value pair
-	DeleteAll() error                           // delete all key, value pairs
-	CloneDatabase(newPath string) error         // clone the database to the newPath
-	Type() string                               // return the underlying type of db
-	Close() error                               // close the db
+	List() ([][]byte, error)                            // list all values
+	Get(key string) ([]byte, error)                     // get a value along with key
+	Set(key string, value []byte) error                 // set a key, value pair
+	Batch(keys []string, values [][]byte) error         // batch set key, value pairs
+	Delete(key string) error                            // delete a key, value pair
+	DeleteAll() error                                   // delete all key, value pairs
+	Range(f func(key string, value []byte) error) error // iterate the pairs in order of key until f fails, the value is only valid in f
+	CloneDatabase(newPath string) error                 // clone the database to the newPath
+	Type() string                                       // return the underlying type of db
+	Close() error                                       // close the db
 }
@@ -11,9 +11,33 @@ var (
 	ErrInvalidMapping         = errors.New("invalid mapping")
 	ErrDocumentNotFound       = errors.New("document not found")
 	ErrIndexAlreadyExists     = errors.New("the index already exists")
+	ErrInvalidIndexName       = errors.New("invalid index name")
 	ErrIndexCloneNotSupported = errors.New("the index don't support clone")
 	ErrBulkDataFormat         = errors.New("error bulk data format")
 	ErrIndexClosed            = errors.New("index closed")
+	ErrAliasNotFound          = errors.New("alias not found")
+	ErrInvalidAliasAction     = errors.New("invalid alias action")
+	ErrAliasNoWriteIndex      = errors.New("the alias has no write index")
+	ErrIndexAliasConflict     = errors.New("the name is already used by an index or alias")
+	ErrTaskNotFound           = errors.New("task not found")
+	ErrTaskCompleted          = errors.New("the task already completed")
+	ErrReindexSameIndex       = errors.New("the source and dest index can't be the same")
+	ErrScrollNotFound         = errors.New("scroll not found or expired")
+	ErrTooManyScrolls         = errors.New("too many open scrolls")
+	ErrMultiSearchDataFormat  = errors.New("error msearch data format")
+	ErrVersionConflict        = errors.New("version conflict, the document has been modified")
+	ErrDocumentAlreadyExists  = errors.New("the document already exists")
+	ErrInvalidAggregation     = errors.New("the aggregation must have exactly one type")
+	ErrTooManyBuckets         = errors.New("too many buckets")
+	ErrIncompatibleMapping    = errors.New("incompatible mapping changes, reindex is required")
+	ErrStrictDynamicMapping   = errors.New("dynamic field is not allowed by the strict mapping")
+	ErrRepositoryNotFound     = errors.New("snapshot repository not found")
+	ErrInvalidRepository      = errors.New("invalid snapshot repository, the type must be fs and the location is required")
+	ErrInvalidSnapshotName    = errors.New("invalid repository or snapshot name")
+	ErrSnapshotNotFound       = errors.New("snapshot not found")
+	ErrSnapshotAlreadyExists  = errors.New("the snapshot already exists")
+	ErrSnapshotInProgress     = errors.New("the snapshot is in progress")
+	ErrInvalidTranslogSettings = errors.New("invalid translog settings")
 )
 
 //underlying db error.
@@ -0,0 +1,57 @@
+package maps
+
+import (
+	"path"
+	"strings"
+)
+
+// Filter returns a copy of input which only contains the fields matching includes and not matching excludes.
+// The patterns are dotted paths of the fields, e.g. "user.name", and wildcard "*" is supported, e.g. "user.*".
+// All fields are included if includes is empty. An included object field keeps all its children except the excluded.
+func Filter(input map[string]interface{}, includes, excludes []string) map[string]interface{} {
+	return filter(input, "", includes, excludes, len(includes) == 0)
+}
+
+func filter(input map[string]interface{}, prefix string, includes, excludes []string, included bool) map[string]interface{} {
+	output := make(map[string]interface{}, len(input))
+	for key, val := range input {
+		p := prefix + key
+		if matchAny(excludes, p) {
+			continue
+		}
+		in := included || matchAny(includes, p)
+		if m, ok := val.(map[string]interface{}); ok {
+			if !in && !mayMatchChildren(includes, p) {
+				continue
+			}
+			child := filter(m, p+".", includes, excludes, in)
+			if in || len(child) > 0 {
+				output[key] = child
+			}
+			continue
+		}
+		if in {
+			output[key] = val
+		}
+	}
+	return output
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatchChildren reports whether any pattern may match the children of p.
+func mayMatchChildren(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, p+".") || strings.Contains(pattern, "*") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package maps
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"reflect"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	input := map[string]interface{}{
+		"title": "quicksearch",
+		"user": map[string]interface{}{
+			"name": "feiming",
+			"age":  18,
+			"address": map[string]interface{}{
+				"city": "Beijing",
+			},
+		},
+		"tags": []interface{}{"search", "go"},
+	}
+	cases := []struct {
+		includes []string
+		excludes []string
+		expected map[string]interface{}
+	}{
+		{nil, nil, input},
+		{[]string{"title"}, nil, map[string]interface{}{"title": "quicksearch"}},
+		{[]string{"user.name", "tags"}, nil, map[string]interface{}{
+			"user": map[string]interface{}{"name": "feiming"},
+			"tags": []interface{}{"search", "go"},
+		}},
+		{[]string{"user"}, []string{"user.address"}, map[string]interface{}{
+			"user": map[string]interface{}{"name": "feiming", "age": 18},
+		}},
+		{[]string{"user.*"}, []string{"user.a*"}, map[string]interface{}{
+			"user": map[string]interface{}{"name": "feiming"},
+		}},
+		{nil, []string{"user", "tags"}, map[string]interface{}{"title": "quicksearch"}},
+		{[]string{"notexist"}, nil, map[string]interface{}{}},
+	}
+	for _, c := range cases {
+		output := Filter(input, c.includes, c.excludes)
+		if !reflect.DeepEqual(output, c.expected) {
+			json.Print("Filtered map", output)
+			t.Errorf("Filter(%v, %v) returns unexpected map", c.includes, c.excludes)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package maps
+
+// Merge returns a new map which merges src into dst deeply, the nested objects are merged
+// recursively, and the other values in src replace the ones in dst, e.g. arrays are replaced
+// as a whole. The input maps are not modified.
+func Merge(dst, src map[string]interface{}) map[string]interface{} {
+	output := make(map[string]interface{}, len(dst)+len(src))
+	for key, val := range dst {
+		output[key] = val
+	}
+	for key, val := range src {
+		srcMap, ok := val.(map[string]interface{})
+		if !ok {
+			output[key] = val
+			continue
+		}
+		if dstMap, ok := output[key].(map[string]interface{}); ok {
+			output[key] = Merge(dstMap, srcMap)
+		} else {
+			output[key] = srcMap
+		}
+	}
+	return output
+}
@@ -0,0 +1,43 @@
+package maps
+
+import (
+	"github.com/feimingxliu/quicksearch/pkg/util/json"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	dst := map[string]interface{}{
+		"title": "quicksearch",
+		"user": map[string]interface{}{
+			"name":    "feiming",
+			"address": map[string]interface{}{"city": "Beijing", "zip": "100000"},
+		},
+		"tags": []interface{}{"search"},
+	}
+	src := map[string]interface{}{
+		"user": map[string]interface{}{
+			"age":     18,
+			"address": map[string]interface{}{"city": "Shanghai"},
+		},
+		"tags":  []interface{}{"go"},
+		"title": map[string]interface{}{"en": "quicksearch"},
+	}
+	expected := map[string]interface{}{
+		"title": map[string]interface{}{"en": "quicksearch"},
+		"user": map[string]interface{}{
+			"name":    "feiming",
+			"age":     18,
+			"address": map[string]interface{}{"city": "Shanghai", "zip": "100000"},
+		},
+		"tags": []interface{}{"go"},
+	}
+	output := Merge(dst, src)
+	json.Print("Merged map", output)
+	if !reflect.DeepEqual(output, expected) {
+		t.Error("Merge returns unexpected map")
+	}
+	if dst["user"].(map[string]interface{})["address"].(map[string]interface{})["city"] != "Beijing" {
+		t.Error("Merge modifies the input map")
+	}
+}
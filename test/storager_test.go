@@ -0,0 +1,143 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/feimingxliu/quicksearch/internal/pkg/storager"
+	qerrors "github.com/feimingxliu/quicksearch/pkg/errors"
+	"github.com/feimingxliu/quicksearch/pkg/util/random"
+	"path/filepath"
+	"testing"
+)
+
+// TestStorager runs the same checks against every storage backend.
+func TestStorager(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		typ  storager.StorageType
+	}{
+		{"bolt", storager.Bolt},
+		{"leveldb", storager.Leveldb},
+		{"badger", storager.Badger},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testStorager(t, tt.typ, tt.name)
+		})
+	}
+}
+
+func testStorager(t *testing.T, typ storager.StorageType, name string) {
+	dir := t.TempDir()
+	db, err := storager.NewStorager(typ, filepath.Join(dir, name+".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+	if db.Type() != name {
+		t.Fatalf("got storager type %s", db.Type())
+	}
+
+	// single key.
+	if _, err = db.Get("key"); !errors.Is(err, qerrors.ErrKeyNotFound) {
+		t.Fatalf("get missing key: %v", err)
+	}
+	if err = db.Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get("key"); err != nil || string(v) != "value" {
+		t.Fatalf("get key: %s, %v", v, err)
+	}
+	if err = db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Get("key"); !errors.Is(err, qerrors.ErrKeyNotFound) {
+		t.Fatalf("get deleted key: %v", err)
+	}
+	if err = db.Delete("key"); err != nil {
+		t.Fatalf("delete missing key: %v", err)
+	}
+	for op, f := range map[string]func() error{
+		"get":    func() error { _, err := db.Get(""); return err },
+		"set":    func() error { return db.Set("", nil) },
+		"delete": func() error { return db.Delete("") },
+		"batch":  func() error { return db.Batch([]string{"a", ""}, [][]byte{nil, nil}) },
+	} {
+		if err := f(); !errors.Is(err, qerrors.ErrEmptyKey) {
+			t.Errorf("%s empty key: %v, expected %v", op, err, qerrors.ErrEmptyKey)
+		}
+	}
+
+	// batch, list and range in order of key.
+	keys := make([]string, 0, 1000)
+	values := make([][]byte, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("%04d", i))
+		values = append(values, []byte(random.RandomString(10)))
+	}
+	if err = db.Batch(keys, values[:10]); !errors.Is(err, qerrors.ErrKeyValueNotMatch) {
+		t.Fatalf("batch mismatched pairs: %v", err)
+	}
+	if err = db.Batch(keys, values); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, db, values)
+	i := 0
+	stop := errors.New("stop")
+	err = db.Range(func(key string, value []byte) error {
+		if key != keys[i] || string(value) != string(values[i]) {
+			t.Fatalf("range pair %d: %s=%s, expected %s=%s", i, key, value, keys[i], values[i])
+		}
+		if i++; i == 100 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || i != 100 {
+		t.Fatalf("range stopped at %d: %v", i, err)
+	}
+
+	// the clone has all the pairs and is independent of the origin.
+	clonePath := filepath.Join(dir, name+"_copy.db")
+	if err = db.CloneDatabase(clonePath); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, db, nil)
+	if err = db.Set("key", []byte("value")); err != nil {
+		t.Fatalf("set after delete all: %v", err)
+	}
+	err = db.Close()
+	db = nil
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db, err = storager.NewStorager(typ, clonePath); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, db, values)
+	if _, err = db.Get("key"); !errors.Is(err, qerrors.ErrKeyNotFound) {
+		t.Fatalf("get the key set after clone: %v", err)
+	}
+}
+
+func checkList(t *testing.T, db storager.Storager, values [][]byte) {
+	t.Helper()
+	list, err := db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(values) {
+		t.Fatalf("listed %d values, expected %d", len(list), len(values))
+	}
+	for i := range list {
+		if string(list[i]) != string(values[i]) {
+			t.Fatalf("value %d: %s, expected %s", i, list[i], values[i])
+		}
+	}
+}